@@ -0,0 +1,130 @@
+// Command sanctions imports sanctions lists from local files into the database
+// and optionally screens every shareholder and representative against them.
+//
+// Download the lists from their publishers, then run for example
+//
+//	go run ./cmd/sanctions -un consolidated.xml -ofac sdn.csv -ofac-alt alt.csv -screen
+//
+// Each list given replaces the previously imported version of the same list.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/driver"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+	"github.com/chamrasilva89/reservationWeb/internal/sanctions"
+)
+
+func main() {
+	dsn := flag.String("dsn", "host=localhost port=5432 dbname=reservation user=postgres password=ChamVish@123", "Database connection string")
+	unPath := flag.String("un", "", "UN Security Council consolidated list (XML) to import")
+	ofacPath := flag.String("ofac", "", "OFAC SDN list (sdn.csv) to import")
+	ofacAltPath := flag.String("ofac-alt", "", "OFAC SDN aliases (alt.csv), optional with -ofac")
+	screen := flag.Bool("screen", false, "Screen all shareholders and representatives after importing")
+	threshold := flag.Float64("threshold", sanctions.DefaultThreshold, "Lowest name match score, from 0 to 1, reported as a hit")
+	flag.Parse()
+
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	if *unPath == "" && *ofacPath == "" && !*screen {
+		fmt.Fprintln(os.Stderr, "nothing to do, give -un, -ofac or -screen")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *ofacAltPath != "" && *ofacPath == "" {
+		fmt.Fprintln(os.Stderr, "-ofac-alt needs -ofac")
+		os.Exit(2)
+	}
+	if *threshold <= 0 || *threshold > 1 {
+		fmt.Fprintln(os.Stderr, "-threshold must be a score from 0 to 1")
+		os.Exit(2)
+	}
+
+	cfg := driver.DefaultConfig(*dsn)
+	cfg.Logger = logger
+	conn, err := driver.ConnectSQL(cfg)
+	if err != nil {
+		logger.Error("cannot connect to the database", "error", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	db := dbrepo.NewPostgresRepo(conn, &config.AppConfig{Logger: logger})
+
+	if err := run(db, logger, *unPath, *ofacPath, *ofacAltPath, *screen, *threshold); err != nil {
+		logger.Error("sanctions import failed", "error", err)
+		conn.Close()
+		os.Exit(1)
+	}
+}
+
+// run imports the lists given and screens the subjects when asked to
+func run(db repository.DatabaseRepo, logger *slog.Logger, unPath, ofacPath, ofacAltPath string, screen bool, threshold float64) error {
+	if unPath != "" {
+		entries, err := readList(unPath, sanctions.ParseUN)
+		if err != nil {
+			return err
+		}
+		if err := db.ReplaceSanctionsEntries(models.SanctionsUN, entries); err != nil {
+			return err
+		}
+		logger.Info("imported sanctions list", "source", models.SanctionsUN, "path", unPath, "entries", len(entries))
+	}
+
+	if ofacPath != "" {
+		entries, err := readList(ofacPath, func(sdn io.Reader) ([]models.SanctionsEntry, error) {
+			if ofacAltPath == "" {
+				return sanctions.ParseOFAC(sdn, nil)
+			}
+			alt, err := os.Open(ofacAltPath)
+			if err != nil {
+				return nil, err
+			}
+			defer alt.Close()
+			return sanctions.ParseOFAC(sdn, alt)
+		})
+		if err != nil {
+			return err
+		}
+		if err := db.ReplaceSanctionsEntries(models.SanctionsOFAC, entries); err != nil {
+			return err
+		}
+		logger.Info("imported sanctions list", "source", models.SanctionsOFAC, "path", ofacPath, "entries", len(entries))
+	}
+
+	if screen {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		screened, found, err := sanctions.ScreenAll(ctx, db, threshold)
+		if err != nil {
+			return err
+		}
+		logger.Info("screened shareholders and representatives", "screened", screened, "hits", found)
+	}
+	return nil
+}
+
+// readList opens a list file and parses it
+func readList(path string, parse func(io.Reader) ([]models.SanctionsEntry, error)) ([]models.SanctionsEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := parse(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return entries, nil
+}
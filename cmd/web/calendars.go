@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/handler"
+	"github.com/chamrasilva89/reservationWeb/internal/ical"
+)
+
+// importCalendars imports the external calendars of the rooms at every
+// interval, so dates booked on other sites are blocked here soon after
+func importCalendars(interval time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			start := time.Now()
+			imported, failed, conflicts, err := ical.ImportAll(ctx, handler.Repo.DB, ical.Sources{Client: app.CalendarClient, Dir: app.CalendarDir})
+			if err != nil {
+				app.Logger.Error("calendar import failed", "imported", imported, "failed", failed, "error", err)
+				continue
+			}
+			for _, c := range conflicts {
+				app.Logger.Warn("external calendar conflicts with the room's dates", "calendar_id", c.CalendarID,
+					"uid", c.Event.UID, "start_date", c.Event.StartDate.Format("2006-01-02"),
+					"end_date", c.Event.EndDate.Format("2006-01-02"), "reservation_id", c.ReservationID)
+			}
+			app.Logger.Info("calendar import finished", "imported", imported, "failed", failed,
+				"conflicts", len(conflicts), "duration", time.Since(start).String())
+		}
+	}
+}
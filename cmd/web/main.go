@@ -1,47 +1,150 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
 	"encoding/gob"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/chamrasilva89/reservationWeb/internal/antivirus"
 	"github.com/chamrasilva89/reservationWeb/internal/config"
 	"github.com/chamrasilva89/reservationWeb/internal/driver"
+	"github.com/chamrasilva89/reservationWeb/internal/extract"
 	"github.com/chamrasilva89/reservationWeb/internal/handler"
 	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/ical"
+	"github.com/chamrasilva89/reservationWeb/internal/invoice"
+	"github.com/chamrasilva89/reservationWeb/internal/mailer"
+	"github.com/chamrasilva89/reservationWeb/internal/metrics"
 	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/payments"
+	"github.com/chamrasilva89/reservationWeb/internal/pricing"
 	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/chamrasilva89/reservationWeb/internal/risk"
+	"github.com/chamrasilva89/reservationWeb/internal/sanctions"
+	"github.com/chamrasilva89/reservationWeb/internal/thumbnail"
 )
 
-const portNumber = ":8080"
-
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog *log.Logger
-var errorLog *log.Logger
+var dbConfig = driver.DefaultConfig("")
+var logLevel = "info"
+var srvConfig serverConfig
+var clamdAddr string
+var clamdTimeout time.Duration
+var riskRulesPath string
+var sanctionsScreenAt string
+var sanctionsScreenTime time.Duration
+var smtpConfig mailer.SMTP
+var taxes string
+var stripeConfig payments.Stripe
+var linkSecret string
+var cancellation = pricing.DefaultCancellationPolicy
+var calendarImportInterval time.Duration
+var calendarTimeout = 30 * time.Second
 
 func main() {
-	// Print a message to indicate that the application has started on the specified port
-	fmt.Println(fmt.Sprintf("Application started on : %s", portNumber))
+	// Read command line flags
+	flag.BoolVar(&app.DemoMode, "demo", false, "Run without a database, keeping all data in memory")
+	flag.StringVar(&logLevel, "log-level", "info", "Minimum log level: debug, info, warn or error")
+	flag.StringVar(&dbConfig.DSN, "dsn", "host=localhost port=5432 dbname=reservation user=postgres password=ChamVish@123", "Database connection string")
+	flag.StringVar(&dbConfig.ReplicaDSN, "replica-dsn", "", "Optional read replica connection string, used for list and report queries")
+	flag.IntVar(&dbConfig.MaxOpenConns, "db-max-open", dbConfig.MaxOpenConns, "Maximum number of open database connections")
+	flag.IntVar(&dbConfig.MaxIdleConns, "db-max-idle", dbConfig.MaxIdleConns, "Maximum number of idle database connections")
+	flag.DurationVar(&dbConfig.ConnMaxLifetime, "db-conn-max-lifetime", dbConfig.ConnMaxLifetime, "Maximum time a database connection is reused")
+	flag.DurationVar(&dbConfig.ConnMaxIdleTime, "db-conn-max-idle-time", dbConfig.ConnMaxIdleTime, "Maximum time a database connection stays idle")
+	flag.IntVar(&dbConfig.ConnectRetries, "db-connect-retries", dbConfig.ConnectRetries, "Number of times to retry connecting to the database at startup")
+	flag.DurationVar(&dbConfig.RetryBackoff, "db-retry-backoff", dbConfig.RetryBackoff, "Wait before the first connection retry, doubled after each retry")
+	flag.StringVar(&app.UploadPath, "upload-path", config.FileUploadPath, "Directory uploaded customer files are stored in")
+	flag.StringVar(&clamdAddr, "clamd-addr", "", "ClamAV clamd address (tcp://host:port or unix:///path/to/socket) used to scan uploads, empty disables scanning")
+	flag.DurationVar(&clamdTimeout, "clamd-timeout", 30*time.Second, "Maximum time to scan one uploaded file")
+	flag.StringVar(&riskRulesPath, "risk-rules", "", "JSON file with the AML risk scoring rules, empty uses the built-in rules")
+	flag.StringVar(&sanctionsScreenAt, "sanctions-screen-at", "02:00", "Time of day (HH:MM) to screen all shareholders and representatives against the sanctions lists, empty disables the nightly screening")
+	flag.Float64Var(&app.SanctionsThreshold, "sanctions-threshold", sanctions.DefaultThreshold, "Lowest name match score, from 0 to 1, reported as a sanctions hit")
+	flag.StringVar(&smtpConfig.Addr, "smtp-addr", "", "Mail server (host:port) used to email guests, empty disables email")
+	flag.StringVar(&smtpConfig.From, "mail-from", "Reservations <reservations@localhost>", "Sender of email to guests")
+	flag.StringVar(&smtpConfig.Username, "smtp-user", "", "Mail server user name, empty sends without authenticating")
+	flag.StringVar(&smtpConfig.Password, "smtp-password", "", "Mail server password")
+	flag.DurationVar(&smtpConfig.Timeout, "smtp-timeout", 10*time.Second, "Maximum time to send one email")
+	flag.StringVar(&app.BaseURL, "base-url", "http://localhost:8080", "Public URL of the site, used for links in email")
+	flag.StringVar(&taxes, "taxes", "", "Taxes charged on stays, like \"VAT=5,Municipality fee=7\", empty charges 5% VAT")
+	flag.StringVar(&stripeConfig.SecretKey, "stripe-secret-key", "", "Stripe secret API key used to take payments, empty disables online payment")
+	flag.StringVar(&app.PaymentsPublicKey, "stripe-publishable-key", "", "Stripe publishable key used by the payment page")
+	flag.StringVar(&stripeConfig.WebhookSecret, "stripe-webhook-secret", "", "Secret Stripe signs calls to /payments/webhook with")
+	flag.StringVar(&stripeConfig.BaseURL, "stripe-api-url", payments.StripeAPI, "Stripe API address, for testing against a mock server")
+	flag.BoolVar(&stripeConfig.ManualCapture, "stripe-manual-capture", false, "Only authorize payments, staff capture them from the reservation's page")
+	flag.DurationVar(&stripeConfig.Timeout, "stripe-timeout", 15*time.Second, "Maximum time for one call to the Stripe API")
+	flag.StringVar(&linkSecret, "link-secret", "", "Secret signing the links emailed to guests to manage their reservations, empty makes a new one at every start")
+	flag.IntVar(&cancellation.FreeDays, "free-cancellation-days", cancellation.FreeDays, "Guests cancel for free until this many days before arrival")
+	flag.IntVar(&cancellation.FeePercent, "cancellation-fee", cancellation.FeePercent, "Percentage of the total charged for cancelling later")
+	flag.DurationVar(&calendarImportInterval, "calendar-import-interval", 30*time.Minute, "How often the external calendars of rooms are imported, 0 disables the import")
+	flag.DurationVar(&calendarTimeout, "calendar-timeout", calendarTimeout, "Maximum time to read one external calendar")
+	flag.StringVar(&app.CalendarDir, "calendar-dir", "", "Directory of the calendar files rooms can import, empty allows only calendar addresses")
+	flag.StringVar(&srvConfig.Addr, "addr", ":8080", "Address to listen on")
+	flag.DurationVar(&srvConfig.ReadHeaderTimeout, "read-header-timeout", 5*time.Second, "Maximum time to read request headers")
+	flag.DurationVar(&srvConfig.ReadTimeout, "read-timeout", 60*time.Second, "Maximum time to read a whole request, including uploads")
+	flag.DurationVar(&srvConfig.WriteTimeout, "write-timeout", 60*time.Second, "Maximum time to write a response")
+	flag.DurationVar(&srvConfig.IdleTimeout, "idle-timeout", 120*time.Second, "Maximum time to keep an idle keep-alive connection open")
+	flag.IntVar(&srvConfig.MaxHeaderBytes, "max-header-bytes", 1<<20, "Maximum size of request headers")
+	flag.DurationVar(&srvConfig.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "Maximum time to wait for in-flight requests on shutdown")
+	flag.StringVar(&srvConfig.TLSCert, "tls-cert", "", "TLS certificate file (PEM), serves HTTPS when set together with -tls-key")
+	flag.StringVar(&srvConfig.TLSKey, "tls-key", "", "TLS private key file (PEM)")
+	flag.BoolVar(&srvConfig.TLSSelfSigned, "tls-self-signed", false, "Serve HTTPS with a generated self-signed certificate (development only)")
+	flag.BoolVar(&srvConfig.TLSProxy, "tls-proxy", false, "The site is served over HTTPS by a reverse proxy, mark cookies Secure")
+	flag.Parse()
 
 	// Initialize the database and handle potential errors
 	db, err := run()
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer db.SQL.Close()
+
+	// ctx is cancelled on SIGINT or SIGTERM, which starts the graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if sanctionsScreenAt != "" {
+		startWorker(ctx, "sanctions-screening", screenNightly(sanctionsScreenTime))
+	}
+	if calendarImportInterval > 0 {
+		startWorker(ctx, "calendar-import", importCalendars(calendarImportInterval))
+	}
 
 	// Create an HTTP server with the specified configuration and start listening for incoming requests
-	srv := &http.Server{
-		Addr:    portNumber, // Configure the bind address
-		Handler: routes(&app),
+	srv, err := newServer(srvConfig, routes(&app))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Log a message to indicate that the application has started on the specified address
+	app.Logger.Info("application started", "addr", srvConfig.Addr, "tls", srvConfig.usesTLS())
+	err = serve(ctx, srv, srvConfig.ShutdownTimeout)
+	if err != nil {
+		app.Logger.Error("server stopped with an error", "error", err)
+	}
+
+	// Stop the background workers before closing the database they use
+	stop()
+	workers.Wait()
+	if db != nil {
+		db.Close()
+	}
+	app.Logger.Info("application stopped")
+
+	if err != nil {
+		os.Exit(1)
 	}
-	err = srv.ListenAndServe()
-	log.Fatal(err)
 }
 
 func run() (*driver.DB, error) {
@@ -59,42 +162,146 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Memorandum{})
 	app.InProduction = false
 
-	// Initialize loggers for information and error logs
-	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	// Initialize the structured logger, writing JSON lines to stdout
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(logLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", logLevel, err)
+	}
+	app.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 
 	// Create a new session manager with specific settings
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
+	session.Cookie.Secure = app.InProduction || srvConfig.secureCookies()
 
 	app.Session = session
 
-	// Connect to the database
-	log.Println("Connecting to the database")
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=reservation user=postgres password=ChamVish@123")
-	if err != nil {
-		log.Fatal("Cannot connect to the database")
-		return nil, err
-	}
-	log.Println("Database Connection Established")
-
 	// Create the template cache for rendering
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
-		log.Fatal("Cannot create template cache")
-		return nil, err
+		return nil, fmt.Errorf("cannot create template cache: %w", err)
 	}
 	app.TemplateCache = tc
 	app.UseCache = false
 
-	// Initialize repository and handlers with the application configuration and database connection
-	repo := handler.NewRepo(&app, db)
+	// Scan uploads for malware when clamd is configured
+	if clamdAddr != "" {
+		scanner, err := antivirus.NewClamAV(clamdAddr, clamdTimeout)
+		if err != nil {
+			return nil, err
+		}
+		app.Scanner = scanner
+		app.Logger.Info("scanning uploads with clamd", "address", clamdAddr)
+	} else {
+		app.Logger.Warn("no virus scanner configured, uploads are stored without scanning")
+	}
+
+	// Render PDF previews when poppler's pdftoppm is installed
+	if pdf := thumbnail.FindPdftoppm(); pdf != nil {
+		app.PDFRenderer = pdf
+	} else {
+		app.Logger.Info("pdftoppm not found, PDF files get a placeholder thumbnail")
+	}
+
+	// Read fields from scanned documents when tesseract is installed
+	if ocr := extract.FindTesseract(); ocr != nil {
+		app.OCR = ocr
+	} else {
+		app.Logger.Info("tesseract not found, fields can't be read from scanned documents")
+	}
+
+	// Score customers' AML risk with the configured rules
+	if riskRulesPath != "" {
+		rules, err := risk.LoadFile(riskRulesPath)
+		if err != nil {
+			return nil, err
+		}
+		app.RiskRules = rules
+		app.Logger.Info("loaded risk scoring rules", "path", riskRulesPath, "rules", len(rules.Rules))
+	}
+
+	// Check the sanctions screening settings before anything starts
+	if app.SanctionsThreshold < 0 || app.SanctionsThreshold > 1 {
+		return nil, fmt.Errorf("invalid sanctions threshold %v, expected a score from 0 to 1", app.SanctionsThreshold)
+	}
+	if sanctionsScreenAt != "" {
+		sanctionsScreenTime, err = parseTimeOfDay(sanctionsScreenAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Email guests when a mail server is configured
+	if smtpConfig.Addr != "" {
+		app.Mailer = &smtpConfig
+		app.Logger.Info("sending email through mail server", "address", smtpConfig.Addr)
+	} else {
+		app.Logger.Info("no mail server configured, guests are not emailed")
+	}
+	app.BaseURL = strings.TrimSuffix(app.BaseURL, "/")
+
+	// Sign the links guests manage their reservations with
+	if linkSecret != "" {
+		app.LinkSecret = []byte(linkSecret)
+	} else {
+		app.LinkSecret = make([]byte, 32)
+		if _, err := rand.Read(app.LinkSecret); err != nil {
+			return nil, err
+		}
+		app.Logger.Warn("no -link-secret set, links emailed to guests stop working when the server restarts")
+	}
+	if cancellation.FreeDays < 0 || cancellation.FeePercent < 0 || cancellation.FeePercent > 100 {
+		return nil, fmt.Errorf("invalid cancellation policy, expected days from 0 and a fee from 0 to 100%%")
+	}
+	app.Cancellation = &cancellation
+	if calendarImportInterval < 0 || calendarTimeout <= 0 {
+		return nil, fmt.Errorf("invalid calendar import settings, expected a positive interval and timeout")
+	}
+	app.CalendarClient = ical.NewClient(calendarTimeout)
+
+	// Take payments online when a payment provider is configured
+	if stripeConfig.SecretKey != "" {
+		if stripeConfig.WebhookSecret == "" || app.PaymentsPublicKey == "" {
+			return nil, fmt.Errorf("-stripe-secret-key needs -stripe-publishable-key and -stripe-webhook-secret")
+		}
+		app.Payments = &stripeConfig
+		app.Logger.Info("taking payments through stripe", "api", stripeConfig.BaseURL)
+	} else {
+		app.Logger.Info("no payment provider configured, reservations are paid at the hotel")
+	}
+	if taxes != "" {
+		if app.Taxes, err = invoice.ParseTaxes(taxes); err != nil {
+			return nil, err
+		}
+	}
+
+	// Initialize the repository, either in memory for the demo mode or with a database connection
+	var repo *handler.Repository
+	var db *driver.DB
+	if app.DemoMode {
+		app.Logger.Warn("running in demo mode, data will not be persisted")
+		repo, err = handler.NewMemoryRepo(&app)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Connect to the database
+		app.Logger.Info("connecting to the database")
+		dbConfig.Logger = app.Logger
+		db, err = driver.ConnectSQL(dbConfig)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to the database: %w", err)
+		}
+		app.Logger.Info("database connection established")
+		pools := map[string]*sql.DB{"primary": db.SQL}
+		if db.Replica != nil {
+			pools["replica"] = db.Replica
+		}
+		metrics.RegisterDBStats(pools)
+		repo = handler.NewRepo(&app, db)
+	}
 	handler.NewHandlers(repo)
 
 	// Initialize the renderer and helpers
@@ -3,6 +3,7 @@ package main
 import "testing"
 
 func TestRun(t *testing.T) {
+	app.DemoMode = true
 	_, err := run()
 	if err != nil {
 		t.Error("failed run()")
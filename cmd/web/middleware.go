@@ -1,23 +1,106 @@
 package main
 
 import (
-	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/metrics"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/google/uuid"
 	"github.com/justinas/nosurf"
 )
 
 /*
-WriteToConsole:
+RequestID:
 
-This middleware function simply logs a message to the console when an HTTP request is received.
-It takes an http.Handler called next as an argument, which represents the next middleware or the final request handler.
-After logging the message, it calls the ServeHTTP method of the next handler to continue processing the request.
+This middleware assigns an ID to every request so that log lines and error pages belonging to the same request can be matched up.
+An X-Request-ID header sent by a proxy in front of the application is reused when it is present and reasonably short, otherwise a new UUID is generated.
+The ID is stored in the request context, where helpers.RequestID can read it, and echoed back in the X-Request-ID response header.
 */
-func WriteToConsole(next http.Handler) http.Handler {
+func RequestID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("Hit the page")
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 64 {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next.ServeHTTP(w, r.WithContext(helpers.WithRequestID(r.Context(), id)))
+	})
+}
+
+/*
+AccessLog:
+
+This middleware writes one structured log line per request once it has been served.
+It wraps the response writer to capture the status code and the number of bytes written, and records them together with the method, path, latency, remote address and request ID.
+*/
+func AccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		app.Logger.Info("request",
+			"request_id", helpers.RequestID(r),
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", ww.BytesWritten(),
+			"latency_ms", float64(time.Since(start).Microseconds())/1000,
+			"remote_addr", r.RemoteAddr,
+		)
+	})
+}
+
+/*
+Metrics:
+
+This middleware records the number and latency of requests for the /metrics endpoint.
+Requests are labelled with the chi route pattern (for example /customer/details/{id}) rather than the raw path, so that IDs in URLs do not create a new time series per customer.
+*/
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		metrics.HTTPRequests.Inc(r.Method, route, strconv.Itoa(status))
+		metrics.HTTPDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
+
+/*
+LimitRequestBody:
+
+This middleware refuses request bodies larger than uploads.MaxRequestSize with 413 Request Entity Too Large.
+It has to run before NoSurf, which parses multipart forms to find the CSRF token, so the limit applies before any upload is read.
+Bodies without a Content-Length are cut off at the limit while they are read.
+*/
+func LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > uploads.MaxRequestSize {
+			helpers.ClientError(w, r, http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, uploads.MaxRequestSize)
 		next.ServeHTTP(w, r)
 	})
 }
@@ -27,16 +110,18 @@ NoSurf:
 
 This middleware function is responsible for preventing Cross-Site Request Forgery (CSRF) attacks using the "nosurf" package.
 It wraps the next handler with CSRF protection by creating a new nosurf.CSRFHandler with the next handler as its target.
-It configures the base CSRF cookie with settings like HttpOnly, Path, and whether it should be set as Secure (likely depending on whether the application is in production).
+It configures the base CSRF cookie with settings like HttpOnly, Path, and whether it should be set as Secure (when the site is served over HTTPS).
 The CSRF handler adds protection against CSRF attacks by including and validating CSRF tokens in requests.
 */
 func NoSurf(next http.Handler) http.Handler {
 	csrfHandler := nosurf.New(next)
+	// The payment provider calls the webhook, which is checked by its signature instead
+	csrfHandler.ExemptPath("/payments/webhook")
 
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
-		Secure:   app.InProduction,
+		Secure:   app.InProduction || srvConfig.secureCookies(),
 		SameSite: http.SameSiteLaxMode,
 	})
 	return csrfHandler
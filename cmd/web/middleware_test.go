@@ -1,9 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
 )
 
 func TestNoSurve(t *testing.T) {
@@ -27,3 +35,88 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = helpers.RequestID(r)
+	}))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if seen == "" {
+		t.Error("expected a generated request ID in the context")
+	}
+	if w.Header().Get("X-Request-ID") != seen {
+		t.Errorf("expected response header %q but got %q", seen, w.Header().Get("X-Request-ID"))
+	}
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Request-ID", "from-proxy")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	if seen != "from-proxy" {
+		t.Errorf("expected the incoming request ID to be reused, got %q", seen)
+	}
+}
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	app.Logger = slog.New(slog.NewJSONHandler(&buf, nil))
+
+	h := RequestID(AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/about", nil))
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry["status"] != float64(http.StatusTeapot) || entry["path"] != "/about" || entry["request_id"] == "" {
+		t.Errorf("unexpected access log entry %v", entry)
+	}
+}
+
+func TestLimitRequestBody(t *testing.T) {
+	app.Logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+	helpers.NewHelpers(&app)
+
+	var called bool
+	h := LimitRequestBody(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if _, err := io.ReadAll(r.Body); err != nil {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		}
+	}))
+
+	var tests = []struct {
+		name          string
+		size          int64
+		contentLength int64
+		status        int
+		called        bool
+	}{
+		{"small", 1024, 1024, http.StatusOK, true},
+		{"too large", uploads.MaxRequestSize + 1, uploads.MaxRequestSize + 1, http.StatusRequestEntityTooLarge, false},
+		{"too large without length", uploads.MaxRequestSize + 1, -1, http.StatusRequestEntityTooLarge, true},
+	}
+
+	for _, e := range tests {
+		called = false
+		r := httptest.NewRequest("POST", "/customer/add", io.LimitReader(zeros{}, e.size))
+		r.ContentLength = e.contentLength
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != e.status || called != e.called {
+			t.Errorf("%s: expected status %d and called %v but got %d and %v", e.name, e.status, e.called, w.Code, called)
+		}
+	}
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
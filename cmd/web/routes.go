@@ -5,6 +5,7 @@ import (
 
 	"github.com/chamrasilva89/reservationWeb/internal/config"
 	"github.com/chamrasilva89/reservationWeb/internal/handler"
+	"github.com/chamrasilva89/reservationWeb/internal/metrics"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -13,29 +14,54 @@ func routes(app *config.AppConfig) http.Handler {
 	// Create a new Chi router instance
 	mux := chi.NewRouter()
 
+	// Assign a request ID and log every request
+	mux.Use(RequestID)
+	mux.Use(AccessLog)
+	mux.Use(Metrics)
+
 	// Use Chi middleware for recovering from panics
 	mux.Use(middleware.Recoverer)
 
+	// Refuse oversized bodies before NoSurf reads them looking for the CSRF token
+	mux.Use(LimitRequestBody)
+
 	// Use the NoSurf middleware (not shown in this code snippet, but it's assumed to be part of your application)
 	mux.Use(NoSurf)
 
 	// Use the SessionLoad middleware (not shown in this code snippet, but it's assumed to be part of your application)
 	mux.Use(SessionLoad)
 
+	// Health checks and metrics for the load balancer and monitoring
+	mux.Get("/healthz", handler.Repo.Healthz)
+	mux.Get("/readyz", handler.Repo.Readyz)
+	mux.Method(http.MethodGet, "/metrics", metrics.Handler())
+
 	// Define routes and associate them with their corresponding handlers
 	mux.Get("/", handler.Repo.Home)
 	mux.Get("/about", handler.Repo.About)
-	mux.Get("/generals", handler.Repo.Generals)
-	mux.Get("/majors", handler.Repo.Majors)
+	mux.Get("/rooms/{slug}", handler.Repo.ShowRoom)
+	mux.Get("/rooms/{slug}/photos/{id}", handler.Repo.ShowRoomPhoto)
+	mux.Get("/rooms/{slug}/calendar.ics", handler.Repo.RoomCalendarFeed)
+	mux.Handle("/generals", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/make-reservation", handler.Repo.Reservation)
 	mux.Post("/make-reservation", handler.Repo.PostReservation)
 	mux.Get("/search-availability", handler.Repo.Availability)
 	mux.Get("/reservation-summary", handler.Repo.ReservationSummary)
+	mux.Get("/reservation/{reference}/confirmation.pdf", handler.Repo.ReservationConfirmation)
+	mux.Get("/reservation/{reference}/pay", handler.Repo.ReservationPayment)
+	mux.Post("/payments/webhook", handler.Repo.PaymentWebhook)
+	mux.Get("/reservation/find", handler.Repo.FindReservation)
+	mux.Post("/reservation/find", handler.Repo.PostFindReservation)
+	mux.Get("/reservation/{reference}/manage", handler.Repo.ManageReservation)
+	mux.Post("/reservation/{reference}/manage/dates", handler.Repo.PostChangeReservationDates)
+	mux.Post("/reservation/{reference}/manage/cancel", handler.Repo.PostCancelReservation)
 
 	mux.Get("/contact", handler.Repo.Contact)
 
 	mux.Post("/search-availability", handler.Repo.PostAvailability)
+	mux.Get("/choose-room/{id}", handler.Repo.ChooseRoom)
 	mux.Post("/search-availability-g", handler.Repo.AvailabilityJSON)
 
 	mux.Get("/user/login", handler.Repo.ShowLogin)
@@ -50,7 +76,15 @@ func routes(app *config.AppConfig) http.Handler {
 		customerMux.Get("/add", handler.Repo.AddCustomer)
 		customerMux.Post("/add", handler.Repo.PostCustomer)
 		customerMux.Get("/all", handler.Repo.AllCustomers)
+		customerMux.Get("/export", handler.Repo.ShowExport)
+		customerMux.Get("/export/{dataset}", handler.Repo.ExportCustomerData)
+		customerMux.Get("/import", handler.Repo.ShowImport)
+		customerMux.Post("/import", handler.Repo.PostImport)
+		customerMux.Get("/import/{id}", handler.Repo.ShowCustomerImport)
+		customerMux.Post("/import/{id}/mapping", handler.Repo.PostCustomerImportMapping)
+		customerMux.Post("/import/{id}/commit", handler.Repo.PostCommitCustomerImport)
 		customerMux.Get("/details/{id}", handler.Repo.ShowCustomerDetails)
+		customerMux.Get("/{id}/dossier.pdf", handler.Repo.CustomerDossier)
 		customerMux.Get("/trade-license/{id}", handler.Repo.ShowCustomerTradeLicense)
 		customerMux.Post("/trade-license/{id}", handler.Repo.PostTradeLicense)
 		customerMux.Get("/partners/{id}", handler.Repo.ShowCustomerPartners)
@@ -59,6 +93,13 @@ func routes(app *config.AppConfig) http.Handler {
 		customerMux.Post("/add-partner/{id}", handler.Repo.PostPartner)
 		customerMux.Get("/add-memorandum/{id}", handler.Repo.AddMemorandum)
 		customerMux.Post("/add-memorandum/{id}", handler.Repo.PostRepresentative)
+		customerMux.Get("/files/{id}", handler.Repo.ShowFile)
+		customerMux.Get("/files/{id}/thumb", handler.Repo.ShowFileThumb)
+		customerMux.Post("/extract", handler.Repo.PostExtractDocument)
+		customerMux.Get("/onboard/{id}", handler.Repo.ShowOnboarding)
+		customerMux.Post("/onboard/{id}/draft", handler.Repo.PostOnboardingDraft)
+		customerMux.Post("/onboard/{id}/signed-memorandum", handler.Repo.PostSignedMemorandum)
+		customerMux.Post("/onboard/{id}/activate", handler.Repo.PostActivateCustomer)
 	})
 
 	// Serve static files from the "static" directory
@@ -68,15 +109,37 @@ func routes(app *config.AppConfig) http.Handler {
 	mux.Route("/admin", func(adminMux chi.Router) {
 		adminMux.Use(Auth)
 		adminMux.Get("/dashboard", handler.Repo.AdminDashboard)
+		adminMux.Get("/reviews/{id}", handler.Repo.AdminShowReview)
+		adminMux.Post("/reviews/{id}", handler.Repo.AdminPostReview)
+		adminMux.Post("/reviews/{id}/resubmit", handler.Repo.AdminPostResubmitReview)
+		adminMux.Get("/sanctions", handler.Repo.AdminSanctionsHits)
+		adminMux.Post("/sanctions/{id}", handler.Repo.AdminPostSanctionsHit)
+
+		adminMux.Get("/rooms", handler.Repo.AdminRooms)
+		adminMux.Get("/rooms/new", handler.Repo.AdminNewRoom)
+		adminMux.Post("/rooms/new", handler.Repo.AdminPostNewRoom)
+		adminMux.Get("/rooms/{id}", handler.Repo.AdminShowRoom)
+		adminMux.Post("/rooms/{id}", handler.Repo.AdminPostRoom)
+		adminMux.Post("/rooms/{id}/delete", handler.Repo.AdminDeleteRoom)
+		adminMux.Post("/rooms/{id}/photos", handler.Repo.AdminPostRoomPhoto)
+		adminMux.Post("/rooms/{id}/photos/{photoID}/delete", handler.Repo.AdminDeleteRoomPhoto)
+		adminMux.Post("/rooms/{id}/calendars", handler.Repo.AdminPostRoomCalendar)
+		adminMux.Post("/rooms/{id}/calendars/import", handler.Repo.AdminImportRoomCalendars)
+		adminMux.Post("/rooms/{id}/calendars/{calendarID}/delete", handler.Repo.AdminDeleteRoomCalendar)
+		adminMux.Get("/pricing", handler.Repo.AdminPricing)
+		adminMux.Post("/pricing/periods", handler.Repo.AdminPostRatePeriod)
+		adminMux.Post("/pricing/periods/{id}/delete", handler.Repo.AdminDeleteRatePeriod)
+		adminMux.Post("/pricing/discounts", handler.Repo.AdminPostDiscountCode)
+		adminMux.Post("/pricing/discounts/{id}/active", handler.Repo.AdminPostDiscountCodeActive)
 
 		adminMux.Get("/reservations-new", handler.Repo.AdminNewReservations)
 		adminMux.Get("/reservations-all", handler.Repo.AdminAllReservations)
-		// Add more admin routes here
-
-		// Example:
-		// adminMux.Get("/process-reservation/{src}/{id}/do", handler.Repo.AdminProcessReservation)
-		// adminMux.Get("/reservations/{src}/{id}/show", handler.Repo.AdminShowReservation)
-		// adminMux.Post("/reservations/{src}/{id}", handler.Repo.AdminPostShowReservation)
+		adminMux.Get("/reservations/{src}/{id}/show", handler.Repo.AdminShowReservation)
+		adminMux.Post("/reservations/{src}/{id}", handler.Repo.AdminPostShowReservation)
+		adminMux.Post("/reservations/{src}/{id}/state", handler.Repo.AdminPostReservationState)
+		adminMux.Get("/reservations/{src}/{id}/confirmation.pdf", handler.Repo.AdminReservationConfirmation)
+		adminMux.Post("/reservations/{src}/{id}/capture", handler.Repo.AdminCaptureReservation)
+		adminMux.Post("/reservations/{src}/{id}/refund", handler.Repo.AdminRefundReservation)
 	})
 
 	return mux
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/handler"
+	"github.com/chamrasilva89/reservationWeb/internal/sanctions"
+)
+
+// parseTimeOfDay reads a time of day written as HH:MM and returns it as the
+// time since midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// nextRun returns the first time after now that is at the given time of day
+func nextRun(now time.Time, at time.Duration) time.Time {
+	y, m, d := now.Date()
+	next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+	if !next.After(now) {
+		next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(at)
+	}
+	return next
+}
+
+// screenNightly screens every shareholder and representative against the
+// sanctions lists once a day at the given time, so names entered before a
+// list was updated are checked against the new entries. The lists are loaded
+// again first, which also brings the screener of names entered from then on
+// up to date.
+func screenNightly(at time.Duration) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		for {
+			next := nextRun(time.Now(), at)
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+
+			threshold := app.SanctionsThreshold
+			if threshold <= 0 {
+				threshold = sanctions.DefaultThreshold
+			}
+			start := time.Now()
+			s, err := handler.Repo.Sanctions.Reload(handler.Repo.DB, threshold)
+			if err != nil {
+				app.Logger.Error("cannot load sanctions lists", "error", err)
+				continue
+			}
+			screened, found, err := s.ScreenAll(ctx, handler.Repo.DB)
+			if err != nil {
+				app.Logger.Error("sanctions screening failed", "screened", screened, "hits", found, "error", err)
+				continue
+			}
+			app.Logger.Info("sanctions screening finished", "screened", screened, "hits", found,
+				"duration", time.Since(start).String())
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	at, err := parseTimeOfDay("02:30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at != 2*time.Hour+30*time.Minute {
+		t.Errorf("expected 2h30m but got %s", at)
+	}
+
+	for _, s := range []string{"", "2am", "25:00", "02:60"} {
+		if _, err := parseTimeOfDay(s); err == nil {
+			t.Errorf("expected an error for %q", s)
+		}
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	at := 2 * time.Hour
+	tests := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC), time.Date(2026, 10, 20, 2, 0, 0, 0, time.UTC)},
+		{time.Date(2026, 12, 31, 23, 0, 0, 0, time.UTC), time.Date(2027, 1, 1, 2, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		if got := nextRun(tt.now, at); !got.Equal(tt.want) {
+			t.Errorf("nextRun(%s): expected %s but got %s", tt.now, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// serverConfig holds the settings of the http.Server, filled from command line flags
+type serverConfig struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	ShutdownTimeout   time.Duration
+
+	// TLSCert and TLSKey are PEM files; when both are empty and TLSSelfSigned
+	// is set a throwaway certificate is generated for development
+	TLSCert       string
+	TLSKey        string
+	TLSSelfSigned bool
+	TLSProxy      bool // a reverse proxy in front of the server terminates TLS
+}
+
+// usesTLS reports whether the server terminates TLS itself instead of relying on a reverse proxy
+func (c serverConfig) usesTLS() bool {
+	return c.TLSCert != "" || c.TLSKey != "" || c.TLSSelfSigned
+}
+
+// secureCookies reports whether the site is served over HTTPS, by the server
+// itself or a proxy, so that cookies must only be sent over HTTPS
+func (c serverConfig) secureCookies() bool {
+	return c.usesTLS() || c.TLSProxy
+}
+
+// workers tracks the background goroutines started with startWorker, so that
+// shutdown can wait for them to finish
+var workers sync.WaitGroup
+
+// startWorker runs fn in the background. fn must return once ctx is cancelled,
+// which happens when the application shuts down.
+func startWorker(ctx context.Context, name string, fn func(ctx context.Context)) {
+	workers.Add(1)
+	go func() {
+		defer workers.Done()
+		app.Logger.Info("background worker started", "worker", name)
+		fn(ctx)
+		app.Logger.Info("background worker stopped", "worker", name)
+	}()
+}
+
+// newServer creates the http.Server with the configured timeouts and limits
+func newServer(c serverConfig, handler http.Handler) (*http.Server, error) {
+	srv := &http.Server{
+		Addr:              c.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		ReadTimeout:       c.ReadTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+		ErrorLog:          slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
+	}
+
+	if c.usesTLS() {
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		if c.TLSCert != "" || c.TLSKey != "" {
+			cert, err := tls.LoadX509KeyPair(c.TLSCert, c.TLSKey)
+			if err != nil {
+				return nil, fmt.Errorf("cannot load TLS certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		} else {
+			cert, err := selfSignedCertificate()
+			if err != nil {
+				return nil, fmt.Errorf("cannot create self-signed certificate: %w", err)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		srv.TLSConfig = tlsConfig
+	}
+
+	return srv, nil
+}
+
+// serve runs srv until ctx is cancelled, then stops accepting connections and
+// waits up to shutdownTimeout for in-flight requests to complete
+func serve(ctx context.Context, srv *http.Server, shutdownTimeout time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			errs <- err
+		}
+		close(errs)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+
+	app.Logger.Info("shutting down, draining in-flight requests", "timeout", shutdownTimeout.String())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return <-errs
+}
+
+// selfSignedCertificate generates a short lived certificate for localhost, for
+// trying out TLS in development without creating certificate files
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"reservationWeb development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(30 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe_GracefulShutdown(t *testing.T) {
+	app.Logger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		w.Write([]byte("done"))
+	})
+
+	srv, err := newServer(serverConfig{Addr: addr, TLSSelfSigned: true}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- serve(ctx, srv, 5*time.Second) }()
+
+	stopped := make(chan struct{})
+	startWorker(ctx, "test", func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	var resp *http.Response
+	responded := make(chan error)
+	go func() {
+		for i := 0; i < 50; i++ {
+			resp, err = client.Get("https://" + addr)
+			if err == nil {
+				break
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		responded <- err
+	}()
+
+	<-started
+	cancel()
+
+	if err := <-responded; err != nil {
+		t.Fatalf("in-flight request was not drained: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "done" {
+		t.Errorf("expected the in-flight response to complete, got %q", body)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("expected a clean shutdown but got %v", err)
+	}
+
+	<-stopped
+	workers.Wait()
+}
+
+func TestNewServer_BadCertificate(t *testing.T) {
+	_, err := newServer(serverConfig{TLSCert: "missing.pem", TLSKey: "missing.key"}, http.NotFoundHandler())
+	if err == nil {
+		t.Error("expected an error for missing certificate files")
+	}
+}
+
+func TestServerConfig_SecureCookies(t *testing.T) {
+	var tests = []struct {
+		name   string
+		config serverConfig
+		secure bool
+	}{
+		{"plain HTTP", serverConfig{}, false},
+		{"certificate", serverConfig{TLSCert: "cert.pem", TLSKey: "key.pem"}, true},
+		{"self-signed", serverConfig{TLSSelfSigned: true}, true},
+		{"behind a TLS proxy", serverConfig{TLSProxy: true}, true},
+	}
+
+	for _, e := range tests {
+		if got := e.config.secureCookies(); got != e.secure {
+			t.Errorf("%s: expected secure cookies %v but got %v", e.name, e.secure, got)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Package antivirus scans uploaded files for malware before they are stored
+package antivirus
+
+import (
+	"context"
+	"io"
+)
+
+// Scan statuses recorded with every stored file
+const (
+	StatusClean      = "clean"
+	StatusInfected   = "infected"
+	StatusNotScanned = "not_scanned" // no scanner was configured when the file was stored
+)
+
+// Result is the verdict of a scan
+type Result struct {
+	Infected  bool
+	Signature string // name of the detected malware, empty when the file is clean
+}
+
+// Scanner checks the content read from r for malware. An error means the
+// content could not be scanned, not that it is infected.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
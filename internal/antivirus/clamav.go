@@ -0,0 +1,143 @@
+package antivirus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultChunkSize is the size of the chunks files are streamed to clamd in
+const DefaultChunkSize = 64 << 10
+
+// ClamAV scans files with a clamd daemon, streaming them over its INSTREAM command
+type ClamAV struct {
+	Network   string        // "tcp" or "unix"
+	Address   string        // host:port or socket path
+	Timeout   time.Duration // limit for a whole scan, 0 means none
+	ChunkSize int
+}
+
+// NewClamAV returns a scanner for the clamd listening at addr, which is either
+// tcp://host:port, unix:///path/to/clamd.sock, a bare host:port or a bare socket path
+func NewClamAV(addr string, timeout time.Duration) (*ClamAV, error) {
+	c := &ClamAV{Timeout: timeout, ChunkSize: DefaultChunkSize}
+
+	switch {
+	case strings.HasPrefix(addr, "tcp://"):
+		c.Network, c.Address = "tcp", strings.TrimPrefix(addr, "tcp://")
+	case strings.HasPrefix(addr, "unix://"):
+		c.Network, c.Address = "unix", strings.TrimPrefix(addr, "unix://")
+	case strings.HasPrefix(addr, "/"):
+		c.Network, c.Address = "unix", addr
+	default:
+		c.Network, c.Address = "tcp", addr
+	}
+
+	if c.Address == "" {
+		return nil, fmt.Errorf("invalid clamd address %q", addr)
+	}
+	return c, nil
+}
+
+// Ping checks that clamd is up and answering
+func (c *ClamAV) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected reply from clamd: %q", reply)
+	}
+	return nil
+}
+
+// Scan streams the content of r to clamd and returns its verdict
+func (c *ClamAV) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+// command sends a null terminated command to clamd, followed by the content of
+// r in length prefixed chunks when r is not nil, and returns the reply
+func (c *ClamAV) command(ctx context.Context, cmd string, r io.Reader) (string, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	w := bufio.NewWriter(conn)
+	w.WriteString("z" + cmd + "\x00")
+
+	if r != nil {
+		chunkSize := c.ChunkSize
+		if chunkSize <= 0 {
+			chunkSize = DefaultChunkSize
+		}
+		buf := make([]byte, chunkSize)
+		for {
+			n, err := r.Read(buf)
+			if n > 0 {
+				binary.Write(w, binary.BigEndian, uint32(n))
+				if _, err := w.Write(buf[:n]); err != nil {
+					return "", fmt.Errorf("cannot send file to clamd: %w", err)
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return "", err
+			}
+		}
+		// A zero length chunk ends the stream
+		binary.Write(w, binary.BigEndian, uint32(0))
+	}
+
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("cannot send file to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return "", fmt.Errorf("cannot read reply from clamd: %w", err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// parseReply turns a clamd INSTREAM reply such as "stream: OK" or
+// "stream: Eicar-Signature FOUND" into a Result
+func parseReply(reply string) (Result, error) {
+	status := strings.TrimPrefix(reply, "stream: ")
+
+	switch {
+	case status == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	case strings.HasSuffix(status, " ERROR"):
+		return Result{}, errors.New("clamd: " + strings.TrimSuffix(status, " ERROR"))
+	default:
+		return Result{}, fmt.Errorf("unexpected reply from clamd: %q", reply)
+	}
+}
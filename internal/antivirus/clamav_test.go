@@ -0,0 +1,150 @@
+package antivirus
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// eicar is the standard antivirus test file
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd answers PING and INSTREAM like clamd, reporting the EICAR test
+// file as infected and streams larger than maxSize as an error
+func fakeClamd(t *testing.T, network, address string, maxSize int) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveClamd(conn, maxSize)
+		}
+	}()
+}
+
+func serveClamd(conn net.Conn, maxSize int) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	cmd, err := r.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch cmd {
+	case "zPING\x00":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM\x00":
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, r, int64(size)); err != nil {
+				return
+			}
+		}
+		switch {
+		case data.Len() > maxSize:
+			conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+		case bytes.Contains(data.Bytes(), []byte(eicar)):
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		default:
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamAV(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcpAddr := l.Addr().String()
+	l.Close()
+
+	fakeClamd(t, "tcp", tcpAddr, 1<<20)
+	socket := filepath.Join(t.TempDir(), "clamd.sock")
+	fakeClamd(t, "unix", socket, 1<<20)
+
+	for _, addr := range []string{"tcp://" + tcpAddr, "unix://" + socket} {
+		c, err := NewClamAV(addr, 5*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.ChunkSize = 16 // exercise streaming in several chunks
+
+		if err := c.Ping(context.Background()); err != nil {
+			t.Errorf("%s: ping failed: %v", addr, err)
+		}
+
+		res, err := c.Scan(context.Background(), strings.NewReader("%PDF-1.4 harmless"))
+		if err != nil || res.Infected {
+			t.Errorf("%s: expected a clean result but got %+v (%v)", addr, res, err)
+		}
+
+		res, err = c.Scan(context.Background(), strings.NewReader("%PDF-1.4 "+eicar))
+		if err != nil || !res.Infected || res.Signature != "Eicar-Test-Signature" {
+			t.Errorf("%s: expected the EICAR signature but got %+v (%v)", addr, res, err)
+		}
+
+		if _, err := c.Scan(context.Background(), bytes.NewReader(make([]byte, 2<<20))); err == nil {
+			t.Errorf("%s: expected an error when clamd refuses the stream", addr)
+		}
+	}
+}
+
+func TestClamAV_Unreachable(t *testing.T) {
+	c, _ := NewClamAV(filepath.Join(t.TempDir(), "missing.sock"), time.Second)
+	if _, err := c.Scan(context.Background(), strings.NewReader("data")); err == nil {
+		t.Error("expected an error when clamd is not running")
+	}
+}
+
+func TestNewClamAV(t *testing.T) {
+	var tests = []struct {
+		addr    string
+		network string
+		address string
+	}{
+		{"tcp://127.0.0.1:3310", "tcp", "127.0.0.1:3310"},
+		{"clamav:3310", "tcp", "clamav:3310"},
+		{"unix:///run/clamd.sock", "unix", "/run/clamd.sock"},
+		{"/run/clamd.sock", "unix", "/run/clamd.sock"},
+	}
+
+	for _, e := range tests {
+		c, err := NewClamAV(e.addr, 0)
+		if err != nil {
+			t.Errorf("%s: %v", e.addr, err)
+			continue
+		}
+		if c.Network != e.network || c.Address != e.address {
+			t.Errorf("%s: expected %s %s but got %s %s", e.addr, e.network, e.address, c.Network, c.Address)
+		}
+	}
+
+	if _, err := NewClamAV("tcp://", 0); err == nil {
+		t.Error("expected an error for an empty address")
+	}
+}
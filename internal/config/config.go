@@ -2,19 +2,49 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
+	"net/http"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/chamrasilva89/reservationWeb/internal/antivirus"
+	"github.com/chamrasilva89/reservationWeb/internal/extract"
+	"github.com/chamrasilva89/reservationWeb/internal/invoice"
+	"github.com/chamrasilva89/reservationWeb/internal/mailer"
+	"github.com/chamrasilva89/reservationWeb/internal/payments"
+	"github.com/chamrasilva89/reservationWeb/internal/pricing"
+	"github.com/chamrasilva89/reservationWeb/internal/risk"
+	"github.com/chamrasilva89/reservationWeb/internal/thumbnail"
 )
 
+// FileUploadPath is the default directory for uploaded customer files
 const FileUploadPath = "E:\\GO\\FF\\"
 
 // Appconfig hold the app config
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
+	Logger        *slog.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
+	DemoMode      bool                  // keep all data in memory instead of connecting to the database
+	UploadPath    string                // directory uploaded customer files are stored in
+	Scanner       antivirus.Scanner     // checks uploads for malware, nil when no scanner is configured
+	PDFRenderer   thumbnail.PDFRenderer // renders PDF previews, nil shows a placeholder instead
+	OCR           extract.OCR           // reads text from scanned documents, nil disables extraction
+	RiskRules     *risk.Engine          // scores customers' AML risk, nil uses risk.Default
+
+	SanctionsThreshold float64 // lowest name match score reported as a sanctions hit, 0 uses the default
+
+	Mailer  mailer.Mailer // sends email to guests, nil disables email
+	BaseURL string        // public URL of the site, used for links in email
+	Taxes   []invoice.Tax // charged on stays, nil uses invoice.DefaultTaxes
+
+	Payments          payments.Provider // takes payments for reservations, nil disables online payment
+	PaymentsPublicKey string            // identifies the site to the provider's script in the guest's browser
+
+	LinkSecret   []byte                      // signs the links guests manage their reservations with
+	Cancellation *pricing.CancellationPolicy // what guests pay for cancelling, nil uses pricing.DefaultCancellationPolicy
+
+	CalendarClient *http.Client // reads the external calendars of rooms, nil uses ical.DefaultClient
+	CalendarDir    string       // holds the calendar files rooms can import, empty allows only addresses
 }
@@ -0,0 +1,457 @@
+// Package dossier lays out the KYC dossier of a customer, the one document
+// auditors get with everything recorded about it, as a PDF
+package dossier
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/pdf"
+)
+
+// Dossier is everything that goes into a customer's dossier
+type Dossier struct {
+	Customer     models.Customer
+	TradeLicense *models.TradeLicense // nil until one is recorded
+	Partners     []models.TradeLicenseHolder
+	Memorandum   []models.Memorandum
+	Risk         models.RiskAssessment
+	Reviews      []models.KYCReview
+	Hits         []models.SanctionsHit
+	Images       []Image
+
+	GeneratedBy string
+	GeneratedAt time.Time
+}
+
+// Image is a copy of an uploaded document. Image is nil when the file can't
+// be shown, with Note saying why.
+type Image struct {
+	Caption string
+	Image   image.Image
+	Note    string
+}
+
+// ExpiringDays is how many days before it expires a document is flagged
+const ExpiringDays = 30
+
+// Expiry states of documents
+const (
+	ExpiryValid       = "Valid"
+	ExpiryExpiring    = "Expiring soon"
+	ExpiryExpired     = "Expired"
+	ExpiryNotRecorded = "Not recorded"
+)
+
+// Expiry is the expiry status of one document
+type Expiry struct {
+	Document string
+	Holder   string
+	Expires  time.Time
+	Status   string
+}
+
+// Expiries lists the expiry status of the customer's documents as of now
+func (d Dossier) Expiries(now time.Time) []Expiry {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	status := func(t time.Time) string {
+		switch {
+		case t.IsZero():
+			return ExpiryNotRecorded
+		case t.Before(today):
+			return ExpiryExpired
+		case t.Before(today.AddDate(0, 0, ExpiringDays)):
+			return ExpiryExpiring
+		}
+		return ExpiryValid
+	}
+
+	var expiries []Expiry
+	if tl := d.TradeLicense; tl != nil {
+		expiries = append(expiries, Expiry{"Trade license " + tl.TradeLicenseNo, d.Customer.CustomerName, tl.LicenseExpiry, status(tl.LicenseExpiry)})
+	}
+	for _, p := range d.Partners {
+		expiries = append(expiries,
+			Expiry{"Emirates ID " + p.ShEmirateID, p.ShareHolderName, p.ShEmIDExp, status(p.ShEmIDExp)},
+			Expiry{"Passport " + p.ShPassport, p.ShareHolderName, p.ShPassportExp, status(p.ShPassportExp)})
+	}
+	for _, rep := range d.Memorandum {
+		if rep.RepEmID != "" || !rep.RepEmIDExp.IsZero() {
+			expiries = append(expiries, Expiry{"Emirates ID " + rep.RepEmID, rep.RepresentativeName, rep.RepEmIDExp, status(rep.RepEmIDExp)})
+		}
+		if rep.RepPassport != "" || !rep.RepPassportExp.IsZero() {
+			expiries = append(expiries, Expiry{"Passport " + rep.RepPassport, rep.RepresentativeName, rep.RepPassportExp, status(rep.RepPassportExp)})
+		}
+	}
+	return expiries
+}
+
+// Layout of the pages, in points
+const (
+	margin      = 50.0
+	top         = 80.0 // where the content of a page starts, under the header
+	bottom      = pdf.PageHeight - 60
+	width       = pdf.PageWidth - 2*margin
+	fontSize    = 9.0
+	lineHeight  = 12.0
+	cellPadding = 3.0
+)
+
+// Write writes the dossier as a PDF
+func Write(w io.Writer, d Dossier) error {
+	l := &layout{doc: pdf.New(), d: d}
+	l.doc.Title = "KYC dossier of " + d.Customer.CustomerName
+	l.doc.Created = d.GeneratedAt
+	l.newPage()
+
+	c := d.Customer
+	l.heading("Customer profile")
+	l.fields([][2]string{
+		{"Customer code", c.CustomerCode},
+		{"Customer name", c.CustomerName},
+		{"Status", c.Status},
+		{"Business name", c.BusinessName},
+		{"Nature of business", c.NatureOfBusiness},
+		{"Location", c.LocationDetails},
+		{"Contact person", c.ContactPerson},
+		{"Phone", c.ContactNo},
+		{"Mobile", c.MobileNo},
+		{"Email", c.Email},
+		{"Marketer", strings.TrimSpace(c.MarketerName + " " + c.MarketedBy)},
+		{"Customer since", date(c.CreatedAt)},
+	})
+
+	l.heading("Trade license")
+	if tl := d.TradeLicense; tl != nil {
+		l.fields([][2]string{
+			{"License number", tl.TradeLicenseNo},
+			{"Emirate", tl.Emirate},
+			{"MOHRE number", tl.MohreNo},
+			{"Trade name", tl.TradeName},
+			{"Legal status", tl.LegalStatus},
+			{"Established", date(tl.EstablishDate)},
+			{"Registered", date(tl.RegistrationDate)},
+			{"Expires", date(tl.LicenseExpiry)},
+		})
+	} else {
+		l.paragraph("No trade license is recorded.")
+	}
+
+	l.heading("Shareholders")
+	l.capTable()
+
+	l.heading("Memorandum representatives")
+	if len(d.Memorandum) == 0 {
+		l.paragraph("No representative is recorded.")
+	} else {
+		rows := make([][]string, len(d.Memorandum))
+		for i, rep := range d.Memorandum {
+			rows[i] = []string{rep.RepresentativeName, rep.RepNoOfShares, rep.RepEmID, rep.RepPassport}
+		}
+		l.table([]string{"Name", "Shares", "Emirates ID", "Passport"}, []float64{0.4, 0.15, 0.25, 0.2}, rows)
+	}
+
+	l.heading("Document expiry")
+	expiries := d.Expiries(d.GeneratedAt)
+	if len(expiries) == 0 {
+		l.paragraph("No document with an expiry date is recorded.")
+	} else {
+		rows := make([][]string, len(expiries))
+		for i, e := range expiries {
+			rows[i] = []string{e.Document, e.Holder, date(e.Expires), e.Status}
+		}
+		l.table([]string{"Document", "Holder", "Expires", "Status"}, []float64{0.35, 0.3, 0.15, 0.2}, rows)
+	}
+
+	l.heading("Risk and compliance")
+	l.compliance()
+
+	if len(d.Images) > 0 {
+		l.heading("Document copies")
+		for _, img := range d.Images {
+			l.image(img)
+		}
+	}
+
+	l.footers()
+	_, err := l.doc.WriteTo(w)
+	return err
+}
+
+// layout draws the dossier top to bottom, starting new pages as they fill up
+type layout struct {
+	doc *pdf.Document
+	d   Dossier
+	y   float64
+}
+
+func (l *layout) newPage() {
+	l.doc.AddPage()
+	l.doc.SetColor(0, 0, 0)
+	l.doc.SetFont(pdf.HelveticaBold, 14)
+	l.doc.Text(margin, 45, "KYC dossier")
+	l.doc.SetFont(pdf.Helvetica, fontSize)
+	name := l.d.Customer.CustomerCode + "  " + l.d.Customer.CustomerName
+	l.doc.Text(pdf.PageWidth-margin-l.doc.TextWidth(name), 45, name)
+	l.doc.Line(margin, 55, pdf.PageWidth-margin, 55)
+	l.y = top
+}
+
+// need starts a new page unless h points are left on this one
+func (l *layout) need(h float64) {
+	if l.y+h > bottom {
+		l.newPage()
+	}
+}
+
+func (l *layout) heading(s string) {
+	l.need(3*lineHeight + 10)
+	l.y += 10
+	l.doc.SetColor(0, 0, 0)
+	l.doc.SetFont(pdf.HelveticaBold, 12)
+	l.doc.Text(margin, l.y, s)
+	l.y += 6
+	l.doc.Line(margin, l.y, margin+width, l.y)
+	l.y += lineHeight + 2
+}
+
+func (l *layout) paragraph(s string) {
+	l.doc.SetFont(pdf.Helvetica, fontSize)
+	for _, line := range l.wrap(s, width) {
+		l.need(lineHeight)
+		l.doc.Text(margin, l.y, line)
+		l.y += lineHeight
+	}
+}
+
+// fields draws labelled values in two columns, leaving out empty values
+func (l *layout) fields(fields [][2]string) {
+	const labelWidth = 130.0
+	for _, f := range fields {
+		if strings.TrimSpace(f[1]) == "" {
+			continue
+		}
+		l.doc.SetFont(pdf.Helvetica, fontSize)
+		lines := l.wrap(f[1], width-labelWidth)
+		l.need(float64(len(lines)) * lineHeight)
+
+		l.doc.SetColor(90, 90, 90)
+		l.doc.Text(margin, l.y, f[0])
+		l.doc.SetColor(0, 0, 0)
+		for _, line := range lines {
+			l.doc.Text(margin+labelWidth, l.y, line)
+			l.y += lineHeight
+		}
+	}
+}
+
+// table draws rows under a header, columns getting the given shares of the
+// page width. The header is drawn again on every page the table continues on.
+func (l *layout) table(headers []string, shares []float64, rows [][]string) {
+	widths := make([]float64, len(shares))
+	for i, s := range shares {
+		widths[i] = s * width
+	}
+
+	drawRow := func(cells []string, header bool) {
+		font := pdf.Helvetica
+		if header {
+			font = pdf.HelveticaBold
+		}
+		l.doc.SetFont(font, fontSize)
+		wrapped := make([][]string, len(cells))
+		lines := 1
+		for i, cell := range cells {
+			wrapped[i] = l.wrap(cell, widths[i]-2*cellPadding)
+			lines = max(lines, len(wrapped[i]))
+		}
+		h := float64(lines)*lineHeight + cellPadding
+
+		if header {
+			l.doc.SetColor(230, 230, 230)
+			l.doc.FillRect(margin, l.y-lineHeight+cellPadding, width, h)
+		}
+		l.doc.SetColor(0, 0, 0)
+		x := margin
+		for i, cell := range wrapped {
+			for j, line := range cell {
+				l.doc.Text(x+cellPadding, l.y+float64(j)*lineHeight, line)
+			}
+			x += widths[i]
+		}
+		l.doc.SetColor(200, 200, 200)
+		l.doc.Line(margin, l.y-lineHeight+cellPadding+h, margin+width, l.y-lineHeight+cellPadding+h)
+		l.y += h
+	}
+
+	l.need(2 * lineHeight)
+	drawRow(headers, true)
+	for _, row := range rows {
+		l.doc.SetFont(pdf.Helvetica, fontSize)
+		lines := 1
+		for i, cell := range row {
+			lines = max(lines, len(l.wrap(cell, widths[i]-2*cellPadding)))
+		}
+		if l.y+float64(lines)*lineHeight > bottom {
+			l.newPage()
+			drawRow(headers, true)
+		}
+		drawRow(row, false)
+	}
+	l.y += lineHeight / 2
+}
+
+// capTable draws the shareholders with their share of the company, when the
+// numbers of shares are recorded
+func (l *layout) capTable() {
+	if len(l.d.Partners) == 0 {
+		l.paragraph("No shareholder is recorded.")
+		return
+	}
+
+	total := 0
+	for _, p := range l.d.Partners {
+		total += p.ShNoOfShares
+	}
+	rows := make([][]string, 0, len(l.d.Partners)+1)
+	for _, p := range l.d.Partners {
+		shares, percent := "", ""
+		if p.ShNoOfShares > 0 {
+			shares = strconv.Itoa(p.ShNoOfShares)
+		}
+		if total > 0 {
+			percent = fmt.Sprintf("%.2f%%", float64(p.ShNoOfShares)*100/float64(total))
+		}
+		rows = append(rows, []string{p.ShareHolderName, p.ShareHolderRole, p.ShNationality, shares, percent})
+	}
+	if total > 0 {
+		rows = append(rows, []string{"Total", "", "", strconv.Itoa(total), "100.00%"})
+	}
+	l.table([]string{"Name", "Role", "Nationality", "Shares", "Ownership"}, []float64{0.32, 0.18, 0.22, 0.14, 0.14}, rows)
+}
+
+func (l *layout) compliance() {
+	if r := l.d.Risk; r.Rating != "" {
+		l.fields([][2]string{{"AML risk rating", fmt.Sprintf("%s, score %d, assessed %s", r.Rating, r.Score, date(r.AssessedAt))}})
+		for _, f := range r.Factors {
+			l.fields([][2]string{{"", fmt.Sprintf("%s (+%d) %s", f.Description, f.Points, f.Detail)}})
+		}
+	} else {
+		l.fields([][2]string{{"AML risk rating", "Not assessed"}})
+	}
+
+	if len(l.d.Hits) == 0 {
+		l.fields([][2]string{{"Sanctions screening", "No potential match"}})
+	} else {
+		rows := make([][]string, len(l.d.Hits))
+		for i, h := range l.d.Hits {
+			rows[i] = []string{h.Name, h.ListedName + " (" + strings.ToUpper(h.Source) + ")", fmt.Sprintf("%.0f%%", h.Score*100), h.State}
+		}
+		l.y += lineHeight / 2
+		l.table([]string{"Screened name", "Listed as", "Score", "State"}, []float64{0.3, 0.4, 0.1, 0.2}, rows)
+	}
+
+	if len(l.d.Reviews) > 0 {
+		rows := make([][]string, len(l.d.Reviews))
+		for i, rv := range l.d.Reviews {
+			rows[i] = []string{rv.RecordLabel(), rv.State, date(rv.UpdatedAt), rv.Comment}
+		}
+		l.y += lineHeight / 2
+		l.table([]string{"KYC review", "State", "Updated", "Comment"}, []float64{0.3, 0.15, 0.15, 0.4}, rows)
+	}
+}
+
+// image draws a document copy at most half a page high, with its caption
+func (l *layout) image(img Image) {
+	const maxHeight = 300.0
+
+	l.doc.SetFont(pdf.HelveticaBold, fontSize)
+	if img.Image == nil {
+		l.need(2 * lineHeight)
+		l.doc.Text(margin, l.y, img.Caption)
+		l.doc.SetFont(pdf.Helvetica, fontSize)
+		l.doc.Text(margin+l.doc.TextWidth(img.Caption+"  ")+10, l.y, img.Note)
+		l.y += 1.5 * lineHeight
+		return
+	}
+
+	b := img.Image.Bounds()
+	w, h := width, width*float64(b.Dy())/float64(b.Dx())
+	if h > maxHeight {
+		w, h = maxHeight*float64(b.Dx())/float64(b.Dy()), maxHeight
+	}
+	l.need(h + 2*lineHeight)
+	l.doc.Text(margin, l.y, img.Caption)
+	l.y += 6
+	if err := l.doc.Image(img.Image, margin, l.y, w, h); err != nil {
+		l.doc.SetFont(pdf.Helvetica, fontSize)
+		l.doc.Text(margin, l.y+lineHeight, "The copy can't be shown: "+err.Error())
+		h = 2 * lineHeight
+	}
+	l.y += h + lineHeight
+}
+
+// footers numbers the pages and says when the dossier was generated and by whom
+func (l *layout) footers() {
+	n := l.doc.PageCount()
+	generated := "Generated " + l.d.GeneratedAt.Format("02 Jan 2006 15:04")
+	if l.d.GeneratedBy != "" {
+		generated += " by " + l.d.GeneratedBy
+	}
+	for i := 1; i <= n; i++ {
+		l.doc.SetPage(i)
+		l.doc.SetColor(120, 120, 120)
+		l.doc.SetFont(pdf.Helvetica, 8)
+		l.doc.Line(margin, pdf.PageHeight-45, pdf.PageWidth-margin, pdf.PageHeight-45)
+		l.doc.Text(margin, pdf.PageHeight-32, generated)
+		page := fmt.Sprintf("Page %d of %d", i, n)
+		l.doc.Text(pdf.PageWidth-margin-l.doc.TextWidth(page), pdf.PageHeight-32, page)
+	}
+}
+
+// wrap breaks text into lines no wider than w in the current font. Words
+// longer than a line are broken anywhere.
+func (l *layout) wrap(text string, w float64) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			for l.doc.TextWidth(word) > w {
+				n := len([]rune(word))
+				for n > 1 && l.doc.TextWidth(string([]rune(word)[:n])) > w {
+					n--
+				}
+				if line != "" {
+					lines = append(lines, line)
+					line = ""
+				}
+				lines = append(lines, string([]rune(word)[:n]))
+				word = string([]rune(word)[n:])
+			}
+			switch {
+			case line == "":
+				line = word
+			case l.doc.TextWidth(line+" "+word) <= w:
+				line += " " + word
+			default:
+				lines = append(lines, line)
+				line = word
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// date formats a date for the dossier, leaving dates that were never set empty
+func date(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("02 Jan 2006")
+}
@@ -0,0 +1,68 @@
+package dossier
+
+import (
+	"bytes"
+	"image"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+func TestExpiries(t *testing.T) {
+	now := time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC)
+	d := Dossier{
+		Customer:     models.Customer{CustomerName: "Alpha Trading"},
+		TradeLicense: &models.TradeLicense{TradeLicenseNo: "123456", LicenseExpiry: now.AddDate(1, 0, 0)},
+		Partners: []models.TradeLicenseHolder{{
+			ShareHolderName: "Jane", ShEmirateID: "784-1", ShEmIDExp: now.AddDate(0, 0, 10), ShPassport: "P1",
+		}},
+		Memorandum: []models.Memorandum{{RepresentativeName: "Joe", RepPassport: "P2", RepPassportExp: now.AddDate(0, 0, -1)}},
+	}
+
+	want := []string{ExpiryValid, ExpiryExpiring, ExpiryNotRecorded, ExpiryExpired}
+	got := d.Expiries(now)
+	if len(got) != len(want) {
+		t.Fatalf("expected %d documents, got %+v", len(want), got)
+	}
+	for i, e := range got {
+		if e.Status != want[i] {
+			t.Errorf("expected %s to be %q, got %q", e.Document, want[i], e.Status)
+		}
+	}
+	if got[1].Holder != "Jane" || got[3].Document != "Passport P2" {
+		t.Errorf("unexpected documents %+v", got)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	d := Dossier{
+		Customer:    models.Customer{CustomerCode: "C001", CustomerName: "Alpha Trading"},
+		GeneratedBy: "Admin",
+		GeneratedAt: time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC),
+		Images: []Image{
+			{Caption: "Passport of Jane", Image: image.NewRGBA(image.Rect(0, 0, 300, 200))},
+			{Caption: "Emirates ID of Jane", Note: "The file is not an image"},
+		},
+	}
+	for i := 0; i < 80; i++ {
+		d.Partners = append(d.Partners, models.TradeLicenseHolder{ShareHolderName: "Partner", ShNoOfShares: 10})
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, d); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "%PDF-") || !strings.HasSuffix(out, "%%EOF\n") {
+		t.Fatal("expected a PDF file")
+	}
+	// 80 shareholders don't fit on the first page
+	if strings.Contains(out, "/Count 1 >>") || !strings.Contains(out, "/Subtype /Image") {
+		t.Error("expected several pages with an image")
+	}
+	if !strings.Contains(out, "/Title (KYC dossier of Alpha Trading)") {
+		t.Error("expected the dossier's title")
+	}
+}
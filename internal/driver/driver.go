@@ -1,66 +1,158 @@
 package driver
 
 import (
-    "database/sql"
-    "time"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
 
-    _ "github.com/jackc/pgconn"
-    _ "github.com/jackc/pgx/v4"
-    _ "github.com/jackc/pgx/v4/stdlib"
+	_ "github.com/jackc/pgconn"
+	_ "github.com/jackc/pgx/v4"
+	_ "github.com/jackc/pgx/v4/stdlib"
 )
 
 // DB represents the database connection.
 type DB struct {
-    SQL *sql.DB
+	SQL *sql.DB
+
+	// Replica is an optional read-only connection used for list and report
+	// queries. It is nil when no replica is configured.
+	Replica *sql.DB
 }
 
-// dbConn is a singleton instance of the DB type.
-var dbConn = &DB{}
-
-// Constants for configuring the database connection.
-const maxOpenDBConn = 15
-const maxIdleDBConn = 5
-const maxDBLifeTime = 10 * time.Minute
-
-// ConnectSQL connects to the PostgreSQL database and returns a DB object.
-func ConnectSQL(dsn string) (*DB, error) {
-	/*Inside ConnectSQL, a new database connection is created using the NewDatabase function. The connection is then configured with the specified maximum connection settings.*/
-    d, err := NewDatabase(dsn)
-    if err != nil {
-        panic(err)
-    }
-    d.SetMaxOpenConns(maxOpenDBConn)
-    d.SetMaxIdleConns(maxIdleDBConn)
-    d.SetConnMaxIdleTime(maxDBLifeTime)
-
-    dbConn.SQL = d
-
-    err = testDB(d)
-    if err != nil {
-        return nil, err
-    }
-    return dbConn, nil
+// Reader returns the connection to use for read-only queries that can tolerate
+// replication lag: the replica if there is one, otherwise the primary.
+func (d *DB) Reader() *sql.DB {
+	if d.Replica != nil {
+		return d.Replica
+	}
+	return d.SQL
 }
 
-// testDB pings the database to check if it's reachable.
-func testDB(d *sql.DB) error {
-    err := d.Ping()
-    if err != nil {
-        return err
-    }
-    return nil
+// Close closes the primary and replica connection pools.
+func (d *DB) Close() error {
+	err := d.SQL.Close()
+	if d.Replica != nil {
+		if rerr := d.Replica.Close(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// Config holds the settings for connecting to the database.
+type Config struct {
+	DSN        string
+	ReplicaDSN string // optional
+
+	// Pool settings, applied to the primary and the replica
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+
+	// ConnectRetries is how many times a failed connection is retried at
+	// startup, waiting RetryBackoff before the first retry and doubling the
+	// wait after each one, up to MaxRetryBackoff
+	ConnectRetries  int
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	PingTimeout     time.Duration
+
+	Logger *slog.Logger // optional, logs failed attempts
+}
+
+// DefaultConfig returns the default pool and retry settings for dsn.
+func DefaultConfig(dsn string) Config {
+	return Config{
+		DSN:             dsn,
+		MaxOpenConns:    15,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: 30 * time.Minute,
+		ConnMaxIdleTime: 10 * time.Minute,
+		ConnectRetries:  5,
+		RetryBackoff:    500 * time.Millisecond,
+		MaxRetryBackoff: 10 * time.Second,
+		PingTimeout:     5 * time.Second,
+	}
+}
+
+// ConnectSQL connects to the PostgreSQL database, and the replica if one is
+// configured, retrying with backoff while the database is unreachable.
+func ConnectSQL(cfg Config) (*DB, error) {
+	primary, err := connectWithRetry(cfg, cfg.DSN, "primary")
+	if err != nil {
+		return nil, err
+	}
+
+	d := &DB{SQL: primary}
+	if cfg.ReplicaDSN != "" {
+		d.Replica, err = connectWithRetry(cfg, cfg.ReplicaDSN, "replica")
+		if err != nil {
+			primary.Close()
+			return nil, err
+		}
+	}
+
+	return d, nil
 }
 
-// NewDatabase creates a new SQL database connection and pings it.
-func NewDatabase(dsn string) (*sql.DB, error) {
-    db, err := sql.Open("pgx", dsn)
-    if err != nil {
-        return nil, err
-    }
+// connectWithRetry opens a pool for dsn and pings it until it answers or the retries run out.
+func connectWithRetry(cfg Config, dsn, name string) (*sql.DB, error) {
+	backoff := cfg.RetryBackoff
+	var err error
+
+	for attempt := 0; attempt <= cfg.ConnectRetries; attempt++ {
+		if attempt > 0 {
+			if cfg.Logger != nil {
+				cfg.Logger.Warn("cannot connect to the database, retrying",
+					"database", name, "attempt", attempt, "wait", backoff.String(), "error", err)
+			}
+			time.Sleep(backoff)
+			backoff *= 2
+			if cfg.MaxRetryBackoff > 0 && backoff > cfg.MaxRetryBackoff {
+				backoff = cfg.MaxRetryBackoff
+			}
+		}
+
+		var d *sql.DB
+		d, err = NewDatabase(dsn, cfg)
+		if err == nil {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("cannot connect to the %s database after %d attempts: %w", name, cfg.ConnectRetries+1, err)
+}
 
-    if err = db.Ping(); err != nil {
-        return nil, err
-    }
+// NewDatabase creates a new SQL database connection pool with the pool settings of cfg and pings it.
+func NewDatabase(dsn string, cfg Config) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
 
-    return db, nil
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+
+	if err = testDB(db, cfg.PingTimeout); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// testDB pings the database to check if it's reachable.
+func testDB(d *sql.DB, timeout time.Duration) error {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return d.PingContext(ctx)
 }
@@ -0,0 +1,41 @@
+package driver
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectSQL_RetriesThenFails(t *testing.T) {
+	cfg := DefaultConfig("host=127.0.0.1 port=1 dbname=none user=none connect_timeout=1")
+	cfg.ConnectRetries = 2
+	cfg.RetryBackoff = 10 * time.Millisecond
+	cfg.MaxRetryBackoff = 15 * time.Millisecond
+
+	start := time.Now()
+	_, err := ConnectSQL(cfg)
+	if err == nil {
+		t.Fatal("expected an error connecting to a closed port")
+	}
+	if !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("expected the error to report 3 attempts, got %v", err)
+	}
+	if time.Since(start) < 25*time.Millisecond {
+		t.Error("expected connect to back off between attempts")
+	}
+}
+
+func TestDB_Reader(t *testing.T) {
+	primary, replica := &sql.DB{}, &sql.DB{}
+
+	d := &DB{SQL: primary}
+	if d.Reader() != primary {
+		t.Error("expected reads to go to the primary without a replica")
+	}
+
+	d.Replica = replica
+	if d.Reader() != replica {
+		t.Error("expected reads to go to the replica")
+	}
+}
@@ -0,0 +1,135 @@
+// Package export writes tables of records as CSV or Excel files, one row at a
+// time, so large exports are streamed to the client instead of built in memory
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Formats files can be exported in
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Column is a column of an exported table of T
+type Column[T any] struct {
+	Key    string // names the column in the columns parameter
+	Header string
+	Value  func(T) string
+}
+
+// Select returns the columns named in keys, a comma separated list, in the
+// order given. An empty list selects all columns.
+func Select[T any](all []Column[T], keys string) ([]Column[T], error) {
+	if strings.TrimSpace(keys) == "" {
+		return all, nil
+	}
+
+	byKey := make(map[string]Column[T], len(all))
+	for _, c := range all {
+		byKey[c.Key] = c
+	}
+
+	var selected []Column[T]
+	seen := make(map[string]bool)
+	for _, k := range strings.Split(keys, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" || seen[k] {
+			continue
+		}
+		c, ok := byKey[k]
+		if !ok {
+			return nil, fmt.Errorf("unknown column %q", k)
+		}
+		seen[k] = true
+		selected = append(selected, c)
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no columns selected")
+	}
+	return selected, nil
+}
+
+// Headers returns the header row of columns
+func Headers[T any](columns []Column[T]) []string {
+	headers := make([]string, len(columns))
+	for i, c := range columns {
+		headers[i] = c.Header
+	}
+	return headers
+}
+
+// Row returns the values of the columns for one record
+func Row[T any](columns []Column[T], record T) []string {
+	row := make([]string, len(columns))
+	for i, c := range columns {
+		row[i] = c.Value(record)
+	}
+	return row
+}
+
+// Writer writes the rows of an exported file. Close must be called after the
+// last row to complete the file.
+type Writer interface {
+	Write(row []string) error
+	Close() error
+}
+
+// New returns a writer for format, FormatCSV or FormatXLSX. Excel files get a
+// single sheet named sheet.
+func New(format string, w io.Writer, sheet string) (Writer, error) {
+	switch format {
+	case FormatCSV:
+		return NewCSV(w), nil
+	case FormatXLSX:
+		return NewXLSX(w, sheet)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// ContentType returns the media type of files in format
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// csvWriter writes CSV files that open correctly in Excel
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// NewCSV returns a writer of CSV files. The file starts with a byte order
+// mark so Excel reads it as UTF-8, and values that spreadsheets would run as
+// formulas are quoted.
+func NewCSV(w io.Writer) Writer {
+	io.WriteString(w, "\uFEFF")
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+func (c *csvWriter) Write(row []string) error {
+	safe := make([]string, len(row))
+	for i, v := range row {
+		safe[i] = defuse(v)
+	}
+	return c.w.Write(safe)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// defuse prefixes values starting like a spreadsheet formula with a quote, so
+// a name like =HYPERLINK(...) entered by a customer is shown instead of run
+func defuse(v string) string {
+	if v != "" && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
@@ -0,0 +1,129 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"strings"
+	"testing"
+)
+
+type person struct {
+	Name  string
+	Phone string
+}
+
+var personColumns = []Column[person]{
+	{Key: "name", Header: "Name", Value: func(p person) string { return p.Name }},
+	{Key: "phone", Header: "Phone", Value: func(p person) string { return p.Phone }},
+}
+
+func TestSelect(t *testing.T) {
+	all, err := Select(personColumns, "")
+	if err != nil || len(all) != 2 {
+		t.Fatalf("expected all columns, got %d (%v)", len(all), err)
+	}
+
+	cols, err := Select(personColumns, " phone,name,phone ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := Headers(cols); len(h) != 2 || h[0] != "Phone" || h[1] != "Name" {
+		t.Errorf("expected the columns in the order asked for, got %q", h)
+	}
+
+	for _, keys := range []string{"name,age", ",,"} {
+		if _, err := Select(personColumns, keys); err == nil {
+			t.Errorf("expected an error for %q", keys)
+		}
+	}
+}
+
+func TestCSV(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSV(&buf)
+	w.Write(Headers(personColumns))
+	w.Write(Row(personColumns, person{Name: "=HYPERLINK(\"http://x\")", Phone: "+971501234567"}))
+	w.Write(Row(personColumns, person{Name: "Smith, John", Phone: "0501234567"}))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(buf.Bytes(), []byte("\uFEFF")) {
+		t.Error("expected the file to start with a byte order mark")
+	}
+	records, err := csv.NewReader(strings.NewReader(strings.TrimPrefix(buf.String(), "\uFEFF"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records but got %d", len(records))
+	}
+	if records[1][0] != `'=HYPERLINK("http://x")` || records[1][1] != "'+971501234567" {
+		t.Errorf("expected formulas to be defused, got %q", records[1])
+	}
+	if records[2][0] != "Smith, John" || records[2][1] != "0501234567" {
+		t.Errorf("expected plain values to be kept, got %q", records[2])
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := New(FormatXLSX, &buf, "People: all [2026]")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(Headers(personColumns))
+	w.Write(Row(personColumns, person{Name: "Ali & <Sons>", Phone: "0501234567"}))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("expected a valid zip file: %v", err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(b)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("expected the workbook to contain %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `name="People  all  2026 "`) {
+		t.Errorf("expected the sheet name to be cleaned, got %s", parts["xl/workbook.xml"])
+	}
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<c r="A1" t="inlineStr" s="1"><is><t xml:space="preserve">Name</t></is></c>`,
+		`<c r="A2" t="inlineStr"><is><t xml:space="preserve">Ali &amp; &lt;Sons&gt;</t></is></c>`,
+		`<c r="B2" t="inlineStr"><is><t xml:space="preserve">0501234567</t></is></c>`,
+		`</sheetData></worksheet>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("expected the sheet to contain %s, got %s", want, sheet)
+		}
+	}
+
+	if _, err := New("pdf", &buf, ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d): expected %s but got %s", i, want, got)
+		}
+	}
+}
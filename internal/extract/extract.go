@@ -0,0 +1,213 @@
+// Package extract reads KYC fields such as passport and Emirates ID numbers
+// from scanned documents, to prefill forms for staff to confirm
+package extract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg" // register the decoders for scanned documents
+	_ "image/png"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/thumbnail"
+)
+
+// Kinds of documents fields can be extracted from
+const (
+	Passport     = "passport"
+	EmiratesID   = "emirates_id"
+	TradeLicense = "trade_license"
+)
+
+// ocrSize is the longest side PDF pages are rendered at for OCR
+const ocrSize = 2400
+
+// Errors returned by Extract
+var (
+	ErrUnsupported = errors.New("fields can't be extracted from this document")
+	ErrNoOCR       = errors.New("no OCR backend is configured")
+)
+
+// OCR turns the picture of a document into text
+type OCR interface {
+	Recognize(ctx context.Context, img image.Image) (string, error)
+}
+
+// Proposal holds the values read from a document. Fields that could not be
+// read are left empty; nothing here is trusted until staff confirm it.
+type Proposal struct {
+	Kind           string
+	Source         string // mrz when read from a machine readable zone, text otherwise
+	Name           string
+	DocumentNumber string // passport or trade license number
+	EmiratesID     string // formatted as 784-YYYY-NNNNNNN-C
+	Nationality    string // ISO 3166 alpha-3 code
+	BirthDate      time.Time
+	ExpiryDate     time.Time
+	TradeName      string
+	Warnings       []string
+}
+
+// Extractor reads documents with an OCR backend and parses the fields out of the text
+type Extractor struct {
+	OCR OCR
+	PDF thumbnail.PDFRenderer // renders PDF scans for OCR, nil means PDFs are unsupported
+}
+
+// Extract reads the fields of a document of the given kind from r, a JPEG,
+// PNG or PDF file of contentType
+func (e *Extractor) Extract(ctx context.Context, r io.Reader, contentType, kind string) (Proposal, error) {
+	if kind != Passport && kind != EmiratesID && kind != TradeLicense {
+		return Proposal{}, ErrUnsupported
+	}
+	if e.OCR == nil {
+		return Proposal{}, ErrNoOCR
+	}
+
+	img, err := e.image(ctx, r, contentType)
+	if err != nil {
+		return Proposal{}, err
+	}
+
+	text, err := e.OCR.Recognize(ctx, img)
+	if err != nil {
+		return Proposal{}, fmt.Errorf("ocr: %w", err)
+	}
+
+	return Parse(text, kind), nil
+}
+
+// image decodes a scanned image, or renders the first page of a PDF
+func (e *Extractor) image(ctx context.Context, r io.Reader, contentType string) (image.Image, error) {
+	switch contentType {
+	case "image/jpeg", "image/png":
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		if cfg.Width*cfg.Height > thumbnail.MaxPixels {
+			return nil, ErrUnsupported
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		return img, err
+	case "application/pdf":
+		if e.PDF == nil {
+			return nil, ErrUnsupported
+		}
+		// The renderer works on files, so copy the upload to a temporary one
+		tmp, err := os.CreateTemp("", "extract-*.pdf")
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = io.Copy(tmp, r)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, err
+		}
+		return e.PDF.RenderFirstPage(ctx, tmp.Name(), ocrSize)
+	default:
+		return nil, ErrUnsupported
+	}
+}
+
+// Parse extracts the fields of a document of the given kind from its OCR text
+func Parse(text, kind string) Proposal {
+	p := Proposal{Kind: kind, Source: "text"}
+
+	switch kind {
+	case Passport:
+		if m, err := ParseMRZ(text); err == nil && m.Format == "TD3" {
+			p.fromMRZ(m)
+			p.DocumentNumber = m.DocumentNumber
+		} else {
+			p.Warnings = append(p.Warnings, "The passport's machine readable zone could not be read.")
+		}
+
+	case EmiratesID:
+		if m, err := ParseMRZ(text); err == nil && m.Format == "TD1" {
+			p.fromMRZ(m)
+			p.EmiratesID = FormatEmiratesID(m.OptionalData)
+		}
+		// The front of the card has the number printed, but no zone
+		if p.EmiratesID == "" {
+			if match := emiratesIDText.FindStringSubmatch(text); match != nil {
+				p.EmiratesID = FormatEmiratesID(match[1] + match[2] + match[3] + match[4])
+			}
+		}
+		if p.ExpiryDate.IsZero() {
+			p.ExpiryDate = findDate(expiryText, text)
+		}
+
+	case TradeLicense:
+		if match := licenseNoText.FindStringSubmatch(text); match != nil {
+			p.DocumentNumber = match[1]
+		}
+		if match := tradeNameText.FindStringSubmatch(text); match != nil {
+			p.TradeName = strings.TrimSpace(match[1])
+		}
+		p.ExpiryDate = findDate(expiryText, text)
+	}
+
+	return p
+}
+
+// fromMRZ copies the fields shared by all zones
+func (p *Proposal) fromMRZ(m MRZ) {
+	p.Source = "mrz"
+	p.Name = strings.TrimSpace(m.GivenNames + " " + m.Surname)
+	p.Nationality = m.Nationality
+	p.BirthDate = m.BirthDate
+	p.ExpiryDate = m.ExpiryDate
+	if !m.Valid {
+		p.Warnings = append(p.Warnings, "The check digits of the machine readable zone don't match, some characters were probably misread.")
+	}
+}
+
+var (
+	emiratesIDText = regexp.MustCompile(`\b(784)[-\s]?(\d{4})[-\s]?(\d{7})[-\s]?(\d)\b`)
+	licenseNoText  = regexp.MustCompile(`(?i)licen[cs]e\s*(?:no|number|#)\.?\s*:?\s*([A-Z0-9][A-Z0-9/-]{3,})`)
+	tradeNameText  = regexp.MustCompile(`(?i)trade\s*name\s*:?[ \t]*([^\r\n]+)`)
+	expiryText     = regexp.MustCompile(`(?i)(?:expiry|expiration)\s*date\s*:?\s*(\d{1,2}[/.-]\d{1,2}[/.-]\d{4})`)
+)
+
+// findDate parses the day/month/year date captured by re, the order used on UAE documents
+func findDate(re *regexp.Regexp, text string) time.Time {
+	match := re.FindStringSubmatch(text)
+	if match == nil {
+		return time.Time{}
+	}
+	s := strings.NewReplacer(".", "/", "-", "/").Replace(match[1])
+	t, err := time.Parse("2/1/2006", s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// FormatEmiratesID formats a 15 digit Emirates ID number as 784-YYYY-NNNNNNN-C,
+// returning "" for anything else
+func FormatEmiratesID(digits string) string {
+	if len(digits) != 15 || !strings.HasPrefix(digits, "784") {
+		return ""
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return ""
+		}
+	}
+	return digits[0:3] + "-" + digits[3:7] + "-" + digits[7:14] + "-" + digits[14:15]
+}
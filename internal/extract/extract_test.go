@@ -0,0 +1,158 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Specimen zones from ICAO 9303 and a made up Emirates ID card
+const (
+	passportMRZ = "P<UTOERIKSSON<<ANNA<MARIA<<<<<<<<<<<<<<<<<<<\n" +
+		"L898902C36UTO7408122F1204159ZE184226B<<<<<10"
+	idCardMRZ = "I<UTOD231458907<<<<<<<<<<<<<<<\n" +
+		"7408122F1204159UTO<<<<<<<<<<<6\n" +
+		"ERIKSSON<<ANNA<MARIA<<<<<<<<<<"
+	emiratesIDMRZ = "ILARE1234567897784198012345678\n" +
+		"8001151M3106305IND<<<<<<<<<<<3\n" +
+		"KUMAR<<RAJESH<<<<<<<<<<<<<<<<<"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestParseMRZ(t *testing.T) {
+	m, err := ParseMRZ("PASSPORT\nUtopia\n" + passportMRZ + "\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MRZ{
+		Format:         "TD3",
+		DocumentType:   "P",
+		IssuingCountry: "UTO",
+		DocumentNumber: "L898902C3",
+		Surname:        "ERIKSSON",
+		GivenNames:     "ANNA MARIA",
+		Nationality:    "UTO",
+		BirthDate:      date(1974, time.August, 12),
+		Sex:            "F",
+		ExpiryDate:     date(2012, time.April, 15),
+		OptionalData:   "ZE184226B",
+		Valid:          true,
+	}
+	if m != want {
+		t.Errorf("expected %+v\n but got %+v", want, m)
+	}
+
+	m, err = ParseMRZ(idCardMRZ)
+	if err != nil || m.Format != "TD1" || m.DocumentNumber != "D23145890" || !m.Valid {
+		t.Errorf("unexpected TD1 result %+v (%v)", m, err)
+	}
+
+	// Typical OCR noise: spaces, lower case and lost trailing fillers
+	noisy := strings.ToLower(strings.Replace(passportMRZ, "<<<<<<<<<<<<<<<<<<<\n", "<<<<<<<<<<<<<<<<<\n", 1))
+	noisy = strings.Replace(noisy, "l898902c3", "l898 902c3", 1)
+	m, err = ParseMRZ(noisy)
+	if err != nil || m.DocumentNumber != "L898902C3" || !m.Valid {
+		t.Errorf("expected the noisy zone to be read, got %+v (%v)", m, err)
+	}
+
+	// A misread digit breaks the check digits
+	m, err = ParseMRZ(strings.Replace(passportMRZ, "L898902C3", "L898902C8", 1))
+	if err != nil || m.Valid {
+		t.Errorf("expected an invalid zone, got %+v (%v)", m, err)
+	}
+
+	if _, err := ParseMRZ("no zone here\nat all"); err != ErrNoMRZ {
+		t.Errorf("expected ErrNoMRZ but got %v", err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	p := Parse(passportMRZ, Passport)
+	if p.Source != "mrz" || p.DocumentNumber != "L898902C3" || p.Name != "ANNA MARIA ERIKSSON" ||
+		!p.ExpiryDate.Equal(date(2012, time.April, 15)) || len(p.Warnings) != 0 {
+		t.Errorf("unexpected passport proposal %+v", p)
+	}
+
+	p = Parse("back of card\n"+emiratesIDMRZ, EmiratesID)
+	if p.EmiratesID != "784-1980-1234567-8" || !p.ExpiryDate.Equal(date(2031, time.June, 30)) || p.Nationality != "IND" {
+		t.Errorf("unexpected Emirates ID proposal %+v", p)
+	}
+
+	p = Parse("United Arab Emirates\nID Number 784-1980-1234567-8\nExpiry Date: 30/06/2031", EmiratesID)
+	if p.Source != "text" || p.EmiratesID != "784-1980-1234567-8" || !p.ExpiryDate.Equal(date(2031, time.June, 30)) {
+		t.Errorf("unexpected Emirates ID front proposal %+v", p)
+	}
+
+	text := "Government of Dubai\nDepartment of Economy and Tourism\n" +
+		"License No: 1234567\nTrade Name : Falcon General Trading L.L.C\nExpiry Date 14-02-2027\n"
+	p = Parse(text, TradeLicense)
+	if p.DocumentNumber != "1234567" || p.TradeName != "Falcon General Trading L.L.C" ||
+		!p.ExpiryDate.Equal(date(2027, time.February, 14)) {
+		t.Errorf("unexpected trade license proposal %+v", p)
+	}
+
+	p = Parse("nothing useful", Passport)
+	if p.DocumentNumber != "" || len(p.Warnings) != 1 {
+		t.Errorf("expected a warning and no fields, got %+v", p)
+	}
+}
+
+type fakeOCR struct {
+	text string
+	got  image.Image
+}
+
+func (f *fakeOCR) Recognize(ctx context.Context, img image.Image) (string, error) {
+	f.got = img
+	return f.text, nil
+}
+
+func TestExtractor(t *testing.T) {
+	var scan bytes.Buffer
+	png.Encode(&scan, image.NewGray(image.Rect(0, 0, 40, 20)))
+
+	ocr := &fakeOCR{text: passportMRZ}
+	e := &Extractor{OCR: ocr}
+
+	p, err := e.Extract(context.Background(), bytes.NewReader(scan.Bytes()), "image/png", Passport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ocr.got == nil || ocr.got.Bounds().Dx() != 40 || p.DocumentNumber != "L898902C3" {
+		t.Errorf("unexpected proposal %+v", p)
+	}
+
+	if _, err := e.Extract(context.Background(), strings.NewReader("%PDF-1.4"), "application/pdf", Passport); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a PDF without a renderer, got %v", err)
+	}
+	if _, err := e.Extract(context.Background(), bytes.NewReader(scan.Bytes()), "image/png", "visa"); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for an unknown kind, got %v", err)
+	}
+	if _, err := (&Extractor{}).Extract(context.Background(), bytes.NewReader(scan.Bytes()), "image/png", Passport); !errors.Is(err, ErrNoOCR) {
+		t.Errorf("expected ErrNoOCR, got %v", err)
+	}
+}
+
+func TestFormatEmiratesID(t *testing.T) {
+	var tests = []struct {
+		in, want string
+	}{
+		{"784198012345678", "784-1980-1234567-8"},
+		{"78419801234567", ""},
+		{"123198012345678", ""},
+		{"7841980123456X8", ""},
+	}
+	for _, e := range tests {
+		if got := FormatEmiratesID(e.in); got != e.want {
+			t.Errorf("FormatEmiratesID(%q): expected %q but got %q", e.in, e.want, got)
+		}
+	}
+}
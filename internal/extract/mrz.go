@@ -0,0 +1,189 @@
+package extract
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// MRZ holds the fields of a machine readable zone, as printed at the bottom
+// of passports (TD3, two lines of 44 characters) and on the back of ID cards
+// such as the Emirates ID (TD1, three lines of 30 characters)
+type MRZ struct {
+	Format         string // TD1 or TD3
+	DocumentType   string
+	IssuingCountry string
+	DocumentNumber string
+	Surname        string
+	GivenNames     string
+	Nationality    string
+	BirthDate      time.Time
+	Sex            string
+	ExpiryDate     time.Time
+	OptionalData   string // holds the Emirates ID number on Emirates ID cards
+
+	// Valid reports whether all check digits match. OCR errors usually break
+	// at least one of them, so fields of an invalid MRZ need a closer look.
+	Valid bool
+}
+
+// ErrNoMRZ is returned when the text contains no machine readable zone
+var ErrNoMRZ = errors.New("no machine readable zone found")
+
+var mrzLine = regexp.MustCompile(`^[A-Z0-9<]+$`)
+
+// ParseMRZ finds a TD3 or TD1 machine readable zone in OCR output and decodes it
+func ParseMRZ(text string) (MRZ, error) {
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		l = cleanMRZLine(l)
+		if len(l) >= 28 && mrzLine.MatchString(l) {
+			lines = append(lines, l)
+		} else if len(lines) > 0 && l != "" {
+			// The zone is over, keep looking further down in case this was noise
+			if m, ok := findMRZ(lines); ok {
+				return m, nil
+			}
+			lines = nil
+		}
+	}
+
+	if m, ok := findMRZ(lines); ok {
+		return m, nil
+	}
+	return MRZ{}, ErrNoMRZ
+}
+
+// cleanMRZLine undoes the usual OCR mistakes in zone lines: spaces, lower case
+// and the filler character read as a guillemet
+func cleanMRZLine(l string) string {
+	l = strings.ToUpper(strings.TrimSpace(l))
+	l = strings.NewReplacer(" ", "", "«", "<<", "‹", "<", "＜", "<").Replace(l)
+	return l
+}
+
+// findMRZ looks for consecutive lines forming a zone
+func findMRZ(lines []string) (MRZ, bool) {
+	for i := range lines {
+		if i+1 < len(lines) && lines[i][0] == 'P' {
+			l1, l2 := fitLine(lines[i], 44), fitLine(lines[i+1], 44)
+			if l1 != "" && l2 != "" {
+				return parseTD3(l1, l2), true
+			}
+		}
+		if i+2 < len(lines) && strings.ContainsRune("IAC", rune(lines[i][0])) {
+			l1, l2, l3 := fitLine(lines[i], 30), fitLine(lines[i+1], 30), fitLine(lines[i+2], 30)
+			if l1 != "" && l2 != "" && l3 != "" {
+				return parseTD1(l1, l2, l3), true
+			}
+		}
+	}
+	return MRZ{}, false
+}
+
+// fitLine pads a line that lost a few trailing fillers in OCR to length n,
+// returning "" when the line is clearly not n characters long
+func fitLine(l string, n int) string {
+	if len(l) > n || len(l) < n-3 {
+		return ""
+	}
+	return l + strings.Repeat("<", n-len(l))
+}
+
+// parseTD3 decodes a passport zone
+func parseTD3(l1, l2 string) MRZ {
+	m := MRZ{
+		Format:         "TD3",
+		DocumentType:   trimFiller(l1[0:2]),
+		IssuingCountry: trimFiller(l1[2:5]),
+		DocumentNumber: trimFiller(l2[0:9]),
+		Nationality:    trimFiller(l2[10:13]),
+		BirthDate:      mrzDate(l2[13:19], false),
+		Sex:            trimFiller(l2[20:21]),
+		ExpiryDate:     mrzDate(l2[21:27], true),
+		OptionalData:   trimFiller(l2[28:42]),
+	}
+	m.Surname, m.GivenNames = mrzName(l1[5:44])
+
+	m.Valid = checkDigit(l2[0:9]) == l2[9] &&
+		checkDigit(l2[13:19]) == l2[19] &&
+		checkDigit(l2[21:27]) == l2[27] &&
+		(l2[42] == '<' && strings.Trim(l2[28:42], "<") == "" || checkDigit(l2[28:42]) == l2[42]) &&
+		checkDigit(l2[0:10]+l2[13:20]+l2[21:43]) == l2[43]
+	return m
+}
+
+// parseTD1 decodes an ID card zone
+func parseTD1(l1, l2, l3 string) MRZ {
+	m := MRZ{
+		Format:         "TD1",
+		DocumentType:   trimFiller(l1[0:2]),
+		IssuingCountry: trimFiller(l1[2:5]),
+		DocumentNumber: trimFiller(l1[5:14]),
+		OptionalData:   trimFiller(l1[15:30]),
+		BirthDate:      mrzDate(l2[0:6], false),
+		Sex:            trimFiller(l2[7:8]),
+		ExpiryDate:     mrzDate(l2[8:14], true),
+		Nationality:    trimFiller(l2[15:18]),
+	}
+	m.Surname, m.GivenNames = mrzName(l3)
+
+	m.Valid = checkDigit(l1[5:14]) == l1[14] &&
+		checkDigit(l2[0:6]) == l2[6] &&
+		checkDigit(l2[8:14]) == l2[14] &&
+		checkDigit(l1[5:30]+l2[0:7]+l2[8:15]+l2[18:29]) == l2[29]
+	return m
+}
+
+// checkDigit computes the ICAO 9303 check digit of s, with weights 7, 3, 1
+func checkDigit(s string) byte {
+	weights := [3]int{7, 3, 1}
+	sum := 0
+	for i := 0; i < len(s); i++ {
+		var v int
+		switch c := s[i]; {
+		case c >= '0' && c <= '9':
+			v = int(c - '0')
+		case c >= 'A' && c <= 'Z':
+			v = int(c-'A') + 10
+		}
+		sum += v * weights[i%3]
+	}
+	return byte('0' + sum%10)
+}
+
+// mrzDate decodes a YYMMDD date. Expiry dates are in this century, birth
+// dates in the last 100 years.
+func mrzDate(s string, expiry bool) time.Time {
+	t, err := time.Parse("060102", s)
+	if err != nil {
+		return time.Time{}
+	}
+	// time.Parse puts 69-99 in the 1900s and 00-68 in the 2000s
+	if expiry && t.Year() < 2000 {
+		t = t.AddDate(100, 0, 0)
+	}
+	if !expiry && t.After(time.Now()) {
+		t = t.AddDate(-100, 0, 0)
+	}
+	return t
+}
+
+// mrzName splits SURNAME<<GIVEN<NAMES into its parts
+func mrzName(s string) (surname, given string) {
+	parts := strings.SplitN(strings.TrimRight(s, "<"), "<<", 2)
+	surname = strings.Join(strings.FieldsFunc(parts[0], isFiller), " ")
+	if len(parts) == 2 {
+		given = strings.Join(strings.FieldsFunc(parts[1], isFiller), " ")
+	}
+	return surname, given
+}
+
+func isFiller(r rune) bool {
+	return r == '<'
+}
+
+func trimFiller(s string) string {
+	return strings.Trim(s, "<")
+}
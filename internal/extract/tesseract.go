@@ -0,0 +1,50 @@
+package extract
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strings"
+)
+
+// Tesseract recognizes text with a local tesseract binary
+type Tesseract struct {
+	Path     string // path of the tesseract binary
+	Language string // tesseract language code, eng when empty
+}
+
+// FindTesseract returns an OCR backend using the tesseract binary on the PATH, or nil when it is not installed
+func FindTesseract() *Tesseract {
+	path, err := exec.LookPath("tesseract")
+	if err != nil {
+		return nil
+	}
+	return &Tesseract{Path: path}
+}
+
+// Recognize passes img to tesseract as a PNG on stdin and returns the text it reads
+func (t *Tesseract) Recognize(ctx context.Context, img image.Image) (string, error) {
+	var stdin bytes.Buffer
+	if err := png.Encode(&stdin, img); err != nil {
+		return "", err
+	}
+
+	lang := t.Language
+	if lang == "" {
+		lang = "eng"
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Path, "stdin", "stdout", "-l", lang)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("tesseract: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
@@ -0,0 +1,291 @@
+package forms
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxRows is the largest number of rows bound into a slice of structs.
+const MaxRows = 100
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Bind copies the form data into the struct dst points to and validates it.
+//
+// Each exported field with a form tag is set from the value of that name;
+// fields whose value was not posted keep what they held, so handlers can fill
+// in IDs before binding. Values that don't parse as the field's type, such as
+// a date that isn't one, become field errors. The validate tag lists the rules
+// checked on the value before it is bound:
+//
+//	required         the value is not blank
+//	email            the value is an email address
+//	min=N            the value is at least N characters long
+//	date             the value is a date, as posted by date inputs
+//	future           the date is today or later
+//	after=field      the date is later than the one in field
+//	range=min:max    the value is a whole number from min to max
+//	phone, mobile    the value is a UAE phone or mobile number
+//	emiratesid       the value is an Emirates ID number
+//	passport=field   the value is a passport number of the nationality in field
+//	tradelicense=field  the value is a license number of the emirate in field
+//
+// Validators that normalize values, such as phone, store the normalized value.
+// Fields referred to by rules are looked up next to the field being checked.
+//
+// A struct field with a form tag binds a nested struct from names prefixed by
+// the tag and a dot, e.g. "partner.name". A slice of structs binds the rows
+// posted as "partners[0].name", "partners[1].name" and so on, in index order.
+// Other slices take all the values posted for their name.
+//
+// Bind reports whether the form is valid.
+func (f *Form) Bind(dst interface{}) bool {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("forms: Bind needs a pointer to a struct, got %T", dst))
+	}
+	f.bindStruct(v.Elem(), "")
+	return f.Valid()
+}
+
+// bindStruct binds the fields of v from the names starting with prefix.
+func (f *Form) bindStruct(v reflect.Value, prefix string) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" {
+			continue
+		}
+		fv := v.Field(i)
+
+		name, ok := sf.Tag.Lookup("form")
+		if !ok {
+			// Embedded structs share the names of the struct they are part of
+			if sf.Anonymous && fv.Kind() == reflect.Struct {
+				f.bindStruct(fv, prefix)
+			}
+			continue
+		}
+		if name == "-" {
+			continue
+		}
+		key := prefix + name
+		rules := sf.Tag.Get("validate")
+
+		switch {
+		case fv.Type() == timeType || fv.Kind() != reflect.Struct && fv.Kind() != reflect.Slice:
+			f.validate(key, rules, prefix)
+			if _, posted := f.Values[key]; posted {
+				f.decode(key, fv)
+			}
+		case fv.Kind() == reflect.Struct:
+			f.bindStruct(fv, key+".")
+		case fv.Type().Elem().Kind() == reflect.Struct:
+			f.bindRows(key, rules, fv)
+		default:
+			f.bindRepeated(key, rules, fv)
+		}
+	}
+}
+
+// bindRows binds a slice of structs from the rows posted as key[N].name.
+func (f *Form) bindRows(key, rules string, fv reflect.Value) {
+	indexes := f.rowIndexes(key)
+	if len(indexes) > MaxRows {
+		f.Errors.Add(key, fmt.Sprintf("No more than %d rows can be posted", MaxRows))
+		indexes = indexes[:MaxRows]
+	}
+	if len(indexes) == 0 {
+		if hasRule(rules, "required") {
+			f.Errors.Add(key, "Add at least one row")
+		}
+		return
+	}
+
+	rows := reflect.MakeSlice(fv.Type(), len(indexes), len(indexes))
+	for n, index := range indexes {
+		f.bindStruct(rows.Index(n), fmt.Sprintf("%s[%d].", key, index))
+	}
+	fv.Set(rows)
+}
+
+// rowIndexes returns the sorted row numbers posted for key.
+func (f *Form) rowIndexes(key string) []int {
+	seen := make(map[int]bool)
+	for name := range f.Values {
+		rest, ok := strings.CutPrefix(name, key+"[")
+		if !ok {
+			continue
+		}
+		end := strings.Index(rest, "].")
+		if end < 0 {
+			continue
+		}
+		index, err := strconv.Atoi(rest[:end])
+		if err != nil || index < 0 {
+			continue
+		}
+		seen[index] = true
+	}
+
+	indexes := make([]int, 0, len(seen))
+	for index := range seen {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// bindRepeated binds a slice of simple values from all the values posted for key.
+func (f *Form) bindRepeated(key, rules string, fv reflect.Value) {
+	var values []string
+	for _, value := range f.Values[key] {
+		if strings.TrimSpace(value) != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		if hasRule(rules, "required") {
+			f.Errors.Add(key, "Choose at least one")
+		}
+		return
+	}
+
+	out := reflect.MakeSlice(fv.Type(), len(values), len(values))
+	for n, value := range values {
+		if msg := setValue(out.Index(n), value); msg != "" {
+			f.Errors.Add(key, msg)
+			return
+		}
+	}
+	fv.Set(out)
+}
+
+// decode parses the value posted for key into fv, adding a field error when it doesn't parse.
+func (f *Form) decode(key string, fv reflect.Value) {
+	if fv.Type() == timeType {
+		if t, ok := f.Date(key); ok {
+			fv.Set(reflect.ValueOf(t))
+		}
+		return
+	}
+	if msg := setValue(fv, f.Get(key)); msg != "" {
+		f.Errors.Add(key, msg)
+	}
+}
+
+// setValue parses s into v, returning an error message for the user when it doesn't parse.
+func setValue(v reflect.Value, s string) string {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(strings.TrimSpace(s))
+		return ""
+	case reflect.Bool:
+		switch strings.ToLower(strings.TrimSpace(s)) {
+		case "", "0", "false", "off", "no":
+			v.SetBool(false)
+		default:
+			v.SetBool(true)
+		}
+		return ""
+	}
+
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	if s == "" {
+		v.Set(reflect.Zero(v.Type()))
+		return ""
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return "Enter a whole number"
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return "Enter a whole number"
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return "Enter a number"
+		}
+		v.SetFloat(n)
+	default:
+		panic(fmt.Sprintf("forms: can't bind a form value into a %s", v.Type()))
+	}
+	return ""
+}
+
+// validate checks the rules of a validate tag on the value posted for key.
+func (f *Form) validate(key, rules, prefix string) {
+	if rules == "" {
+		return
+	}
+	for _, rule := range strings.Split(rules, ",") {
+		name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch name {
+		case "required":
+			f.Required(key)
+		case "email":
+			if f.Has(key) {
+				f.IsEmail(key)
+			}
+		case "min":
+			n, err := strconv.Atoi(param)
+			if err != nil {
+				panic(fmt.Sprintf("forms: bad min rule %q on %s", rule, key))
+			}
+			if f.Has(key) {
+				f.MinLength(key, n)
+			}
+		case "date":
+			f.Date(key)
+		case "future":
+			f.NotPast(key)
+		case "after":
+			f.DateAfter(key, prefix+param)
+		case "range":
+			lo, hi, ok := strings.Cut(param, ":")
+			min, err1 := strconv.Atoi(lo)
+			max, err2 := strconv.Atoi(hi)
+			if !ok || err1 != nil || err2 != nil {
+				panic(fmt.Sprintf("forms: bad range rule %q on %s", rule, key))
+			}
+			f.IntRange(key, min, max)
+		case "phone":
+			f.Phone(key)
+		case "mobile":
+			f.Mobile(key)
+		case "emiratesid":
+			f.EmiratesID(key)
+		case "passport":
+			nationality := ""
+			if param != "" {
+				nationality = f.Get(prefix + param)
+			}
+			f.Passport(key, nationality)
+		case "tradelicense":
+			f.TradeLicense(key, f.Get(prefix+param))
+		default:
+			panic(fmt.Sprintf("forms: unknown rule %q on %s", rule, key))
+		}
+	}
+}
+
+// hasRule reports whether the rules of a validate tag include name.
+func hasRule(rules, name string) bool {
+	for _, rule := range strings.Split(rules, ",") {
+		if strings.TrimSpace(rule) == name {
+			return true
+		}
+	}
+	return false
+}
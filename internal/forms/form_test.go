@@ -4,7 +4,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestForm_Valid(t *testing.T) {
@@ -79,3 +81,280 @@ func TestForm_MinLength(t *testing.T) {
 	form = New(postedValues)
 
 }
+
+func TestForm_EmiratesID(t *testing.T) {
+	var tests = []struct {
+		value string
+		valid bool
+		want  string
+	}{
+		{"784-1980-1234567-8", true, "784-1980-1234567-8"},
+		{"784198012345678", true, "784-1980-1234567-8"},
+		{"784 1980 1234567 8", true, "784-1980-1234567-8"},
+		{"784-1980-1234567-9", false, ""},
+		{"785-1980-1234567-8", false, ""},
+		{"784-2999-1234567-8", false, ""},
+		{"784-1980-123456-8", false, ""},
+		{"", true, ""},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"id": {e.value}})
+		if got := form.EmiratesID("id"); got != e.valid {
+			t.Errorf("%q: expected valid %v but got %v", e.value, e.valid, got)
+		}
+		if e.valid && form.Get("id") != e.want {
+			t.Errorf("%q: expected %q but got %q", e.value, e.want, form.Get("id"))
+		}
+		if !e.valid && form.Errors.Get("id") == "" {
+			t.Errorf("%q: expected a field error", e.value)
+		}
+	}
+}
+
+func TestForm_Passport(t *testing.T) {
+	var tests = []struct {
+		value       string
+		nationality string
+		valid       bool
+	}{
+		{"L898902C3", "", true},
+		{"j 1234567", "India", true},
+		{"12345678", "India", false},
+		{"AB1234567", "Pakistan", true},
+		{"123456789", "United Kingdom", true},
+		{"ABCDEFGH", "", false},
+		{"A12", "", false},
+		{"A1234567890", "", false},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"passport": {e.value}})
+		if got := form.Passport("passport", e.nationality); got != e.valid {
+			t.Errorf("%q (%s): expected valid %v but got %v", e.value, e.nationality, e.valid, got)
+		}
+	}
+
+	form := New(url.Values{"passport": {"j 1234567"}})
+	form.Passport("passport", "India")
+	if form.Get("passport") != "J1234567" {
+		t.Errorf("expected the passport number to be normalized, got %q", form.Get("passport"))
+	}
+}
+
+func TestForm_Phone(t *testing.T) {
+	var tests = []struct {
+		value  string
+		mobile bool
+		want   string
+	}{
+		{"04 123 4567", false, "+97141234567"},
+		{"+971 4 123 4567", false, "+97141234567"},
+		{"00971-2-1234567", false, "+97121234567"},
+		{"050 123 4567", true, "+971501234567"},
+		{"(055) 123-4567", true, "+971551234567"},
+		{"971501234567", true, "+971501234567"},
+		{"+9710501234567", true, "+971501234567"},
+		{"12345", false, ""},
+		{"+44 20 7946 0958", false, ""},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"phone": {e.value}})
+		ok := form.Phone("phone")
+		if ok != (e.want != "") || ok && form.Get("phone") != e.want {
+			t.Errorf("phone %q: expected %q but got %q (%v)", e.value, e.want, form.Get("phone"), ok)
+		}
+
+		form = New(url.Values{"mobile": {e.value}})
+		ok = form.Mobile("mobile")
+		if ok != e.mobile || ok && form.Get("mobile") != e.want {
+			t.Errorf("mobile %q: expected %v but got %q (%v)", e.value, e.mobile, form.Get("mobile"), ok)
+		}
+	}
+}
+
+func TestForm_Dates(t *testing.T) {
+	now = func() time.Time { return time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	form := New(url.Values{"issued": {"2024-01-01"}, "expiry": {"2023-12-31"}, "bad": {"31/12/2026"}})
+	if _, ok := form.Date("bad"); ok || form.Errors.Get("bad") == "" {
+		t.Error("expected an error for a date in the wrong format")
+	}
+	if d, ok := form.Date("issued"); !ok || d != time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) {
+		t.Errorf("expected 2024-01-01 but got %v", d)
+	}
+	if d, ok := form.Date("missing"); !ok || !d.IsZero() {
+		t.Error("expected an empty date to be left to Required")
+	}
+	if form.DateAfter("expiry", "issued") {
+		t.Error("expected an expiry before the issue date to be refused")
+	}
+	if !form.DateAfter("bad", "issued") {
+		t.Error("expected invalid dates to be left to Date")
+	}
+
+	form = New(url.Values{"today": {"2026-10-19"}, "yesterday": {"2026-10-18"}})
+	if !form.NotPast("today") {
+		t.Error("expected today not to be in the past")
+	}
+	if form.NotPast("yesterday") {
+		t.Error("expected yesterday to be in the past")
+	}
+}
+
+func TestForm_IntRange(t *testing.T) {
+	var tests = []struct {
+		value string
+		valid bool
+	}{
+		{"100", true},
+		{"1,000", true},
+		{"0", false},
+		{"1000001", false},
+		{"12.5", false},
+		{"many", false},
+		{"", true},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"shares": {e.value}})
+		if _, ok := form.IntRange("shares", 1, 1000000); ok != e.valid {
+			t.Errorf("%q: expected valid %v but got %v", e.value, e.valid, ok)
+		}
+	}
+}
+
+func TestForm_TradeLicense(t *testing.T) {
+	var tests = []struct {
+		value   string
+		emirate string
+		valid   bool
+		want    string
+	}{
+		{"CN-1234567", "ABU", true, "CN-1234567"},
+		{"cn1234567", "ABU", true, "CN-1234567"},
+		{"1234567", "ABU", false, ""},
+		{"123456", "DUB", true, "123456"},
+		{"CN-123456", "DUB", false, ""},
+		{"654321", "SHA", true, "654321"},
+		{"123456", "XYZ", false, ""},
+	}
+
+	for _, e := range tests {
+		form := New(url.Values{"license": {e.value}})
+		if got := form.TradeLicense("license", e.emirate); got != e.valid {
+			t.Errorf("%q (%s): expected valid %v but got %v", e.value, e.emirate, e.valid, got)
+		}
+		if e.valid && form.Get("license") != e.want {
+			t.Errorf("%q (%s): expected %q but got %q", e.value, e.emirate, e.want, form.Get("license"))
+		}
+	}
+}
+
+type bindAddress struct {
+	City string `form:"city" validate:"required"`
+}
+
+type bindPartner struct {
+	Name   string    `form:"name" validate:"required"`
+	Shares int       `form:"shares" validate:"range=1:100"`
+	Expiry time.Time `form:"expiry" validate:"future"`
+}
+
+type bindCustomer struct {
+	ID       int
+	Code     string        `form:"code" validate:"required,min=3"`
+	Mobile   string        `form:"mobile" validate:"mobile"`
+	Since    time.Time     `form:"since" validate:"date"`
+	Until    time.Time     `form:"until" validate:"after=since"`
+	Active   bool          `form:"active"`
+	Rate     float64       `form:"rate"`
+	Rooms    []int         `form:"rooms"`
+	Address  bindAddress   `form:"address"`
+	Partners []bindPartner `form:"partners" validate:"required"`
+	Ignored  string        `form:"-"`
+}
+
+func TestForm_Bind(t *testing.T) {
+	now = func() time.Time { return time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	form := New(url.Values{
+		"code":               {"C-100"},
+		"mobile":             {"050 123 4567"},
+		"since":              {"2020-02-01"},
+		"until":              {"2030-02-01"},
+		"active":             {"on"},
+		"rate":               {"1,250.50"},
+		"rooms":              {"1", "3"},
+		"address.city":       {"Dubai"},
+		"partners[3].name":   {"Second"},
+		"partners[0].name":   {"First"},
+		"partners[0].shares": {"40"},
+		"partners[3].expiry": {"2027-01-01"},
+		"Ignored":            {"x"},
+	})
+	c := bindCustomer{ID: 7}
+	if !form.Bind(&c) {
+		t.Fatalf("expected the form to be valid, got %v", form.Errors)
+	}
+
+	want := bindCustomer{
+		ID:      7,
+		Code:    "C-100",
+		Mobile:  "+971501234567",
+		Since:   time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC),
+		Until:   time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC),
+		Active:  true,
+		Rate:    1250.5,
+		Rooms:   []int{1, 3},
+		Address: bindAddress{City: "Dubai"},
+		Partners: []bindPartner{
+			{Name: "First", Shares: 40},
+			{Name: "Second", Expiry: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("expected %+v but got %+v", want, c)
+	}
+}
+
+func TestForm_BindErrors(t *testing.T) {
+	now = func() time.Time { return time.Date(2026, 10, 19, 15, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	form := New(url.Values{
+		"code":               {"C1"},
+		"mobile":             {"04 123 4567"},
+		"since":              {"01/02/2020"},
+		"until":              {"2030-02-01"},
+		"rate":               {"cheap"},
+		"rooms":              {"1", "two"},
+		"partners[0].shares": {"400"},
+		"partners[0].expiry": {"2026-10-18"},
+	})
+	var c bindCustomer
+	if form.Bind(&c) {
+		t.Fatal("expected the form to be invalid")
+	}
+
+	for _, field := range []string{"code", "mobile", "since", "rate", "rooms", "address.city",
+		"partners[0].name", "partners[0].shares", "partners[0].expiry"} {
+		if form.Errors.Get(field) == "" {
+			t.Errorf("expected an error for %s", field)
+		}
+	}
+	if n := len(form.Errors["since"]); n != 1 {
+		t.Errorf("expected a single error for an invalid date, got %d", n)
+	}
+	if !c.Since.IsZero() || c.Rate != 0 {
+		t.Error("expected values that don't parse to be left unset")
+	}
+
+	form = New(url.Values{"code": {"C-100"}})
+	if form.Bind(&c); form.Errors.Get("partners") == "" {
+		t.Error("expected an error when no required rows are posted")
+	}
+}
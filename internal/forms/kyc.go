@@ -0,0 +1,272 @@
+package forms
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateLayout is the format dates are posted in by date inputs.
+const DateLayout = "2006-01-02"
+
+// now returns the current time, tests replace it to pin "today".
+var now = time.Now
+
+// EmiratesID checks that a field holds an Emirates ID number, 784-YYYY-NNNNNNN-C,
+// whose last digit is the Luhn check digit of the others. Valid numbers are
+// rewritten in that format. Empty fields are left to Required.
+func (f *Form) EmiratesID(field string) bool {
+	value := f.Get(field)
+	if value == "" {
+		return true
+	}
+	id, ok := normalizeEmiratesID(value)
+	if !ok {
+		f.Errors.Add(field, "Enter an Emirates ID number as 784-YYYY-NNNNNNN-C")
+		return false
+	}
+	if !luhn(strings.ReplaceAll(id, "-", "")) {
+		f.Errors.Add(field, "This Emirates ID number is not valid, please check the digits")
+		return false
+	}
+	f.Set(field, id)
+	return true
+}
+
+// normalizeEmiratesID formats an Emirates ID typed with or without separators,
+// checking the 784 prefix and the year of birth.
+func normalizeEmiratesID(s string) (string, bool) {
+	digits := strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(s))
+	if len(digits) != 15 || !strings.HasPrefix(digits, "784") {
+		return "", false
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	year, _ := strconv.Atoi(digits[3:7])
+	if year < 1900 || year > now().Year() {
+		return "", false
+	}
+	return digits[0:3] + "-" + digits[3:7] + "-" + digits[7:14] + "-" + digits[14:15], true
+}
+
+// luhn reports whether the last digit of s is the Luhn check digit of the others.
+func luhn(s string) bool {
+	sum := 0
+	double := false
+	for i := len(s) - 1; i >= 0; i-- {
+		d := int(s[i] - '0')
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
+
+// passportPatterns are the passport number formats of the nationalities we see
+// most, keyed by the names used in the nationality lists of the templates.
+var passportPatterns = map[string]*regexp.Regexp{
+	"Bangladesh":     regexp.MustCompile(`^[A-Z]{2}[0-9]{7}$`),
+	"India":          regexp.MustCompile(`^[A-Z][0-9]{7}$`),
+	"Pakistan":       regexp.MustCompile(`^[A-Z]{2}[0-9]{7}$`),
+	"Philippines":    regexp.MustCompile(`^[A-Z]{1,2}[0-9]{6,7}[A-Z]?$`),
+	"Sri Lanka":      regexp.MustCompile(`^[A-Z][0-9]{7}$`),
+	"United Kingdom": regexp.MustCompile(`^[0-9]{9}$`),
+	"United States":  regexp.MustCompile(`^[A-Z0-9][0-9]{8}$`),
+}
+
+// passportNumber is the ICAO document number: up to 9 letters and digits.
+var passportNumber = regexp.MustCompile(`^[A-Z0-9]{6,9}$`)
+
+// Passport checks that a field holds a passport number, using the format of the
+// given nationality when it is known. The value is upper cased and stripped of
+// spaces. Empty fields are left to Required.
+func (f *Form) Passport(field, nationality string) bool {
+	value := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(f.Get(field)), " ", ""))
+	if value == "" {
+		return true
+	}
+	if !passportNumber.MatchString(value) || !strings.ContainsAny(value, "0123456789") {
+		f.Errors.Add(field, "Enter the passport number as printed, 6 to 9 letters and digits")
+		return false
+	}
+	if re, ok := passportPatterns[nationality]; ok && !re.MatchString(value) {
+		f.Errors.Add(field, fmt.Sprintf("This is not a valid %s passport number", nationality))
+		return false
+	}
+	f.Set(field, value)
+	return true
+}
+
+// Phone checks that a field holds a UAE landline or mobile number and rewrites
+// it in E.164 format, +971 followed by the national number. Empty fields are
+// left to Required.
+func (f *Form) Phone(field string) bool {
+	value := f.Get(field)
+	if value == "" {
+		return true
+	}
+	e164, ok := normalizePhone(value)
+	if !ok {
+		f.Errors.Add(field, "Enter a UAE phone number, for example 04 123 4567 or +971 4 123 4567")
+		return false
+	}
+	f.Set(field, e164)
+	return true
+}
+
+// Mobile is like Phone but only accepts mobile numbers.
+func (f *Form) Mobile(field string) bool {
+	value := f.Get(field)
+	if value == "" {
+		return true
+	}
+	e164, ok := normalizePhone(value)
+	if !ok || !uaeMobile.MatchString(strings.TrimPrefix(e164, "+971")) {
+		f.Errors.Add(field, "Enter a UAE mobile number, for example 050 123 4567 or +971 50 123 4567")
+		return false
+	}
+	f.Set(field, e164)
+	return true
+}
+
+var (
+	uaeMobile   = regexp.MustCompile(`^5[0-9]{8}$`)
+	uaeLandline = regexp.MustCompile(`^[2-9][0-9]{7}$`)
+)
+
+// normalizePhone turns a UAE number in national or international format into E.164.
+func normalizePhone(s string) (string, bool) {
+	n := strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "").Replace(strings.TrimSpace(s))
+	switch {
+	case strings.HasPrefix(n, "+971"):
+		n = n[4:]
+	case strings.HasPrefix(n, "00971"):
+		n = n[5:]
+	case strings.HasPrefix(n, "971") && len(n) >= 11:
+		n = n[3:]
+	}
+	// The trunk prefix is dropped in international format, but often typed anyway
+	n = strings.TrimPrefix(n, "0")
+
+	if !uaeMobile.MatchString(n) && !uaeLandline.MatchString(n) {
+		return "", false
+	}
+	return "+971" + n, true
+}
+
+// Date parses a field posted by a date input, adding an error when it is not a
+// date. Empty fields give the zero time and are left to Required.
+func (f *Form) Date(field string) (time.Time, bool) {
+	value := strings.TrimSpace(f.Get(field))
+	if value == "" {
+		return time.Time{}, true
+	}
+	t, err := time.Parse(DateLayout, value)
+	if err != nil {
+		f.Errors.Add(field, "Enter a valid date")
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// DateAfter checks that the date in field is later than the one in earlier,
+// such as an expiry date after the issue date. It only compares valid dates.
+func (f *Form) DateAfter(field, earlier string) bool {
+	t, err := time.Parse(DateLayout, f.Get(field))
+	if err != nil {
+		return true
+	}
+	e, err := time.Parse(DateLayout, f.Get(earlier))
+	if err != nil {
+		return true
+	}
+	if !t.After(e) {
+		f.Errors.Add(field, fmt.Sprintf("This date must be after %s", e.Format("02 Jan 2006")))
+		return false
+	}
+	return true
+}
+
+// NotPast checks that the date in field is today or later, for documents that
+// must not have expired. It only checks valid dates.
+func (f *Form) NotPast(field string) bool {
+	t, err := time.Parse(DateLayout, f.Get(field))
+	if err != nil {
+		return true
+	}
+	y, m, d := now().Date()
+	if t.Before(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) {
+		f.Errors.Add(field, "This date is in the past")
+		return false
+	}
+	return true
+}
+
+// IntRange checks that a field holds a whole number between min and max, such
+// as a number of shares. Empty fields are left to Required.
+func (f *Form) IntRange(field string, min, max int) (int, bool) {
+	value := strings.ReplaceAll(strings.TrimSpace(f.Get(field)), ",", "")
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		f.Errors.Add(field, "Enter a whole number")
+		return 0, false
+	}
+	if n < min || n > max {
+		f.Errors.Add(field, fmt.Sprintf("This must be between %d and %d", min, max))
+		return n, false
+	}
+	f.Set(field, value)
+	return n, true
+}
+
+// tradeLicensePatterns are the license number formats of each emirate's
+// economic department, keyed by the emirate codes used in the templates.
+var tradeLicensePatterns = map[string]struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	"ABU": {"Abu Dhabi", regexp.MustCompile(`^CN-[0-9]{5,7}$`)},
+	"AJM": {"Ajman", regexp.MustCompile(`^[0-9]{4,6}$`)},
+	"DUB": {"Dubai", regexp.MustCompile(`^[0-9]{5,7}$`)},
+	"FUJ": {"Fujairah", regexp.MustCompile(`^[0-9]{3,6}$`)},
+	"RAS": {"Ras al-Khaimah", regexp.MustCompile(`^[0-9]{3,6}$`)},
+	"SHA": {"Sharjah", regexp.MustCompile(`^[0-9]{5,6}$`)},
+	"UMM": {"Umm al-Quwain", regexp.MustCompile(`^[0-9]{3,6}$`)},
+}
+
+// TradeLicense checks that a field holds a trade license number in the format
+// of the emirate that issued it. Abu Dhabi numbers are rewritten as CN-NNNNNNN.
+// Empty fields are left to Required.
+func (f *Form) TradeLicense(field, emirate string) bool {
+	value := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(f.Get(field)), " ", ""))
+	if value == "" {
+		return true
+	}
+	format, ok := tradeLicensePatterns[emirate]
+	if !ok {
+		f.Errors.Add(field, "Choose the emirate that issued the license")
+		return false
+	}
+	if emirate == "ABU" && strings.HasPrefix(value, "CN") && !strings.HasPrefix(value, "CN-") {
+		value = "CN-" + value[2:]
+	}
+	if !format.pattern.MatchString(value) {
+		f.Errors.Add(field, fmt.Sprintf("This is not a valid %s trade license number", format.name))
+		return false
+	}
+	f.Set(field, value)
+	return true
+}
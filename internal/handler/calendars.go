@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/ical"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// calendarFeedURL is where booking sites read the blocked dates of a room
+func calendarFeedURL(room models.Room) string {
+	return "/rooms/" + room.Slug + "/calendar.ics"
+}
+
+// calendarSources reads the external calendars of rooms
+func (m *Repository) calendarSources() ical.Sources {
+	return ical.Sources{Client: m.App.CalendarClient, Dir: m.App.CalendarDir}
+}
+
+// RoomCalendarFeed serves the dates a room is reserved or blocked from today
+// on as an iCalendar feed, for the booking sites listing the room. Dates
+// imported from those sites are left out, each site knows its own bookings.
+func (m *Repository) RoomCalendarFeed(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	y, mo, d := time.Now().Date()
+	restrictions, err := m.DB.RoomRestrictions(room.ID, time.Date(y, mo, d, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	host := r.Host
+	if u, err := url.Parse(m.App.BaseURL); err == nil && u.Host != "" {
+		host = u.Host
+	}
+	cal := ical.Calendar{ProdID: "-//reservationWeb//Room availability//EN", Name: room.RoomName}
+	for _, rr := range restrictions {
+		if rr.CalendarID != 0 {
+			continue
+		}
+		// Guests' details stay private, booking sites only need the dates
+		summary := "Blocked"
+		if rr.RestrictionID == models.RestrictionReservation {
+			summary = "Reserved"
+		}
+		cal.Events = append(cal.Events, ical.Event{
+			UID:     fmt.Sprintf("restriction-%d@%s", rr.ID, host),
+			Summary: summary,
+			Start:   rr.StartDate,
+			End:     rr.EndDate,
+		})
+	}
+
+	var buf bytes.Buffer
+	if err := ical.Write(&buf, cal); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s.ics"`, room.Slug))
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+// importRoomCalendar imports an external calendar now and returns its
+// conflicts. A calendar that can't be read is returned as a *ical.ReadError,
+// which it records, rather than as an error.
+func (m *Repository) importRoomCalendar(r *http.Request, c models.RoomCalendar) ([]models.CalendarConflict, *ical.ReadError, error) {
+	conflicts, err := ical.Import(r.Context(), m.DB, m.calendarSources(), c, time.Now())
+	var readErr *ical.ReadError
+	if errors.As(err, &readErr) {
+		m.App.Logger.Warn("cannot import external calendar", "request_id", helpers.RequestID(r), "calendar_id", c.ID,
+			"room_id", c.RoomID, "error", err)
+		return nil, readErr, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m.App.Logger.Info("external calendar imported", "request_id", helpers.RequestID(r), "calendar_id", c.ID,
+		"room_id", c.RoomID, "conflicts", len(conflicts), "user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	return conflicts, nil, nil
+}
+
+// AdminPostRoomCalendar adds an external calendar to a room and imports it
+func (m *Repository) AdminPostRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var c models.RoomCalendar
+	form := forms.New(r.PostForm)
+	form.Bind(&c)
+	c.RoomID = room.ID
+	c.Name, c.Source = strings.TrimSpace(c.Name), strings.TrimSpace(c.Source)
+	if c.Source != "" {
+		if err := m.calendarSources().Check(c.Source); err != nil {
+			msg := err.Error()
+			form.Errors.Add("calendarSource", strings.ToUpper(msg[:1])+msg[1:]+". Enter the calendar's address, like https://example.com/room.ics")
+		}
+	}
+	if !form.Valid() {
+		m.renderRoom(w, r, room, form)
+		return
+	}
+
+	id, err := m.DB.InsertRoomCalendar(c)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	c.ID = id
+	conflicts, readErr, err := m.importRoomCalendar(r, c)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	switch {
+	case readErr != nil:
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("%s was added but can't be imported: %s", c.Name, readErr))
+	case len(conflicts) > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("%s was imported, %d of its dates conflict with this room's", c.Name, len(conflicts)))
+	default:
+		m.App.Session.Put(r.Context(), "flash", c.Name+" was imported")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d#calendars", room.ID), http.StatusSeeOther)
+}
+
+// AdminImportRoomCalendars imports the external calendars of a room now,
+// without waiting for the scheduled import
+func (m *Repository) AdminImportRoomCalendars(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	calendars, err := m.DB.RoomCalendars(room.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	var failed []string
+	found := 0
+	for _, c := range calendars {
+		conflicts, readErr, err := m.importRoomCalendar(r, c)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if readErr != nil {
+			failed = append(failed, c.Name)
+		}
+		found += len(conflicts)
+	}
+
+	switch {
+	case len(failed) > 0:
+		m.App.Session.Put(r.Context(), "error", "Can't import "+strings.Join(failed, ", ")+", see below")
+	case found > 0:
+		m.App.Session.Put(r.Context(), "warning", fmt.Sprintf("Calendars imported, %d dates conflict with this room's", found))
+	default:
+		m.App.Session.Put(r.Context(), "flash", "Calendars imported")
+	}
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d#calendars", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoomCalendar removes an external calendar from a room, freeing
+// the dates it blocked
+func (m *Repository) AdminDeleteRoomCalendar(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "calendarID"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	c, err := m.DB.GetRoomCalendar(id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && c.RoomID != room.ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if err := m.DB.DeleteRoomCalendar(c.ID); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("external calendar deleted", "request_id", helpers.RequestID(r), "room_id", room.ID,
+		"calendar_id", c.ID, "user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", c.Name+" removed, its dates are free again")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d#calendars", room.ID), http.StatusSeeOther)
+}
@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/ical"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestRoomCalendars(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	// The booking site has a stay overlapping ours and one on free dates
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/calendar")
+		io.WriteString(w, "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n"+
+			"BEGIN:VEVENT\r\nUID:stay-1@site\r\nSUMMARY:Reserved\r\nDTSTART;VALUE=DATE:20510703\r\nDTEND;VALUE=DATE:20510705\r\nEND:VEVENT\r\n"+
+			"BEGIN:VEVENT\r\nUID:stay-2@site\r\nSUMMARY:Reserved\r\nDTSTART;VALUE=DATE:20510710\r\nDTEND;VALUE=DATE:20510712\r\nEND:VEVENT\r\n"+
+			"END:VCALENDAR\r\n")
+	}))
+	defer site.Close()
+	Repo.App.CalendarClient = site.Client()
+	defer func() { Repo.App.CalendarClient = nil }()
+
+	m := manageLink.FindStringSubmatch(book(t, ts, "2", "2051-07-01", "2051-07-04"))
+	if m == nil {
+		t.Fatal("expected the summary to link to the guest portal")
+	}
+	res, err := Repo.DB.GetReservationByReference(m[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reviewer := login(t, ts, dbrepo.DemoReviewerEmail)
+	resp, _ := reviewer.PostForm(ts.URL+"/admin/rooms/2/calendars", url.Values{"calendarName": {"Site"}, "calendarSource": {site.URL}})
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a reviewer to be refused, got status %d", resp.StatusCode)
+	}
+
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	for _, source := range []string{"feeds/site.ics", "https://", "http://%zz"} {
+		resp, _ = admin.PostForm(ts.URL+"/admin/rooms/2/calendars", url.Values{"calendarName": {"Site"}, "calendarSource": {source}})
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "like https://example.com/room.ics") {
+			t.Fatalf("expected %q to be refused, got status %d", source, resp.StatusCode)
+		}
+	}
+
+	resp, _ = admin.PostForm(ts.URL+"/admin/rooms/2/calendars", url.Values{"calendarName": {"Site"}, "calendarSource": {site.URL + "/room.ics"}})
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the calendar to be added, got status %d", resp.StatusCode)
+	}
+	day := func(d int) time.Time { return time.Date(2051, 7, d, 0, 0, 0, 0, time.UTC) }
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(day(10), day(12), 2); free {
+		t.Error("expected the booking site's stay to block the room")
+	}
+	if page := book(t, ts, "2", "2051-07-11", "2051-07-13"); !strings.Contains(page, "not available on these dates anymore") {
+		t.Error("expected booking the dates of the booking site's stay to be refused")
+	}
+
+	calendars, _ := Repo.DB.RoomCalendars(2)
+	if len(calendars) != 1 || len(calendars[0].Conflicts) != 1 || calendars[0].Conflicts[0].ReservationID != res.ID {
+		t.Fatalf("expected the overlapping stay to be reported, got %+v", calendars)
+	}
+	resp, _ = admin.Get(ts.URL + "/admin/rooms/2")
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "/admin/reservations/all/") || !strings.Contains(string(page), "/rooms/majors-suite/calendar.ics") {
+		t.Error("expected the room page to show the conflict and the feed")
+	}
+
+	// The feed has our reservation, not what was imported
+	resp, _ = ts.Client().Get(ts.URL + "/rooms/majors-suite/calendar.ics")
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/calendar") {
+		t.Fatalf("expected the calendar feed, got status %d", resp.StatusCode)
+	}
+	events, err := ical.Parse(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, e := range events {
+		if strings.HasSuffix(e.UID, "@site") || e.Start.Equal(day(10)) {
+			t.Errorf("expected imported stays to be left out of the feed, got %+v", e)
+		}
+		found = found || e.Start.Equal(day(1)) && e.End.Equal(day(4)) && e.Summary == "Reserved"
+	}
+	if !found {
+		t.Errorf("expected the reservation in the feed, got %+v", events)
+	}
+	if resp, _ := ts.Client().Get(ts.URL + "/rooms/no-such-room/calendar.ics"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected no feed for an unknown room, got status %d", resp.StatusCode)
+	}
+
+	// Removing the calendar frees its dates
+	resp, _ = admin.PostForm(ts.URL+fmt.Sprintf("/admin/rooms/1/calendars/%d/delete", calendars[0].ID), nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the calendar of another room not to be found, got status %d", resp.StatusCode)
+	}
+	resp, _ = admin.PostForm(ts.URL+fmt.Sprintf("/admin/rooms/2/calendars/%d/delete", calendars[0].ID), nil)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the calendar to be removed, got status %d", resp.StatusCode)
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(day(10), day(12), 2); !free {
+		t.Error("expected the dates of the removed calendar to be free")
+	}
+}
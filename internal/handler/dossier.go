@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/dossier"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/onboarding"
+	"github.com/chamrasilva89/reservationWeb/internal/thumbnail"
+)
+
+// dossierImageSize is the longest side, in pixels, of document copies in dossiers
+const dossierImageSize = 1000
+
+// CustomerDossier serves the KYC dossier of a customer as a PDF, with its
+// profile, trade license, shareholders, representatives, document expiry,
+// risk rating and copies of the uploaded IDs
+func (m *Repository) CustomerDossier(w http.ResponseWriter, r *http.Request) {
+	id, ok := onboardingCustomerID(w, r)
+	if !ok {
+		return
+	}
+
+	rec, err := m.loadOnboarding(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	d := dossier.Dossier{
+		Customer:     rec.Customer,
+		TradeLicense: rec.TradeLicense,
+		Partners:     rec.Partners,
+		Memorandum:   rec.Memorandum,
+		Reviews:      rec.Reviews,
+		GeneratedAt:  time.Now(),
+	}
+	if d.Risk, err = m.currentRisk(r, id); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if d.Hits, err = m.DB.GetSanctionsHitsByCustomerID(id); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	d.GeneratedBy = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	d.Images = m.dossierImages(r, rec)
+
+	// Lay out the whole file first, so errors can still get an error page
+	var buf bytes.Buffer
+	if err := dossier.Write(&buf, d); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	name := fmt.Sprintf("dossier-%s-%s.pdf", rec.Customer.CustomerCode, d.GeneratedAt.Format("2006-01-02"))
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+
+	m.App.Logger.Info("dossier generated", "request_id", helpers.RequestID(r), "customer_id", id,
+		"images", len(d.Images), "bytes", buf.Len(), "user_id", user.ID)
+}
+
+// dossierImages returns copies of the trade license and of the IDs and
+// passports of the customer's shareholders and representatives. Files that
+// can't be shown are listed with the reason.
+func (m *Repository) dossierImages(r *http.Request, rec onboarding.Record) []dossier.Image {
+	byPath := make(map[string]models.Attachment, len(rec.Attachments))
+	for _, a := range rec.Attachments {
+		byPath[a.FilePath] = a
+	}
+
+	var images []dossier.Image
+	add := func(caption, path string) {
+		if path == "" {
+			return
+		}
+		a, ok := byPath[path]
+		if !ok {
+			images = append(images, dossier.Image{Caption: caption, Note: "The file is missing"})
+			return
+		}
+		img, err := m.Thumbs.Preview(r.Context(), a, dossierImageSize)
+		switch {
+		case errors.Is(err, thumbnail.ErrUnsupported):
+			images = append(images, dossier.Image{Caption: caption, Note: "The file can't be shown, see " + a.OriginalName})
+		case err != nil:
+			m.App.Logger.Warn("cannot render document copy for dossier", "request_id", helpers.RequestID(r),
+				"attachment_id", a.File_id, "error", err)
+			images = append(images, dossier.Image{Caption: caption, Note: "The file can't be read"})
+		default:
+			images = append(images, dossier.Image{Caption: caption, Image: img})
+		}
+	}
+
+	if tl := rec.TradeLicense; tl != nil {
+		add("Trade license "+tl.TradeLicenseNo, tl.FilePath)
+	}
+	for _, p := range rec.Partners {
+		add("Emirates ID of "+p.ShareHolderName, p.ShIDFilepath)
+		add("Passport of "+p.ShareHolderName, p.ShPassFilepath)
+	}
+	for _, rep := range rec.Memorandum {
+		add("Emirates ID of "+rep.RepresentativeName, rep.RepIDFilepath)
+		add("Passport of "+rep.RepresentativeName, rep.RepPassFilepath)
+	}
+	return images
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCustomerDossier(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 300, 200)))
+	resp := postCustomer(t, ts, "DOS01", "photo.png", img.Bytes())
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the customer to be added, got status %d", resp.StatusCode)
+	}
+	var id int
+	fmt.Sscanf(resp.Header.Get("Location"), "/customer/onboard/%d", &id)
+
+	// A shareholder with a copy of their passport
+	var passport bytes.Buffer
+	png.Encode(&passport, image.NewRGBA(image.Rect(0, 0, 200, 300)))
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("shareHolderName", "Dossier Holder")
+	mw.WriteField("shEmirateID", "784-1980-1234567-8")
+	mw.WriteField("shPassport", "DS1234567")
+	fw, _ := mw.CreateFormFile("shPassFilepath", "passport.png")
+	fw.Write(passport.Bytes())
+	mw.Close()
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Post(ts.URL+fmt.Sprintf("/customer/add-partner/%d", id), mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the partner to be added, got status %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(ts.URL + fmt.Sprintf("/customer/%d/dossier.pdf", id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdf, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" {
+		t.Fatalf("expected a PDF, got status %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	if d := resp.Header.Get("Content-Disposition"); !strings.Contains(d, "dossier-DOS01-") {
+		t.Errorf("unexpected Content-Disposition %q", d)
+	}
+	if !bytes.HasPrefix(pdf, []byte("%PDF-")) || !bytes.Contains(pdf, []byte("/Subtype /Image")) {
+		t.Error("expected a PDF with the passport copy")
+	}
+
+	resp, _ = client.Get(ts.URL + "/customer/99999/dossier.pdf")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown customer, got status %d", resp.StatusCode)
+	}
+}
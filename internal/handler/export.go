@@ -0,0 +1,268 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/export"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// exportDate formats a date for exports, leaving dates that were never set empty
+func exportDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+var customerColumns = []export.Column[models.Customer]{
+	{Key: "id", Header: "Customer ID", Value: func(c models.Customer) string { return strconv.Itoa(c.CustomerId) }},
+	{Key: "code", Header: "Customer code", Value: func(c models.Customer) string { return c.CustomerCode }},
+	{Key: "name", Header: "Customer name", Value: func(c models.Customer) string { return c.CustomerName }},
+	{Key: "status", Header: "Status", Value: func(c models.Customer) string { return c.Status }},
+	{Key: "contact_person", Header: "Contact person", Value: func(c models.Customer) string { return c.ContactPerson }},
+	{Key: "contact_no", Header: "Phone", Value: func(c models.Customer) string { return c.ContactNo }},
+	{Key: "mobile_no", Header: "Mobile", Value: func(c models.Customer) string { return c.MobileNo }},
+	{Key: "email", Header: "Email", Value: func(c models.Customer) string { return c.Email }},
+	{Key: "business_name", Header: "Business name", Value: func(c models.Customer) string { return c.BusinessName }},
+	{Key: "nature_of_business", Header: "Nature of business", Value: func(c models.Customer) string { return c.NatureOfBusiness }},
+	{Key: "location", Header: "Location", Value: func(c models.Customer) string { return c.LocationDetails }},
+	{Key: "marketer_code", Header: "Marketer code", Value: func(c models.Customer) string { return c.MarketedBy }},
+	{Key: "marketer_name", Header: "Marketer name", Value: func(c models.Customer) string { return c.MarketerName }},
+	{Key: "marketer_email", Header: "Marketer email", Value: func(c models.Customer) string { return c.MarketerEmail }},
+	{Key: "created", Header: "Added on", Value: func(c models.Customer) string { return exportDate(c.CreatedAt) }},
+}
+
+var tradeLicenseColumns = []export.Column[models.TradeLicense]{
+	{Key: "customer_id", Header: "Customer ID", Value: func(t models.TradeLicense) string { return strconv.Itoa(t.CustomerId) }},
+	{Key: "customer_code", Header: "Customer code", Value: func(t models.TradeLicense) string { return t.CustomerCode }},
+	{Key: "license_no", Header: "Trade license number", Value: func(t models.TradeLicense) string { return t.TradeLicenseNo }},
+	{Key: "emirate", Header: "Emirate", Value: func(t models.TradeLicense) string { return t.Emirate }},
+	{Key: "mohre_no", Header: "MOHRE number", Value: func(t models.TradeLicense) string { return t.MohreNo }},
+	{Key: "trade_name", Header: "Trade name", Value: func(t models.TradeLicense) string { return t.TradeName }},
+	{Key: "legal_status", Header: "Legal status", Value: func(t models.TradeLicense) string { return t.LegalStatus }},
+	{Key: "established", Header: "Establishment date", Value: func(t models.TradeLicense) string { return exportDate(t.EstablishDate) }},
+	{Key: "registered", Header: "Registration date", Value: func(t models.TradeLicense) string { return exportDate(t.RegistrationDate) }},
+	{Key: "expiry", Header: "Expiry date", Value: func(t models.TradeLicense) string { return exportDate(t.LicenseExpiry) }},
+	{Key: "copy", Header: "Copy uploaded", Value: func(t models.TradeLicense) string { return yesNo(t.FilePath != "") }},
+}
+
+var shareholderColumns = []export.Column[models.TradeLicenseHolder]{
+	{Key: "customer_id", Header: "Customer ID", Value: func(s models.TradeLicenseHolder) string { return strconv.Itoa(s.CustomerId) }},
+	{Key: "customer_code", Header: "Customer code", Value: func(s models.TradeLicenseHolder) string { return s.CustomerCode }},
+	{Key: "customer_name", Header: "Customer name", Value: func(s models.TradeLicenseHolder) string { return s.CustomerName }},
+	{Key: "name", Header: "Shareholder name", Value: func(s models.TradeLicenseHolder) string { return s.ShareHolderName }},
+	{Key: "role", Header: "Role", Value: func(s models.TradeLicenseHolder) string { return s.ShareHolderRole }},
+	{Key: "nationality", Header: "Nationality", Value: func(s models.TradeLicenseHolder) string { return s.ShNationality }},
+	{Key: "shares", Header: "Number of shares", Value: func(s models.TradeLicenseHolder) string { return strconv.Itoa(s.ShNoOfShares) }},
+	{Key: "emirates_id", Header: "Emirates ID", Value: func(s models.TradeLicenseHolder) string { return s.ShEmirateID }},
+	{Key: "emirates_id_expiry", Header: "Emirates ID expiry", Value: func(s models.TradeLicenseHolder) string { return exportDate(s.ShEmIDExp) }},
+	{Key: "passport", Header: "Passport", Value: func(s models.TradeLicenseHolder) string { return s.ShPassport }},
+	{Key: "passport_expiry", Header: "Passport expiry", Value: func(s models.TradeLicenseHolder) string { return exportDate(s.ShPassportExp) }},
+}
+
+var memorandumColumns = []export.Column[models.Memorandum]{
+	{Key: "customer_id", Header: "Customer ID", Value: func(r models.Memorandum) string { return strconv.Itoa(r.CustomerId) }},
+	{Key: "customer_code", Header: "Customer code", Value: func(r models.Memorandum) string { return r.CustomerCode }},
+	{Key: "name", Header: "Representative name", Value: func(r models.Memorandum) string { return r.RepresentativeName }},
+	{Key: "shares", Header: "Number of shares", Value: func(r models.Memorandum) string { return r.RepNoOfShares }},
+	{Key: "emirates_id", Header: "Emirates ID", Value: func(r models.Memorandum) string { return r.RepEmID }},
+	{Key: "emirates_id_expiry", Header: "Emirates ID expiry", Value: func(r models.Memorandum) string { return exportDate(r.RepEmIDExp) }},
+	{Key: "passport", Header: "Passport", Value: func(r models.Memorandum) string { return r.RepPassport }},
+	{Key: "passport_expiry", Header: "Passport expiry", Value: func(r models.Memorandum) string { return exportDate(r.RepPassportExp) }},
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// Datasets that can be exported
+const (
+	exportCustomers     = "customers"
+	exportTradeLicenses = "trade-licenses"
+	exportShareholders  = "shareholders"
+	exportMemorandums   = "memorandums"
+)
+
+// exportDataset describes a dataset on the export page
+type exportDataset struct {
+	Name    string
+	Title   string
+	Columns []exportColumn
+}
+
+// exportColumn is a column offered for selection on the export page
+type exportColumn struct {
+	Key    string
+	Header string
+}
+
+// offered lists the columns of a dataset for the export page
+func offered[T any](columns []export.Column[T]) []exportColumn {
+	offered := make([]exportColumn, len(columns))
+	for i, c := range columns {
+		offered[i] = exportColumn{Key: c.Key, Header: c.Header}
+	}
+	return offered
+}
+
+// customerFilter reads the filters of the customer list from the query string
+func customerFilter(r *http.Request) models.CustomerFilter {
+	q := r.URL.Query()
+	return models.CustomerFilter{
+		Search: strings.TrimSpace(q.Get("q")),
+		Status: q.Get("status"),
+	}
+}
+
+// filterQuery encodes a customer filter back into a query string
+func filterQuery(f models.CustomerFilter) string {
+	v := url.Values{}
+	if f.Search != "" {
+		v.Set("q", f.Search)
+	}
+	if f.Status != "" {
+		v.Set("status", f.Status)
+	}
+	return v.Encode()
+}
+
+// exportUser returns the logged in user when they may export data, and
+// otherwise answers 403 Forbidden
+func (m *Repository) exportUser(w http.ResponseWriter, r *http.Request) (models.User, bool) {
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	user, err := m.DB.GetUserByID(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return user, false
+	}
+	if !user.CanExport() {
+		helpers.ClientError(w, r, http.StatusForbidden)
+		return user, false
+	}
+	return user, true
+}
+
+// ShowExport shows the export page, where reviewers pick a dataset, its
+// columns and a format. The customer list's filters apply to every dataset.
+func (m *Repository) ShowExport(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.exportUser(w, r); !ok {
+		return
+	}
+
+	f := customerFilter(r)
+	data := make(map[string]interface{})
+	data["filter"] = f
+	data["datasets"] = []exportDataset{
+		{exportCustomers, "Customers", offered(customerColumns)},
+		{exportTradeLicenses, "Trade licenses", offered(tradeLicenseColumns)},
+		{exportShareholders, "Shareholders", offered(shareholderColumns)},
+		{exportMemorandums, "Memorandum representatives", offered(memorandumColumns)},
+	}
+
+	render.Templates(w, r, "customer-export.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// ExportCustomerData streams a dataset of the customers matching the list's
+// filters as CSV or Excel, with the columns asked for
+func (m *Repository) ExportCustomerData(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.exportUser(w, r)
+	if !ok {
+		return
+	}
+
+	q := r.URL.Query()
+	format := q.Get("format")
+	if format == "" {
+		format = export.FormatCSV
+	}
+	if format != export.FormatCSV && format != export.FormatXLSX {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	columns := strings.Join(q["columns"], ",")
+	f := customerFilter(r)
+	db := m.DB
+
+	var rows int
+	var err error
+	switch dataset := chi.URLParam(r, "dataset"); dataset {
+	case exportCustomers:
+		rows, err = streamExport(w, r, dataset, format, customerColumns, columns,
+			func(fn func(models.Customer) error) error { return db.EachCustomer(f, fn) })
+	case exportTradeLicenses:
+		rows, err = streamExport(w, r, dataset, format, tradeLicenseColumns, columns,
+			func(fn func(models.TradeLicense) error) error { return db.EachTradeLicense(f, fn) })
+	case exportShareholders:
+		rows, err = streamExport(w, r, dataset, format, shareholderColumns, columns,
+			func(fn func(models.TradeLicenseHolder) error) error { return db.EachShareholder(f, fn) })
+	case exportMemorandums:
+		rows, err = streamExport(w, r, dataset, format, memorandumColumns, columns,
+			func(fn func(models.Memorandum) error) error { return db.EachMemorandum(f, fn) })
+	default:
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		// The file is already partly sent, so all that can be done is to log it
+		m.App.Logger.Error("export failed", "request_id", helpers.RequestID(r), "dataset", chi.URLParam(r, "dataset"),
+			"format", format, "rows", rows, "user_id", user.ID, "error", err)
+		return
+	}
+	if rows < 0 {
+		return
+	}
+
+	m.App.Logger.Info("data exported", "request_id", helpers.RequestID(r), "dataset", chi.URLParam(r, "dataset"),
+		"format", format, "columns", columns, "search", f.Search, "status", f.Status, "rows", rows, "user_id", user.ID)
+}
+
+// streamExport writes the records each gives as a file download, one row at
+// a time. It returns the number of rows written, or -1 when the request was
+// answered with an error instead.
+func streamExport[T any](w http.ResponseWriter, r *http.Request, dataset, format string, all []export.Column[T], keys string, each func(func(T) error) error) (int, error) {
+	columns, err := export.Select(all, keys)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return -1, nil
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", dataset, time.Now().Format("20060102"), format)
+	w.Header().Set("Content-Type", export.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	out, err := export.New(format, w, dataset)
+	if err != nil {
+		return 0, err
+	}
+	if err := out.Write(export.Headers(columns)); err != nil {
+		return 0, err
+	}
+
+	rows := 0
+	err = each(func(record T) error {
+		rows++
+		return out.Write(export.Row(columns, record))
+	})
+	if err != nil {
+		return rows, err
+	}
+	return rows, out.Close()
+}
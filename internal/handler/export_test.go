@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestExport(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	for _, c := range []models.Customer{
+		{CustomerCode: "EXP01", CustomerName: "Export Alpha Trading", Email: "alpha@example.com", Status: models.CustomerActive},
+		{CustomerCode: "EXP02", CustomerName: "Export Beta Foods", Email: "=cmd|calc", Status: models.CustomerOnboarding},
+	} {
+		id, err := Repo.DB.InsertCustomer(c, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		Repo.DB.UpdateCustomerStatus(id, c.Status)
+		Repo.DB.InsertPartner(models.TradeLicenseHolder{CustomerId: id, ShareHolderName: c.CustomerName + " Owner",
+			ShNationality: "India", ShPassportExp: time.Date(2030, 1, 2, 0, 0, 0, 0, time.UTC)}, 1)
+	}
+
+	// Anonymous users can't export
+	client := ts.Client()
+	resp, _ := client.Get(ts.URL + "/customer/export/customers")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected anonymous exports to be forbidden, got status %d", resp.StatusCode)
+	}
+
+	reviewer := login(t, ts, dbrepo.DemoReviewerEmail)
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := reviewer.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp, body
+	}
+
+	resp, body := get("/customer/export/customers?q=export&status=active&columns=code&columns=name,email")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/csv; charset=utf-8" ||
+		!strings.HasPrefix(resp.Header.Get("Content-Disposition"), `attachment; filename="customers-`) {
+		t.Fatalf("expected a CSV download, got status %d and headers %v", resp.StatusCode, resp.Header)
+	}
+	records, err := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(body, []byte("\uFEFF")))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || strings.Join(records[0], "|") != "Customer code|Customer name|Email" ||
+		strings.Join(records[1], "|") != "EXP01|Export Alpha Trading|alpha@example.com" {
+		t.Errorf("expected the active customer with the selected columns, got %q", records)
+	}
+
+	_, body = get("/customer/export/customers?q=EXP02&columns=email")
+	if !strings.Contains(string(body), "'=cmd|calc") {
+		t.Errorf("expected formulas to be defused, got %q", body)
+	}
+
+	resp, body = get("/customer/export/shareholders?q=export&format=xlsx")
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+		t.Fatalf("expected an Excel download, got status %d", resp.StatusCode)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatalf("expected a valid workbook: %v", err)
+	}
+	var sheet []byte
+	for _, f := range zr.File {
+		if f.Name == "xl/worksheets/sheet1.xml" {
+			rc, _ := f.Open()
+			sheet, _ = io.ReadAll(rc)
+			rc.Close()
+		}
+	}
+	for _, want := range []string{"Export Alpha Trading Owner", "Export Beta Foods Owner", "2030-01-02"} {
+		if !bytes.Contains(sheet, []byte(want)) {
+			t.Errorf("expected the sheet to contain %s", want)
+		}
+	}
+
+	for path, status := range map[string]int{
+		"/customer/export/customers?columns=salary": http.StatusBadRequest,
+		"/customer/export/customers?format=pdf":     http.StatusBadRequest,
+		"/customer/export/invoices":                 http.StatusNotFound,
+		"/customer/export?q=export&status=active":   http.StatusOK,
+		"/customer/all?q=alpha":                     http.StatusOK,
+	} {
+		if resp, _ := get(path); resp.StatusCode != status {
+			t.Errorf("%s: expected status %d but got %d", path, status, resp.StatusCode)
+		}
+	}
+
+	_, body = get("/customer/all?q=alpha")
+	if !strings.Contains(string(body), "EXP01") || strings.Contains(string(body), "EXP02") {
+		t.Error("expected the customer list to be filtered")
+	}
+}
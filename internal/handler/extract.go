@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/chamrasilva89/reservationWeb/internal/extract"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+)
+
+// extractResponse is the JSON answer of PostExtractDocument. Fields holds the
+// values read from the document, with dates formatted as 2006-01-02.
+type extractResponse struct {
+	OK       bool              `json:"ok"`
+	Message  string            `json:"message,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Warnings []string          `json:"warnings,omitempty"`
+}
+
+// PostExtractDocument reads the fields of a scanned passport, Emirates ID or
+// trade license posted in the document field. The file is not stored; the
+// values only prefill the form, staff confirm them before saving.
+func (m *Repository) PostExtractDocument(w http.ResponseWriter, r *http.Request) {
+	if !m.parseUploadForm(w, r) {
+		return
+	}
+
+	files := r.MultipartForm.File["document"]
+	if len(files) != 1 {
+		writeExtract(w, http.StatusBadRequest, extractResponse{Message: "Post exactly one document."})
+		return
+	}
+
+	u, err := m.Uploads.Check(r.Context(), 0, "document", files[0])
+	if err != nil {
+		if uploads.IsRejected(err) {
+			m.App.Logger.Info("document for extraction rejected", "request_id", helpers.RequestID(r), "error", err)
+			writeExtract(w, http.StatusUnprocessableEntity, extractResponse{Message: err.Error()})
+			return
+		}
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	f, err := u.Open()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	p, err := m.Extractor.Extract(r.Context(), f, u.ContentType, r.Form.Get("kind"))
+	switch {
+	case errors.Is(err, extract.ErrNoOCR):
+		writeExtract(w, http.StatusNotImplemented, extractResponse{Message: "Reading documents is not set up on this server."})
+		return
+	case errors.Is(err, extract.ErrUnsupported):
+		writeExtract(w, http.StatusUnprocessableEntity, extractResponse{Message: err.Error()})
+		return
+	case err != nil:
+		m.App.Logger.Error("cannot extract document fields", "request_id", helpers.RequestID(r), "error", err)
+		writeExtract(w, http.StatusInternalServerError, extractResponse{Message: "The document could not be read."})
+		return
+	}
+
+	fields := map[string]string{}
+	for name, value := range map[string]string{
+		"name":            p.Name,
+		"document_number": p.DocumentNumber,
+		"emirates_id":     p.EmiratesID,
+		"nationality":     p.Nationality,
+		"trade_name":      p.TradeName,
+	} {
+		if value != "" {
+			fields[name] = value
+		}
+	}
+	if !p.BirthDate.IsZero() {
+		fields["birth_date"] = p.BirthDate.Format("2006-01-02")
+	}
+	if !p.ExpiryDate.IsZero() {
+		fields["expiry_date"] = p.ExpiryDate.Format("2006-01-02")
+	}
+
+	m.App.Logger.Debug("extracted document fields", "kind", p.Kind, "source", p.Source, "fields", len(fields))
+	writeExtract(w, http.StatusOK, extractResponse{OK: true, Fields: fields, Warnings: p.Warnings})
+}
+
+func writeExtract(w http.ResponseWriter, status int, resp extractResponse) {
+	out, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(out)
+}
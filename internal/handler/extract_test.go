@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/extract"
+)
+
+type fakeOCR struct {
+	text string
+}
+
+func (f fakeOCR) Recognize(ctx context.Context, img image.Image) (string, error) {
+	return f.text, nil
+}
+
+func postDocument(t *testing.T, ts *httptest.Server, kind string, data []byte) (int, extractResponse) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("kind", kind)
+	fw, _ := mw.CreateFormFile("document", "scan.png")
+	fw.Write(data)
+	mw.Close()
+
+	resp, err := ts.Client().Post(ts.URL+"/customer/extract", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var out extractResponse
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, out
+}
+
+func TestPostExtractDocument(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	var scan bytes.Buffer
+	png.Encode(&scan, image.NewGray(image.Rect(0, 0, 40, 20)))
+
+	Repo.Extractor = &extract.Extractor{}
+	if status, _ := postDocument(t, ts, extract.Passport, scan.Bytes()); status != http.StatusNotImplemented {
+		t.Errorf("expected %d without an OCR backend but got %d", http.StatusNotImplemented, status)
+	}
+
+	Repo.Extractor = &extract.Extractor{OCR: fakeOCR{text: "P<UTOERIKSSON<<ANNA<MARIA<<<<<<<<<<<<<<<<<<<\n" +
+		"L898902C36UTO7408122F1204159ZE184226B<<<<<10"}}
+	defer func() { Repo.Extractor = &extract.Extractor{} }()
+
+	status, resp := postDocument(t, ts, extract.Passport, scan.Bytes())
+	if status != http.StatusOK || !resp.OK {
+		t.Fatalf("expected the passport to be read, got %d %+v", status, resp)
+	}
+	if resp.Fields["document_number"] != "L898902C3" || resp.Fields["expiry_date"] != "2012-04-15" ||
+		resp.Fields["name"] != "ANNA MARIA ERIKSSON" {
+		t.Errorf("unexpected fields %v", resp.Fields)
+	}
+
+	if status, _ := postDocument(t, ts, extract.Passport, []byte("not an image")); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected %d for a file that is not a document but got %d", http.StatusUnprocessableEntity, status)
+	}
+	if status, _ := postDocument(t, ts, "visa", scan.Bytes()); status != http.StatusUnprocessableEntity {
+		t.Errorf("expected %d for an unknown kind but got %d", http.StatusUnprocessableEntity, status)
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/thumbnail"
+	"github.com/go-chi/chi"
+)
+
+// attachmentFromURL loads the attachment whose ID is in the URL, answering 404
+// itself when there is none
+func (m *Repository) attachmentFromURL(w http.ResponseWriter, r *http.Request) (models.Attachment, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Attachment{}, false
+	}
+
+	a, err := m.DB.GetAttachmentByID(id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, r, http.StatusNotFound)
+		} else {
+			helpers.ServerError(w, r, err)
+		}
+		return models.Attachment{}, false
+	}
+	return a, true
+}
+
+// ShowFile serves an uploaded file to staff, under its original name
+func (m *Repository) ShowFile(w http.ResponseWriter, r *http.Request) {
+	a, ok := m.attachmentFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	f, err := os.Open(a.FilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helpers.ClientError(w, r, http.StatusNotFound)
+			return
+		}
+		helpers.ServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	name := a.OriginalName
+	if name == "" {
+		name = a.FileName
+	}
+	w.Header().Set("Content-Type", a.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("inline", map[string]string{"filename": name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	http.ServeContent(w, r, "", a.CreatedAt, f)
+}
+
+// ShowFileThumb serves the thumbnail of an uploaded file, made on first use and
+// cached after that. Files without a thumbnail get a placeholder picture.
+func (m *Repository) ShowFileThumb(w http.ResponseWriter, r *http.Request) {
+	a, ok := m.attachmentFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+
+	path, err := m.Thumbs.Thumbnail(r.Context(), a)
+	if err != nil {
+		if !errors.Is(err, thumbnail.ErrUnsupported) {
+			m.App.Logger.Warn("cannot create thumbnail",
+				"request_id", helpers.RequestID(r),
+				"file_id", a.File_id,
+				"error", err,
+			)
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(thumbnail.Placeholder())
+		return
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	modified := time.Time{}
+	if fi, err := f.Stat(); err == nil {
+		modified = fi.ModTime()
+	}
+	w.Header().Set("Content-Type", "image/jpeg")
+	http.ServeContent(w, r, "", modified, f)
+}
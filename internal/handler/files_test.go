@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// postCustomer posts the add customer form with one file in the photos field
+func postCustomer(t *testing.T, ts *httptest.Server, code, fileName string, data []byte) *http.Response {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fields := map[string]string{
+		"customerCode":  code,
+		"customerName":  "Test Customer",
+		"contactPerson": "Test Person",
+		"contactNo":     "041234567",
+		"mobileNo":      "0501234567",
+		"email":         "test@example.com",
+	}
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	fw, _ := mw.CreateFormFile("photos", fileName)
+	fw.Write(data)
+	mw.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Post(ts.URL+"/customer/add", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestFiles(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 600, 300)))
+
+	resp := postCustomer(t, ts, "FILES01", "photo.png", img.Bytes())
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the customer to be added, got status %d", resp.StatusCode)
+	}
+
+	// A file that is not a document is refused with a message on the form
+	resp = postCustomer(t, ts, "FILES02", "notes.pdf", []byte("plain text"))
+	page, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "only PDF, JPEG and PNG files can be uploaded") {
+		t.Errorf("expected the form to show the rejected file, got status %d", resp.StatusCode)
+	}
+
+	customers, _ := Repo.DB.AllCustomers()
+	var fileID int
+	for _, c := range customers {
+		if c.CustomerCode == "FILES01" {
+			attachments, err := Repo.DB.GetAttachmentsByCustomerID(c.CustomerId)
+			if err != nil || len(attachments.Attachments) != 1 {
+				t.Fatalf("expected one attachment, got %+v (%v)", attachments, err)
+			}
+			fileID = attachments.Attachments[0].File_id
+		}
+	}
+
+	var tests = []struct {
+		name        string
+		url         string
+		status      int
+		contentType string
+	}{
+		{"file", fmt.Sprintf("/customer/files/%d", fileID), http.StatusOK, "image/png"},
+		{"thumb", fmt.Sprintf("/customer/files/%d/thumb", fileID), http.StatusOK, "image/jpeg"},
+		{"missing file", "/customer/files/999999", http.StatusNotFound, ""},
+		{"missing thumb", "/customer/files/999999/thumb", http.StatusNotFound, ""},
+		{"bad id", "/customer/files/abc/thumb", http.StatusNotFound, ""},
+	}
+
+	for _, e := range tests {
+		resp, err := ts.Client().Get(ts.URL + e.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != e.status {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.status, resp.StatusCode)
+			continue
+		}
+		if e.contentType != "" && resp.Header.Get("Content-Type") != e.contentType {
+			t.Errorf("%s: expected %s but got %s", e.name, e.contentType, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/customer/files/%d", fileID))
+	if cd := resp.Header.Get("Content-Disposition"); cd != `inline; filename=photo.png` {
+		t.Errorf("expected the original name in Content-Disposition, got %q", cd)
+	}
+}
@@ -3,10 +3,8 @@ package handler
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"mime/multipart"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -16,30 +14,87 @@ import (
 
 	"github.com/chamrasilva89/reservationWeb/internal/config"
 	"github.com/chamrasilva89/reservationWeb/internal/driver"
+	"github.com/chamrasilva89/reservationWeb/internal/extract"
 	"github.com/chamrasilva89/reservationWeb/internal/forms"
 	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/metrics"
 	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/onboarding"
+	"github.com/chamrasilva89/reservationWeb/internal/pricing"
 	"github.com/chamrasilva89/reservationWeb/internal/render"
 	"github.com/chamrasilva89/reservationWeb/internal/repository"
 	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+	"github.com/chamrasilva89/reservationWeb/internal/risk"
+	"github.com/chamrasilva89/reservationWeb/internal/sanctions"
+	"github.com/chamrasilva89/reservationWeb/internal/thumbnail"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
 	"github.com/go-chi/chi"
-	"github.com/google/uuid"
 )
 
 var Repo *Repository
 
 type Repository struct {
-	App *config.AppConfig
-	DB  repository.DatabaseRepo
+	App       *config.AppConfig
+	DB        repository.DatabaseRepo
+	Uploads   *uploads.Store
+	Thumbs    *thumbnail.Generator
+	Extractor *extract.Extractor
+	Risk      *risk.Engine
+	Sanctions *sanctions.Cache
 }
 
 func NewRepo(a *config.AppConfig, db *driver.DB) *Repository {
+	repo := dbrepo.NewPostgresRepo(db, a)
 	return &Repository{
-		App: a,
-		DB:  dbrepo.NewPostgresRepo(db.SQL, a),
+		App:       a,
+		DB:        repo,
+		Uploads:   newUploadStore(a, repo),
+		Thumbs:    newThumbnailGenerator(a),
+		Extractor: &extract.Extractor{OCR: a.OCR, PDF: a.PDFRenderer},
+		Risk:      riskEngine(a),
+		Sanctions: &sanctions.Cache{},
 	}
 }
 
+// NewMemoryRepo creates a repository backed by the in-memory database, used by
+// the tests and the demo mode
+func NewMemoryRepo(a *config.AppConfig) (*Repository, error) {
+	db, err := dbrepo.NewMemoryRepo(a)
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{
+		App:       a,
+		DB:        db,
+		Uploads:   newUploadStore(a, db),
+		Thumbs:    newThumbnailGenerator(a),
+		Extractor: &extract.Extractor{OCR: a.OCR, PDF: a.PDFRenderer},
+		Risk:      riskEngine(a),
+		Sanctions: &sanctions.Cache{},
+	}, nil
+}
+
+// riskEngine returns the configured risk scoring rules, or the default ones
+func riskEngine(a *config.AppConfig) *risk.Engine {
+	if a.RiskRules != nil {
+		return a.RiskRules
+	}
+	return risk.Default()
+}
+
+// newUploadStore creates the store for uploaded files, scanning them with the configured scanner
+func newUploadStore(a *config.AppConfig, db repository.DatabaseRepo) *uploads.Store {
+	s := uploads.NewStore(a.UploadPath, db)
+	s.Scanner = a.Scanner
+	return s
+}
+
+// newThumbnailGenerator creates the generator for thumbnails of uploaded files,
+// caching them next to the uploads
+func newThumbnailGenerator(a *config.AppConfig) *thumbnail.Generator {
+	return thumbnail.New(filepath.Join(a.UploadPath, ".thumbs"), a.PDFRenderer)
+}
+
 func NewHandlers(r *Repository) {
 	Repo = r
 }
@@ -48,20 +103,34 @@ func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
 	render.Templates(w, r, "home.page.tmpl", &models.TemplateData{})
 }
 
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Templates(w, r, "generals.page.tmpl", &models.TemplateData{})
-}
+// Reservation shows the reservation form, with the room and dates chosen
+// after searching for availability and their price
+func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
+	form := forms.New(nil)
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok || res.RoomID == 0 {
+		m.renderReservation(w, r, models.Reservation{}, form)
+		return
+	}
 
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Templates(w, r, "majors.page.tmpl", &models.TemplateData{})
+	price, err := m.quote(res.Room, res.StartDate, res.EndDate, "")
+	if err = addPriceError(form, err); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	res.Price = price
+	m.renderReservation(w, r, res, form)
 }
 
-func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
-	var emptyReservation models.Reservation
+// renderReservation shows the reservation form with the price of the stay when it is known
+func (m *Repository) renderReservation(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
 	data := make(map[string]interface{})
-	data["reservation"] = emptyReservation
+	data["reservation"] = res
+	if len(res.Price.Lines) > 0 {
+		data["invoice"] = m.invoice(res)
+	}
 	render.Templates(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
+		Form: form,
 		Data: data,
 	})
 }
@@ -69,7 +138,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -80,24 +149,40 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, sd)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	endDate, err := time.Parse(layout, ed)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	// Parse room_id from the form and handle errors
 	roomID, err := strconv.Atoi(r.Form.Get("room_id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+	room, err := m.DB.GetRoomByID(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	reference, err := newReservationReference()
+	if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	// Create a reservation object with form data
 	reservation := models.Reservation{
+		Reference: reference,
 		FirstName: r.Form.Get("first_name"),
 		LastName:  r.Form.Get("last_name"),
 		Email:     r.Form.Get("email"),
@@ -105,6 +190,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		StartDate: startDate,
 		EndDate:   endDate,
 		RoomID:    roomID,
+		Room:      room,
 	}
 
 	// Create a form object for validation
@@ -115,40 +201,46 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
+	// Work out the price of the stay, which is kept with the reservation
+	reservation.Price, err = m.quote(room, startDate, endDate, form.Get("discount_code"))
+	if err = addPriceError(form, err); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	// If the form is not valid, render the reservation page with validation errors
 	if !form.Valid() {
-		data := make(map[string]interface{})
-		data["reservation"] = reservation
-
-		render.Templates(w, r, "make-reservation.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
-		})
+		m.renderReservation(w, r, reservation, form)
 		return
 	}
 
 	// Insert the reservation into the database
 	newReservationID, err := m.DB.InsertReservation(reservation)
-	if err != nil {
-		helpers.ServerError(w, err)
+	if errors.Is(err, repository.ErrDiscountUsedUp) {
+		form.Errors.Add("discount_code", "This code has been used up")
+		reservation.Price, _ = m.quote(room, startDate, endDate, "")
+		m.renderReservation(w, r, reservation, form)
 		return
 	}
-
-	// Create a room restriction for the reservation
-	restriction := models.RoomRestriction{
-		StartDate:     startDate,
-		EndDate:       endDate,
-		RoomID:        roomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	if errors.Is(err, repository.ErrNotAvailable) {
+		// Booked by another guest, or blocked by an external calendar, since the search
+		m.App.Session.Put(r.Context(), "error", "The room is not available on these dates anymore, please search again")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
 	}
+	metrics.ReservationsCreated.Inc()
 
-	// Insert the room restriction into the database
-	err = m.DB.InsertRoomRestriction(restriction)
+	// Load the reservation back with its room, for the confirmation
+	reservation, err = m.DB.GetReservationByID(newReservationID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	m.sendConfirmation(r, reservation)
 
 	// Store the reservation in the session and redirect to the reservation summary page
 	m.App.Session.Put(r.Context(), "reservation", reservation)
@@ -160,23 +252,39 @@ func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	start := r.Form.Get("start")
 	end := r.Form.Get("end")
 
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, start)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	endDate, err := time.Parse(layout, end)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !endDate.After(startDate) {
+		m.App.Session.Put(r.Context(), "error", "The departure must be after the arrival")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+	if endDate.After(startDate.AddDate(0, 0, pricing.MaxNights)) {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Stays are at most %d nights", pricing.MaxNights))
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
 	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -186,7 +294,71 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
 		return
 	}
-	w.Write([]byte(fmt.Sprintf("start date is %s and end date is %s", start, end)))
+
+	// Price the stay in every free room, rooms needing a longer stay are shown
+	// with the reason they can't be booked
+	var choices []roomChoice
+	for _, room := range rooms {
+		c := roomChoice{Room: room}
+		c.Price, err = m.quote(room, startDate, endDate, "")
+		if err != nil {
+			var minStay *pricing.MinStayError
+			if !errors.As(err, &minStay) {
+				helpers.ServerError(w, r, err)
+				return
+			}
+			msg := minStay.Error()
+			c.Unavailable = strings.ToUpper(msg[:1]) + msg[1:]
+		}
+		choices = append(choices, c)
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", models.Reservation{StartDate: startDate, EndDate: endDate})
+
+	data := make(map[string]interface{})
+	data["rooms"] = choices
+	data["nights"] = models.Reservation{StartDate: startDate, EndDate: endDate}.Nights()
+	render.Templates(w, r, "choose-room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// roomChoice is a free room offered for the dates searched, with the price of the stay
+type roomChoice struct {
+	Room        models.Room
+	Price       models.Price
+	Unavailable string // why the room can't be booked for these dates
+}
+
+// ChooseRoom adds the room chosen after searching for availability to the
+// reservation and goes on to the reservation form
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Search for your dates first")
+		http.Redirect(w, r, "/search-availability", http.StatusSeeOther)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(roomID)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	res.RoomID = room.ID
+	res.Room = room
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
 }
 
 type jsonResponse struct {
@@ -204,7 +376,6 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		panic(err)
 	}
-	log.Println(string(out))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(out)
 }
@@ -220,7 +391,7 @@ func (m *Repository) About(w http.ResponseWriter, r *http.Request) {
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		log.Println("cannor get items from session")
+		m.App.Logger.Debug("cannot get reservation from session", "request_id", helpers.RequestID(r))
 		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
@@ -228,6 +399,13 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	m.App.Session.Remove(r.Context(), "reservation")
 	data := make(map[string]interface{})
 	data["reservation"] = reservation
+	data["invoice"] = m.invoice(reservation)
+	data["confirmationURL"] = confirmationURL(reservation)
+	data["emailed"] = m.App.Mailer != nil
+	data["manageURL"] = m.manageURL(reservation)
+	if m.App.Payments != nil {
+		data["paymentURL"] = paymentURL(reservation)
+	}
 
 	render.Templates(w, r, "reservation-summary.page.tmpl", &models.TemplateData{
 		Data: data,
@@ -248,7 +426,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 	// Parse the form data from the HTTP request
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.Error("cannot parse login form", "request_id", helpers.RequestID(r), "error", err)
 	}
 
 	// Get the user's email and password from the form data
@@ -292,15 +470,28 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
+// AdminDashboard shows the admin dashboard with the queue of records waiting
+// for KYC review
 func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Templates(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	reviews, err := m.DB.PendingKYCReviews()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reviews"] = reviews
+	data["userID"] = m.App.Session.GetInt(r.Context(), "user_id")
+	render.Templates(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-// AdminNewReservations shows all new reservations in admin tool
+// AdminNewReservations shows the pending reservations in admin tool
 func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
+	reservations, err := m.DB.AllReservations(models.ReservationPending)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -311,19 +502,27 @@ func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request
 	})
 }
 
-// AdminAllReservations shows all reservations inu admin tool
+// AdminAllReservations shows all reservations in admin tool, or those in the
+// state given by the state query parameter
 func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+	state := r.URL.Query().Get("state")
+	if state != "" && !models.IsReservationState(state) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	reservations, err := m.DB.AllReservations(state)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	data := make(map[string]interface{})
 	data["reservations"] = reservations
+	data["states"] = models.ReservationStates
 
 	render.Templates(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
-		Data: data,
+		Data:      data,
+		StringMap: map[string]string{"state": state},
 	})
 }
 
@@ -333,7 +532,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -351,13 +550,21 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 	// Get the reservation from the database using the reservation ID
 	res, err := m.DB.GetReservationByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	// Create data for rendering the reservation details
+	history, err := m.DB.GetReservationHistory(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["history"] = history
+	data["payments"] = m.App.Payments != nil
 
 	// Render the "admin-reservations-show" template with the string map, data, and an empty form
 	render.Templates(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
@@ -371,7 +578,7 @@ func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request
 func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -379,7 +586,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	id, err := strconv.Atoi(exploded[4])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -390,7 +597,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	res, err := m.DB.GetReservationByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -401,7 +608,7 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 
 	err = m.DB.UpdateReservation(res)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -417,84 +624,117 @@ func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Req
 	}
 }
 
-// AdminProcessReservation marks a reservation as processed
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
-	// Extract the 'id' and 'src' parameters from the URL
-	id, _ := strconv.Atoi(chi.URLParam(r, "id"))
-	src := chi.URLParam(r, "src")
-
-	// Call the UpdateProcessedForReservation method to mark the reservation as processed
-	err := m.DB.UpdateProcessedForReservation(id, 1)
+// AdminPostReservationState moves a reservation to the state of the posted
+// form, when the reservation may change to it. Cancelling from the admin tool
+// charges no fee.
+func (m *Repository) AdminPostReservationState(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		log.Println(err)
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
 	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	show := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id)
 
-	// Get the 'y' and 'm' query parameters from the URL
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
-
-	// Set a flash message using the session to indicate that the reservation is marked as processed
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+	state := r.Form.Get("state")
+	if err := res.CheckTransition(state, time.Now()); err != nil {
+		m.App.Session.Put(r.Context(), "error", "The reservation wasn't changed, "+err.Error())
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
 
-	if year == "" {
-		// If 'year' is empty, redirect to the reservations page for the specified 'src'
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
+	e := models.ReservationEvent{
+		ReservationID: id,
+		FromState:     res.State,
+		ToState:       state,
+		UserID:        m.App.Session.GetInt(r.Context(), "user_id"),
+		Comment:       strings.TrimSpace(r.Form.Get("comment")),
+	}
+	if state == models.ReservationCancelled {
+		err = m.DB.CancelReservation(e, 0)
 	} else {
-		// If 'year' is not empty, redirect to the reservations calendar page with 'year' and 'month' query parameters
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%s&m=%s", year, month), http.StatusSeeOther)
+		err = m.DB.TransitionReservation(e)
 	}
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "error", "The reservation was changed meanwhile, please try again")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("reservation state changed", "request_id", helpers.RequestID(r), "reservation_id", id,
+		"from", res.State, "to", state, "user_id", e.UserID)
+	if state == models.ReservationCancelled {
+		m.cancelPayment(r, res)
+	}
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked "+models.StateLabel(state))
+	http.Redirect(w, r, show, http.StatusSeeOther)
 }
 
+// AddCustomer shows the first step of onboarding a customer, filled in from
+// the user's draft when there is one
 func (m *Repository) AddCustomer(w http.ResponseWriter, r *http.Request) {
+	form, err := m.draftForm(r, 0, onboarding.StepCompany)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
 	var emptyCustomer models.Customer
 	data := make(map[string]interface{})
 	data["customer"] = emptyCustomer
+	m.addOnboarding(data, 0, onboarding.StepCompany)
 	render.Templates(w, r, "add-customer.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
+		Form: form,
 		Data: data,
 	})
 }
 
 func (m *Repository) PostCustomer(w http.ResponseWriter, r *http.Request) {
 	// Parse the form to handle form fields and file uploads
-	err := r.ParseMultipartForm(10 << 20) // 10MB maximum file size
-	if err != nil {
-		helpers.ServerError(w, err)
+	if !m.parseUploadForm(w, r) {
 		return
 	}
 	// Retrieve the uploaded files
 	files := r.MultipartForm.File["photos"]
-	fmt.Println("Customer intert started ", files)
-	// Create a reservation object with form data
-	customer := models.Customer{
-		CustomerCode:     r.Form.Get("customerCode"),
-		CustomerName:     r.Form.Get("customerName"),
-		ContactNo:        r.Form.Get("contactNo"),
-		ContactPerson:    r.Form.Get("contactPerson"),
-		MobileNo:         r.Form.Get("mobileNo"),
-		BusinessName:     r.Form.Get("businessName"),
-		Email:            r.Form.Get("email"),
-		LocationDetails:  r.Form.Get("locationDetails"),
-		NatureOfBusiness: r.Form.Get("natureOfBusiness"),
-		MarketedBy:       r.Form.Get("marketedBy"),
-		MarketerName:     r.Form.Get("marketerName"),
-		MarketerEmail:    r.Form.Get("marketerEmail"),
-		Status:           r.Form.Get("status"),
-	}
-	fmt.Println("Customer Code:", r.Form.Get("customerCode"))
-	fmt.Println("Customer Name:", r.Form.Get("customerName"))
-	fmt.Println("Customer data", customer.CustomerCode, customer.CustomerName, customer.ContactNo)
-	// Create a form object for validation
+	m.App.Logger.Debug("adding customer", "customer_code", r.Form.Get("customerCode"), "files", len(files))
+
+	// Bind and validate the form data, phone numbers are stored in E.164 format.
+	// New customers are onboarding until their checklist is complete.
+	customer := models.Customer{Status: models.CustomerOnboarding}
 	form := forms.New(r.PostForm)
+	form.Bind(&customer)
 
-	// Check required fields and add validation errors
-	form.Required("customerCode", "customerName", "contactPerson", "contactNo", "mobileNo", "email")
-	form.IsEmail("email")
+	// Check the uploaded files before anything is stored, the customer is new so there are no duplicates yet
+	checked, err := m.Uploads.CheckAll(r.Context(), 0, "photos", files)
+	if err != nil {
+		if !uploads.IsRejected(err) {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.rejectUpload(r, form, "photos", err)
+	}
 
 	// If the form is not valid, render the reservation page with validation errors
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["customer"] = customer
+		m.addOnboarding(data, 0, onboarding.StepCompany)
 
 		render.Templates(w, r, "add-customer.page.tmpl", &models.TemplateData{
 			Form: form,
@@ -504,87 +744,159 @@ func (m *Repository) PostCustomer(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert the reservation into the database
-	newCustomerID, err := m.DB.InsertCustomer(customer)
+	newCustomerID, err := m.DB.InsertCustomer(customer, m.submitter(r))
 	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
-	// If files were uploaded, save them in a directory with the customer code
-	if len(files) > 0 {
-		customerCode := r.Form.Get("customerCode")
-		//customerDir := fmt.Sprintf("/path/to/upload_directory/%s", customerCode)
-		//customerDir := "E:\\GO\\FF\\" + customerCode
-		customerDir := fmt.Sprintf("%s\\%s", config.FileUploadPath, customerCode)
-		if _, err := os.Stat(customerDir); os.IsNotExist(err) {
-			// The directory doesn't exist, so create it
-			if err := os.MkdirAll(customerDir, os.ModePerm); err != nil {
-				helpers.ServerError(w, err)
-				return
-			}
+		helpers.ServerError(w, r, err)
+		return
+	}
+	metrics.CustomersAdded.Inc()
+
+	// Save the uploaded files in the customer's directory
+	for _, u := range checked {
+		if _, err := m.saveUpload(r, newCustomerID, customer.CustomerCode, uploads.KindCustomer, u); err != nil {
+			helpers.ServerError(w, r, err)
+			return
 		}
+	}
 
-		for _, file := range files {
-			//uniqueFilename := generateUniqueFilename()
+	m.logSubmitted(r, newCustomerID, models.RecordCustomer, newCustomerID)
+	m.refreshRisk(r, newCustomerID)
 
-			// Extract the file extension from the original filename
-			originalFilename := file.Filename
-			//fileExtension := filepath.Ext(originalFilename)
+	if err := m.DB.DeleteCustomerDraft(0, m.draftUserID(r, 0), onboarding.StepCompany); err != nil {
+		m.App.Logger.Error("cannot delete onboarding draft", "request_id", helpers.RequestID(r), "error", err)
+	}
 
-			// Append the file extension to the unique filename
-			uniqueFilenameWithExtension := originalFilename
+	m.App.Session.Put(r.Context(), "flash", "New Customer Added Successfully..! ID :"+strconv.Itoa(newCustomerID))
+	// Store the customer in the session and carry on with the next onboarding step
+	m.App.Session.Put(r.Context(), "customer", customer)
+	http.Redirect(w, r, fmt.Sprintf("/customer/onboard/%d", newCustomerID), http.StatusSeeOther)
+}
 
-			filePath := filepath.Join(customerDir, uniqueFilenameWithExtension)
+// parseUploadForm parses a multipart form, refusing requests larger than
+// uploads.MaxRequestSize. It sends the error response itself and reports
+// whether the handler can go on.
+func (m *Repository) parseUploadForm(w http.ResponseWriter, r *http.Request) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, uploads.MaxRequestSize)
+	err := r.ParseMultipartForm(10 << 20) // files above 10MB are buffered on disk
+	if err == nil {
+		return true
+	}
 
-			destinationFile, err := os.Create(filePath)
-			if err != nil {
-				helpers.ServerError(w, err)
-				return
-			}
-			defer destinationFile.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		helpers.ClientError(w, r, http.StatusRequestEntityTooLarge)
+		return false
+	}
+	helpers.ClientError(w, r, http.StatusBadRequest)
+	return false
+}
 
-			sourceFile, err := file.Open()
-			if err != nil {
-				helpers.ServerError(w, err)
-				return
-			}
-			defer sourceFile.Close()
+// checkUploads validates the single file posted in each of fields, adding a form
+// error for every file that is rejected. Fields without a file are left out of
+// the result.
+func (m *Repository) checkUploads(r *http.Request, form *forms.Form, customerID int, fields ...string) (map[string]*uploads.Upload, error) {
+	checked := make(map[string]*uploads.Upload)
+	seen := make(map[string]bool)
+
+	for _, field := range fields {
+		files := r.MultipartForm.File[field]
+		if len(files) == 0 {
+			continue
+		}
+		if len(files) > 1 {
+			form.Errors.Add(field, "only one file can be uploaded")
+			continue
+		}
 
-			_, err = io.Copy(destinationFile, sourceFile)
-			if err != nil {
-				helpers.ServerError(w, err)
-				return
-			}
-			fmt.Println("File name with Extension", uniqueFilenameWithExtension)
-			// Save the file path in the database
-			_, err = m.DB.InsertFile(customerCode, filePath, newCustomerID, uniqueFilenameWithExtension)
-			if err != nil {
-				helpers.ServerError(w, err)
-				return
+		u, err := m.Uploads.Check(r.Context(), customerID, field, files[0])
+		if err != nil {
+			if !uploads.IsRejected(err) {
+				return nil, err
 			}
+			m.rejectUpload(r, form, field, err)
+			continue
 		}
+		if seen[u.Checksum] {
+			form.Errors.Add(field, uploads.ErrDuplicate.Error())
+			continue
+		}
+		seen[u.Checksum] = true
+		checked[field] = u
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "New Customer Added Successfully..! ID :"+strconv.Itoa(newCustomerID))
-	// Store the reservation in the session and redirect to the reservation summary page
-	m.App.Session.Put(r.Context(), "customer", customer)
-	http.Redirect(w, r, "/customer/all", http.StatusSeeOther)
+	return checked, nil
 }
 
-func generateUniqueFilename() string {
-	// Generate a UUID to ensure a unique filename
-	id := uuid.New()
-	return id.String()
+// saveUpload stores a checked file for the customer and makes its thumbnail,
+// a thumbnail that can't be made is tried again when it is first shown
+func (m *Repository) saveUpload(r *http.Request, customerID int, customerCode, kind string, u *uploads.Upload) (models.Attachment, error) {
+	a, err := m.Uploads.Save(customerID, customerCode, kind, u)
+	if err != nil {
+		return a, err
+	}
+	m.App.Logger.Debug("stored file", "customer_id", customerID, "kind", kind, "file_id", a.File_id, "original_name", a.OriginalName)
+	metrics.UploadsStored.Inc(kind)
+
+	if err := m.Thumbs.Generate(r.Context(), a); err != nil && !errors.Is(err, thumbnail.ErrUnsupported) {
+		m.App.Logger.Warn("cannot create thumbnail",
+			"request_id", helpers.RequestID(r),
+			"file_id", a.File_id,
+			"error", err,
+		)
+	}
+	return a, nil
+}
+
+// rejectUpload shows why a file was refused next to its form field, logging
+// the files the virus scanner flagged or could not check
+func (m *Repository) rejectUpload(r *http.Request, form *forms.Form, field string, err error) {
+	form.Errors.Add(field, err.Error())
+
+	var infected *uploads.InfectedError
+	var scanErr *uploads.ScanError
+	switch {
+	case errors.As(err, &infected):
+		m.App.Logger.Warn("infected upload quarantined",
+			"request_id", helpers.RequestID(r),
+			"field", field,
+			"original_name", infected.Upload.OriginalName,
+			"checksum", infected.Upload.Checksum,
+			"signature", infected.Signature,
+		)
+		metrics.UploadsQuarantined.Inc()
+	case errors.As(err, &scanErr):
+		m.App.Logger.Error("virus scan failed",
+			"request_id", helpers.RequestID(r),
+			"field", field,
+			"error", scanErr.Err,
+		)
+	}
 }
 
 func (m *Repository) AllCustomers(w http.ResponseWriter, r *http.Request) {
-	customers, err := m.DB.AllCustomers()
+	// Narrow the list down with the search and status filters, which exports keep
+	f := customerFilter(r)
+	var customers []models.Customer
+	err := m.DB.EachCustomer(f, func(c models.Customer) error {
+		customers = append(customers, c)
+		return nil
+	})
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	data := make(map[string]interface{})
 	data["customer"] = customers
+	data["filter"] = f
+	data["filterQuery"] = filterQuery(f)
+	data["canExport"] = user.CanExport()
 
 	render.Templates(w, r, "all-customers.page.tmpl", &models.TemplateData{
 		Data: data,
@@ -593,14 +905,12 @@ func (m *Repository) AllCustomers(w http.ResponseWriter, r *http.Request) {
 
 func (m *Repository) ShowCustomerDetails(w http.ResponseWriter, r *http.Request) {
 	// Split the request URI to extract the customer ID
-	fmt.Println("Inside customer show 1")
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("Inside customer show ", id)
 
 	// Get the customer information from the database using the customer ID
 	res, err := m.DB.GetCustomerByID(id)
@@ -611,42 +921,60 @@ func (m *Repository) ShowCustomerDetails(w http.ResponseWriter, r *http.Request)
 			render.Templates(w, r, "empty-page.tmpl", &models.TemplateData{})
 			return
 		}
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
-	// Get the attachments for the customer from the database
+	// Get the attachments for the customer from the database, a customer may have none
 	attachments, err := m.DB.GetAttachmentsByCustomerID(id)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			// Handle the "no rows in result set" error by rendering an empty page
-			render.Templates(w, r, "empty-page.tmpl", &models.TemplateData{})
-			return
-		}
-		helpers.ServerError(w, err)
+	if err != nil && err != sql.ErrNoRows {
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("attachments", attachments)
+	m.App.Logger.Debug("loaded customer attachments", "customer_id", id, "count", len(attachments.Attachments))
 
 	// Create data for rendering the customer details
 	data := make(map[string]interface{})
 	data["customer"] = res
 
-	// Create a slice to store valid attachment file paths
-	validAttachments := []string{}
+	// Create a slice to store the attachments whose files still exist
+	validAttachments := []models.Attachment{}
 
 	// Check if each attachment file exists
 	for _, attachment := range attachments.Attachments {
 		if _, err := os.Stat(attachment.FilePath); err == nil {
 			// The file exists, add it to the validAttachments slice
-			validAttachments = append(validAttachments, attachment.FilePath)
+			validAttachments = append(validAttachments, attachment)
 		}
 	}
 
 	// Add valid attachments to the data
 	data["attachments"] = validAttachments
 
+	// Add the KYC reviews of the customer's records
+	reviews, err := m.DB.GetKYCReviewsByCustomerID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data["reviews"] = reviews
+
+	// Add the AML risk rating, reassessed when it is from an earlier day
+	assessment, err := m.currentRisk(r, id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data["risk"] = assessment
+
+	// Add the sanctions hits of the customer's shareholders and representatives
+	hits, err := m.DB.GetSanctionsHitsByCustomerID(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data["sanctionsHits"] = hits
+
 	// Render the "customer-details.page.tmpl" template with the data
 	render.Templates(w, r, "customer-details.page.tmpl", &models.TemplateData{
 		Data: data,
@@ -656,11 +984,10 @@ func (m *Repository) ShowCustomerDetails(w http.ResponseWriter, r *http.Request)
 
 func (m *Repository) ShowCustomerTradeLicense(w http.ResponseWriter, r *http.Request) {
 	// Split the request URI to extract the customer ID
-	fmt.Println("Inside Trade License")
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -670,8 +997,20 @@ func (m *Repository) ShowCustomerTradeLicense(w http.ResponseWriter, r *http.Req
 	res, err := m.DB.GetTradeLicenseInforByID(id)
 	if err != nil {
 		res = models.TradeLicense{} // Modify this to match your data structure
+
+		// Fill the form in from a draft when one was saved
+		draft, err := m.draftForm(r, id, onboarding.StepTradeLicense)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		forms.New(draft.Values).Bind(&res)
 	}
 	res.CustomerId = id
+	if err := m.addOnboarding(data, id, onboarding.StepTradeLicense); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
 	// Create data for rendering the customer details
 	data["tradelicense"] = res
 
@@ -692,35 +1031,21 @@ func (m *Repository) ShowCustomerTradeLicense(w http.ResponseWriter, r *http.Req
 }
 
 func (m *Repository) ShowCustomerPartners(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Inside ShowCustomerPartners")
-
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("Customer ID:", id)
 
 	data := make(map[string]interface{})
 
 	// Get the trade information from the database using the customer ID
-	fmt.Println("Getting trade information from the database...")
 	res, err := m.DB.GetTradeShareInforByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("Trade information retrieved successfully.")
-
-	fmt.Println("Printing trade information:")
-	for _, shareholder := range res {
-		fmt.Printf("Trade License ID: %d\n", shareholder.TradeLicenseID)
-		fmt.Printf("Customer ID: %d\n", shareholder.CustomerId)
-		fmt.Printf("Shareholder ID: %d\n", shareholder.ShareHolderID)
-		fmt.Printf("Customer Code: %s\n", shareholder.ShEmirateID)
-		fmt.Printf("Shareholder Name: %s\n", shareholder.ShareHolderName)
-	}
 
 	// Create data for rendering the customer details
 	data["partners"] = res
@@ -744,10 +1069,7 @@ func (m *Repository) ShowCustomerPartners(w http.ResponseWriter, r *http.Request
 		}
 	}
 
-	fmt.Println("Valid Attachments:")
-	for _, attachment := range validAttachments {
-		fmt.Println(attachment)
-	}
+	m.App.Logger.Debug("found attachments", "customer_id", id, "count", len(validAttachments))
 
 	// Render the "customer-partner-details.tmpl" template with the data
 	render.Templates(w, r, "customer-partner-details.page.tmpl", &models.TemplateData{
@@ -758,35 +1080,21 @@ func (m *Repository) ShowCustomerPartners(w http.ResponseWriter, r *http.Request
 }
 
 func (m *Repository) ShowCustomerMemorandum(w http.ResponseWriter, r *http.Request) {
-	fmt.Println("Inside ShowCustomerMemorandum")
-
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("Customer ID:", id)
 
 	data := make(map[string]interface{})
 
 	// Get the trade information from the database using the customer ID
-	fmt.Println("Getting trade information from the database...")
 	res, err := m.DB.GetMemorandumInforByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("Trade information retrieved successfully.")
-
-	fmt.Println("Printing trade information:")
-	for _, memorandum := range res {
-		fmt.Printf("Trade License ID: %d\n", memorandum.TradeLicenseID)
-		fmt.Printf("Customer ID: %d\n", memorandum.CustomerId)
-		fmt.Printf("Representative: %s\n", memorandum.RepresentativeName)
-		fmt.Printf("EMI ID: %s\n", memorandum.RepEmID)
-		fmt.Printf("Passsport: %s\n", memorandum.RepPassport)
-	}
 
 	// Create data for rendering the customer details
 	data["memorandum"] = res
@@ -811,10 +1119,7 @@ func (m *Repository) ShowCustomerMemorandum(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
-	fmt.Println("Valid Attachments:")
-	for _, attachment := range validAttachments {
-		fmt.Println(attachment)
-	}
+	m.App.Logger.Debug("found attachments", "customer_id", id, "count", len(validAttachments))
 
 	// Render the "customer-partner-details.tmpl" template with the data
 	render.Templates(w, r, "customer-memorandum-details.page.tmpl", &models.TemplateData{
@@ -829,111 +1134,31 @@ func (m *Repository) PostTradeLicense(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("Trade Post Started")
-	err = r.ParseMultipartForm(10 << 20) // 10MB maximum file size
-	if err != nil {
-		helpers.ServerError(w, err)
+	if !m.parseUploadForm(w, r) {
 		return
 	}
 
-	// Retrieve the uploaded files
-	files := r.MultipartForm.File["photos"]
-	var filePath string
-	var fileName string
-	fmt.Println("Trade Post Started 2", files)
-	// Create a customer ID as an integer
-	customerIDStr := r.Form.Get("customerId")
-	customerIDint, err := strconv.Atoi(customerIDStr)
-	if err != nil {
-		customerIDint = 0
-	}
-	fmt.Println("Trade Post Started 3", customerIDStr)
-	// Parse date fields
-	parseDate := func(fieldName string) (time.Time, error) {
-		dateStr := r.Form.Get(fieldName)
-		if dateStr == "" {
-			return time.Time{}, nil
-		}
-		return time.Parse("2006-01-02", dateStr)
-	}
-
-	establishmentDate, _ := parseDate("establishmentDate")
-	registrationDate, _ := parseDate("registrationDate")
-	licenseExpiryDate, _ := parseDate("licenseExpiryDate")
-	fmt.Println("Trade Post Started 4", establishmentDate, registrationDate, licenseExpiryDate)
-	// If files were uploaded, save them in a directory with the customer code
-	if len(files) == 1 {
-		customerCode, err := m.DB.GetCustomerCodeByID(id)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
-		tradeLicenseDir := r.Form.Get("tradelicenseid")
-		//customerDir := fmt.Sprintf("E:\\GO\\FF\\%s\\%s", customerCode, tradeLicenseDir)
-		customerDir := fmt.Sprintf("%s%s\\%s", config.FileUploadPath, customerCode, tradeLicenseDir)
-		if _, err := os.Stat(customerDir); os.IsNotExist(err) {
-			if err := os.MkdirAll(customerDir, os.ModePerm); err != nil {
-				helpers.ServerError(w, err)
-				return
-			}
-		}
-
-		file := files[0] // Assuming the file slice contains only one file
-
-		// Use the original filename as it is
-		filePath = filepath.Join(customerDir, file.Filename)
-		fileName = file.Filename
-		destinationFile, err := os.Create(filePath)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
-		defer destinationFile.Close()
-
-		sourceFile, err := file.Open()
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
-		defer sourceFile.Close()
-
-		_, err = io.Copy(destinationFile, sourceFile)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
-
-		fmt.Println("File name with Extension", file.Filename)
-	}
-
-	// Create a trade license object with form data
-	tradeLicense := models.TradeLicense{
-		CustomerId:       customerIDint,
-		Emirate:          r.Form.Get("emirate"),
-		TradeLicenseNo:   r.Form.Get("tradelicenseid"),
-		MohreNo:          r.Form.Get("mohreno"),
-		EstablishDate:    establishmentDate,
-		RegistrationDate: registrationDate,
-		LicenseExpiry:    licenseExpiryDate,
-		TradeName:        r.Form.Get("tradeName"),
-		LegalStatus:      r.Form.Get("legalState"),
-		FilePath:         filePath,
-		FileName:         fileName,
-	}
+	m.App.Logger.Debug("adding trade license", "customer_id", id, "files", len(r.MultipartForm.File["photos"]))
 
-	// Create a form object for validation
+	// Bind and validate the form data
+	tradeLicense := models.TradeLicense{CustomerId: id}
 	form := forms.New(r.PostForm)
+	form.Bind(&tradeLicense)
 
-	// Check required fields and add validation errors
-	form.Required("tradelicenseid", "licenseExpiryDate", "mohreno")
+	checked, err := m.checkUploads(r, form, id, "photos")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
 	// If the form is not valid, render the trade license page with validation errors
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["tradelicense"] = tradeLicense
+		m.addOnboarding(data, id, onboarding.StepTradeLicense)
 
 		render.Templates(w, r, "customer-trade-license.page.tmpl", &models.TemplateData{
 			Form: form,
@@ -942,30 +1167,58 @@ func (m *Repository) PostTradeLicense(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// If a file was uploaded, save it in the customer's directory
+	if u, ok := checked["photos"]; ok {
+		customerCode, err := m.DB.GetCustomerCodeByID(id)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		a, err := m.saveUpload(r, id, customerCode, uploads.KindTradeLicense, u)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		tradeLicense.FilePath = a.FilePath
+		tradeLicense.FileName = a.OriginalName
+	}
+
 	// Insert the trade license into the database
-	newTradeLicenseID, err := m.DB.InsertTradeLicense(tradeLicense)
+	newTradeLicenseID, err := m.DB.InsertTradeLicense(tradeLicense, m.submitter(r))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	m.logSubmitted(r, id, models.RecordTradeLicense, newTradeLicenseID)
+	m.refreshRisk(r, id)
 
 	// Set flash message and redirect
 	m.App.Session.Put(r.Context(), "flash", "New Trade License Added Successfully. ID: "+strconv.Itoa(newTradeLicenseID))
-	http.Redirect(w, r, "/customer/all", http.StatusSeeOther)
+	m.finishStep(w, r, id, onboarding.StepTradeLicense, "/customer/all")
 }
 
 func (m *Repository) AddPartner(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form, err := m.draftForm(r, id, onboarding.StepPartners)
+	if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
+
 	var emptyCustomer models.TradeLicenseHolder
 	data := make(map[string]interface{})
 	data["partner"] = emptyCustomer
+	if err := m.addOnboarding(data, id, onboarding.StepPartners); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
 	render.Templates(w, r, "customer-add-partner.page.tmpl", &models.TemplateData{
-		Form:       forms.New(nil),
+		Form:       form,
 		Data:       data,
 		CustomerID: id,
 	})
@@ -975,12 +1228,18 @@ func (m *Repository) AddMemorandum(w http.ResponseWriter, r *http.Request) {
 	exploded := strings.Split(r.RequestURI, "/")
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	customerCode, err := m.DB.GetCustomerCodeByID(id)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form, err := m.draftForm(r, id, onboarding.StepMemorandum)
+	if err != nil {
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -988,8 +1247,12 @@ func (m *Repository) AddMemorandum(w http.ResponseWriter, r *http.Request) {
 	data := make(map[string]interface{})
 	data["memorandum"] = emptyCustomer
 	data["CustomerCode"] = customerCode
+	if err := m.addOnboarding(data, id, onboarding.StepMemorandum); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 	render.Templates(w, r, "customer-add-memorandum.page.tmpl", &models.TemplateData{
-		Form:       forms.New(nil),
+		Form:       form,
 		Data:       data,
 		CustomerID: id,
 	})
@@ -1001,152 +1264,89 @@ func (m *Repository) PostPartner(w http.ResponseWriter, r *http.Request) {
 	if len(exploded) < 3 {
 		// Handle the case where there are not enough segments in the URI.
 		http.Error(w, "Invalid request URI", http.StatusBadRequest)
-		fmt.Println("Invalid request URI")
 		return
 	}
 
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
-		fmt.Println("Error converting ID:", err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("PostPartner", id)
 
 	// Parse the form to handle form fields and file uploads
-	err = r.ParseMultipartForm(10 << 20) // 10MB maximum file size
-	if err != nil {
-		helpers.ServerError(w, err)
-		fmt.Println("Error parsing form:", err)
+	if !m.parseUploadForm(w, r) {
 		return
 	}
 
-	// Retrieve the uploaded files
-	idfiles := r.MultipartForm.File["shIDFilepath"]
-	passfiles := r.MultipartForm.File["ShPassFilepath"]
-
-	var idfilePath string
-	var passfilePath string
-	var customerCode string
-	// Parse date fields
-	parseDate := func(fieldName string) (time.Time, error) {
-		dateStr := r.Form.Get(fieldName)
-		if dateStr == "" {
-			return time.Time{}, nil
-		}
-		return time.Parse("2006-01-02", dateStr)
-	}
-
-	idExpireDate, _ := parseDate("shEmIDExp")
-	passExpireDate, _ := parseDate("shPassportExp")
-
-	// Function to handle file uploads
-	handleFileUpload := func(files []*multipart.FileHeader, filePath *string) {
-		if len(files) == 1 {
-			customerCode, err = m.DB.GetCustomerCodeByID(id)
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error getting customer code:", err)
-				return
-			}
-
-			partnerDir := "partners"
-			//customerDir := fmt.Sprintf("E:\\GO\\FF\\%s\\%s", customerCode, partnerDir)
-			customerDir := fmt.Sprintf("%s%s\\%s", config.FileUploadPath, customerCode, partnerDir)
-			if _, err := os.Stat(customerDir); os.IsNotExist(err) {
-				if err := os.MkdirAll(customerDir, os.ModePerm); err != nil {
-					helpers.ServerError(w, err)
-					fmt.Println("Error creating customer directory:", err)
-					return
-				}
-			}
-
-			file := files[0] // Assuming the file slice contains only one file
-
-			// Use the original filename as it is
-			*filePath = filepath.Join(customerDir, file.Filename)
-			destinationFile, err := os.Create(*filePath)
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error creating destination file:", err)
-				return
-			}
-			defer destinationFile.Close()
-
-			sourceFile, err := file.Open()
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error opening source file:", err)
-				return
-			}
-			defer sourceFile.Close()
-
-			_, err = io.Copy(destinationFile, sourceFile)
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error copying files:", err)
-				return
-			}
-
-			fmt.Printf("Added file: %s\n", file.Filename)
-		}
-	}
-
-	// Handle file uploads for ID and Passport
-	handleFileUpload(idfiles, &idfilePath)
-	handleFileUpload(passfiles, &passfilePath)
-
-	// Create a reservation object with form data
+	// Bind and validate the form data
 	partner := models.TradeLicenseHolder{
-		CustomerId:      id,
-		CustomerCode:    customerCode,
-		CustomerName:    r.Form.Get("customerName"),
-		ShareHolderRole: r.Form.Get("shareHolderRole"),
-		ShNationality:   r.Form.Get("shareHolderNationality"),
-		ShareHolderName: r.Form.Get("shareHolderName"),
-		ShEmirateID:     r.Form.Get("shEmirateID"),
-		ShEmIDExp:       idExpireDate,
-		ShIDFilepath:    idfilePath,
-		ShPassport:      r.Form.Get("shPassport"),
-		ShPassportExp:   passExpireDate,
-		ShPassFilepath:  passfilePath,
-		CreatedAt:       time.Now(),
-		UpdatedAt:       time.Now(),
-	}
-	fmt.Println("Partner emi File:", idfilePath)
-	fmt.Println("partner pass file:", passfilePath)
-
-	// Create a form object for validation
+		CustomerId: id,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
 	form := forms.New(r.PostForm)
+	form.Bind(&partner)
 
-	// Check required fields and add validation errors
-	form.Required("shareHolderName", "shEmirateID", "shPassport")
+	checked, err := m.checkUploads(r, form, id, "shIDFilepath", "shPassFilepath")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
 	// If the form is not valid, render the reservation page with validation errors
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["partners"] = partner
+		m.addOnboarding(data, id, onboarding.StepPartners)
 
 		render.Templates(w, r, "customer-add-partner.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
+			Form:       form,
+			Data:       data,
+			CustomerID: id,
 		})
 		return
 	}
 
+	// Save the ID and passport copies in the customer's directory
+	if len(checked) > 0 {
+		partner.CustomerCode, err = m.DB.GetCustomerCodeByID(id)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	for field, filePath := range map[string]*string{
+		"shIDFilepath":   &partner.ShIDFilepath,
+		"shPassFilepath": &partner.ShPassFilepath,
+	} {
+		u, ok := checked[field]
+		if !ok {
+			continue
+		}
+		a, err := m.saveUpload(r, id, partner.CustomerCode, uploads.KindPartner, u)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		*filePath = a.FilePath
+	}
+
 	// Insert the reservation into the database
-	newCustomerID, err := m.DB.InsertPartner(partner)
+	newCustomerID, err := m.DB.InsertPartner(partner, m.submitter(r))
 	if err != nil {
-		helpers.ServerError(w, err)
-		fmt.Println("Error inserting partner:", err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	m.logSubmitted(r, id, models.RecordPartner, newCustomerID)
+	m.refreshRisk(r, id)
+	m.screenSubject(r, models.ScreeningSubject{CustomerID: id, SubjectType: models.RecordPartner,
+		SubjectID: newCustomerID, Name: partner.ShareHolderName})
 	url := fmt.Sprintf("/customer/partners/%d", id)
 	m.App.Session.Put(r.Context(), "flash", "New Partner Added Successfully..! ID :"+strconv.Itoa(newCustomerID))
 
-	// Store the reservation in the session and redirect to the reservation summary page
+	// Store the partner in the session and redirect to the partners or the onboarding checklist
 	m.App.Session.Put(r.Context(), "partner", partner)
-	http.Redirect(w, r, url, http.StatusSeeOther)
+	m.finishStep(w, r, id, onboarding.StepPartners, url)
 }
 
 func (m *Repository) PostRepresentative(w http.ResponseWriter, r *http.Request) {
@@ -1155,148 +1355,87 @@ func (m *Repository) PostRepresentative(w http.ResponseWriter, r *http.Request)
 	if len(exploded) < 3 {
 		// Handle the case where there are not enough segments in the URI.
 		http.Error(w, "Invalid request URI", http.StatusBadRequest)
-		fmt.Println("Invalid request URI")
 		return
 	}
 
 	id, err := strconv.Atoi(exploded[3])
 	if err != nil {
-		helpers.ServerError(w, err)
-		fmt.Println("Error converting ID:", err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	fmt.Println("PostRepresentative", id)
 
 	// Parse the form to handle form fields and file uploads
-	err = r.ParseMultipartForm(10 << 20) // 10MB maximum file size
-	if err != nil {
-		helpers.ServerError(w, err)
-		fmt.Println("Error parsing form:", err)
+	if !m.parseUploadForm(w, r) {
 		return
 	}
 
-	// Retrieve the uploaded files
-	idfiles := r.MultipartForm.File["repIDFilepath"]
-	passfiles := r.MultipartForm.File["repPassFilepath"]
-
-	var idfilePath string
-	var passfilePath string
-	var customerCode string
-	// Parse date fields
-	parseDate := func(fieldName string) (time.Time, error) {
-		dateStr := r.Form.Get(fieldName)
-		if dateStr == "" {
-			return time.Time{}, nil
-		}
-		return time.Parse("2006-01-02", dateStr)
-	}
-
-	idExpireDate, _ := parseDate("repEmIDExp")
-	passExpireDate, _ := parseDate("repPassportExp")
-
-	// Function to handle file uploads
-	handleFileUpload := func(files []*multipart.FileHeader, filePath *string) {
-		if len(files) == 1 {
-			customerCode, err = m.DB.GetCustomerCodeByID(id)
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error getting customer code:", err)
-				return
-			}
-
-			partnerDir := "memorandum"
-			//customerDir := fmt.Sprintf("E:\\GO\\FF\\%s\\%s", customerCode, partnerDir)
-			customerDir := fmt.Sprintf("%s%s\\%s", config.FileUploadPath, customerCode, partnerDir)
-			if _, err := os.Stat(customerDir); os.IsNotExist(err) {
-				if err := os.MkdirAll(customerDir, os.ModePerm); err != nil {
-					helpers.ServerError(w, err)
-					fmt.Println("Error creating customer directory:", err)
-					return
-				}
-			}
-
-			file := files[0] // Assuming the file slice contains only one file
-
-			// Use the original filename as it is
-			*filePath = filepath.Join(customerDir, file.Filename)
-			destinationFile, err := os.Create(*filePath)
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error creating destination file:", err)
-				return
-			}
-			defer destinationFile.Close()
-
-			sourceFile, err := file.Open()
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error opening source file:", err)
-				return
-			}
-			defer sourceFile.Close()
-
-			_, err = io.Copy(destinationFile, sourceFile)
-			if err != nil {
-				helpers.ServerError(w, err)
-				fmt.Println("Error copying files:", err)
-				return
-			}
-
-			fmt.Printf("Added file: %s\n", file.Filename)
-		}
-	}
-
-	// Handle file uploads for ID and Passport
-	handleFileUpload(idfiles, &idfilePath)
-	handleFileUpload(passfiles, &passfilePath)
-
-	// Create a reservation object with form data
+	// Bind and validate the form data
 	partner := models.Memorandum{
-		CustomerId:         id,
-		CustomerCode:       customerCode,
-		RepresentativeName: r.Form.Get("representativeName"),
-		RepNoOfShares:      r.Form.Get("repNoOfShares"),
-		RepEmID:            r.Form.Get("repEmID"),
-		RepEmIDExp:         idExpireDate,
-		RepIDFilepath:      idfilePath,
-		RepPassport:        r.Form.Get("repPassport"),
-		RepPassportExp:     passExpireDate,
-		RepPassFilepath:    passfilePath,
-		CreatedAt:          time.Now(),
-		UpdatedAt:          time.Now(),
-	}
-	fmt.Println("Rep emi File:", idfilePath)
-	fmt.Println("Rep pass file:", passfilePath)
-
-	// Create a form object for validation
+		CustomerId: id,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
 	form := forms.New(r.PostForm)
+	form.Bind(&partner)
 
-	// Check required fields and add validation errors
-	form.Required("representativeName", "repNoOfShares")
+	checked, err := m.checkUploads(r, form, id, "repIDFilepath", "repPassFilepath")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
 	// If the form is not valid, render the reservation page with validation errors
 	if !form.Valid() {
 		data := make(map[string]interface{})
 		data["memorandum"] = partner
+		m.addOnboarding(data, id, onboarding.StepMemorandum)
 
 		render.Templates(w, r, "customer-add-memorandum.page.tmpl", &models.TemplateData{
-			Form: form,
-			Data: data,
+			Form:       form,
+			Data:       data,
+			CustomerID: id,
 		})
 		return
 	}
 
+	// Save the ID and passport copies in the customer's directory
+	if len(checked) > 0 {
+		partner.CustomerCode, err = m.DB.GetCustomerCodeByID(id)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	for field, filePath := range map[string]*string{
+		"repIDFilepath":   &partner.RepIDFilepath,
+		"repPassFilepath": &partner.RepPassFilepath,
+	} {
+		u, ok := checked[field]
+		if !ok {
+			continue
+		}
+		a, err := m.saveUpload(r, id, partner.CustomerCode, uploads.KindMemorandum, u)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		*filePath = a.FilePath
+	}
+
 	// Insert the reservation into the database
-	newCustomerID, err := m.DB.InsertMemorandum(partner)
+	newCustomerID, err := m.DB.InsertMemorandum(partner, m.submitter(r))
 	if err != nil {
-		helpers.ServerError(w, err)
-		fmt.Println("Error inserting partner:", err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	m.logSubmitted(r, id, models.RecordMemorandum, newCustomerID)
+	m.refreshRisk(r, id)
+	m.screenSubject(r, models.ScreeningSubject{CustomerID: id, SubjectType: models.RecordMemorandum,
+		SubjectID: newCustomerID, Name: partner.RepresentativeName})
 	url := fmt.Sprintf("/customer/memorandum/%d", id)
 	m.App.Session.Put(r.Context(), "flash", "New Representative Added Successfully..! ID :"+strconv.Itoa(newCustomerID))
 
-	// Store the reservation in the session and redirect to the reservation summary page
+	// Store the representative in the session and redirect to the memorandum or the onboarding checklist
 	m.App.Session.Put(r.Context(), "memorandum", partner)
-	http.Redirect(w, r, url, http.StatusSeeOther)
+	m.finishStep(w, r, id, onboarding.StepMemorandum, url)
 }
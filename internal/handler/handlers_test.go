@@ -19,10 +19,12 @@ var theTests = []struct {
 	params             []postData
 	expectedStatusCode int
 }{
+	{"healthz", "/healthz", "GET", []postData{}, http.StatusOK},
+	{"readyz", "/readyz", "GET", []postData{}, http.StatusOK},
 	{"home", "/", "GET", []postData{}, http.StatusOK},
 	{"about", "/about", "GET", []postData{}, http.StatusOK},
-	{"generals", "/generals", "GET", []postData{}, http.StatusOK},
-	{"majors", "/majors", "GET", []postData{}, http.StatusOK},
+	{"generals", "/rooms/generals-quarters", "GET", []postData{}, http.StatusOK},
+	{"majors", "/rooms/majors-suite", "GET", []postData{}, http.StatusOK},
 	{"search", "/search-availability", "GET", []postData{}, http.StatusOK},
 	{"reservation", "/make-reservation", "GET", []postData{}, http.StatusOK},
 	{"contact", "/contact", "GET", []postData{}, http.StatusOK},
@@ -39,6 +41,9 @@ var theTests = []struct {
 		{key: "last_name", value: "silva"},
 		{key: "email", value: "cc@gmail.com"},
 		{key: "phone", value: "07159020877"},
+		{key: "start_date", value: "2050-01-01"},
+		{key: "end_date", value: "2050-01-02"},
+		{key: "room_id", value: "1"},
 	}, http.StatusOK},
 }
 
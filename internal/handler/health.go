@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+)
+
+// healthResponse is the JSON body of the health endpoints
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Healthz reports that the process is alive. It does not check any dependency,
+// so a failing database does not get the process restarted.
+func (m *Repository) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeHealth(w, http.StatusOK, healthResponse{Status: "ok"})
+}
+
+// Readyz reports whether the application can serve traffic: the database answers,
+// the templates are loaded, the upload directory is writable and the virus
+// scanner, if there is one, is reachable
+func (m *Repository) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]error{
+		"database":  m.DB.Ping(),
+		"templates": m.checkTemplates(),
+		"storage":   m.checkStorage(),
+	}
+	if p, ok := m.App.Scanner.(interface{ Ping(context.Context) error }); ok {
+		checks["antivirus"] = p.Ping(r.Context())
+	}
+
+	resp := healthResponse{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+	for name, err := range checks {
+		if err != nil {
+			m.App.Logger.Warn("readiness check failed", "check", name, "error", err)
+			resp.Checks[name] = err.Error()
+			resp.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[name] = "ok"
+	}
+
+	writeHealth(w, status, resp)
+}
+
+func (m *Repository) checkTemplates() error {
+	if len(m.App.TemplateCache) == 0 {
+		return errors.New("template cache is empty")
+	}
+	return nil
+}
+
+// checkStorage writes and removes a temporary file in the upload directory
+func (m *Repository) checkStorage() error {
+	if err := os.MkdirAll(m.App.UploadPath, os.ModePerm); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(m.App.UploadPath, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+func writeHealth(w http.ResponseWriter, status int, resp healthResponse) {
+	out, err := json.Marshal(resp)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(out)
+}
@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/importer"
+	"github.com/chamrasilva89/reservationWeb/internal/metrics"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// lookupCustomer finds customers by code for the importer
+func (m *Repository) lookupCustomer(code string) (int, bool, error) {
+	id, err := m.DB.GetCustomerIDByCode(code)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return id, true, nil
+}
+
+// customerImport loads the import of an import route. Imports are only shown
+// to the user who uploaded them; others get 404 Not Found.
+func (m *Repository) customerImport(w http.ResponseWriter, r *http.Request) (models.CustomerImport, importer.Kind, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.CustomerImport{}, importer.Kind{}, false
+	}
+
+	ci, err := m.DB.GetCustomerImportByID(id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && ci.UserID != m.App.Session.GetInt(r.Context(), "user_id") {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return ci, importer.Kind{}, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return ci, importer.Kind{}, false
+	}
+
+	kind, ok := importer.Lookup(ci.Kind)
+	if !ok {
+		helpers.ServerError(w, r, fmt.Errorf("import %d has unknown kind %q", ci.ID, ci.Kind))
+		return ci, kind, false
+	}
+	return ci, kind, true
+}
+
+// importMapping is a field of the import's column mapping as the page shows it
+type importMapping struct {
+	Field  importer.Field
+	Column int // -1 when no column is mapped to the field
+}
+
+func importMappings(kind importer.Kind, mapping map[string]int) []importMapping {
+	fields := make([]importMapping, len(kind.Fields))
+	for i, f := range kind.Fields {
+		col, ok := mapping[f.Name]
+		if !ok {
+			col = -1
+		}
+		fields[i] = importMapping{Field: f, Column: col}
+	}
+	return fields
+}
+
+// ShowImport shows the first step of the import wizard, where staff upload a
+// spreadsheet of customers, trade licenses or shareholders
+func (m *Repository) ShowImport(w http.ResponseWriter, r *http.Request) {
+	m.renderImportUpload(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderImportUpload(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["kinds"] = importer.Kinds
+	data["maxRows"] = importer.MaxRows
+
+	render.Templates(w, r, "customer-import.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// PostImport reads an uploaded spreadsheet and keeps it pending import, with
+// its columns mapped to fields by their headers
+func (m *Repository) PostImport(w http.ResponseWriter, r *http.Request) {
+	if !m.parseUploadForm(w, r) {
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	kind, ok := importer.Lookup(form.Get("kind"))
+	if !ok {
+		form.Errors.Add("kind", "Choose what the file holds")
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		form.Errors.Add("file", "Choose a CSV or Excel file")
+		m.renderImportUpload(w, r, form)
+		return
+	}
+	defer file.Close()
+
+	table, err := importer.Read(header.Filename, file)
+	if err != nil {
+		form.Errors.Add("file", "The file can't be imported: "+err.Error())
+	}
+	if !form.Valid() {
+		m.renderImportUpload(w, r, form)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	id, err := m.DB.InsertCustomerImport(models.CustomerImport{
+		UserID:   userID,
+		Kind:     kind.Name,
+		FileName: header.Filename,
+		Headers:  table.Headers,
+		Rows:     table.Rows,
+		Mapping:  kind.Guess(table.Headers),
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("import uploaded", "request_id", helpers.RequestID(r), "import_id", id, "kind", kind.Name,
+		"file_name", header.Filename, "rows", len(table.Rows), "user_id", userID)
+	http.Redirect(w, r, fmt.Sprintf("/customer/import/%d", id), http.StatusSeeOther)
+}
+
+// ShowCustomerImport shows a pending import's column mapping with a dry run
+// of every row, or the report of a committed import
+func (m *Repository) ShowCustomerImport(w http.ResponseWriter, r *http.Request) {
+	ci, kind, ok := m.customerImport(w, r)
+	if !ok {
+		return
+	}
+
+	rows := ci.Report
+	if ci.State == models.ImportPending {
+		var err error
+		rows, err = kind.Check(importer.Table{Headers: ci.Headers, Rows: ci.Rows}, ci.Mapping, m.lookupCustomer)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	valid := 0
+	for _, row := range rows {
+		if row.Valid() {
+			valid++
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["import"] = ci
+	data["kind"] = kind
+	data["rows"] = rows
+	data["valid"] = valid
+	data["invalid"] = len(rows) - valid
+	data["unmapped"] = kind.Unmapped(ci.Mapping)
+	data["mapping"] = importMappings(kind, ci.Mapping)
+	data["pending"] = ci.State == models.ImportPending
+
+	render.Templates(w, r, "customer-import-show.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// PostCustomerImportMapping changes which column each field of a pending
+// import is read from. Fields mapped to no column are left empty.
+func (m *Repository) PostCustomerImportMapping(w http.ResponseWriter, r *http.Request) {
+	ci, kind, ok := m.customerImport(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	mapping := make(map[string]int)
+	for _, f := range kind.Fields {
+		v := r.PostForm.Get("map-" + f.Name)
+		if v == "" {
+			continue
+		}
+		col, err := strconv.Atoi(v)
+		if err != nil || col < 0 || col >= len(ci.Headers) {
+			helpers.ClientError(w, r, http.StatusBadRequest)
+			return
+		}
+		mapping[f.Name] = col
+	}
+
+	back := fmt.Sprintf("/customer/import/%d", ci.ID)
+	err := m.DB.UpdateCustomerImportMapping(ci.ID, mapping)
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", "This import was already committed")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Column mapping saved, check the rows again before importing")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// PostCommitCustomerImport checks the rows of a pending import once more and
+// stores the valid ones in one transaction, along with their KYC reviews like
+// records entered through the forms; rows with errors are left out and
+// listed in the import's report.
+func (m *Repository) PostCommitCustomerImport(w http.ResponseWriter, r *http.Request) {
+	ci, kind, ok := m.customerImport(w, r)
+	if !ok {
+		return
+	}
+	back := fmt.Sprintf("/customer/import/%d", ci.ID)
+
+	if ci.State != models.ImportPending {
+		m.App.Session.Put(r.Context(), "warning", "This import was already committed")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if unmapped := kind.Unmapped(ci.Mapping); len(unmapped) > 0 {
+		m.App.Session.Put(r.Context(), "error", "Map a column to every required field before importing")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	rows, err := kind.Check(importer.Table{Headers: ci.Headers, Rows: ci.Rows}, ci.Mapping, m.lookupCustomer)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	valid := 0
+	for _, row := range rows {
+		if row.Valid() {
+			valid++
+		}
+	}
+	if valid == 0 {
+		m.App.Session.Put(r.Context(), "error", "None of the rows can be imported, correct the file and upload it again")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.CommitCustomerImport(ci.ID, rows, m.submitter(r))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", "This import was already committed")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	// The records are stored, so follow-up failures are logged rather than failing the request
+	screener, err := m.screener()
+	if err != nil {
+		m.App.Logger.Error("cannot load sanctions lists", "request_id", helpers.RequestID(r), "error", err)
+	}
+	var matches []string
+	customers := make(map[int]bool)
+	for _, row := range rows {
+		if !row.Valid() {
+			continue
+		}
+		if row.Shareholder != nil && screener != nil {
+			sub := models.ScreeningSubject{CustomerID: row.CustomerID, SubjectType: models.RecordPartner,
+				SubjectID: row.RecordID, Name: row.Shareholder.ShareHolderName}
+			if m.recordHits(r, screener, sub) > 0 {
+				matches = append(matches, sub.Name)
+			}
+		}
+		customers[row.CustomerID] = true
+	}
+	for id := range customers {
+		m.refreshRisk(r, id)
+	}
+	if kind.Name == importer.KindCustomers {
+		metrics.CustomersAdded.Add(float64(valid))
+	}
+
+	m.App.Logger.Info("import committed", "request_id", helpers.RequestID(r), "import_id", ci.ID, "kind", kind.Name,
+		"imported", valid, "skipped", len(rows)-valid, "user_id", ci.UserID)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Imported %d of %d rows", valid, len(rows)))
+	switch {
+	case len(matches) == 1:
+		m.App.Session.Put(r.Context(), "warning",
+			fmt.Sprintf("%s is a possible match on a sanctions list and was sent for review", matches[0]))
+	case len(matches) > 1:
+		m.App.Session.Put(r.Context(), "warning",
+			fmt.Sprintf("%d shareholders are possible matches on a sanctions list and were sent for review: %s",
+				len(matches), strings.Join(matches, ", ")))
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+// postImport uploads a spreadsheet to the import wizard and returns where it redirected to
+func postImport(t *testing.T, ts *httptest.Server, client *http.Client, kind, fileName, data string) (*http.Response, string) {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("kind", kind)
+	fw, _ := mw.CreateFormFile("file", fileName)
+	fw.Write([]byte(data))
+	mw.Close()
+
+	resp, err := client.Post(ts.URL+"/customer/import", mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp, resp.Header.Get("Location")
+}
+
+func TestImport(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	get := func(client *http.Client, path string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	// Files that can't be read are refused on the upload page
+	resp, _ := postImport(t, ts, admin, "customers", "customers.pdf", "%PDF")
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the upload form again for a PDF, got status %d", resp.StatusCode)
+	}
+
+	csv := "Code,Name,Contact,Telephone,Mobile,E-mail,Region\n" +
+		"IMP01,Import Alpha,Jane,04 123 4567,050 123 4567,jane@alpha.ae,DXB\n" +
+		"IMP02,Import Beta,Joe,04 123 4567,0501234567,joe@beta.ae,AUH\n" +
+		"IMP03,,Jim,12,0501234567,jim@gamma.ae,SHJ\n"
+	resp, location := postImport(t, ts, admin, "customers", "customers.csv", csv)
+	if resp.StatusCode != http.StatusSeeOther || !strings.HasPrefix(location, "/customer/import/") {
+		t.Fatalf("expected a redirect to the import, got status %d to %q", resp.StatusCode, location)
+	}
+
+	// The dry run shows the rows' errors and stores nothing
+	status, body := get(admin, location)
+	if status != http.StatusOK || !strings.Contains(body, "Customer name: This field cannot be blank") ||
+		!strings.Contains(body, "Phone: Enter a UAE phone number") {
+		t.Fatalf("expected the dry run with row errors, got status %d", status)
+	}
+	if _, err := Repo.DB.GetCustomerIDByCode("IMP01"); err == nil {
+		t.Error("expected nothing to be stored by the dry run")
+	}
+
+	// Imports are only shown to the user who uploaded them
+	reviewer := login(t, ts, dbrepo.DemoReviewerEmail)
+	if status, _ := get(reviewer, location); status != http.StatusNotFound {
+		t.Errorf("expected another user's import to be hidden, got status %d", status)
+	}
+
+	// Unmapping a required field keeps the import from being committed
+	resp, err := admin.PostForm(ts.URL+location+"/mapping", url.Values{"map-customerCode": {"0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = admin.PostForm(ts.URL+location+"/commit", nil)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected a redirect, got status %d", resp.StatusCode)
+	}
+	if _, err := Repo.DB.GetCustomerIDByCode("IMP01"); err == nil {
+		t.Error("expected nothing to be imported while required fields are unmapped")
+	}
+
+	mapping := url.Values{}
+	for field, col := range map[string]string{"customerCode": "0", "customerName": "1", "contactPerson": "2",
+		"contactNo": "3", "mobileNo": "4", "email": "5"} {
+		mapping.Set("map-"+field, col)
+	}
+	if resp, _ = admin.PostForm(ts.URL+location+"/mapping", mapping); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the mapping to be saved, got status %d", resp.StatusCode)
+	}
+	if resp, _ = admin.PostForm(ts.URL+location+"/mapping", url.Values{"map-email": {"7"}}); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected a column out of range to be refused, got status %d", resp.StatusCode)
+	}
+
+	// Valid rows are stored and go to review, the others are in the report
+	if resp, _ = admin.PostForm(ts.URL+location+"/commit", nil); resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected a redirect after the commit, got status %d", resp.StatusCode)
+	}
+	id, err := Repo.DB.GetCustomerIDByCode("IMP01")
+	if err != nil {
+		t.Fatalf("expected IMP01 to be imported: %v", err)
+	}
+	c, _ := Repo.DB.GetCustomerByID(id)
+	if c.Status != models.CustomerOnboarding || c.MobileNo != "+971501234567" {
+		t.Errorf("expected an onboarding customer with a normalized mobile number, got %+v", c)
+	}
+	reviews, _ := Repo.DB.GetKYCReviewsByCustomerID(id)
+	if len(reviews) != 1 || reviews[0].State != models.ReviewPending || reviews[0].Submitter.Email != dbrepo.DemoUserEmail {
+		t.Errorf("expected the imported customer to be pending review, submitted by the importer, got %+v", reviews)
+	}
+	if _, err := Repo.DB.GetCustomerIDByCode("IMP03"); err == nil {
+		t.Error("expected the invalid row to be skipped")
+	}
+
+	status, body = get(admin, location)
+	if status != http.StatusOK || strings.Count(body, ">Imported<") != 2 || !strings.Contains(body, "Customer name: This field cannot be blank") {
+		t.Errorf("expected the report with 2 imported rows and the skipped one, got status %d", status)
+	}
+	if resp, _ = admin.PostForm(ts.URL+location+"/commit", nil); resp.StatusCode != http.StatusSeeOther {
+		t.Errorf("expected a second commit to redirect, got status %d", resp.StatusCode)
+	}
+
+	// Trade licenses are linked to the imported customers by code
+	csv = "Customer code,Emirate,Trade license number,MOHRE number,Registration date,Expiry date\n" +
+		"IMP01,Dubai,123456,M-1,01/01/2020,31/12/2099\n" +
+		"NOPE,Dubai,123457,M-2,01/01/2020,31/12/2099\n"
+	_, location = postImport(t, ts, admin, "trade-licenses", "licenses.csv", csv)
+	status, body = get(admin, location)
+	if status != http.StatusOK || !strings.Contains(body, "There is no customer with code NOPE") {
+		t.Fatalf("expected the unknown customer code to be reported, got status %d", status)
+	}
+	admin.PostForm(ts.URL+location+"/commit", nil)
+	tl, err := Repo.DB.GetTradeLicenseInforByID(id)
+	if err != nil || tl.TradeLicenseNo != "123456" || tl.Emirate != "DUB" || tl.CustomerCode != "IMP01" {
+		t.Errorf("expected the trade license of IMP01, got %+v (%v)", tl, err)
+	}
+}
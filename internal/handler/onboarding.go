@@ -0,0 +1,326 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/onboarding"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+	"github.com/go-chi/chi"
+)
+
+// loadOnboarding loads everything the onboarding checklist of a customer looks at
+func (m *Repository) loadOnboarding(id int) (onboarding.Record, error) {
+	var rec onboarding.Record
+	var err error
+
+	rec.Customer, err = m.DB.GetCustomerByID(id)
+	if err != nil {
+		return rec, err
+	}
+
+	tl, err := m.DB.GetTradeLicenseInforByID(id)
+	switch {
+	case err == nil:
+		rec.TradeLicense = &tl
+	case !errors.Is(err, sql.ErrNoRows):
+		return rec, err
+	}
+
+	if rec.Partners, err = m.DB.GetTradeShareInforByID(id); err != nil {
+		return rec, err
+	}
+	if rec.Memorandum, err = m.DB.GetMemorandumInforByID(id); err != nil {
+		return rec, err
+	}
+
+	images, err := m.DB.GetAttachmentsByCustomerID(id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return rec, err
+	}
+	rec.Attachments = images.Attachments
+
+	if rec.Reviews, err = m.DB.GetKYCReviewsByCustomerID(id); err != nil {
+		return rec, err
+	}
+
+	return rec, nil
+}
+
+// addOnboarding puts the wizard's progress bar in data while the customer is
+// onboarding, so step pages show where staff are
+func (m *Repository) addOnboarding(data map[string]interface{}, customerID int, step string) error {
+	if customerID == 0 {
+		data["onboarding"] = onboarding.Checklist{}.Progress(0, step)
+		return nil
+	}
+
+	rec, err := m.loadOnboarding(customerID)
+	if err != nil {
+		return err
+	}
+	if rec.Customer.Status == models.CustomerOnboarding {
+		data["onboarding"] = onboarding.Check(rec, time.Now()).Progress(customerID, step)
+	}
+	return nil
+}
+
+// draftForm returns a form holding the saved draft of a step, or an empty one
+func (m *Repository) draftForm(r *http.Request, customerID int, step string) (*forms.Form, error) {
+	d, err := m.DB.GetCustomerDraft(customerID, m.draftUserID(r, customerID), step)
+	if errors.Is(err, sql.ErrNoRows) {
+		return forms.New(nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return forms.New(d.Values), nil
+}
+
+// draftUserID returns the user drafts are kept for: the logged in user for
+// customers that are not added yet, nobody for the others
+func (m *Repository) draftUserID(r *http.Request, customerID int) int {
+	if customerID != 0 {
+		return 0
+	}
+	return m.App.Session.GetInt(r.Context(), "user_id")
+}
+
+// finishStep drops the draft of a step that was just submitted and redirects
+// back to the wizard while the customer is onboarding, or to next otherwise
+func (m *Repository) finishStep(w http.ResponseWriter, r *http.Request, customerID int, step, next string) {
+	if err := m.DB.DeleteCustomerDraft(customerID, 0, step); err != nil {
+		m.App.Logger.Error("cannot delete onboarding draft", "request_id", helpers.RequestID(r),
+			"customer_id", customerID, "step", step, "error", err)
+	}
+
+	c, err := m.DB.GetCustomerByID(customerID)
+	if err == nil && c.Status == models.CustomerOnboarding {
+		next = fmt.Sprintf("/customer/onboard/%d", customerID)
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// onboardingCustomerID reads the customer ID of an onboarding route
+func onboardingCustomerID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 0 {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// ShowOnboarding shows the onboarding checklist of a customer, with what is
+// left to do in each step
+func (m *Repository) ShowOnboarding(w http.ResponseWriter, r *http.Request) {
+	id, ok := onboardingCustomerID(w, r)
+	if !ok {
+		return
+	}
+
+	rec, err := m.loadOnboarding(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	drafts, err := m.DB.GetCustomerDrafts(id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	drafted := make(map[string]time.Time)
+	for _, d := range drafts {
+		drafted[d.Step] = d.UpdatedAt
+	}
+
+	var signed []models.Attachment
+	for _, a := range rec.Attachments {
+		if a.Kind == uploads.KindSignedMemorandum {
+			signed = append(signed, a)
+		}
+	}
+
+	checklist := onboarding.Check(rec, time.Now())
+	data := make(map[string]interface{})
+	data["customer"] = rec.Customer
+	data["checklist"] = checklist
+	data["progress"] = checklist.Progress(id, checklist.Next())
+	data["drafts"] = drafted
+	data["partners"] = rec.Partners
+	data["memorandum"] = rec.Memorandum
+	data["signed"] = signed
+	data["complete"] = checklist.Complete()
+	data["active"] = rec.Customer.Status == models.CustomerActive
+
+	render.Templates(w, r, "customer-onboarding.page.tmpl", &models.TemplateData{
+		Data:       data,
+		Form:       forms.New(nil),
+		CustomerID: id,
+	})
+}
+
+// PostOnboardingDraft saves the values of a step's form without validating
+// them, so staff can come back and finish it. Files are not kept.
+func (m *Repository) PostOnboardingDraft(w http.ResponseWriter, r *http.Request) {
+	id, ok := onboardingCustomerID(w, r)
+	if !ok {
+		return
+	}
+	if !m.parseUploadForm(w, r) {
+		return
+	}
+
+	step := r.PostForm.Get("wizardStep")
+	if !onboarding.ValidStep(step) || id == 0 && step != onboarding.StepCompany {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if id != 0 {
+		if _, err := m.DB.GetCustomerByID(id); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				helpers.ClientError(w, r, http.StatusNotFound)
+				return
+			}
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	values := url.Values{}
+	for k, v := range r.PostForm {
+		if k != "csrf_token" && k != "wizardStep" {
+			values[k] = v
+		}
+	}
+
+	err := m.DB.SaveCustomerDraft(models.CustomerDraft{
+		CustomerID: id,
+		UserID:     m.draftUserID(r, id),
+		Step:       step,
+		Values:     values,
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	msg := "Draft saved, you can finish this step later."
+	if len(r.MultipartForm.File) > 0 {
+		msg += " Attached files are not kept in drafts, choose them again when you finish."
+	}
+	m.App.Session.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, onboarding.URL(step, id), http.StatusSeeOther)
+}
+
+// PostSignedMemorandum stores the signed memorandum of association of a customer
+func (m *Repository) PostSignedMemorandum(w http.ResponseWriter, r *http.Request) {
+	id, ok := onboardingCustomerID(w, r)
+	if !ok {
+		return
+	}
+	if !m.parseUploadForm(w, r) {
+		return
+	}
+
+	customerCode, err := m.DB.GetCustomerCodeByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	back := fmt.Sprintf("/customer/onboard/%d", id)
+	form := forms.New(r.PostForm)
+	checked, err := m.checkUploads(r, form, id, "signedMemorandum")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if msg := form.Errors.Get("signedMemorandum"); msg != "" {
+		m.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	u, ok := checked["signedMemorandum"]
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Choose the signed memorandum to upload.")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if _, err := m.saveUpload(r, id, customerCode, uploads.KindSignedMemorandum, u); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Signed memorandum uploaded")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// PostActivateCustomer marks an onboarding customer active, once every
+// required item of the checklist is done
+func (m *Repository) PostActivateCustomer(w http.ResponseWriter, r *http.Request) {
+	id, ok := onboardingCustomerID(w, r)
+	if !ok {
+		return
+	}
+
+	rec, err := m.loadOnboarding(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	checklist := onboarding.Check(rec, time.Now())
+	if !checklist.Complete() {
+		m.App.Session.Put(r.Context(), "error", "The customer can't be activated yet: "+strings.Join(checklist.Missing(), ", "))
+		http.Redirect(w, r, fmt.Sprintf("/customer/onboard/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.ActivateCustomer(id)
+	if errors.Is(err, repository.ErrReviewsPending) {
+		m.App.Session.Put(r.Context(), "error", "The customer can't be activated yet: a review was sent back meanwhile")
+		http.Redirect(w, r, fmt.Sprintf("/customer/onboard/%d", id), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	for _, s := range onboarding.Steps {
+		if err := m.DB.DeleteCustomerDraft(id, 0, s.Name); err != nil {
+			m.App.Logger.Error("cannot delete onboarding draft", "request_id", helpers.RequestID(r),
+				"customer_id", id, "step", s.Name, "error", err)
+		}
+	}
+
+	m.App.Logger.Info("customer activated", "request_id", helpers.RequestID(r), "customer_id", id,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Customer onboarding complete, "+rec.Customer.CustomerName+" is now active")
+	http.Redirect(w, r, fmt.Sprintf("/customer/details/%d", id), http.StatusSeeOther)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+)
+
+// postFields posts fields as a multipart form without following redirects
+func postFields(t *testing.T, ts *httptest.Server, url string, fields map[string]string) *http.Response {
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return postWith(t, client, ts.URL+url, fields)
+}
+
+// postWith posts fields as a multipart form with client
+func postWith(t *testing.T, client *http.Client, url string, fields map[string]string) *http.Response {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range fields {
+		mw.WriteField(k, v)
+	}
+	mw.Close()
+
+	resp, err := client.Post(url, mw.FormDataContentType(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestOnboarding(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	var img bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 60, 30)))
+
+	resp := postCustomer(t, ts, "ONB01", "photo.png", img.Bytes())
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusSeeOther || !strings.HasPrefix(location, "/customer/onboard/") {
+		t.Fatalf("expected a redirect to the onboarding checklist, got %d %s", resp.StatusCode, location)
+	}
+	var id int
+	fmt.Sscanf(location, "/customer/onboard/%d", &id)
+
+	c, err := Repo.DB.GetCustomerByID(id)
+	if err != nil || c.Status != models.CustomerOnboarding {
+		t.Fatalf("expected the new customer to be onboarding, got %q (%v)", c.Status, err)
+	}
+
+	// A draft is kept until the step is finished
+	resp = postFields(t, ts, fmt.Sprintf("/customer/onboard/%d/draft", id), map[string]string{
+		"wizardStep": "trade-license",
+		"mohreno":    "MOHRE-778",
+	})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != fmt.Sprintf("/customer/trade-license/%d", id) {
+		t.Fatalf("expected the draft to be saved, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/customer/trade-license/%d", id))
+	page, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), "MOHRE-778") {
+		t.Error("expected the trade license form to show the draft")
+	}
+
+	resp = postFields(t, ts, fmt.Sprintf("/customer/onboard/%d/draft", id), map[string]string{"wizardStep": "payment"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected %d for an unknown step but got %d", http.StatusBadRequest, resp.StatusCode)
+	}
+
+	resp, _ = ts.Client().Get(ts.URL + location)
+	page, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Trade license recorded") {
+		t.Errorf("expected the checklist, got status %d", resp.StatusCode)
+	}
+
+	// Activation is refused while the checklist is incomplete
+	activate := fmt.Sprintf("/customer/onboard/%d/activate", id)
+	resp = postFields(t, ts, activate, nil)
+	if resp.Header.Get("Location") != location {
+		t.Errorf("expected activation to be refused, got %s", resp.Header.Get("Location"))
+	}
+	if c, _ := Repo.DB.GetCustomerByID(id); c.Status != models.CustomerOnboarding {
+		t.Errorf("expected the customer to stay onboarding, got %q", c.Status)
+	}
+
+	// The company details wait for a reviewer
+	reviews, _ := Repo.DB.GetKYCReviewsByCustomerID(id)
+	if len(reviews) != 1 || reviews[0].State != models.ReviewPending {
+		t.Fatalf("expected the company details to be pending review, got %+v", reviews)
+	}
+	Repo.DB.UpdateKYCReviewState(models.KYCReviewEvent{ReviewID: reviews[0].ID,
+		FromState: models.ReviewPending, ToState: models.ReviewApproved})
+
+	Repo.DB.InsertTradeLicense(models.TradeLicense{CustomerId: id, TradeLicenseNo: "123456",
+		LicenseExpiry: time.Now().AddDate(1, 0, 0), FilePath: "license.pdf"}, 1)
+	Repo.DB.InsertPartner(models.TradeLicenseHolder{CustomerId: id, ShareHolderName: "Partner",
+		ShIDFilepath: "id.pdf", ShPassFilepath: "passport.pdf"}, 1)
+	Repo.DB.InsertFile(models.Attachment{CustomerId: id, Kind: uploads.KindSignedMemorandum, FilePath: "moa.pdf"})
+
+	// Records entered after the company details are submitted with them, and
+	// the customer can't be activated until every one is approved
+	reviews, _ = Repo.DB.GetKYCReviewsByCustomerID(id)
+	if len(reviews) != 3 {
+		t.Fatalf("expected 3 reviews, got %+v", reviews)
+	}
+	Repo.DB.UpdateKYCReviewState(models.KYCReviewEvent{ReviewID: reviews[1].ID,
+		FromState: models.ReviewPending, ToState: models.ReviewApproved})
+	resp = postFields(t, ts, activate, nil)
+	if resp.Header.Get("Location") != location {
+		t.Errorf("expected activation to be refused with a shareholder pending review, got %s", resp.Header.Get("Location"))
+	}
+	Repo.DB.UpdateKYCReviewState(models.KYCReviewEvent{ReviewID: reviews[2].ID,
+		FromState: models.ReviewPending, ToState: models.ReviewApproved})
+
+	resp = postFields(t, ts, activate, nil)
+	if resp.Header.Get("Location") != fmt.Sprintf("/customer/details/%d", id) {
+		t.Errorf("expected the customer to be activated, got %s", resp.Header.Get("Location"))
+	}
+	if c, _ := Repo.DB.GetCustomerByID(id); c.Status != models.CustomerActive {
+		t.Errorf("expected the customer to be active, got %q", c.Status)
+	}
+	if drafts, _ := Repo.DB.GetCustomerDrafts(id); len(drafts) != 0 {
+		t.Errorf("expected the drafts to be dropped, got %d", len(drafts))
+	}
+}
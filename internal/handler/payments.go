@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/payments"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// maxWebhookSize limits the body of webhook calls, Stripe's events are far smaller
+const maxWebhookSize = 64 << 10
+
+// paymentURL is where the guest pays for a reservation
+func paymentURL(res models.Reservation) string {
+	return "/reservation/" + res.Reference + "/pay"
+}
+
+// ReservationPayment shows the guest the payment form of a reservation. The
+// payment intent is created the first time and kept, so the guest pays the
+// same intent when coming back to the page.
+func (m *Repository) ReservationPayment(w http.ResponseWriter, r *http.Request) {
+	reference := chi.URLParam(r, "reference")
+	if m.App.Payments == nil || len(reference) != 10 {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByReference(reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	inv := m.invoice(res)
+
+	if res.PaymentStatus == models.PaymentPending && res.PaymentIntentID == "" && !res.Cancelled() {
+		// Changing the dates cancels the intent, the version makes its replacement a new one
+		intent, err := m.App.Payments.CreateIntent(r.Context(), payments.NewIntent{
+			Amount:      inv.Total,
+			Reference:   res.Reference,
+			Version:     res.UpdatedAt.UTC().Format("20060102T150405.000000"),
+			Email:       res.Email,
+			Description: fmt.Sprintf("Reservation %s, %s", res.Reference, res.Room.RoomName),
+		})
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if err := m.DB.SetPaymentIntent(res.ID, intent.ID, intent.ClientSecret); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		res.PaymentIntentID, res.PaymentClientSecret = intent.ID, intent.ClientSecret
+		m.App.Logger.Info("payment intent created", "request_id", helpers.RequestID(r), "reservation_id", res.ID,
+			"intent_id", intent.ID, "amount", int64(intent.Amount))
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["invoice"] = inv
+	data["publicKey"] = m.App.PaymentsPublicKey
+	render.Templates(w, r, "reservation-pay.page.tmpl", &models.TemplateData{
+		Data: data,
+		StringMap: map[string]string{
+			"returnURL": m.App.BaseURL + paymentURL(res),
+		},
+	})
+}
+
+// PaymentWebhook receives the payment provider's events and updates the
+// payment status of reservations. The provider retries calls that fail and
+// may send an event more than once, so events are applied once by their ID
+// and answered with 200 OK whenever there is nothing to retry.
+func (m *Repository) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	if m.App.Payments == nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusRequestEntityTooLarge)
+		return
+	}
+	event, err := m.App.Payments.VerifyWebhook(payload, r.Header)
+	if err != nil {
+		m.App.Logger.Warn("payment webhook refused", "request_id", helpers.RequestID(r), "error", err)
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var status string
+	switch event.Kind {
+	case payments.EventSucceeded:
+		status = models.PaymentPaid
+	case payments.EventRefunded:
+		status = models.PaymentRefunded
+	case payments.EventFailed:
+		// The guest can try again with the same intent
+		m.App.Logger.Info("payment failed", "request_id", helpers.RequestID(r), "event_id", event.ID, "intent_id", event.IntentID)
+		w.WriteHeader(http.StatusOK)
+		return
+	default:
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	applied, err := m.DB.ApplyPaymentEvent(models.PaymentEvent{
+		ID:       event.ID,
+		IntentID: event.IntentID,
+		Status:   status,
+		Amount:   event.Amount,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		// Not one of ours, maybe made from the provider's dashboard
+		m.App.Logger.Warn("payment event for unknown intent", "request_id", helpers.RequestID(r),
+			"event_id", event.ID, "intent_id", event.IntentID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("payment event received", "request_id", helpers.RequestID(r), "event_id", event.ID,
+		"intent_id", event.IntentID, "status", status, "applied", applied)
+	if applied && status == models.PaymentPaid {
+		m.refundLatePayment(r, event.IntentID)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// adminPaidReservation loads the reservation of an admin payment route for an
+// administrator, answering itself when there is none or it has no payment
+func (m *Repository) adminPaidReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	if !m.requireAdmin(w, r) {
+		return models.Reservation{}, false
+	}
+	if m.App.Payments == nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return res, false
+	}
+	if res.PaymentIntentID == "" {
+		m.App.Session.Put(r.Context(), "error", "The guest hasn't started paying this reservation")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id), http.StatusSeeOther)
+		return res, false
+	}
+	return res, true
+}
+
+// AdminCaptureReservation takes the payment the guest authorized, when
+// payments are only authorized at booking
+func (m *Repository) AdminCaptureReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminPaidReservation(w, r)
+	if !ok {
+		return
+	}
+	show := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), res.ID)
+	if res.Cancelled() {
+		m.App.Session.Put(r.Context(), "error", "The reservation is cancelled, its payment is not captured")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	intent, err := m.App.Payments.Capture(r.Context(), res.PaymentIntentID)
+	if err != nil {
+		m.App.Logger.Warn("cannot capture payment", "request_id", helpers.RequestID(r), "reservation_id", res.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", "The payment could not be captured: "+err.Error())
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+	if _, err := m.DB.ApplyPaymentEvent(models.PaymentEvent{
+		ID:       "capture:" + intent.ID,
+		IntentID: intent.ID,
+		Status:   models.PaymentPaid,
+		Amount:   intent.Amount,
+	}); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("payment captured", "request_id", helpers.RequestID(r), "reservation_id", res.ID,
+		"intent_id", intent.ID, "user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Payment captured")
+	http.Redirect(w, r, show, http.StatusSeeOther)
+}
+
+// AdminRefundReservation gives the guest back what they paid for a reservation
+func (m *Repository) AdminRefundReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.adminPaidReservation(w, r)
+	if !ok {
+		return
+	}
+	show := fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), res.ID)
+	if res.PaymentStatus != models.PaymentPaid {
+		m.App.Session.Put(r.Context(), "error", "Only paid reservations can be refunded")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	// Guests who cancelled late keep paying the cancellation fee
+	amount := res.AmountPaid - res.CancellationFee
+	if amount <= 0 {
+		m.App.Session.Put(r.Context(), "error", "The cancellation fee takes all that was paid, there is nothing to refund")
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+
+	refund, err := m.App.Payments.Refund(r.Context(), res.PaymentIntentID, amount)
+	if err != nil {
+		m.App.Logger.Warn("cannot refund payment", "request_id", helpers.RequestID(r), "reservation_id", res.ID, "error", err)
+		m.App.Session.Put(r.Context(), "error", "The payment could not be refunded: "+err.Error())
+		http.Redirect(w, r, show, http.StatusSeeOther)
+		return
+	}
+	// The provider reports the refund to the webhook too, which then changes nothing
+	if _, err := m.DB.ApplyPaymentEvent(models.PaymentEvent{
+		ID:       "refund:" + refund.ID,
+		IntentID: res.PaymentIntentID,
+		Status:   models.PaymentRefunded,
+		Amount:   refund.Amount,
+	}); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("payment refunded", "request_id", helpers.RequestID(r), "reservation_id", res.ID,
+		"refund_id", refund.ID, "amount", int64(refund.Amount), "user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s %s refunded", models.Currency, refund.Amount))
+	http.Redirect(w, r, show, http.StatusSeeOther)
+}
@@ -0,0 +1,265 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/payments"
+	"github.com/chamrasilva89/reservationWeb/internal/payments/paymentstest"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestPayments(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	stripeServer := paymentstest.NewStripeServer(t)
+	Repo.App.Payments = &payments.Stripe{
+		SecretKey:     stripeServer.SecretKey,
+		WebhookSecret: "whsec_test",
+		BaseURL:       stripeServer.URL,
+	}
+	Repo.App.PaymentsPublicKey = "pk_test_key"
+	defer func() { Repo.App.Payments, Repo.App.PaymentsPublicKey = nil, "" }()
+
+	jar, _ := cookiejar.New(nil)
+	guest := &http.Client{Transport: ts.Client().Transport, Jar: jar}
+	resp, err := guest.PostForm(ts.URL+"/make-reservation", url.Values{
+		"first_name": {"Jane"},
+		"last_name":  {"Guest"},
+		"email":      {"jane@example.com"},
+		"start_date": {"2051-03-01"},
+		"end_date":   {"2051-03-04"},
+		"room_id":    {"2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	m := regexp.MustCompile(`href="(/reservation/([A-Z0-9]{10})/pay)"`).FindSubmatch(page)
+	if m == nil {
+		t.Fatal("expected the summary to link to the payment page")
+	}
+	payURL, reference := string(m[1]), string(m[2])
+
+	// The intent is created once for the invoice total
+	for i := 0; i < 2; i++ {
+		resp, _ = ts.Client().Get(ts.URL + payURL)
+		page, _ = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "pk_test_key") {
+			t.Fatalf("expected the payment page, got status %d", resp.StatusCode)
+		}
+	}
+	res, err := Repo.DB.GetReservationByReference(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	intentID := stripeServer.IntentFor(reference)
+	if res.PaymentIntentID != intentID || res.PaymentStatus != models.PaymentPending ||
+		!strings.Contains(string(page), res.PaymentClientSecret) {
+		t.Fatalf("expected the reservation to keep intent %s, got %+v", intentID, res)
+	}
+
+	webhook := func(payload []byte, signature string) int {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/payments/webhook", bytes.NewReader(payload))
+		req.Header.Set("Stripe-Signature", signature)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	stripeServer.Pay(intentID)
+	paid := stripeServer.Event("evt_paid", "payment_intent.succeeded", intentID)
+	if status := webhook(paid, payments.SignWebhook(paid, "whsec_other", time.Now())); status != http.StatusBadRequest {
+		t.Errorf("expected a wrongly signed webhook to be refused, got status %d", status)
+	}
+	if res, _ := Repo.DB.GetReservationByReference(reference); res.PaymentStatus != models.PaymentPending {
+		t.Fatalf("a wrongly signed webhook marked the reservation %s", res.PaymentStatus)
+	}
+
+	// Stripe may send an event more than once
+	for i := 0; i < 2; i++ {
+		if status := webhook(paid, payments.SignWebhook(paid, "whsec_test", time.Now())); status != http.StatusOK {
+			t.Fatalf("expected the webhook to be accepted, got status %d", status)
+		}
+	}
+	res, _ = Repo.DB.GetReservationByReference(reference)
+	// 3 nights at 650.00 and 5% VAT
+	if res.PaymentStatus != models.PaymentPaid || res.AmountPaid != 204750 {
+		t.Fatalf("expected the reservation to be paid AED 2,047.50, got %s %s", res.PaymentStatus, res.AmountPaid)
+	}
+
+	unknown := stripeServer.Event("evt_other", "payment_intent.succeeded", "pi_unknown")
+	if status := webhook(unknown, payments.SignWebhook(unknown, "whsec_test", time.Now())); status != http.StatusOK {
+		t.Errorf("expected events of unknown intents to be acknowledged, got status %d", status)
+	}
+
+	// Only administrators refund
+	show := fmt.Sprintf("/admin/reservations/all/%d/show", res.ID)
+	refund := fmt.Sprintf("/admin/reservations/all/%d/refund", res.ID)
+	reviewer := login(t, ts, dbrepo.DemoReviewerEmail)
+	resp, _ = reviewer.PostForm(ts.URL+refund, nil)
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a reviewer to be refused, got status %d", resp.StatusCode)
+	}
+
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	resp, _ = admin.Get(ts.URL + show)
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), refund) {
+		t.Error("expected the reservation page to offer a refund")
+	}
+	resp, _ = admin.PostForm(ts.URL+refund, nil)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != show {
+		t.Fatalf("expected to be sent back to the reservation, got status %d", resp.StatusCode)
+	}
+	res, _ = Repo.DB.GetReservationByReference(reference)
+	if res.PaymentStatus != models.PaymentRefunded || stripeServer.Refunds != 1 {
+		t.Fatalf("expected the reservation to be refunded once, got %s after %d refunds", res.PaymentStatus, stripeServer.Refunds)
+	}
+
+	// Stripe reports the refund too, and refunding again does nothing
+	refunded := stripeServer.Event("evt_refunded", "charge.refunded", intentID)
+	if status := webhook(refunded, payments.SignWebhook(refunded, "whsec_test", time.Now())); status != http.StatusOK {
+		t.Errorf("expected the refund event to be accepted, got status %d", status)
+	}
+	admin.PostForm(ts.URL+refund, nil)
+	if stripeServer.Refunds != 1 {
+		t.Errorf("expected no second refund, got %d", stripeServer.Refunds)
+	}
+	// A late payment event doesn't undo the refund
+	webhook(paid, payments.SignWebhook(paid, "whsec_test", time.Now()))
+	if res, _ := Repo.DB.GetReservationByReference(reference); res.PaymentStatus != models.PaymentRefunded {
+		t.Errorf("expected the reservation to stay refunded, got %s", res.PaymentStatus)
+	}
+}
+
+func TestPaymentsChangeDates(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	stripeServer := paymentstest.NewStripeServer(t)
+	Repo.App.Payments = &payments.Stripe{
+		SecretKey:     stripeServer.SecretKey,
+		WebhookSecret: "whsec_test",
+		BaseURL:       stripeServer.URL,
+		ManualCapture: true,
+	}
+	defer func() { Repo.App.Payments = nil }()
+
+	m := manageLink.FindStringSubmatch(book(t, ts, "2", "2051-05-01", "2051-05-04"))
+	if m == nil {
+		t.Fatal("expected the summary to link to the guest portal")
+	}
+	reference, manage := m[2], "/reservation/"+m[2]+"/manage"
+	guest := guestClient(ts)
+	guest.Get(ts.URL + strings.ReplaceAll(m[1], "&amp;", "&"))
+	pay := func() string {
+		resp, _ := ts.Client().Get(ts.URL + "/reservation/" + reference + "/pay")
+		resp.Body.Close()
+		return stripeServer.IntentFor(reference)
+	}
+	first := pay()
+
+	// A week later costs the same, and is paid with a new intent
+	resp, _ := guest.PostForm(ts.URL+manage+"/dates", url.Values{"start_date": {"2051-05-08"}, "end_date": {"2051-05-11"}})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != manage {
+		t.Fatalf("expected the dates to change, got status %d", resp.StatusCode)
+	}
+	if status := stripeServer.Status(first); status != "canceled" {
+		t.Errorf("expected the intent of the old dates to be cancelled, got %s", status)
+	}
+	second := pay()
+	if second == first || stripeServer.Status(second) != "requires_payment_method" {
+		t.Fatalf("expected a new intent for the new dates, got %s (%s)", second, stripeServer.Status(second))
+	}
+
+	// Authorized payments are settled by staff
+	stripeServer.Pay(second)
+	resp, _ = guest.PostForm(ts.URL+manage+"/dates", url.Values{"start_date": {"2051-05-15"}, "end_date": {"2051-05-18"}})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected to be sent back to the reservation, got status %d", resp.StatusCode)
+	}
+	res, _ := Repo.DB.GetReservationByReference(reference)
+	if res.StartDate.Day() != 8 || res.PaymentIntentID != second || stripeServer.Status(second) != "requires_capture" {
+		t.Errorf("expected the authorized reservation to keep its dates and payment, got %+v", res)
+	}
+}
+
+func TestPaymentsCancellation(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	stripeServer := paymentstest.NewStripeServer(t)
+	Repo.App.Payments = &payments.Stripe{
+		SecretKey:     stripeServer.SecretKey,
+		WebhookSecret: "whsec_test",
+		BaseURL:       stripeServer.URL,
+	}
+	defer func() { Repo.App.Payments = nil }()
+
+	// reserve books a room and opens its payment page
+	reserve := func(start, end string) (string, string) {
+		m := manageLink.FindStringSubmatch(book(t, ts, "2", start, end))
+		if m == nil {
+			t.Fatal("expected the summary to link to the guest portal")
+		}
+		resp, _ := ts.Client().Get(ts.URL + "/reservation/" + m[2] + "/pay")
+		resp.Body.Close()
+		return strings.ReplaceAll(m[1], "&amp;", "&"), m[2]
+	}
+
+	// The guest can't pay a reservation they cancelled
+	link, reference := reserve("2051-06-01", "2051-06-04")
+	guest := guestClient(ts)
+	guest.Get(ts.URL + link)
+	resp, _ := guest.PostForm(ts.URL+"/reservation/"+reference+"/manage/cancel", nil)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be cancelled, got status %d", resp.StatusCode)
+	}
+	if status := stripeServer.Status(stripeServer.IntentFor(reference)); status != "canceled" {
+		t.Errorf("expected the intent of the cancelled reservation to be cancelled, got %s", status)
+	}
+
+	// A payment made while staff cancel is refunded when Stripe reports it
+	_, reference = reserve("2051-06-10", "2051-06-13")
+	intentID := stripeServer.IntentFor(reference)
+	stripeServer.Pay(intentID)
+	res, _ := Repo.DB.GetReservationByReference(reference)
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	resp, _ = admin.PostForm(ts.URL+fmt.Sprintf("/admin/reservations/all/%d/state", res.ID), url.Values{"state": {models.ReservationCancelled}})
+	resp.Body.Close()
+	if res, _ := Repo.DB.GetReservationByReference(reference); !res.Cancelled() {
+		t.Fatalf("expected staff to cancel the reservation, got %s", res.State)
+	}
+	paid := stripeServer.Event("evt_late", "payment_intent.succeeded", intentID)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/payments/webhook", bytes.NewReader(paid))
+	req.Header.Set("Stripe-Signature", payments.SignWebhook(paid, "whsec_test", time.Now()))
+	resp, err := ts.Client().Do(req)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the webhook to be accepted, got %v", err)
+	}
+	res, _ = Repo.DB.GetReservationByReference(reference)
+	if res.PaymentStatus != models.PaymentRefunded || stripeServer.Refunds != 1 || !res.Cancelled() {
+		t.Errorf("expected the late payment to be refunded, got %s %s after %d refunds", res.State, res.PaymentStatus, stripeServer.Refunds)
+	}
+}
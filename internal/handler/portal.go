@@ -0,0 +1,398 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/payments"
+	"github.com/chamrasilva89/reservationWeb/internal/pricing"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"github.com/go-chi/chi"
+)
+
+// manageToken signs a reservation reference, so the link emailed to the guest
+// opens the reservation without looking it up
+func (m *Repository) manageToken(reference string) string {
+	mac := hmac.New(sha256.New, m.App.LinkSecret)
+	mac.Write([]byte("manage:" + reference))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:18])
+}
+
+// manageURL is the signed link the guest manages a reservation with
+func (m *Repository) manageURL(res models.Reservation) string {
+	return "/reservation/" + res.Reference + "/manage?token=" + m.manageToken(res.Reference)
+}
+
+// cancellationPolicy returns the configured cancellation policy
+func (m *Repository) cancellationPolicy() pricing.CancellationPolicy {
+	if m.App.Cancellation == nil {
+		return pricing.DefaultCancellationPolicy
+	}
+	return *m.App.Cancellation
+}
+
+// FindReservation shows the form guests look up their reservation with
+func (m *Repository) FindReservation(w http.ResponseWriter, r *http.Request) {
+	render.Templates(w, r, "reservation-find.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostFindReservation opens the reservation matching the reference and email
+// the guest entered. Both must match, and the guest isn't told which didn't.
+func (m *Repository) PostFindReservation(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("reference", "email")
+	reference := strings.ToUpper(strings.TrimSpace(form.Get("reference")))
+
+	if form.Valid() {
+		res, err := m.DB.GetReservationByReference(reference)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if err == nil && strings.EqualFold(res.Email, strings.TrimSpace(form.Get("email"))) {
+			m.openReservation(r, res)
+			http.Redirect(w, r, "/reservation/"+res.Reference+"/manage", http.StatusSeeOther)
+			return
+		}
+		m.App.Logger.Info("reservation lookup failed", "request_id", helpers.RequestID(r), "reference", reference)
+		form.Errors.Add("reference", "No reservation matches this reference and email")
+	}
+
+	render.Templates(w, r, "reservation-find.page.tmpl", &models.TemplateData{
+		Form: form,
+	})
+}
+
+// openReservation lets the guest's session manage a reservation
+func (m *Repository) openReservation(r *http.Request, res models.Reservation) {
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "guest_reference", res.Reference)
+	m.App.Logger.Info("reservation opened by guest", "request_id", helpers.RequestID(r), "reservation_id", res.ID)
+}
+
+// guestReservation loads the reservation of a guest portal route, sending
+// guests whose session didn't open it to the lookup form
+func (m *Repository) guestReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	reference := chi.URLParam(r, "reference")
+	if m.App.Session.GetString(r.Context(), "guest_reference") != reference {
+		m.App.Session.Put(r.Context(), "warning", "Enter your reference and email to see your reservation")
+		http.Redirect(w, r, "/reservation/find", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByReference(reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return res, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return res, false
+	}
+	return res, true
+}
+
+// ManageReservation shows the guest their reservation, with forms to change
+// its dates or cancel it. The signed link from the confirmation email opens
+// the reservation and redirects here without the token.
+func (m *Repository) ManageReservation(w http.ResponseWriter, r *http.Request) {
+	reference := chi.URLParam(r, "reference")
+	if token := r.URL.Query().Get("token"); token != "" {
+		if !hmac.Equal([]byte(token), []byte(m.manageToken(reference))) {
+			m.App.Session.Put(r.Context(), "error", "This link is not valid, enter your reference and email instead")
+			http.Redirect(w, r, "/reservation/find", http.StatusSeeOther)
+			return
+		}
+		res, err := m.DB.GetReservationByReference(reference)
+		if errors.Is(err, sql.ErrNoRows) {
+			helpers.ClientError(w, r, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.openReservation(r, res)
+		http.Redirect(w, r, "/reservation/"+reference+"/manage", http.StatusSeeOther)
+		return
+	}
+
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	form := forms.New(url.Values{
+		"start_date": {res.StartDate.Format(forms.DateLayout)},
+		"end_date":   {res.EndDate.Format(forms.DateLayout)},
+	})
+	m.renderManage(w, r, res, form)
+}
+
+// renderManage shows the guest portal page of a reservation with the date
+// change form
+func (m *Repository) renderManage(w http.ResponseWriter, r *http.Request, res models.Reservation, form *forms.Form) {
+	policy := m.cancellationPolicy()
+	inv := m.invoice(res)
+	now := time.Now()
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["invoice"] = inv
+	data["policy"] = policy.String()
+	data["deadline"] = policy.Deadline(res.StartDate)
+	data["fee"] = policy.Fee(inv.Total, res.StartDate, now)
+	data["changeable"] = changeable(res) && now.Before(res.StartDate) && res.PaymentStatus == models.PaymentPending
+	data["cancellable"] = res.CheckTransition(models.ReservationCancelled, now) == nil && now.Before(res.StartDate)
+	if m.App.Payments != nil && !res.Cancelled() && res.PaymentStatus == models.PaymentPending {
+		data["paymentURL"] = paymentURL(res)
+	}
+
+	render.Templates(w, r, "reservation-manage.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// requote works out the price of a reservation for other dates, keeping its
+// discount code. The reservation already counts as a use of the code.
+func (m *Repository) requote(res models.Reservation, start, end time.Time) (models.Price, error) {
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		return models.Price{}, err
+	}
+	periods, err := m.DB.RatePeriodsForRoom(room.ID, start, end)
+	if err != nil {
+		return models.Price{}, err
+	}
+
+	var discount *models.DiscountCode
+	if res.Price.DiscountCode != "" {
+		d, err := m.DB.GetDiscountCode(res.Price.DiscountCode)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return models.Price{}, err
+		}
+		if err == nil {
+			d.Uses--
+			discount = &d
+		}
+	}
+	return pricing.Quote(room, periods, discount, start, end)
+}
+
+// PostChangeReservationDates moves the guest's reservation to other dates when
+// the room is free on them, at the price of the new dates. Paid reservations
+// are changed by staff, who settle the difference.
+func (m *Repository) PostChangeReservationDates(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	manage := "/reservation/" + res.Reference + "/manage"
+	if !changeable(res) || !time.Now().Before(res.StartDate) || res.PaymentStatus != models.PaymentPending {
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be changed online anymore, please contact us")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("start_date", "end_date")
+	start, _ := form.Date("start_date")
+	end, _ := form.Date("end_date")
+	form.NotPast("start_date")
+	form.DateAfter("end_date", "start_date")
+	if !form.Valid() {
+		m.renderManage(w, r, res, form)
+		return
+	}
+
+	price, err := m.requote(res, start, end)
+	var discount *pricing.DiscountError
+	if errors.As(err, &discount) {
+		form.Errors.Add("end_date", "Your discount code "+discount.Reason+" for these dates")
+		err = nil
+	}
+	if err = addPriceError(form, err); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !form.Valid() {
+		m.renderManage(w, r, res, form)
+		return
+	}
+
+	// The guest can't pay the old price anymore, and payments made or
+	// authorized already are settled by staff
+	cancelled := false
+	if m.App.Payments != nil && res.PaymentIntentID != "" {
+		_, err := m.App.Payments.Cancel(r.Context(), res.PaymentIntentID)
+		if err != nil {
+			msg := "Your dates can't be changed right now, please try again later"
+			if errors.Is(err, payments.ErrIntentPaid) {
+				msg = "Your payment was received, please contact us to change your dates"
+			}
+			m.App.Logger.Warn("cannot cancel payment to change dates", "request_id", helpers.RequestID(r),
+				"reservation_id", res.ID, "intent_id", res.PaymentIntentID, "error", err)
+			m.App.Session.Put(r.Context(), "error", msg)
+			http.Redirect(w, r, manage, http.StatusSeeOther)
+			return
+		}
+		cancelled = true
+	}
+
+	err = m.DB.ChangeReservationDates(res.ID, start, end, price)
+	if err != nil && cancelled {
+		// Drop the cancelled intent, the guest pays a new one
+		if err := m.DB.SetPaymentIntent(res.ID, "", ""); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	if errors.Is(err, repository.ErrNotAvailable) {
+		form.Errors.Add("start_date", "The room is not available on these dates")
+		m.renderManage(w, r, res, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("reservation dates changed by guest", "request_id", helpers.RequestID(r), "reservation_id", res.ID,
+		"start_date", start.Format(forms.DateLayout), "end_date", end.Format(forms.DateLayout), "total", int64(price.Total))
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Your stay is now from %s to %s",
+		start.Format("Mon 02 Jan 2006"), end.Format("Mon 02 Jan 2006")))
+	http.Redirect(w, r, manage, http.StatusSeeOther)
+}
+
+// changeable reports whether the state of the reservation lets its dates change
+func changeable(res models.Reservation) bool {
+	return res.State == models.ReservationPending || res.State == models.ReservationConfirmed
+}
+
+// PostCancelReservation cancels the guest's reservation before arrival,
+// charging the fee of the cancellation policy. What was paid online beyond
+// the fee is refunded.
+func (m *Repository) PostCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.guestReservation(w, r)
+	if !ok {
+		return
+	}
+	manage := "/reservation/" + res.Reference + "/manage"
+	now := time.Now()
+	if res.CheckTransition(models.ReservationCancelled, now) != nil || !now.Before(res.StartDate) {
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be cancelled online anymore, please contact us")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+
+	fee := m.cancellationPolicy().Fee(m.invoice(res).Total, res.StartDate, now)
+	err := m.DB.CancelReservation(models.ReservationEvent{
+		ReservationID: res.ID,
+		FromState:     res.State,
+		Actor:         models.ActorGuest,
+	}, fee)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Staff changed the reservation meanwhile
+		m.App.Session.Put(r.Context(), "error", "This reservation can't be cancelled online anymore, please contact us")
+		http.Redirect(w, r, manage, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Logger.Info("reservation cancelled by guest", "request_id", helpers.RequestID(r), "reservation_id", res.ID,
+		"fee", int64(fee))
+	m.cancelPayment(r, res)
+
+	msg := "Your reservation is cancelled"
+	if fee > 0 {
+		msg += fmt.Sprintf(", with a fee of %s %s", models.Currency, fee)
+	}
+	if refund := res.AmountPaid - fee; m.App.Payments != nil && res.PaymentStatus == models.PaymentPaid && refund > 0 {
+		if err := m.refundCancellation(r, res, refund); err != nil {
+			// The reservation is cancelled already, staff refund it from the admin tool
+			m.App.Logger.Error("cannot refund cancelled reservation", "request_id", helpers.RequestID(r),
+				"reservation_id", res.ID, "error", err)
+			msg += ". We will refund your payment shortly"
+		} else {
+			msg += fmt.Sprintf(". %s %s is refunded to you", models.Currency, refund)
+		}
+	}
+	m.App.Session.Put(r.Context(), "flash", msg)
+	http.Redirect(w, r, manage, http.StatusSeeOther)
+}
+
+// cancelPayment cancels the intent the guest was asked to pay a cancelled
+// reservation with, so that it can't be paid anymore. A payment made meanwhile
+// is refunded when the provider reports it, and an authorization lapses.
+func (m *Repository) cancelPayment(r *http.Request, res models.Reservation) {
+	if m.App.Payments == nil || res.PaymentIntentID == "" || res.PaymentStatus != models.PaymentPending {
+		return
+	}
+	_, err := m.App.Payments.Cancel(r.Context(), res.PaymentIntentID)
+	if err != nil {
+		m.App.Logger.Warn("cannot cancel payment of cancelled reservation", "request_id", helpers.RequestID(r),
+			"reservation_id", res.ID, "intent_id", res.PaymentIntentID, "error", err)
+	}
+}
+
+// refundLatePayment gives the guest back what they paid beyond the
+// cancellation fee when the payment came after the reservation was cancelled.
+// Payments that can't be refunded now are left for staff, who see the
+// cancelled reservation as paid.
+func (m *Repository) refundLatePayment(r *http.Request, intentID string) {
+	res, err := m.DB.GetReservationByPaymentIntent(intentID)
+	if err != nil || !res.Cancelled() || res.PaymentStatus != models.PaymentPaid {
+		return
+	}
+	refund := res.AmountPaid - res.CancellationFee
+	if refund <= 0 {
+		return
+	}
+	if err := m.refundCancellation(r, res, refund); err != nil {
+		m.App.Logger.Error("cannot refund payment of cancelled reservation", "request_id", helpers.RequestID(r),
+			"reservation_id", res.ID, "intent_id", intentID, "error", err)
+		return
+	}
+	m.App.Logger.Info("payment of cancelled reservation refunded", "request_id", helpers.RequestID(r),
+		"reservation_id", res.ID, "amount", int64(refund))
+}
+
+// refundCancellation gives the guest back amount of what they paid for a
+// cancelled reservation
+func (m *Repository) refundCancellation(r *http.Request, res models.Reservation, amount models.Money) error {
+	refund, err := m.App.Payments.Refund(r.Context(), res.PaymentIntentID, amount)
+	if err != nil {
+		return err
+	}
+	_, err = m.DB.ApplyPaymentEvent(models.PaymentEvent{
+		ID:       "refund:" + refund.ID,
+		IntentID: res.PaymentIntentID,
+		Status:   models.PaymentRefunded,
+		Amount:   refund.Amount,
+	})
+	return err
+}
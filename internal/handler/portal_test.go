@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/pricing"
+)
+
+// guestClient returns a client with its own session that doesn't follow redirects
+func guestClient(ts *httptest.Server) *http.Client {
+	jar, _ := cookiejar.New(nil)
+	return &http.Client{
+		Transport: ts.Client().Transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// book reserves a room as a guest and returns the summary page
+func book(t *testing.T, ts *httptest.Server, roomID, start, end string) string {
+	jar, _ := cookiejar.New(nil)
+	guest := &http.Client{Transport: ts.Client().Transport, Jar: jar}
+	resp, err := guest.PostForm(ts.URL+"/make-reservation", url.Values{
+		"first_name": {"Jane"},
+		"last_name":  {"Guest"},
+		"email":      {"jane@example.com"},
+		"start_date": {start},
+		"end_date":   {end},
+		"room_id":    {roomID},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("cannot book room %s from %s to %s, got status %d", roomID, start, end, resp.StatusCode)
+	}
+	return string(page)
+}
+
+var manageLink = regexp.MustCompile(`href="(/reservation/([A-Z0-9]{10})/manage\?token=[^"]+)"`)
+
+func TestGuestPortal(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	m := manageLink.FindStringSubmatch(book(t, ts, "2", "2051-03-01", "2051-03-04"))
+	if m == nil {
+		t.Fatal("expected the summary to link to the guest portal")
+	}
+	link, reference := strings.ReplaceAll(m[1], "&amp;", "&"), m[2]
+	manage := "/reservation/" + reference + "/manage"
+	book(t, ts, "2", "2051-03-10", "2051-03-12")
+
+	// Without opening the reservation, guests are sent to the lookup
+	guest := guestClient(ts)
+	resp, _ := guest.Get(ts.URL + manage)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != "/reservation/find" {
+		t.Fatalf("expected to be sent to the lookup, got status %d", resp.StatusCode)
+	}
+	resp, _ = guest.Get(ts.URL + manage + "?token=forged")
+	if resp.Header.Get("Location") != "/reservation/find" {
+		t.Error("expected a forged link to be refused")
+	}
+	resp, _ = guest.PostForm(ts.URL+manage+"/cancel", nil)
+	if resp.Header.Get("Location") != "/reservation/find" {
+		t.Error("expected a guest who didn't open the reservation to be refused")
+	}
+
+	// Both the reference and the email must match
+	resp, _ = guest.PostForm(ts.URL+"/reservation/find", url.Values{"reference": {reference}, "email": {"other@example.com"}})
+	page, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "No reservation matches") {
+		t.Fatalf("expected the lookup to fail, got status %d", resp.StatusCode)
+	}
+	resp, _ = guest.PostForm(ts.URL+"/reservation/find", url.Values{
+		"reference": {strings.ToLower(reference)},
+		"email":     {"JANE@example.com"},
+	})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != manage {
+		t.Fatalf("expected the lookup to open the reservation, got status %d", resp.StatusCode)
+	}
+	resp, _ = guest.Get(ts.URL + manage)
+	page, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "AED 2,047.50") {
+		t.Fatalf("expected the reservation, got status %d", resp.StatusCode)
+	}
+
+	// The emailed link opens it too
+	guest = guestClient(ts)
+	resp, _ = guest.Get(ts.URL + link)
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != manage {
+		t.Fatalf("expected the link to open the reservation, got status %d", resp.StatusCode)
+	}
+
+	// Dates taken by the other reservation can't be chosen
+	resp, _ = guest.PostForm(ts.URL+manage+"/dates", url.Values{"start_date": {"2051-03-09"}, "end_date": {"2051-03-11"}})
+	page, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "not available on these dates") {
+		t.Fatalf("expected the room to be unavailable, got status %d", resp.StatusCode)
+	}
+	resp, _ = guest.PostForm(ts.URL+manage+"/dates", url.Values{"start_date": {"2051-03-06"}, "end_date": {"2051-03-06"}})
+	page, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), "This date must be after") {
+		t.Error("expected an error for a stay without nights")
+	}
+
+	// Sunday to Wednesday, but a night less
+	resp, _ = guest.PostForm(ts.URL+manage+"/dates", url.Values{"start_date": {"2051-03-05"}, "end_date": {"2051-03-07"}})
+	if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != manage {
+		t.Fatalf("expected the dates to change, got status %d", resp.StatusCode)
+	}
+	res, err := Repo.DB.GetReservationByReference(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Nights() != 2 || res.Price.Total != 130000 {
+		t.Errorf("expected 2 nights at 650.00, got %d nights for %s", res.Nights(), res.Price.Total)
+	}
+	day := func(d int) time.Time { return time.Date(2051, 3, d, 0, 0, 0, 0, time.UTC) }
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(day(1), day(4), 2); !free {
+		t.Error("expected the old dates to be freed")
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(day(5), day(7), 2); free {
+		t.Error("expected the new dates to be taken")
+	}
+
+	// Cancelling long before arrival is free
+	resp, _ = guest.PostForm(ts.URL+manage+"/cancel", nil)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the reservation to be cancelled, got status %d", resp.StatusCode)
+	}
+	res, _ = Repo.DB.GetReservationByReference(reference)
+	if !res.Cancelled() || res.CancellationFee != 0 {
+		t.Errorf("expected a free cancellation, got cancelled at %v with fee %s", res.CancelledAt, res.CancellationFee)
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(day(5), day(7), 2); !free {
+		t.Error("expected the dates of the cancelled reservation to be freed")
+	}
+	resp, _ = guest.PostForm(ts.URL+manage+"/dates", url.Values{"start_date": {"2051-03-20"}, "end_date": {"2051-03-22"}})
+	if res, _ := Repo.DB.GetReservationByReference(reference); !res.StartDate.Equal(day(5)) {
+		t.Error("expected a cancelled reservation not to change")
+	}
+}
+
+func TestGuestPortalLateCancellation(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	Repo.App.Cancellation = &pricing.CancellationPolicy{FreeDays: 3, FeePercent: 50}
+	defer func() { Repo.App.Cancellation = nil }()
+
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	m := manageLink.FindStringSubmatch(book(t, ts, "2", tomorrow.Format("2006-01-02"), tomorrow.AddDate(0, 0, 2).Format("2006-01-02")))
+	if m == nil {
+		t.Fatal("expected the summary to link to the guest portal")
+	}
+	guest := guestClient(ts)
+	guest.Get(ts.URL + strings.ReplaceAll(m[1], "&amp;", "&"))
+
+	manage := "/reservation/" + m[2] + "/manage"
+	resp, _ := guest.Get(ts.URL + manage)
+	page, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), "Free cancellation until 3 days before arrival, then 50% of the total is charged.") {
+		t.Error("expected the page to show the cancellation policy")
+	}
+
+	guest.PostForm(ts.URL+manage+"/cancel", nil)
+	res, err := Repo.DB.GetReservationByReference(m[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	inv := Repo.invoice(res)
+	if !res.Cancelled() || res.CancellationFee != inv.Total/2 {
+		t.Errorf("expected half of %s charged, got %s", inv.Total, res.CancellationFee)
+	}
+	if res.PaymentStatus != models.PaymentPending {
+		t.Errorf("expected nothing to be refunded, got %s", res.PaymentStatus)
+	}
+}
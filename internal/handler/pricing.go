@@ -0,0 +1,283 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/pricing"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// quote works out the price of a stay in room with the rate periods and the
+// discount code the guest entered, which may be empty. Unknown codes give a
+// pricing.DiscountError like codes that can't be used.
+func (m *Repository) quote(room models.Room, start, end time.Time, code string) (models.Price, error) {
+	periods, err := m.DB.RatePeriodsForRoom(room.ID, start, end)
+	if err != nil {
+		return models.Price{}, err
+	}
+
+	var discount *models.DiscountCode
+	if code = pricing.NormalizeCode(code); code != "" {
+		d, err := m.DB.GetDiscountCode(code)
+		if errors.Is(err, sql.ErrNoRows) {
+			price, _ := pricing.Quote(room, periods, nil, start, end)
+			return price, &pricing.DiscountError{Code: code, Reason: "is not valid"}
+		}
+		if err != nil {
+			return models.Price{}, err
+		}
+		discount = &d
+	}
+	return pricing.Quote(room, periods, discount, start, end)
+}
+
+// addPriceError adds the reason a stay can't be booked at its price to the
+// field of form it is about. Errors that aren't about the stay are returned.
+func addPriceError(form *forms.Form, err error) error {
+	var minStay *pricing.MinStayError
+	var discount *pricing.DiscountError
+	switch {
+	case err == nil:
+	case errors.Is(err, pricing.ErrNoNights):
+		form.Errors.Add("end_date", "The departure must be after the arrival")
+	case errors.Is(err, pricing.ErrTooLong):
+		form.Errors.Add("end_date", fmt.Sprintf("Stays are at most %d nights", pricing.MaxNights))
+	case errors.As(err, &minStay):
+		msg := minStay.Error()
+		form.Errors.Add("end_date", strings.ToUpper(msg[:1])+msg[1:])
+	case errors.As(err, &discount):
+		form.Errors.Add("discount_code", "This code "+discount.Reason)
+	default:
+		return err
+	}
+	return nil
+}
+
+// AdminPricing shows the rate periods and discount codes
+func (m *Repository) AdminPricing(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	m.renderPricing(w, r, forms.New(nil))
+}
+
+// renderPricing shows the pricing page with form, holding the errors of the
+// rate period or discount code that was posted
+func (m *Repository) renderPricing(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	periods, err := m.DB.AllRatePeriods()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	codes, err := m.DB.AllDiscountCodes()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["periods"] = periods
+	data["codes"] = codes
+	data["rooms"] = rooms
+	render.Templates(w, r, "admin-pricing.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// moneyField reads an optional amount in dirhams from form, adding an error
+// when it doesn't parse
+func moneyField(form *forms.Form, field string) models.Money {
+	if !form.Has(field) {
+		return 0
+	}
+	amount, err := models.ParseMoney(form.Get(field))
+	if err != nil {
+		form.Errors.Add(field, "Enter an amount in dirhams, like 450.00")
+	}
+	return amount
+}
+
+// AdminPostRatePeriod adds a rate period
+func (m *Repository) AdminPostRatePeriod(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var p models.RatePeriod
+	form := forms.New(r.PostForm)
+	form.Bind(&p)
+	p.NightlyRate = moneyField(form, "periodNightlyRate")
+	p.WeekendRate = moneyField(form, "periodWeekendRate")
+
+	if !p.StartDate.IsZero() && !p.EndDate.IsZero() && p.EndDate.Before(p.StartDate) {
+		form.Errors.Add("periodEnd", "The last night can't be before the first")
+	}
+	if p.RoomID != 0 {
+		if _, err := m.DB.GetRoomByID(p.RoomID); err != nil {
+			form.Errors.Add("periodRoom", "Choose a room")
+		}
+	}
+	switch {
+	case p.NightlyRate != 0 && p.Percent != 0:
+		form.Errors.Add("periodPercent", "Set either rates or a percentage")
+	case p.NightlyRate != 0 && p.RoomID == 0:
+		form.Errors.Add("periodNightlyRate", "Periods of every room change the rates by a percentage")
+	case p.WeekendRate != 0 && p.NightlyRate == 0:
+		form.Errors.Add("periodNightlyRate", "Set the rate of the other nights too")
+	case p.NightlyRate == 0 && p.Percent == 0 && p.MinNights == 0:
+		form.Errors.Add("periodName", "Set the rates, a percentage or a minimum stay")
+	}
+	if !form.Valid() {
+		m.renderPricing(w, r, form)
+		return
+	}
+
+	id, err := m.DB.InsertRatePeriod(p)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("rate period added", "request_id", helpers.RequestID(r), "period_id", id, "name", p.Name,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Rate period added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminDeleteRatePeriod deletes a rate period. Reservations made during it keep their price.
+func (m *Repository) AdminDeleteRatePeriod(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	err = m.DB.DeleteRatePeriod(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("rate period deleted", "request_id", helpers.RequestID(r), "period_id", id,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Rate period deleted")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// discountCodePattern matches the codes guests can type
+var discountCodePattern = regexp.MustCompile(`^[A-Z0-9-]{3,30}$`)
+
+// AdminPostDiscountCode adds a discount code
+func (m *Repository) AdminPostDiscountCode(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	d := models.DiscountCode{Active: true}
+	form := forms.New(r.PostForm)
+	form.Bind(&d)
+	d.Code = pricing.NormalizeCode(d.Code)
+	d.Amount = moneyField(form, "codeAmount")
+
+	if d.Code != "" && !discountCodePattern.MatchString(d.Code) {
+		form.Errors.Add("code", "Use 3 to 30 letters, digits and dashes")
+	} else if d.Code != "" {
+		_, err := m.DB.GetDiscountCode(d.Code)
+		if err == nil {
+			form.Errors.Add("code", "This code exists already")
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	if (d.Percent == 0) == (d.Amount == 0) {
+		form.Errors.Add("codePercent", "Set either a percentage or an amount off")
+	}
+	if !d.ValidFrom.IsZero() && !d.ValidTo.IsZero() && d.ValidTo.Before(d.ValidFrom) {
+		form.Errors.Add("codeTo", "The last arrival can't be before the first")
+	}
+	if !form.Valid() {
+		m.renderPricing(w, r, form)
+		return
+	}
+
+	id, err := m.DB.InsertDiscountCode(d)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("discount code added", "request_id", helpers.RequestID(r), "code_id", id, "code", d.Code,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Discount code "+d.Code+" added")
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
+
+// AdminPostDiscountCodeActive turns a discount code on or off. Codes are kept
+// rather than deleted, as reservations were made with them.
+func (m *Repository) AdminPostDiscountCodeActive(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	active := r.PostForm.Get("active") == "1"
+
+	err = m.DB.SetDiscountCodeActive(id, active)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("discount code changed", "request_id", helpers.RequestID(r), "code_id", id, "active", active,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	if active {
+		m.App.Session.Put(r.Context(), "flash", "Discount code turned on")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Discount code turned off")
+	}
+	http.Redirect(w, r, "/admin/pricing", http.StatusSeeOther)
+}
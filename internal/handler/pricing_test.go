@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestPricing(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	// The General's Quarters cost 800.00 on the festival night, for stays of 2 nights or more
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	resp, _ := admin.PostForm(ts.URL+"/admin/pricing/periods", url.Values{
+		"periodName":        {"Festival"},
+		"periodRoom":        {"1"},
+		"periodStart":       {"2052-02-03"},
+		"periodEnd":         {"2052-02-03"},
+		"periodNightlyRate": {"800"},
+		"periodMinNights":   {"2"},
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the rate period to be added, got status %d", resp.StatusCode)
+	}
+	resp, _ = admin.PostForm(ts.URL+"/admin/pricing/periods", url.Values{
+		"periodName":        {"Everywhere"},
+		"periodStart":       {"2052-02-01"},
+		"periodEnd":         {"2052-02-28"},
+		"periodNightlyRate": {"100"},
+	})
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Periods of every room change the rates by a percentage") {
+		t.Errorf("expected fixed rates for every room to be refused, got status %d", resp.StatusCode)
+	}
+	resp, _ = admin.PostForm(ts.URL+"/admin/pricing/discounts", url.Values{
+		"code":        {"save50"},
+		"codeAmount":  {"50"},
+		"codeMaxUses": {"1"},
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the discount code to be added, got status %d", resp.StatusCode)
+	}
+
+	jar, _ := cookiejar.New(nil)
+	guest := &http.Client{Transport: ts.Client().Transport, Jar: jar}
+
+	resp, _ = guest.PostForm(ts.URL+"/search-availability", url.Values{"start": {"2052-02-01"}, "end": {"9999-12-31"}})
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/search-availability" {
+		t.Errorf("expected a stay of thousands of years to be refused, got %s", resp.Request.URL.Path)
+	}
+
+	// Thursday, Friday and the Saturday of the festival
+	resp, _ = guest.PostForm(ts.URL+"/search-availability", url.Values{"start": {"2052-02-01"}, "end": {"2052-02-04"}})
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{"AED 1,770.00", "AED 1,950.00", `href="/choose-room/1"`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("expected the rooms to show %q", want)
+		}
+	}
+
+	resp, _ = guest.Get(ts.URL + "/choose-room/1")
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Request.URL.Path != "/make-reservation" || !strings.Contains(string(page), `name="room_id" value="1"`) {
+		t.Fatalf("expected the reservation form for the room, got %s", resp.Request.URL.Path)
+	}
+	for _, want := range []string{"Standard rate, weekend", "Festival", "AED 800.00", `value="2052-02-04"`} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("expected the reservation form to show %q", want)
+		}
+	}
+
+	book := func(start, end, code string) string {
+		resp, err := guest.PostForm(ts.URL+"/make-reservation", url.Values{
+			"first_name":    {"Jane"},
+			"last_name":     {"Guest"},
+			"email":         {"jane@example.com"},
+			"start_date":    {start},
+			"end_date":      {end},
+			"room_id":       {"1"},
+			"discount_code": {code},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(page)
+	}
+
+	if page := book("2052-02-03", "2052-02-04", ""); !strings.Contains(page, "Stays during Festival are at least 2 nights") {
+		t.Error("expected the minimum stay of the festival")
+	}
+	if page := book("2052-02-01", "2052-02-04", "NOPE"); !strings.Contains(page, "This code is not valid") {
+		t.Error("expected an unknown code to be refused")
+	}
+
+	// 1,770.00 less 50.00, with 86.00 VAT
+	summary := book("2052-02-01", "2052-02-04", "Save50")
+	if !strings.Contains(summary, "Reservation Summary") || !strings.Contains(summary, "AED 1,806.00") ||
+		!strings.Contains(summary, "Discount code SAVE50") {
+		t.Fatal("expected the summary with the discounted total")
+	}
+	res, err := Repo.DB.AllReservations("")
+	if err != nil || len(res) != 1 {
+		t.Fatalf("expected one reservation, got %d (%v)", len(res), err)
+	}
+	stored, _ := Repo.DB.GetReservationByID(res[0].ID)
+	if stored.Price.Total != 172000 || stored.Price.Discount != 5000 || len(stored.Price.Lines) != 3 {
+		t.Errorf("expected the price to be stored, got %+v", stored.Price)
+	}
+
+	if page := book("2052-03-01", "2052-03-04", "SAVE50"); !strings.Contains(page, "This code has been used up") {
+		t.Error("expected the code to be used up")
+	}
+}
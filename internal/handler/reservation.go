@@ -0,0 +1,156 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/invoice"
+	"github.com/chamrasilva89/reservationWeb/internal/mailer"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/go-chi/chi"
+)
+
+// referenceAlphabet leaves out letters and digits that are easily confused
+const referenceAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// newReservationReference returns a random reference for a reservation. With
+// 10 characters it can't be guessed, so it is enough to download the
+// reservation's confirmation.
+func newReservationReference() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = referenceAlphabet[int(b[i])%len(referenceAlphabet)]
+	}
+	return string(b), nil
+}
+
+// invoice works out the invoice of a reservation with the configured taxes
+func (m *Repository) invoice(res models.Reservation) invoice.Invoice {
+	taxes := m.App.Taxes
+	if taxes == nil {
+		taxes = invoice.DefaultTaxes
+	}
+	return invoice.New(res, taxes, time.Now())
+}
+
+// confirmationURL is where the guest downloads the confirmation of a reservation
+func confirmationURL(res models.Reservation) string {
+	return "/reservation/" + res.Reference + "/confirmation.pdf"
+}
+
+// sendConfirmation emails the guest the reservation's details with a link to
+// its confirmation. The reservation is stored already, so failures are only
+// logged.
+func (m *Repository) sendConfirmation(r *http.Request, res models.Reservation) {
+	if m.App.Mailer == nil {
+		return
+	}
+	inv := m.invoice(res)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Dear %s,\n\n", res.FirstName)
+	fmt.Fprintf(&body, "Thank you for your reservation. Your reference is %s.\n\n", res.Reference)
+	fmt.Fprintf(&body, "Room:      %s\n", res.Room.RoomName)
+	fmt.Fprintf(&body, "Arrival:   %s\n", res.StartDate.Format("Mon 02 Jan 2006"))
+	fmt.Fprintf(&body, "Departure: %s\n", res.EndDate.Format("Mon 02 Jan 2006"))
+	fmt.Fprintf(&body, "Nights:    %d\n", res.Nights())
+	fmt.Fprintf(&body, "Total:     %s %s including taxes\n\n", models.Currency, inv.Total)
+	if m.App.Payments != nil {
+		fmt.Fprintf(&body, "Pay online at\n%s%s\n\n", m.App.BaseURL, paymentURL(res))
+	}
+	fmt.Fprintf(&body, "Download your confirmation and invoice at\n%s%s\n", m.App.BaseURL, confirmationURL(res))
+	fmt.Fprintf(&body, "\nChange or cancel your reservation at\n%s%s\n", m.App.BaseURL, m.manageURL(res))
+	fmt.Fprintf(&body, "%s\n", m.cancellationPolicy())
+
+	err := m.App.Mailer.Send(r.Context(), mailer.Message{
+		To:      res.Email,
+		Subject: "Reservation confirmation " + res.Reference,
+		Body:    body.String(),
+	})
+	if err != nil {
+		m.App.Logger.Error("cannot email reservation confirmation", "request_id", helpers.RequestID(r),
+			"reservation_id", res.ID, "error", err)
+		return
+	}
+	m.App.Logger.Info("reservation confirmation emailed", "request_id", helpers.RequestID(r), "reservation_id", res.ID)
+}
+
+// writeConfirmation answers with the confirmation and invoice of a reservation as a PDF
+func (m *Repository) writeConfirmation(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	inv := m.invoice(res)
+
+	var buf bytes.Buffer
+	if err := invoice.Write(&buf, inv); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": inv.FileName()}))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "no-store")
+	buf.WriteTo(w)
+}
+
+// ReservationConfirmation serves the confirmation and invoice of a reservation
+// to the guest, who has its reference
+func (m *Repository) ReservationConfirmation(w http.ResponseWriter, r *http.Request) {
+	reference := chi.URLParam(r, "reference")
+	if len(reference) != 10 {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByReference(reference)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.writeConfirmation(w, r, res)
+}
+
+// AdminReservationConfirmation generates the confirmation and invoice of a
+// reservation again for staff
+func (m *Repository) AdminReservationConfirmation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if res.Reference == "" {
+		m.App.Session.Put(r.Context(), "error", "This reservation was made before references were given and has no confirmation")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%s/%d/show", chi.URLParam(r, "src"), id), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Logger.Info("reservation confirmation generated", "request_id", helpers.RequestID(r), "reservation_id", id,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.writeConfirmation(w, r, res)
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/mailer"
+)
+
+// sentMail records the messages sent instead of sending them
+type sentMail []mailer.Message
+
+func (s *sentMail) Send(ctx context.Context, msg mailer.Message) error {
+	*s = append(*s, msg)
+	return nil
+}
+
+func TestReservationConfirmation(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	var sent sentMail
+	Repo.App.Mailer = &sent
+	Repo.App.BaseURL = "https://bookings.example.com"
+	defer func() { Repo.App.Mailer = nil }()
+
+	jar, _ := cookiejar.New(nil)
+	guest := &http.Client{Transport: ts.Client().Transport, Jar: jar}
+	resp, err := guest.PostForm(ts.URL+"/make-reservation", url.Values{
+		"first_name": {"Jane"},
+		"last_name":  {"Guest"},
+		"email":      {"jane@example.com"},
+		"start_date": {"2051-03-01"},
+		"end_date":   {"2051-03-04"},
+		"room_id":    {"2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	// The summary and the email both link to the confirmation
+	m := regexp.MustCompile(`href="(/reservation/([A-Z0-9]{10})/confirmation\.pdf)"`).FindSubmatch(page)
+	if resp.StatusCode != http.StatusOK || m == nil {
+		t.Fatalf("expected the summary with a link to the confirmation, got status %d", resp.StatusCode)
+	}
+	link, reference := string(m[1]), string(m[2])
+	// 3 nights at 650.00 and 5% VAT
+	if !strings.Contains(string(page), "AED 2,047.50") {
+		t.Error("expected the summary to show the total")
+	}
+	if len(sent) != 1 || sent[0].To != "jane@example.com" || !strings.Contains(sent[0].Body, "https://bookings.example.com"+link) {
+		t.Fatalf("expected the guest to be emailed the link, got %+v", sent)
+	}
+
+	resp, _ = ts.Client().Get(ts.URL + link)
+	pdf, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" ||
+		!bytes.Contains(pdf, []byte("/Title (Reservation "+reference+")")) {
+		t.Errorf("expected the confirmation PDF, got status %d", resp.StatusCode)
+	}
+	if d := resp.Header.Get("Content-Disposition"); !strings.Contains(d, "reservation-"+reference+".pdf") {
+		t.Errorf("unexpected Content-Disposition %q", d)
+	}
+
+	resp, _ = ts.Client().Get(ts.URL + "/reservation/AAAAAAAAAA/confirmation.pdf")
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown reference, got status %d", resp.StatusCode)
+	}
+
+	// Staff generate it again from the reservation
+	res, err := Repo.DB.GetReservationByReference(reference)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/admin/reservations/all/%d/show", res.ID))
+	page, _ = io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), fmt.Sprintf("/admin/reservations/all/%d/confirmation.pdf", res.ID)) {
+		t.Error("expected the reservation page to link to the confirmation")
+	}
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/admin/reservations/all/%d/confirmation.pdf", res.ID))
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pdf" {
+		t.Errorf("expected staff to get the confirmation PDF, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// submitter returns the logged in user, who submits the records they enter
+// for review. The repository creates the review along with the record.
+func (m *Repository) submitter(r *http.Request) int {
+	return m.App.Session.GetInt(r.Context(), "user_id")
+}
+
+// logSubmitted logs a record the logged in user just entered and submitted for review
+func (m *Repository) logSubmitted(r *http.Request, customerID int, recordType string, recordID int) {
+	m.App.Logger.Info("record submitted for review", "request_id", helpers.RequestID(r),
+		"customer_id", customerID, "record_type", recordType, "record_id", recordID, "user_id", m.submitter(r))
+}
+
+// reviewRecord loads the record a review is about, for the reviewer to check
+func (m *Repository) reviewRecord(rv models.KYCReview) (interface{}, error) {
+	switch rv.RecordType {
+	case models.RecordCustomer:
+		return m.DB.GetCustomerByID(rv.CustomerID)
+	case models.RecordTradeLicense:
+		return m.DB.GetTradeLicenseByLicenseID(rv.RecordID)
+	case models.RecordPartner:
+		partners, err := m.DB.GetTradeShareInforByID(rv.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range partners {
+			if p.ShareHolderID == rv.RecordID {
+				return p, nil
+			}
+		}
+	case models.RecordMemorandum:
+		representatives, err := m.DB.GetMemorandumInforByID(rv.CustomerID)
+		if err != nil {
+			return nil, err
+		}
+		for _, rep := range representatives {
+			if rep.MemorandumID == rv.RecordID {
+				return rep, nil
+			}
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+// reviewID reads the review ID of an admin review route
+func reviewID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+// renderReview shows a review with its record and history
+func (m *Repository) renderReview(w http.ResponseWriter, r *http.Request, rv models.KYCReview, form *forms.Form) {
+	record, err := m.reviewRecord(rv)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	history, err := m.DB.GetKYCReviewHistory(rv.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	user, err := m.DB.GetUserByID(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["review"] = rv
+	data["record"] = record
+	data["history"] = history
+	data["canDecide"] = rv.State == models.ReviewPending && user.IsReviewer() && rv.SubmittedBy != userID
+	data["ownRecord"] = rv.SubmittedBy == userID
+	data["isReviewer"] = user.IsReviewer()
+
+	render.Templates(w, r, "admin-review.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminShowReview shows a customer record under KYC review, with the form to
+// approve or reject it
+func (m *Repository) AdminShowReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+
+	rv, err := m.DB.GetKYCReviewByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.renderReview(w, r, rv, forms.New(nil))
+}
+
+// AdminPostReview approves or rejects a pending record. Only users with the
+// reviewer role can decide, and never on records they entered themselves.
+// Rejections need a comment telling the maker what is wrong.
+func (m *Repository) AdminPostReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	rv, err := m.DB.GetKYCReviewByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	user, err := m.DB.GetUserByID(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	back := fmt.Sprintf("/admin/reviews/%d", id)
+
+	switch {
+	case !user.IsReviewer():
+		m.App.Session.Put(r.Context(), "error", "Only reviewers can approve or reject records")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	case rv.SubmittedBy == userID:
+		m.App.Session.Put(r.Context(), "error", "You entered this record, another reviewer has to check it")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	var state string
+	form := forms.New(r.PostForm)
+	switch form.Get("action") {
+	case "approve":
+		state = models.ReviewApproved
+	case "reject":
+		state = models.ReviewRejected
+		form.Required("comment")
+	default:
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if !form.Valid() {
+		m.renderReview(w, r, rv, form)
+		return
+	}
+
+	err = m.DB.UpdateKYCReviewState(models.KYCReviewEvent{
+		ReviewID:  id,
+		FromState: models.ReviewPending,
+		ToState:   state,
+		UserID:    userID,
+		Comment:   form.Get("comment"),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", "This record was already reviewed")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("record reviewed", "request_id", helpers.RequestID(r), "review_id", id,
+		"customer_id", rv.CustomerID, "record_type", rv.RecordType, "state", state, "user_id", userID)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s of %s %s", rv.RecordLabel(), rv.CustomerName, state))
+	http.Redirect(w, r, "/admin/dashboard", http.StatusSeeOther)
+}
+
+// AdminPostResubmitReview puts a rejected record back in the review queue once
+// the maker has fixed it. Whoever resubmits becomes the record's submitter, so
+// they can't approve it either.
+func (m *Repository) AdminPostResubmitReview(w http.ResponseWriter, r *http.Request) {
+	id, ok := reviewID(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	rv, err := m.DB.GetKYCReviewByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := m.submitter(r)
+	back := fmt.Sprintf("/admin/reviews/%d", id)
+	err = m.DB.UpdateKYCReviewState(models.KYCReviewEvent{
+		ReviewID:  id,
+		FromState: models.ReviewRejected,
+		ToState:   models.ReviewPending,
+		UserID:    userID,
+		Comment:   r.Form.Get("comment"),
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", "Only rejected records can be resubmitted")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("record resubmitted for review", "request_id", helpers.RequestID(r), "review_id", id,
+		"customer_id", rv.CustomerID, "record_type", rv.RecordType, "user_id", userID)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("%s of %s resubmitted for review", rv.RecordLabel(), rv.CustomerName))
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
@@ -0,0 +1,177 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+// login returns a client logged in as the user with email, which doesn't follow redirects
+func login(t *testing.T, ts *httptest.Server, email string) *http.Client {
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Transport: ts.Client().Transport,
+		Jar:       jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.PostForm(ts.URL+"/user/login", url.Values{
+		"email":    {email},
+		"password": {dbrepo.DemoUserPassword},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Header.Get("Location") != "/" {
+		t.Fatalf("expected %s to be logged in, got redirected to %s", email, resp.Header.Get("Location"))
+	}
+	return client
+}
+
+func TestReview(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	maker := login(t, ts, dbrepo.DemoUserEmail)
+	resp := postWith(t, maker, ts.URL+"/customer/add", map[string]string{
+		"customerCode":  "KYC01",
+		"customerName":  "Four Eyes Trading",
+		"contactPerson": "Test Person",
+		"contactNo":     "041234567",
+		"mobileNo":      "0501234567",
+		"email":         "kyc@example.com",
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the customer to be added, got status %d", resp.StatusCode)
+	}
+	var customerID int
+	fmt.Sscanf(resp.Header.Get("Location"), "/customer/onboard/%d", &customerID)
+
+	reviews, _ := Repo.DB.GetKYCReviewsByCustomerID(customerID)
+	if len(reviews) != 1 || reviews[0].State != models.ReviewPending || reviews[0].RecordType != models.RecordCustomer {
+		t.Fatalf("expected the customer to be pending review, got %+v", reviews)
+	}
+	rv := reviews[0]
+	reviewURL := fmt.Sprintf("%s/admin/reviews/%d", ts.URL, rv.ID)
+
+	resp, _ = maker.Get(ts.URL + "/admin/dashboard")
+	page, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), "Four Eyes Trading") {
+		t.Error("expected the customer in the review queue")
+	}
+
+	// The maker can't review their own record, nor can anonymous users
+	resp, _ = maker.PostForm(reviewURL, url.Values{"action": {"approve"}})
+	if resp.Header.Get("Location") != "/admin/reviews/"+fmt.Sprint(rv.ID) {
+		t.Errorf("expected the maker's approval to be refused, got %s", resp.Header.Get("Location"))
+	}
+	ts.Client().PostForm(reviewURL, url.Values{"action": {"approve"}})
+	if rv, _ := Repo.DB.GetKYCReviewByID(rv.ID); rv.State != models.ReviewPending {
+		t.Fatalf("expected the record to stay pending, got %s", rv.State)
+	}
+
+	// Rejecting needs a comment
+	checker := login(t, ts, dbrepo.DemoReviewerEmail)
+	resp, _ = checker.PostForm(reviewURL, url.Values{"action": {"reject"}})
+	page, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "This field cannot be blank") {
+		t.Errorf("expected a rejection without comment to be refused, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = checker.PostForm(reviewURL, url.Values{"action": {"approve"}, "comment": {"Checked against the license"}})
+	if resp.Header.Get("Location") != "/admin/dashboard" {
+		t.Fatalf("expected the record to be approved, got %d %s", resp.StatusCode, resp.Header.Get("Location"))
+	}
+	rv, _ = Repo.DB.GetKYCReviewByID(rv.ID)
+	if rv.State != models.ReviewApproved || rv.Reviewer.Email != dbrepo.DemoReviewerEmail || rv.Comment != "Checked against the license" {
+		t.Errorf("unexpected review after approval %+v", rv)
+	}
+
+	// A decided record can't be decided again
+	checker.PostForm(reviewURL, url.Values{"action": {"reject"}, "comment": {"Changed my mind"}})
+	if rv, _ := Repo.DB.GetKYCReviewByID(rv.ID); rv.State != models.ReviewApproved {
+		t.Errorf("expected the approval to stand, got %s", rv.State)
+	}
+
+	history, _ := Repo.DB.GetKYCReviewHistory(rv.ID)
+	if len(history) != 2 || history[0].ToState != models.ReviewPending || history[1].ToState != models.ReviewApproved ||
+		history[1].User.Email != dbrepo.DemoReviewerEmail {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	resp, _ = checker.Get(reviewURL)
+	page, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Checked against the license") {
+		t.Errorf("expected the review page to show the history, got status %d", resp.StatusCode)
+	}
+}
+
+func TestReview_Resubmit(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	maker := login(t, ts, dbrepo.DemoUserEmail)
+	checker := login(t, ts, dbrepo.DemoReviewerEmail)
+	customerID, _ := Repo.DB.InsertCustomer(models.Customer{CustomerCode: "KYC02", CustomerName: "Second Look Trading"}, 0)
+	Repo.DB.InsertTradeLicense(models.TradeLicense{CustomerId: customerID, TradeLicenseNo: "111111"}, 0)
+	Repo.DB.InsertTradeLicense(models.TradeLicense{CustomerId: customerID, TradeLicenseNo: "222222"}, 0)
+
+	reviews, _ := Repo.DB.GetKYCReviewsByCustomerID(customerID)
+	if len(reviews) != 3 {
+		t.Fatalf("expected every record to be submitted for review, got %+v", reviews)
+	}
+	rv := reviews[2]
+	reviewURL := fmt.Sprintf("%s/admin/reviews/%d", ts.URL, rv.ID)
+
+	// The reviewer sees the license under review, not the customer's first one
+	resp, _ := checker.Get(reviewURL)
+	page, _ := io.ReadAll(resp.Body)
+	if !strings.Contains(string(page), "222222") || strings.Contains(string(page), "111111") {
+		t.Error("expected the review page to show the license under review")
+	}
+
+	// Only rejected records can be resubmitted
+	resp, _ = maker.PostForm(reviewURL+"/resubmit", nil)
+	if resp.Header.Get("Location") != "/admin/reviews/"+fmt.Sprint(rv.ID) {
+		t.Errorf("expected a redirect to the review, got %s", resp.Header.Get("Location"))
+	}
+	if rv, _ := Repo.DB.GetKYCReviewByID(rv.ID); rv.State != models.ReviewPending || rv.SubmittedBy != 0 {
+		t.Fatalf("expected the pending record to be left alone, got %+v", rv)
+	}
+
+	checker.PostForm(reviewURL, url.Values{"action": {"reject"}, "comment": {"Copy missing"}})
+	resp, _ = maker.PostForm(reviewURL+"/resubmit", url.Values{"comment": {"Copy uploaded"}})
+	if resp.Header.Get("Location") != "/admin/reviews/"+fmt.Sprint(rv.ID) {
+		t.Errorf("expected a redirect to the review, got %s", resp.Header.Get("Location"))
+	}
+	rv, _ = Repo.DB.GetKYCReviewByID(rv.ID)
+	if rv.State != models.ReviewPending || rv.Submitter.Email != dbrepo.DemoUserEmail || rv.Comment != "Copy uploaded" {
+		t.Fatalf("expected the record to be pending again, got %+v", rv)
+	}
+
+	// The reviewer decides again, and who resubmitted can't
+	resp, _ = maker.PostForm(reviewURL, url.Values{"action": {"approve"}})
+	if rv, _ := Repo.DB.GetKYCReviewByID(rv.ID); rv.State != models.ReviewPending {
+		t.Errorf("expected the maker's approval to be refused, got %s", rv.State)
+	}
+	checker.PostForm(reviewURL, url.Values{"action": {"approve"}})
+	if rv, _ := Repo.DB.GetKYCReviewByID(rv.ID); rv.State != models.ReviewApproved {
+		t.Errorf("expected the record to be approved, got %s", rv.State)
+	}
+
+	history, _ := Repo.DB.GetKYCReviewHistory(rv.ID)
+	if len(history) != 4 || history[2].FromState != models.ReviewRejected || history[2].ToState != models.ReviewPending {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
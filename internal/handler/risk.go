@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/risk"
+)
+
+// assessRisk scores a customer with the current rules and stores the result
+func (m *Repository) assessRisk(r *http.Request, customerID int) (models.RiskAssessment, error) {
+	rec, err := m.loadOnboarding(customerID)
+	if err != nil {
+		return models.RiskAssessment{}, err
+	}
+
+	a := m.Risk.Assess(risk.Profile{
+		Customer:     rec.Customer,
+		TradeLicense: rec.TradeLicense,
+		Partners:     rec.Partners,
+		Memorandum:   rec.Memorandum,
+	}, time.Now())
+
+	previous, err := m.DB.GetRiskAssessment(customerID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return a, err
+	}
+	if err := m.DB.SaveRiskAssessment(a); err != nil {
+		return a, err
+	}
+
+	if previous.Rating != a.Rating {
+		m.App.Logger.Info("customer risk rating changed", "request_id", helpers.RequestID(r),
+			"customer_id", customerID, "from", previous.Rating, "to", a.Rating, "score", a.Score)
+	}
+	return a, nil
+}
+
+// refreshRisk reassesses a customer after their records changed. The change is
+// already saved, so failures are logged rather than reported to the user.
+func (m *Repository) refreshRisk(r *http.Request, customerID int) {
+	if _, err := m.assessRisk(r, customerID); err != nil {
+		m.App.Logger.Error("cannot assess customer risk", "request_id", helpers.RequestID(r),
+			"customer_id", customerID, "error", err)
+	}
+}
+
+// currentRisk returns the stored risk rating of a customer, reassessing it when
+// there is none yet or it was made on an earlier day, since documents expire
+func (m *Repository) currentRisk(r *http.Request, customerID int) (models.RiskAssessment, error) {
+	a, err := m.DB.GetRiskAssessment(customerID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return a, err
+	}
+
+	y, mo, d := time.Now().Date()
+	if err != nil || a.AssessedAt.Before(time.Date(y, mo, d, 0, 0, 0, 0, time.Local)) {
+		return m.assessRisk(r, customerID)
+	}
+	return a, nil
+}
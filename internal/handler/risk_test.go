@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+func TestRisk(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	resp := postFields(t, ts, "/customer/add", map[string]string{
+		"customerCode":     "RISK01",
+		"customerName":     "Golden Sands Trading",
+		"contactPerson":    "Test Person",
+		"contactNo":        "041234567",
+		"mobileNo":         "0501234567",
+		"email":            "risk@example.com",
+		"natureOfBusiness": "Wholesale of gold and jewellery",
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the customer to be added, got status %d", resp.StatusCode)
+	}
+	var id int
+	fmt.Sscanf(resp.Header.Get("Location"), "/customer/onboard/%d", &id)
+
+	a, err := Repo.DB.GetRiskAssessment(id)
+	if err != nil || a.Rating != models.RiskMedium || len(a.Factors) != 1 || a.Factors[0].Rule != "cash_intensive_business" {
+		t.Fatalf("expected the new customer to be rated medium risk, got %+v (%v)", a, err)
+	}
+
+	// Adding a shareholder from a listed country raises the rating
+	resp = postFields(t, ts, fmt.Sprintf("/customer/add-partner/%d", id), map[string]string{
+		"shareHolderName":        "Listed Person",
+		"shareHolderNationality": "Myanmar",
+		"shEmirateID":            "784-1980-1234567-8",
+		"shPassport":             "MA1234567",
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the partner to be added, got status %d", resp.StatusCode)
+	}
+	if a, _ := Repo.DB.GetRiskAssessment(id); a.Rating != models.RiskHigh || a.Score != 90 {
+		t.Errorf("expected the customer to be reassessed as high risk, got %+v", a)
+	}
+
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/customer/details/%d", id))
+	page, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Listed Person (Myanmar)") {
+		t.Errorf("expected the details page to show the risk breakdown, got status %d", resp.StatusCode)
+	}
+}
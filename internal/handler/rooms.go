@@ -0,0 +1,430 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+	"github.com/go-chi/chi"
+)
+
+// slugPattern matches the slugs of room pages
+var slugPattern = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// slugSeparators matches what slugify turns into a hyphen
+var slugSeparators = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify makes the slug of a room page from the room's name
+func slugify(name string) string {
+	name = strings.ReplaceAll(strings.ToLower(name), "'", "")
+	return strings.Trim(slugSeparators.ReplaceAllString(name, "-"), "-")
+}
+
+// ShowRoom shows the public page of a room, with its photos, amenities and rate
+func (m *Repository) ShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	render.Templates(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// ShowRoomPhoto serves a photo of a room
+func (m *Repository) ShowRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	p, err := m.DB.GetRoomPhotoByID(id)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && p.RoomID != room.ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	f, err := os.Open(p.FilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			helpers.ClientError(w, r, http.StatusNotFound)
+			return
+		}
+		helpers.ServerError(w, r, err)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", p.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	http.ServeContent(w, r, "", p.CreatedAt, f)
+}
+
+// requireAdmin answers 403 Forbidden unless the logged in user is an administrator
+func (m *Repository) requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	user, err := m.DB.GetUserByID(m.App.Session.GetInt(r.Context(), "user_id"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return false
+	}
+	if !user.IsAdmin() {
+		helpers.ClientError(w, r, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// adminRoom loads the room of an admin room route, answering 404 itself when there is none
+func (m *Repository) adminRoom(w http.ResponseWriter, r *http.Request) (models.Room, bool) {
+	if !m.requireAdmin(w, r) {
+		return models.Room{}, false
+	}
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Room{}, false
+	}
+	room, err := m.DB.GetRoomByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return room, false
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return room, false
+	}
+	return room, true
+}
+
+// AdminRooms lists the rooms in the admin tool
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	render.Templates(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// renderRoom shows the form of a room, which is new when its ID is 0, with the
+// external calendars of rooms that exist
+func (m *Repository) renderRoom(w http.ResponseWriter, r *http.Request, room models.Room, form *forms.Form) {
+	if !form.Has("roomName") && room.ID != 0 {
+		form.Set("roomName", room.RoomName)
+		form.Set("slug", room.Slug)
+		form.Set("description", room.Description)
+		form.Set("capacity", strconv.Itoa(room.Capacity))
+		form.Set("nightlyRate", room.NightlyRate.String())
+		if room.WeekendRate != 0 {
+			form.Set("weekendRate", room.WeekendRate.String())
+		}
+		form.Set("minNights", strconv.Itoa(room.MinNights))
+		form.Set("amenities", strings.Join(room.Amenities, "\n"))
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	if room.ID != 0 {
+		calendars, err := m.DB.RoomCalendars(room.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		data["calendars"] = calendars
+		data["feedURL"] = m.App.BaseURL + calendarFeedURL(room)
+	}
+	render.Templates(w, r, "admin-room.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// AdminNewRoom shows the form to add a room
+func (m *Repository) AdminNewRoom(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	form := forms.New(url.Values{})
+	form.Set("capacity", "2")
+	form.Set("minNights", "1")
+	m.renderRoom(w, r, models.Room{}, form)
+}
+
+// AdminShowRoom shows the form to change a room, with its photos
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	m.renderRoom(w, r, room, forms.New(url.Values{}))
+}
+
+// bindRoom reads the posted room form into room, reporting whether it is valid.
+// The slug is made from the name when it is left blank.
+func (m *Repository) bindRoom(form *forms.Form, room *models.Room) (bool, error) {
+	form.Bind(room)
+
+	if room.Slug == "" {
+		room.Slug = slugify(room.RoomName)
+		form.Set("slug", room.Slug)
+	}
+	if room.Slug != "" && !slugPattern.MatchString(room.Slug) {
+		form.Errors.Add("slug", "Use lowercase letters, digits and dashes, like garden-room")
+	} else if room.Slug != "" {
+		other, err := m.DB.GetRoomBySlug(room.Slug)
+		switch {
+		case err == nil && other.ID != room.ID:
+			form.Errors.Add("slug", "Another room already has this address")
+		case err != nil && !errors.Is(err, sql.ErrNoRows):
+			return false, err
+		}
+	}
+
+	form.Required("nightlyRate")
+	if form.Has("nightlyRate") {
+		rate, err := models.ParseMoney(form.Get("nightlyRate"))
+		if err != nil {
+			form.Errors.Add("nightlyRate", "Enter an amount in dirhams, like 450.00")
+		}
+		room.NightlyRate = rate
+	}
+
+	room.WeekendRate = moneyField(form, "weekendRate")
+	room.MinNights = 1
+	if form.Has("minNights") {
+		room.MinNights, _ = form.IntRange("minNights", 1, 365)
+	}
+
+	room.Amenities = nil
+	for _, a := range strings.Split(form.Get("amenities"), "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			room.Amenities = append(room.Amenities, a)
+		}
+	}
+	return form.Valid(), nil
+}
+
+// AdminPostNewRoom adds a room
+func (m *Repository) AdminPostNewRoom(w http.ResponseWriter, r *http.Request) {
+	if !m.requireAdmin(w, r) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	var room models.Room
+	form := forms.New(r.PostForm)
+	valid, err := m.bindRoom(form, &room)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !valid {
+		m.renderRoom(w, r, models.Room{}, form)
+		return
+	}
+
+	id, err := m.DB.InsertRoom(room)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("room added", "request_id", helpers.RequestID(r), "room_id", id, "slug", room.Slug,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Room added, you can upload its photos now")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", id), http.StatusSeeOther)
+}
+
+// AdminPostRoom saves the changes to a room
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	changed := room
+	form := forms.New(r.PostForm)
+	valid, err := m.bindRoom(form, &changed)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !valid {
+		m.renderRoom(w, r, room, form)
+		return
+	}
+
+	if err := m.DB.UpdateRoom(changed); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("room changed", "request_id", helpers.RequestID(r), "room_id", room.ID, "slug", changed.Slug,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room that was never reserved, with its photos
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+
+	err := m.DB.DeleteRoom(room.ID)
+	if errors.Is(err, repository.ErrRoomInUse) {
+		m.App.Session.Put(r.Context(), "error", "This room has reservations or blocked dates and can't be deleted")
+		http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	for _, p := range room.Photos {
+		if err := os.Remove(p.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			m.App.Logger.Warn("cannot remove room photo", "request_id", helpers.RequestID(r), "path", p.FilePath, "error", err)
+		}
+	}
+
+	m.App.Logger.Info("room deleted", "request_id", helpers.RequestID(r), "room_id", room.ID, "slug", room.Slug,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", room.RoomName+" deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminPostRoomPhoto adds a JPEG or PNG photo to a room
+func (m *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok || !m.parseUploadForm(w, r) {
+		return
+	}
+	back := fmt.Sprintf("/admin/rooms/%d", room.ID)
+
+	files := r.MultipartForm.File["photo"]
+	if len(files) != 1 {
+		m.App.Session.Put(r.Context(), "error", "Choose one photo to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	u, err := m.Uploads.Check(r.Context(), 0, "photo", files[0])
+	if err == nil && !strings.HasPrefix(u.ContentType, "image/") {
+		err = uploads.ErrUnsupportedType
+	}
+	if err != nil {
+		if !uploads.IsRejected(err) {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "error", "The photo can't be uploaded: "+err.Error())
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	path, err := m.Uploads.SaveRoomPhoto(room.ID, u)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	id, err := m.DB.InsertRoomPhoto(models.RoomPhoto{
+		RoomID:      room.ID,
+		FilePath:    path,
+		ContentType: u.ContentType,
+		Caption:     strings.TrimSpace(r.PostForm.Get("caption")),
+	})
+	if err != nil {
+		os.Remove(path)
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("room photo added", "request_id", helpers.RequestID(r), "room_id", room.ID, "photo_id", id,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Photo added")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto removes a photo from a room
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	room, ok := m.adminRoom(w, r)
+	if !ok {
+		return
+	}
+	photoID, err := strconv.Atoi(chi.URLParam(r, "photoID"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	p, err := m.DB.GetRoomPhotoByID(photoID)
+	if errors.Is(err, sql.ErrNoRows) || err == nil && p.RoomID != room.ID {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if err := m.DB.DeleteRoomPhoto(p.ID); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if err := os.Remove(p.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		m.App.Logger.Warn("cannot remove room photo", "request_id", helpers.RequestID(r), "path", p.FilePath, "error", err)
+	}
+
+	m.App.Logger.Info("room photo deleted", "request_id", helpers.RequestID(r), "room_id", room.ID, "photo_id", p.ID,
+		"user_id", m.App.Session.GetInt(r.Context(), "user_id"))
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d", room.ID), http.StatusSeeOther)
+}
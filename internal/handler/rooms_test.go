@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestRooms(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	// Only administrators manage rooms
+	reviewer := login(t, ts, dbrepo.DemoReviewerEmail)
+	resp, _ := reviewer.Get(ts.URL + "/admin/rooms")
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a reviewer to be refused, got status %d", resp.StatusCode)
+	}
+
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	resp, _ = admin.PostForm(ts.URL+"/admin/rooms/new", url.Values{
+		"roomName":    {"Colonel's Loft"},
+		"capacity":    {"3"},
+		"nightlyRate": {"abc"},
+	})
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Enter an amount in dirhams") {
+		t.Fatalf("expected the form again for a wrong rate, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = admin.PostForm(ts.URL+"/admin/rooms/new", url.Values{
+		"roomName":    {"Colonel's Loft"},
+		"description": {"A bright loft under the roof."},
+		"capacity":    {"3"},
+		"nightlyRate": {"720.50"},
+		"amenities":   {"Sea view\n\nKing bed\n"},
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the room to be added, got status %d", resp.StatusCode)
+	}
+	var id int
+	fmt.Sscanf(resp.Header.Get("Location"), "/admin/rooms/%d", &id)
+	room, err := Repo.DB.GetRoomBySlug("colonels-loft")
+	if err != nil || room.ID != id || room.NightlyRate != 72050 || room.Capacity != 3 ||
+		len(room.Amenities) != 2 || room.Amenities[1] != "King bed" {
+		t.Fatalf("unexpected room %+v, %v", room, err)
+	}
+
+	// Slugs are unique
+	resp, _ = admin.PostForm(ts.URL+fmt.Sprintf("/admin/rooms/%d", id), url.Values{
+		"roomName":    {"Colonel's Loft"},
+		"slug":        {"majors-suite"},
+		"capacity":    {"3"},
+		"nightlyRate": {"720.50"},
+	})
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Another room already has this address") {
+		t.Errorf("expected a taken slug to be refused, got status %d", resp.StatusCode)
+	}
+
+	// Photos are uploaded, shown on the public page and served
+	var img, body bytes.Buffer
+	png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 320, 240)))
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("caption", "The loft at dusk")
+	fw, _ := mw.CreateFormFile("photo", "loft.png")
+	fw.Write(img.Bytes())
+	mw.Close()
+	resp, _ = admin.Post(ts.URL+fmt.Sprintf("/admin/rooms/%d/photos", id), mw.FormDataContentType(), &body)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the photo to be added, got status %d", resp.StatusCode)
+	}
+	room, _ = Repo.DB.GetRoomByID(id)
+	if len(room.Photos) != 1 || room.Photos[0].Caption != "The loft at dusk" {
+		t.Fatalf("expected one photo, got %+v", room.Photos)
+	}
+	photo := room.Photos[0]
+
+	resp, _ = ts.Client().Get(ts.URL + "/rooms/colonels-loft")
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{"Colonel&#39;s Loft", "AED 720.50", "Sea view", fmt.Sprintf("/rooms/colonels-loft/photos/%d", photo.ID)} {
+		if !strings.Contains(string(page), want) {
+			t.Errorf("expected the room page to show %q", want)
+		}
+	}
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/rooms/colonels-loft/photos/%d", photo.ID))
+	served, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" || !bytes.Equal(served, img.Bytes()) {
+		t.Errorf("expected the photo, got status %d", resp.StatusCode)
+	}
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/rooms/generals-quarters/photos/%d", photo.ID))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for a photo of another room, got status %d", resp.StatusCode)
+	}
+
+	resp, _ = admin.Post(ts.URL+fmt.Sprintf("/admin/rooms/%d/photos/%d/delete", id, photo.ID), "", nil)
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the photo to be deleted, got status %d", resp.StatusCode)
+	}
+	resp, _ = ts.Client().Get(ts.URL + fmt.Sprintf("/rooms/colonels-loft/photos/%d", photo.ID))
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected a deleted photo to be gone, got status %d", resp.StatusCode)
+	}
+
+	// Rooms with blocked dates are kept
+	start := time.Date(2052, 1, 10, 0, 0, 0, 0, time.UTC)
+	err = Repo.DB.InsertRoomRestriction(models.RoomRestriction{StartDate: start, EndDate: start.AddDate(0, 0, 2), RoomID: id, RestrictionID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, _ = admin.Post(ts.URL+fmt.Sprintf("/admin/rooms/%d/delete", id), "", nil)
+	if _, err := Repo.DB.GetRoomByID(id); resp.StatusCode != http.StatusSeeOther || err != nil {
+		t.Errorf("expected a room in use to be kept, got status %d, %v", resp.StatusCode, err)
+	}
+
+	resp, _ = admin.PostForm(ts.URL+"/admin/rooms/new", url.Values{
+		"roomName":    {"Spare Room"},
+		"capacity":    {"1"},
+		"nightlyRate": {"100"},
+	})
+	fmt.Sscanf(resp.Header.Get("Location"), "/admin/rooms/%d", &id)
+	resp, _ = admin.Post(ts.URL+fmt.Sprintf("/admin/rooms/%d/delete", id), "", nil)
+	if _, err := Repo.DB.GetRoomByID(id); resp.StatusCode != http.StatusSeeOther || err == nil {
+		t.Errorf("expected the room to be deleted, got status %d", resp.StatusCode)
+	}
+
+	// The old room pages redirect to the new ones
+	client := ts.Client()
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, _ = client.Get(ts.URL + "/generals")
+	if resp.StatusCode != http.StatusMovedPermanently || resp.Header.Get("Location") != "/rooms/generals-quarters" {
+		t.Errorf("expected /generals to redirect, got status %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/render"
+	"github.com/chamrasilva89/reservationWeb/internal/sanctions"
+	"github.com/go-chi/chi"
+)
+
+// sanctionsThreshold returns the lowest name match score reported as a hit
+func (m *Repository) sanctionsThreshold() float64 {
+	if m.App.SanctionsThreshold > 0 {
+		return m.App.SanctionsThreshold
+	}
+	return sanctions.DefaultThreshold
+}
+
+// screener returns the screener over the imported lists, which are loaded
+// once and again by the nightly screening
+func (m *Repository) screener() (*sanctions.Screener, error) {
+	return m.Sanctions.Get(m.DB, m.sanctionsThreshold())
+}
+
+// screenSubject checks a shareholder or representative who was just entered
+// against the sanctions lists and records the hits for review. It returns the
+// number of new hits. The record is already saved, so failures are logged
+// rather than reported to the user.
+func (m *Repository) screenSubject(r *http.Request, sub models.ScreeningSubject) int {
+	s, err := m.screener()
+	if err != nil {
+		m.App.Logger.Error("cannot load sanctions lists", "request_id", helpers.RequestID(r), "error", err)
+		return 0
+	}
+
+	found := m.recordHits(r, s, sub)
+	if found > 0 {
+		m.App.Session.Put(r.Context(), "warning",
+			fmt.Sprintf("%s is a possible match on a sanctions list and was sent for review", sub.Name))
+	}
+	return found
+}
+
+// recordHits records the hits of a subject against the screener's lists and
+// returns the number of new ones, logging the failures
+func (m *Repository) recordHits(r *http.Request, s *sanctions.Screener, sub models.ScreeningSubject) int {
+	found := 0
+	for _, h := range s.Hits(sub) {
+		id, err := m.DB.InsertSanctionsHit(h)
+		if err != nil {
+			m.App.Logger.Error("cannot record sanctions hit", "request_id", helpers.RequestID(r),
+				"customer_id", sub.CustomerID, "subject_type", sub.SubjectType, "subject_id", sub.SubjectID, "error", err)
+			continue
+		}
+		if id == 0 {
+			continue
+		}
+		found++
+		m.App.Logger.Warn("possible sanctions match", "request_id", helpers.RequestID(r), "hit_id", id,
+			"customer_id", sub.CustomerID, "subject_type", sub.SubjectType, "subject_id", sub.SubjectID,
+			"source", h.Source, "external_id", h.ExternalID, "score", h.Score)
+	}
+	return found
+}
+
+// renderSanctionsHits shows the hits in a state with the imported lists
+func (m *Repository) renderSanctionsHits(w http.ResponseWriter, r *http.Request, state string, form *forms.Form) {
+	hits, err := m.DB.SanctionsHits(state)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	lists, err := m.DB.SanctionsLists()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	user, err := m.DB.GetUserByID(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["hits"] = hits
+	data["lists"] = lists
+	data["state"] = state
+	data["states"] = []string{models.HitPending, models.HitConfirmed, models.HitDismissed}
+	data["isReviewer"] = user.IsReviewer()
+
+	render.Templates(w, r, "admin-sanctions.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: form,
+	})
+}
+
+// hitsState reads the state filter of the hits screen, pending by default and
+// "all" for every state
+func hitsState(r *http.Request) (string, bool) {
+	switch state := r.URL.Query().Get("state"); state {
+	case "":
+		return models.HitPending, true
+	case "all":
+		return "", true
+	case models.HitPending, models.HitConfirmed, models.HitDismissed:
+		return state, true
+	default:
+		return "", false
+	}
+}
+
+// AdminSanctionsHits shows the names that matched a sanctions list, for a
+// reviewer to confirm or dismiss
+func (m *Repository) AdminSanctionsHits(w http.ResponseWriter, r *http.Request) {
+	state, ok := hitsState(r)
+	if !ok {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	m.renderSanctionsHits(w, r, state, forms.New(nil))
+}
+
+// AdminPostSanctionsHit confirms a hit as a true match or dismisses it as a
+// false positive. Only reviewers can decide, and either way they must say why.
+func (m *Repository) AdminPostSanctionsHit(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil || id < 1 {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	h, err := m.DB.GetSanctionsHitByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	userID := m.App.Session.GetInt(r.Context(), "user_id")
+	user, err := m.DB.GetUserByID(userID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if !user.IsReviewer() {
+		m.App.Session.Put(r.Context(), "error", "Only reviewers can confirm or dismiss sanctions hits")
+		http.Redirect(w, r, "/admin/sanctions", http.StatusSeeOther)
+		return
+	}
+
+	var state string
+	form := forms.New(r.PostForm)
+	switch form.Get("action") {
+	case "confirm":
+		state = models.HitConfirmed
+	case "dismiss":
+		state = models.HitDismissed
+	default:
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	form.Required("comment")
+	if !form.Valid() {
+		m.App.Session.Put(r.Context(), "error", "Say why the hit on "+h.Name+" is "+state)
+		http.Redirect(w, r, "/admin/sanctions", http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateSanctionsHitState(id, state, userID, form.Get("comment"))
+	if errors.Is(err, sql.ErrNoRows) {
+		m.App.Session.Put(r.Context(), "warning", "This hit was already reviewed")
+		http.Redirect(w, r, "/admin/sanctions", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	m.App.Logger.Info("sanctions hit reviewed", "request_id", helpers.RequestID(r), "hit_id", id,
+		"customer_id", h.CustomerID, "source", h.Source, "external_id", h.ExternalID, "state", state, "user_id", userID)
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Hit on %s %s", h.Name, state))
+	http.Redirect(w, r, "/admin/sanctions", http.StatusSeeOther)
+}
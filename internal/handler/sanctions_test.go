@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestSanctionsScreening(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	err := Repo.DB.ReplaceSanctionsEntries(models.SanctionsUN, []models.SanctionsEntry{
+		{ExternalID: "QDi.900", Name: "MUHAMMAD HUSAYN AL-RASHID", EntryType: "individual", Program: "Al-Qaida"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Repo.Sanctions.Reload(Repo.DB, Repo.sanctionsThreshold()); err != nil {
+		t.Fatal(err)
+	}
+
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	resp := postWith(t, admin, ts.URL+"/customer/add", map[string]string{
+		"customerCode":  "SAN01",
+		"customerName":  "Screened Trading",
+		"contactPerson": "Test Person",
+		"contactNo":     "041234567",
+		"mobileNo":      "0501234567",
+		"email":         "sanctions@example.com",
+	})
+	if resp.StatusCode != http.StatusSeeOther {
+		t.Fatalf("expected the customer to be added, got status %d", resp.StatusCode)
+	}
+	var id int
+	fmt.Sscanf(resp.Header.Get("Location"), "/customer/onboard/%d", &id)
+
+	// A transliteration of a listed name is a hit, an unrelated name is not
+	for _, name := range []string{"Mohammed Hussein Al Rashid", "Fatima Noor"} {
+		resp = postWith(t, admin, ts.URL+fmt.Sprintf("/customer/add-partner/%d", id), map[string]string{
+			"shareHolderName":        name,
+			"shareHolderNationality": "Jordan",
+			"shEmirateID":            "784-1980-1234567-8",
+			"shPassport":             "JA1234567",
+		})
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected partner %s to be added, got status %d", name, resp.StatusCode)
+		}
+	}
+	hits, err := Repo.DB.GetSanctionsHitsByCustomerID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hits) != 1 || hits[0].Name != "Mohammed Hussein Al Rashid" || hits[0].ExternalID != "QDi.900" ||
+		hits[0].State != models.HitPending {
+		t.Fatalf("expected one pending hit on the listed shareholder, got %+v", hits)
+	}
+	hit := hits[0]
+
+	resp, _ = admin.Get(ts.URL + "/admin/sanctions")
+	page, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "MUHAMMAD HUSAYN AL-RASHID") {
+		t.Errorf("expected the hits screen to list the hit, got status %d", resp.StatusCode)
+	}
+	resp, _ = admin.Get(ts.URL + "/admin/sanctions?state=unknown")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown state to be rejected, got status %d", resp.StatusCode)
+	}
+
+	post := func(action, comment string) {
+		t.Helper()
+		resp, err := admin.PostForm(ts.URL+fmt.Sprintf("/admin/sanctions/%d", hit.ID), url.Values{
+			"action":  {action},
+			"comment": {comment},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusSeeOther {
+			t.Fatalf("expected a redirect, got status %d", resp.StatusCode)
+		}
+	}
+
+	// Dismissing needs a reason
+	post("dismiss", "")
+	if h, _ := Repo.DB.GetSanctionsHitByID(hit.ID); h.State != models.HitPending {
+		t.Errorf("expected the hit to stay pending without a comment, got %s", h.State)
+	}
+
+	post("dismiss", "Different date of birth and nationality")
+	h, _ := Repo.DB.GetSanctionsHitByID(hit.ID)
+	if h.State != models.HitDismissed || h.Comment != "Different date of birth and nationality" || h.Reviewer.Email != dbrepo.DemoUserEmail {
+		t.Errorf("expected the hit to be dismissed by the admin, got %+v", h)
+	}
+
+	// A decided hit can't be decided again
+	post("confirm", "Changed my mind")
+	if h, _ := Repo.DB.GetSanctionsHitByID(hit.ID); h.State != models.HitDismissed {
+		t.Errorf("expected the hit to stay dismissed, got %s", h.State)
+	}
+
+	resp, _ = admin.Get(ts.URL + fmt.Sprintf("/customer/details/%d", id))
+	page, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(page), "Sanctions screening") {
+		t.Errorf("expected the details page to show the screening, got status %d", resp.StatusCode)
+	}
+}
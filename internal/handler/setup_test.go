@@ -4,6 +4,7 @@ import (
 	"encoding/gob"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -13,6 +14,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/helpers"
 	"github.com/chamrasilva89/reservationWeb/internal/models"
 	"github.com/chamrasilva89/reservationWeb/internal/render"
 	"github.com/go-chi/chi"
@@ -23,18 +25,23 @@ import (
 var app config.AppConfig
 var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
-var functions = template.FuncMap{}
+var functions = template.FuncMap{
+	"humanDate":  render.HumanDate,
+	"formatDate": render.FormatDate,
+	"iterate":    render.Iterate,
+	"add":        render.Add,
+	"stateLabel": models.StateLabel,
+}
 
 func getRoutes() http.Handler {
 	//what i am going to put in session
 	gob.Register(models.Reservation{})
+	gob.Register(models.Customer{})
+	gob.Register(models.TradeLicenseHolder{})
+	gob.Register(models.Memorandum{})
 
 	app.InProduction = false
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-
-	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = errorLog
+	app.Logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
 
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -43,6 +50,8 @@ func getRoutes() http.Handler {
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
+	app.UploadPath = filepath.Join(os.TempDir(), "reservationWeb-test-uploads")
+	app.LinkSecret = []byte("test link secret")
 	var tc map[string]*template.Template
 	tc, err := CreateTestTemplateCache()
 	if err != nil {
@@ -50,31 +59,107 @@ func getRoutes() http.Handler {
 	}
 	app.TemplateCache = tc
 	app.UseCache = true
-	repo := NewRepo(&app)
+	repo, err := NewMemoryRepo(&app)
+	if err != nil {
+		log.Fatal("Cannot create in-memory repository")
+	}
 	NewHandlers(repo)
 
 	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
 	//mux.Use(NoSurf)
 	mux.Use(SessionLoad)
+	mux.Get("/healthz", Repo.Healthz)
+	mux.Get("/readyz", Repo.Readyz)
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals", Repo.Generals)
-	mux.Get("/majors", Repo.Majors)
+	mux.Get("/rooms/{slug}", Repo.ShowRoom)
+	mux.Get("/rooms/{slug}/photos/{id}", Repo.ShowRoomPhoto)
+	mux.Get("/rooms/{slug}/calendar.ics", Repo.RoomCalendarFeed)
+	mux.Handle("/generals", http.RedirectHandler("/rooms/generals-quarters", http.StatusMovedPermanently))
+	mux.Handle("/majors", http.RedirectHandler("/rooms/majors-suite", http.StatusMovedPermanently))
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/search-availability", Repo.Availability)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/reservation/{reference}/confirmation.pdf", Repo.ReservationConfirmation)
+	mux.Get("/reservation/{reference}/pay", Repo.ReservationPayment)
+	mux.Post("/payments/webhook", Repo.PaymentWebhook)
+	mux.Get("/reservation/find", Repo.FindReservation)
+	mux.Post("/reservation/find", Repo.PostFindReservation)
+	mux.Get("/reservation/{reference}/manage", Repo.ManageReservation)
+	mux.Post("/reservation/{reference}/manage/dates", Repo.PostChangeReservationDates)
+	mux.Post("/reservation/{reference}/manage/cancel", Repo.PostCancelReservation)
 
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Post("/search-availability", Repo.PostAvailability)
+	mux.Get("/choose-room/{id}", Repo.ChooseRoom)
 	mux.Post("/search-availability-g", Repo.AvailabilityJSON)
 
+	mux.Get("/customer/add", Repo.AddCustomer)
+	mux.Get("/customer/all", Repo.AllCustomers)
+	mux.Get("/customer/export", Repo.ShowExport)
+	mux.Get("/customer/export/{dataset}", Repo.ExportCustomerData)
+	mux.Get("/customer/import", Repo.ShowImport)
+	mux.Post("/customer/import", Repo.PostImport)
+	mux.Get("/customer/import/{id}", Repo.ShowCustomerImport)
+	mux.Post("/customer/import/{id}/mapping", Repo.PostCustomerImportMapping)
+	mux.Post("/customer/import/{id}/commit", Repo.PostCommitCustomerImport)
+	mux.Get("/customer/details/{id}", Repo.ShowCustomerDetails)
+	mux.Get("/customer/{id}/dossier.pdf", Repo.CustomerDossier)
+	mux.Post("/customer/add", Repo.PostCustomer)
+	mux.Get("/customer/trade-license/{id}", Repo.ShowCustomerTradeLicense)
+	mux.Post("/customer/trade-license/{id}", Repo.PostTradeLicense)
+	mux.Get("/customer/add-partner/{id}", Repo.AddPartner)
+	mux.Post("/customer/add-partner/{id}", Repo.PostPartner)
+	mux.Get("/customer/add-memorandum/{id}", Repo.AddMemorandum)
+	mux.Post("/customer/add-memorandum/{id}", Repo.PostRepresentative)
+	mux.Get("/customer/onboard/{id}", Repo.ShowOnboarding)
+	mux.Post("/customer/onboard/{id}/draft", Repo.PostOnboardingDraft)
+	mux.Post("/customer/onboard/{id}/signed-memorandum", Repo.PostSignedMemorandum)
+	mux.Post("/customer/onboard/{id}/activate", Repo.PostActivateCustomer)
+	mux.Get("/customer/files/{id}", Repo.ShowFile)
+	mux.Get("/customer/files/{id}/thumb", Repo.ShowFileThumb)
+	mux.Post("/customer/extract", Repo.PostExtractDocument)
+
+	mux.Get("/user/login", Repo.ShowLogin)
+	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/admin/dashboard", Repo.AdminDashboard)
+	mux.Get("/admin/reviews/{id}", Repo.AdminShowReview)
+	mux.Post("/admin/reviews/{id}", Repo.AdminPostReview)
+	mux.Post("/admin/reviews/{id}/resubmit", Repo.AdminPostResubmitReview)
+	mux.Get("/admin/sanctions", Repo.AdminSanctionsHits)
+	mux.Post("/admin/sanctions/{id}", Repo.AdminPostSanctionsHit)
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/new", Repo.AdminNewRoom)
+	mux.Post("/admin/rooms/new", Repo.AdminPostNewRoom)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostRoom)
+	mux.Post("/admin/rooms/{id}/delete", Repo.AdminDeleteRoom)
+	mux.Post("/admin/rooms/{id}/photos", Repo.AdminPostRoomPhoto)
+	mux.Post("/admin/rooms/{id}/photos/{photoID}/delete", Repo.AdminDeleteRoomPhoto)
+	mux.Post("/admin/rooms/{id}/calendars", Repo.AdminPostRoomCalendar)
+	mux.Post("/admin/rooms/{id}/calendars/import", Repo.AdminImportRoomCalendars)
+	mux.Post("/admin/rooms/{id}/calendars/{calendarID}/delete", Repo.AdminDeleteRoomCalendar)
+	mux.Get("/admin/pricing", Repo.AdminPricing)
+	mux.Post("/admin/pricing/periods", Repo.AdminPostRatePeriod)
+	mux.Post("/admin/pricing/periods/{id}/delete", Repo.AdminDeleteRatePeriod)
+	mux.Post("/admin/pricing/discounts", Repo.AdminPostDiscountCode)
+	mux.Post("/admin/pricing/discounts/{id}/active", Repo.AdminPostDiscountCodeActive)
+	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
+	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
+	mux.Get("/admin/reservations/{src}/{id}/show", Repo.AdminShowReservation)
+	mux.Post("/admin/reservations/{src}/{id}/state", Repo.AdminPostReservationState)
+	mux.Get("/admin/reservations/{src}/{id}/confirmation.pdf", Repo.AdminReservationConfirmation)
+	mux.Post("/admin/reservations/{src}/{id}/capture", Repo.AdminCaptureReservation)
+	mux.Post("/admin/reservations/{src}/{id}/refund", Repo.AdminRefundReservation)
+
 	fileServer := http.FileServer(http.Dir("./static"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
 
@@ -108,7 +193,7 @@ func CreateTestTemplateCache() (map[string]*template.Template, error) {
 
 	for _, page := range pages {
 		name := filepath.Base(page)
-		ts, err := template.New(name).ParseFiles(page)
+		ts, err := template.New(name).Funcs(functions).ParseFiles(page)
 		if err != nil {
 			return myCache, err
 		}
@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestReservationStates(t *testing.T) {
+	routes := getRoutes()
+	ts := httptest.NewTLSServer(routes)
+	defer ts.Close()
+
+	m := manageLink.FindStringSubmatch(book(t, ts, "1", "2051-05-01", "2051-05-03"))
+	if m == nil {
+		t.Fatal("expected the summary to link to the guest portal")
+	}
+	res, err := Repo.DB.GetReservationByReference(m[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.State != models.ReservationPending {
+		t.Fatalf("expected a new reservation to be pending, got %s", res.State)
+	}
+	show := fmt.Sprintf("/admin/reservations/all/%d/show", res.ID)
+	state := fmt.Sprintf("/admin/reservations/all/%d/state", res.ID)
+
+	admin := login(t, ts, dbrepo.DemoUserEmail)
+	mark := func(to, comment string) string {
+		resp, err := admin.PostForm(ts.URL+state, url.Values{"state": {to}, "comment": {comment}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusSeeOther || resp.Header.Get("Location") != show {
+			t.Fatalf("expected to be sent back to the reservation, got status %d", resp.StatusCode)
+		}
+		res, _ := Repo.DB.GetReservationByID(res.ID)
+		return res.State
+	}
+
+	// Guests can't be checked in before confirming, nor before they arrive
+	if s := mark(models.ReservationCheckedIn, ""); s != models.ReservationPending {
+		t.Errorf("expected a pending reservation not to be checked in, got %s", s)
+	}
+	if s := mark(models.ReservationConfirmed, "Called the guest"); s != models.ReservationConfirmed {
+		t.Fatalf("expected the reservation to be confirmed, got %s", s)
+	}
+	if s := mark(models.ReservationCheckedIn, ""); s != models.ReservationConfirmed {
+		t.Errorf("expected a future reservation not to be checked in, got %s", s)
+	}
+
+	// The list filters by state
+	resp, _ := admin.Get(ts.URL + "/admin/reservations-all?state=confirmed")
+	page, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), show) {
+		t.Error("expected the confirmed reservation in the confirmed list")
+	}
+	resp, _ = admin.Get(ts.URL + "/admin/reservations-all?state=pending")
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(page), show) {
+		t.Error("expected the confirmed reservation not to be in the pending list")
+	}
+	resp, _ = admin.Get(ts.URL + "/admin/reservations-all?state=lost")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an unknown state to be refused, got status %d", resp.StatusCode)
+	}
+
+	// Cancelling frees the room and the history records who did what
+	if s := mark(models.ReservationCancelled, "Guest phoned"); s != models.ReservationCancelled {
+		t.Fatalf("expected the reservation to be cancelled, got %s", s)
+	}
+	if free, _ := Repo.DB.SearchAvailabilityByDatesByRoomID(res.StartDate, res.EndDate, 1); !free {
+		t.Error("expected the room of the cancelled reservation to be freed")
+	}
+	if s := mark(models.ReservationConfirmed, ""); s != models.ReservationCancelled {
+		t.Errorf("expected a cancelled reservation to stay cancelled, got %s", s)
+	}
+
+	history, err := Repo.DB.GetReservationHistory(res.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{models.ReservationPending, models.ReservationConfirmed, models.ReservationCancelled}
+	if len(history) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), history)
+	}
+	for i, e := range history {
+		if e.ToState != want[i] {
+			t.Errorf("change %d: expected %s, got %s", i, want[i], e.ToState)
+		}
+	}
+	if history[0].ActorName() != "Guest" || history[1].UserID == 0 || history[1].Comment != "Called the guest" {
+		t.Errorf("expected the guest then staff as actors, got %+v", history)
+	}
+	resp, _ = admin.Get(ts.URL + show)
+	page, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !strings.Contains(string(page), "Guest phoned") {
+		t.Error("expected the reservation page to show the history")
+	}
+}
+
+func TestReservationCheckTransition(t *testing.T) {
+	arrival := time.Date(2051, 5, 1, 0, 0, 0, 0, time.UTC)
+	var tests = []struct {
+		from string
+		to   string
+		now  time.Time
+		ok   bool
+	}{
+		{models.ReservationPending, models.ReservationConfirmed, arrival.AddDate(0, 0, -10), true},
+		{models.ReservationPending, models.ReservationCheckedIn, arrival, false},
+		{models.ReservationConfirmed, models.ReservationCheckedIn, arrival.Add(-time.Hour), false},
+		{models.ReservationConfirmed, models.ReservationCheckedIn, arrival.Add(9 * time.Hour), true},
+		{models.ReservationConfirmed, models.ReservationNoShow, arrival.AddDate(0, 0, 1), true},
+		{models.ReservationCheckedIn, models.ReservationCheckedOut, arrival.AddDate(0, 0, 2), true},
+		{models.ReservationCheckedIn, models.ReservationCancelled, arrival, false},
+		{models.ReservationCheckedOut, models.ReservationCheckedIn, arrival, false},
+		{models.ReservationNoShow, models.ReservationConfirmed, arrival, false},
+	}
+
+	for _, e := range tests {
+		res := models.Reservation{State: e.from, StartDate: arrival}
+		err := res.CheckTransition(e.to, e.now)
+		if (err == nil) != e.ok {
+			t.Errorf("%s to %s at %s: expected allowed %v, got %v", e.from, e.to, e.now, e.ok, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"runtime/debug"
@@ -10,19 +11,53 @@ import (
 
 var app *config.AppConfig
 
+// contextKey is the type of the keys this package stores in request contexts
+type contextKey string
+
+const requestIDKey contextKey = "request_id"
+
 func NewHelpers(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("CLient error with status of", status)
+// WithRequestID returns a copy of ctx carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the ID assigned to the request by the request ID middleware, if any
+func RequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.Logger.Info("client error",
+		"request_id", RequestID(r),
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", status,
+	)
 	http.Error(w, http.StatusText(status), status)
 }
 
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Printf("Server Error: %+v\nTrace:\n%s", err, trace)
-	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+// ServerError logs the error with a stack trace and sends a 500 response that
+// includes the request ID, so users can quote it when reporting the problem
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	id := RequestID(r)
+	app.Logger.Error("server error",
+		"request_id", id,
+		"method", r.Method,
+		"path", r.URL.Path,
+		"error", err.Error(),
+		"trace", string(debug.Stack()),
+	)
+
+	msg := http.StatusText(http.StatusInternalServerError)
+	if id != "" {
+		msg = fmt.Sprintf("%s\nRequest ID: %s", msg, id)
+	}
+	http.Error(w, msg, http.StatusInternalServerError)
 }
 
 func IsAuthenticated(r *http.Request) bool {
@@ -0,0 +1,231 @@
+// Package ical writes and reads the iCalendar (RFC 5545) feeds booking sites
+// use to share the availability of a room. Only the all-day events that block
+// dates are supported, which is what these feeds contain.
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// dateLayout is the layout of iCalendar DATE values
+const dateLayout = "20060102"
+
+// maxLine is the length in octets at which content lines are folded
+const maxLine = 75
+
+// ErrNotCalendar is returned when reading something that isn't an iCalendar
+// file, such as the error page of a booking site
+var ErrNotCalendar = errors.New("not an iCalendar file")
+
+// Event is an all-day event, blocking the nights from Start to the night
+// before End
+type Event struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time
+}
+
+// Calendar is a feed of events
+type Calendar struct {
+	ProdID string // identifies the application that made the feed
+	Name   string
+	Stamp  time.Time // when the feed was made, now when zero
+	Events []Event
+}
+
+// Write writes the calendar to w as an iCalendar file
+func Write(w io.Writer, c Calendar) error {
+	stamp := c.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	bw := bufio.NewWriter(w)
+	line := func(name, value string) {
+		writeLine(bw, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", c.ProdID)
+	line("CALSCALE", "GREGORIAN")
+	line("METHOD", "PUBLISH")
+	if c.Name != "" {
+		line("X-WR-CALNAME", escape(c.Name))
+	}
+	for _, e := range c.Events {
+		line("BEGIN", "VEVENT")
+		line("UID", escape(e.UID))
+		line("DTSTAMP", stamp.UTC().Format("20060102T150405Z"))
+		line("DTSTART;VALUE=DATE", e.Start.Format(dateLayout))
+		line("DTEND;VALUE=DATE", e.End.Format(dateLayout))
+		line("SUMMARY", escape(e.Summary))
+		line("TRANSP", "OPAQUE")
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return bw.Flush()
+}
+
+// writeLine writes a content line, folding it into lines of at most maxLine
+// octets without splitting UTF-8 characters
+func writeLine(w *bufio.Writer, s string) {
+	limit := maxLine
+	for len(s) > limit {
+		i := limit
+		for i > 0 && !utf8Start(s[i]) {
+			i--
+		}
+		w.WriteString(s[:i])
+		w.WriteString("\r\n ")
+		s = s[i:]
+		// The leading space of the continuation counts
+		limit = maxLine - 1
+	}
+	w.WriteString(s)
+	w.WriteString("\r\n")
+}
+
+// utf8Start reports whether b starts a UTF-8 character
+func utf8Start(b byte) bool {
+	return b&0xC0 != 0x80
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// escape escapes a TEXT value
+func escape(s string) string {
+	return escaper.Replace(s)
+}
+
+var unescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+
+// property is a content line split into its name and value. Parameters are
+// dropped, none changes the meaning of what is read.
+type property struct {
+	name  string
+	value string
+}
+
+// parseLine splits an unfolded content line
+func parseLine(s string) (property, bool) {
+	// The value starts at the first colon outside of a quoted parameter value
+	quoted := false
+	colon := -1
+	for i := 0; i < len(s) && colon < 0; i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				colon = i
+			}
+		}
+	}
+	if colon < 0 {
+		return property{}, false
+	}
+
+	name, _, _ := strings.Cut(s[:colon], ";")
+	return property{name: strings.ToUpper(name), value: s[colon+1:]}, true
+}
+
+// parseDate reads the day of a DATE or DATE-TIME value. Times are dropped:
+// booking sites give the arrival and departure days, sometimes with the
+// check-in and check-out times.
+func parseDate(value string) (time.Time, error) {
+	if len(value) < len(dateLayout) {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	d, err := time.Parse(dateLayout, value[:len(dateLayout)])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q", value)
+	}
+	return d, nil
+}
+
+// Parse reads the events of an iCalendar file. Cancelled events are left out.
+// Events without an end last one day, and those ending on the day they start
+// block that night.
+func Parse(r io.Reader) ([]Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, ErrNotCalendar
+	}
+
+	var events []Event
+	var e *Event
+	cancelled := false
+	for n, s := range lines {
+		p, ok := parseLine(s)
+		if !ok {
+			continue
+		}
+		switch {
+		case p.name == "BEGIN" && strings.EqualFold(p.value, "VEVENT"):
+			e, cancelled = &Event{}, false
+		case e == nil:
+			// Properties of the calendar or of other components
+		case p.name == "END" && strings.EqualFold(p.value, "VEVENT"):
+			if e.Start.IsZero() {
+				return nil, fmt.Errorf("event ending on line %d has no start", n+1)
+			}
+			if !e.End.After(e.Start) {
+				e.End = e.Start.AddDate(0, 0, 1)
+			}
+			if !cancelled {
+				events = append(events, *e)
+			}
+			e = nil
+		case p.name == "UID":
+			e.UID = unescaper.Replace(p.value)
+		case p.name == "SUMMARY":
+			e.Summary = unescaper.Replace(p.value)
+		case p.name == "STATUS":
+			cancelled = strings.EqualFold(p.value, "CANCELLED")
+		case p.name == "DTSTART" || p.name == "DTEND":
+			d, err := parseDate(p.value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n+1, err)
+			}
+			if p.name == "DTSTART" {
+				e.Start = d
+			} else {
+				e.End = d
+			}
+		}
+	}
+	if e != nil {
+		return nil, errors.New("the last event is not ended")
+	}
+	return events, nil
+}
+
+// unfold reads the content lines of r, joining folded lines
+func unfold(r io.Reader) ([]string, error) {
+	var lines []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	for sc.Scan() {
+		s := strings.TrimRight(sc.Text(), "\r")
+		if len(lines) == 0 {
+			s = strings.TrimPrefix(s, "\ufeff")
+		}
+		switch {
+		case s == "":
+		case (s[0] == ' ' || s[0] == '\t') && len(lines) > 0:
+			lines[len(lines)-1] += s[1:]
+		default:
+			lines = append(lines, s)
+		}
+	}
+	return lines, sc.Err()
+}
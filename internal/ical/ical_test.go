@@ -0,0 +1,269 @@
+package ical
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestWriteParse(t *testing.T) {
+	events := []Event{
+		{UID: "1@example.com", Summary: "Reserved", Start: date(2051, 3, 1), End: date(2051, 3, 4)},
+		{UID: "2@example.com", Summary: "Owner; family, visiting\nfrom Al Ain – " + strings.Repeat("é", 60),
+			Start: date(2051, 3, 10), End: date(2051, 3, 11)},
+	}
+
+	var buf bytes.Buffer
+	err := Write(&buf, Calendar{ProdID: "-//reservationWeb//EN", Name: "Major's Suite", Stamp: date(2051, 1, 1), Events: events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSuffix(buf.String(), "\r\n"), "\r\n") {
+		if len(line) > maxLine {
+			t.Errorf("expected lines folded at %d octets, got %d: %q", maxLine, len(line), line)
+		}
+	}
+	if !strings.Contains(buf.String(), "DTSTART;VALUE=DATE:20510301\r\nDTEND;VALUE=DATE:20510304\r\n") {
+		t.Errorf("expected all-day dates, got\n%s", buf.String())
+	}
+
+	got, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(events) {
+		t.Fatalf("expected %d events, got %+v", len(events), got)
+	}
+	for i := range events {
+		if got[i] != events[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, events[i], got[i])
+		}
+	}
+}
+
+// bookingSite is a feed like those of booking sites, with date-times, folded
+// lines, cancelled and open ended events
+const bookingSite = "\ufeffBEGIN:VCALENDAR\r\n" +
+	"PRODID:-//Booking Site//Hosting Calendar//EN\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VTIMEZONE\r\nTZID:Asia/Dubai\r\nBEGIN:STANDARD\r\nDTSTART:19700101T000000\r\nTZOFFSETFROM:+0400\r\nTZOFFSETTO:+0400\r\nEND:STANDARD\r\nEND:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20510305\r\nDTEND;VALUE=DATE:20510308\r\nUID:abc-1@booking\r\nSUMMARY:Reserved\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART;TZID=Asia/Dubai:20510310T150000\r\nDTEND;TZID=\"Asia/Dubai\":20510312T110000\r\nUID:abc-2@booking\r\n" +
+	"SUMMARY:Not available - guest\r\n  with a long name\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART:20510315T120000Z\r\nDTEND:20510316T100000Z\r\nUID:abc-3@booking\r\nSTATUS:CANCELLED\r\nEND:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\nDTSTART;VALUE=DATE:20510320\r\nUID:abc-4@booking\r\nEND:VEVENT\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestParse(t *testing.T) {
+	events, err := Parse(strings.NewReader(bookingSite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Event{
+		{UID: "abc-1@booking", Summary: "Reserved", Start: date(2051, 3, 5), End: date(2051, 3, 8)},
+		{UID: "abc-2@booking", Summary: "Not available - guest with a long name", Start: date(2051, 3, 10), End: date(2051, 3, 12)},
+		{UID: "abc-4@booking", Start: date(2051, 3, 20), End: date(2051, 3, 21)},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("expected %d events, got %+v", len(want), events)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Errorf("event %d: expected %+v, got %+v", i, want[i], events[i])
+		}
+	}
+
+	bad := []struct {
+		name string
+		cal  string
+	}{
+		{"html", "<!DOCTYPE html><html><body>Sign in</body></html>"},
+		{"empty", ""},
+		{"no start", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nUID:x\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"bad date", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:2051-03-01\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"truncated", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nDTSTART:20510301\r\n"},
+	}
+	for _, tt := range bad {
+		if _, err := Parse(strings.NewReader(tt.cal)); err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+	if _, err := Parse(strings.NewReader("<html>")); !errors.Is(err, ErrNotCalendar) {
+		t.Errorf("expected ErrNotCalendar, got %v", err)
+	}
+}
+
+func TestImportAll(t *testing.T) {
+	db, err := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Room 2 is reserved over the first event of the feed
+	err = db.InsertRoomRestriction(models.RoomRestriction{StartDate: date(2051, 3, 6), EndDate: date(2051, 3, 9),
+		RoomID: 2, ReservationID: 7, RestrictionID: models.RestrictionReservation})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var feed atomic.Value
+	feed.Store(bookingSite)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/feed.ics" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar")
+		w.Write([]byte(feed.Load().(string)))
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	src := Sources{Client: ts.Client(), Dir: dir}
+	file := filepath.Join(dir, "owner.ics")
+	owner := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:20000101\nDTEND;VALUE=DATE:20000105\nEND:VEVENT\n" +
+		"BEGIN:VEVENT\nDTSTART;VALUE=DATE:20510401\nDTEND;VALUE=DATE:20510403\nEND:VEVENT\nEND:VCALENDAR\n"
+	if err := os.WriteFile(file, []byte(owner), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	site, _ := db.InsertRoomCalendar(models.RoomCalendar{RoomID: 2, Name: "Booking site", Source: ts.URL + "/feed.ics"})
+	files, _ := db.InsertRoomCalendar(models.RoomCalendar{RoomID: 1, Name: "Owner", Source: file})
+	broken, _ := db.InsertRoomCalendar(models.RoomCalendar{RoomID: 1, Name: "Broken", Source: ts.URL + "/gone.ics"})
+	// Too short to hold the webcal scheme
+	short, _ := db.InsertRoomCalendar(models.RoomCalendar{RoomID: 1, Name: "Short", Source: "http://a"})
+
+	imported, failed, conflicts, err := ImportAll(context.Background(), db, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported != 2 || failed != 2 {
+		t.Errorf("expected 2 calendars imported and 2 failed, got %d and %d", imported, failed)
+	}
+	if len(conflicts) != 1 || conflicts[0].CalendarID != site || conflicts[0].ReservationID != 7 ||
+		conflicts[0].Event.UID != "abc-1@booking" {
+		t.Fatalf("expected the first event to conflict with the reservation, got %+v", conflicts)
+	}
+
+	if free, _ := db.SearchAvailabilityByDatesByRoomID(date(2051, 3, 10), date(2051, 3, 11), 2); free {
+		t.Error("expected the booking site's dates to be blocked")
+	}
+	if free, _ := db.SearchAvailabilityByDatesByRoomID(date(2051, 3, 15), date(2051, 3, 16), 2); !free {
+		t.Error("expected the cancelled event not to block its dates")
+	}
+	if free, _ := db.SearchAvailabilityByDatesByRoomID(date(2051, 4, 2), date(2051, 4, 3), 1); free {
+		t.Error("expected the file's dates to be blocked")
+	}
+	restrictions, _ := db.RoomRestrictions(1, date(1999, 1, 1))
+	if len(restrictions) != 1 || restrictions[0].RestrictionID != models.RestrictionExternal || restrictions[0].CalendarID != files {
+		t.Errorf("expected only the future event of the file as an external restriction, got %+v", restrictions)
+	}
+
+	c, _ := db.GetRoomCalendar(broken)
+	if !strings.Contains(c.LastError, "404") || !c.LastImportedAt.IsZero() {
+		t.Errorf("expected the broken calendar to record the error, got %+v", c)
+	}
+	if c, _ := db.GetRoomCalendar(short); c.LastError == "" {
+		t.Errorf("expected the short URL to fail, got %+v", c)
+	}
+	c, _ = db.GetRoomCalendar(site)
+	if c.LastError != "" || c.Blocks != 3 || len(c.Conflicts) != 1 {
+		t.Errorf("expected the booking site imported with its conflict, got %+v", c)
+	}
+
+	// Importing again replaces the dates, and a failed import keeps them
+	feed.Store(strings.NewReplacer("20510305", "20510301", "20510308", "20510303").Replace(bookingSite))
+	if _, _, conflicts, _ = ImportAll(context.Background(), db, src); len(conflicts) != 0 {
+		t.Errorf("expected the moved event not to conflict, got %+v", conflicts)
+	}
+	if free, _ := db.SearchAvailabilityByDatesByRoomID(date(2051, 3, 1), date(2051, 3, 3), 2); free {
+		t.Error("expected the moved event to block its new dates")
+	}
+	feed.Store("<html>maintenance</html>")
+	ImportAll(context.Background(), db, src)
+	if free, _ := db.SearchAvailabilityByDatesByRoomID(date(2051, 3, 1), date(2051, 3, 3), 2); free {
+		t.Error("expected a failed import to keep the dates blocked")
+	}
+	if c, _ := db.GetRoomCalendar(site); c.LastError != ErrNotCalendar.Error() || len(c.Conflicts) != 0 {
+		t.Errorf("expected the failed import to be recorded, got %+v", c)
+	}
+
+	// Deleting a calendar frees its dates
+	if err := db.DeleteRoomCalendar(site); err != nil {
+		t.Fatal(err)
+	}
+	if free, _ := db.SearchAvailabilityByDatesByRoomID(date(2051, 3, 1), date(2051, 3, 3), 2); !free {
+		t.Error("expected the dates of the deleted calendar to be freed")
+	}
+}
+
+func TestSources(t *testing.T) {
+	dir := t.TempDir()
+	cal := "BEGIN:VCALENDAR\nBEGIN:VEVENT\nDTSTART;VALUE=DATE:20510401\nEND:VEVENT\nEND:VCALENDAR\n"
+	if err := os.WriteFile(filepath.Join(dir, "owner.ics"), []byte(cal), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.ics")
+	if err := os.WriteFile(outside, []byte(cal), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.ics")); err != nil {
+		t.Fatal(err)
+	}
+
+	src := Sources{Dir: dir}
+	for _, source := range []string{"owner.ics", filepath.Join(dir, "owner.ics"), "https://example.com/room.ics", "webcal://example.com/a"} {
+		if err := src.Check(source); err != nil {
+			t.Errorf("expected %s to be accepted, got %v", source, err)
+		}
+	}
+	for _, source := range []string{outside, "../secret.ics", "/etc/passwd", "http://%zz/a", "https:///a"} {
+		if err := src.Check(source); err == nil {
+			t.Errorf("expected %s to be refused", source)
+		}
+	}
+	if err := (Sources{}).Check(filepath.Join(dir, "owner.ics")); err == nil {
+		t.Error("expected files to be refused without a calendar directory")
+	}
+
+	ctx := context.Background()
+	if events, err := src.Read(ctx, "owner.ics"); err != nil || len(events) != 1 {
+		t.Errorf("expected the file to be read, got %+v and %v", events, err)
+	}
+	if _, err := src.Read(ctx, "link.ics"); err == nil {
+		t.Error("expected a link out of the calendar directory to be refused")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(cal))
+	}))
+	defer ts.Close()
+	if _, err := src.Read(ctx, ts.URL); err == nil || !strings.Contains(err.Error(), "public address") {
+		t.Errorf("expected the loopback address to be refused, got %v", err)
+	}
+	for _, ip := range []string{"10.0.0.1", "192.168.1.1", "169.254.169.254", "::1", "fe80::1", "0.0.0.0"} {
+		if err := refusePrivate("tcp", net.JoinHostPort(ip, "80"), nil); err == nil {
+			t.Errorf("expected %s to be refused", ip)
+		}
+	}
+	if err := refusePrivate("tcp", "93.184.215.14:443", nil); err != nil {
+		t.Errorf("expected a public address to be allowed, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package ical
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+)
+
+// MaxSize limits the size of the calendars read, those of booking sites are
+// far smaller
+const MaxSize = 4 << 20
+
+// DefaultClient reads calendars over HTTP when no other client is configured
+var DefaultClient = NewClient(30 * time.Second)
+
+// NewClient returns a client reading calendars over HTTP within timeout. It
+// only connects to public addresses, so that calendars can't be used to reach
+// the services of the server's network, and doesn't go through proxies.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout, Control: refusePrivate}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// refusePrivate refuses connections to loopback, private, link-local and
+// other addresses that aren't on the internet. It runs on the address
+// connected to, after names are resolved and on every redirect.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("calendars can't be read from %s, it isn't a public address", host)
+	}
+	return nil
+}
+
+// IsURL reports whether a calendar source is read over HTTP rather than from
+// a file. webcal: URLs are read with HTTPS.
+func IsURL(source string) bool {
+	for _, scheme := range []string{"http://", "https://", "webcal://"} {
+		if len(source) > len(scheme) && strings.EqualFold(source[:len(scheme)], scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources reads calendars from URLs with Client, and from the files of Dir.
+// Without Dir files can't be read.
+type Sources struct {
+	Client *http.Client
+	Dir    string
+}
+
+// Check returns why source can't be read, or nil. Files are named by their
+// path in Dir or their full path.
+func (s Sources) Check(source string) error {
+	if IsURL(source) {
+		if u, err := url.Parse(source); err != nil || u.Host == "" {
+			return fmt.Errorf("%q is not a valid address", source)
+		}
+		return nil
+	}
+	_, err := s.file(source)
+	return err
+}
+
+// file returns the path of the file source, which must be in Dir
+func (s Sources) file(source string) (string, error) {
+	if s.Dir == "" {
+		return "", errors.New("calendar files can't be imported, only addresses")
+	}
+	dir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return "", err
+	}
+	name := source
+	if !filepath.IsAbs(name) {
+		name = filepath.Join(dir, name)
+	}
+	if rel, err := filepath.Rel(dir, filepath.Clean(name)); err != nil || rel == ".." ||
+		strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("calendar files must be in %s", s.Dir)
+	}
+	return filepath.Clean(name), nil
+}
+
+// open opens the file source, following links only within Dir
+func (s Sources) open(source string) (*os.File, error) {
+	name, err := s.file(source)
+	if err != nil {
+		return nil, err
+	}
+	target, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.EvalSymlinks(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := (Sources{Dir: dir}).file(target); err != nil {
+		return nil, fmt.Errorf("calendar files must be in %s", s.Dir)
+	}
+	return os.Open(target)
+}
+
+// Read reads the events of a calendar from source, an http(s) or webcal URL
+// or a file
+func (s Sources) Read(ctx context.Context, source string) ([]Event, error) {
+	if err := s.Check(source); err != nil {
+		return nil, err
+	}
+
+	var r io.Reader
+	if IsURL(source) {
+		if strings.HasPrefix(strings.ToLower(source), "webcal://") {
+			source = "https://" + source[len("webcal://"):]
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/calendar")
+		client := s.Client
+		if client == nil {
+			client = DefaultClient
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("the calendar answered %s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := s.open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	lr := &io.LimitedReader{R: r, N: MaxSize + 1}
+	events, err := Parse(lr)
+	if err == nil && lr.N == 0 {
+		err = fmt.Errorf("the calendar is larger than %d MB", MaxSize>>20)
+	}
+	return events, err
+}
+
+// ReadError is returned by Import when the calendar can't be read or isn't
+// valid, which the calendar records
+type ReadError struct {
+	Err error
+}
+
+func (e *ReadError) Error() string { return e.Err.Error() }
+
+func (e *ReadError) Unwrap() error { return e.Err }
+
+// Import reads an external calendar and makes its events from today on the
+// external restrictions of its room, returning the events that conflict with
+// the room's reservations and blocks. When the calendar can't be read its
+// room keeps the dates it blocked before, the calendar records the error and
+// a *ReadError is returned.
+func Import(ctx context.Context, db repository.DatabaseRepo, src Sources, c models.RoomCalendar, now time.Time) ([]models.CalendarConflict, error) {
+	events, err := src.Read(ctx, c.Source)
+	if err != nil {
+		if dbErr := db.SetRoomCalendarError(c.ID, err.Error()); dbErr != nil {
+			return nil, dbErr
+		}
+		return nil, &ReadError{Err: err}
+	}
+
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	var blocks []models.CalendarEvent
+	for _, e := range events {
+		if e.End.After(today) {
+			blocks = append(blocks, models.CalendarEvent{UID: e.UID, Summary: e.Summary, StartDate: e.Start, EndDate: e.End})
+		}
+	}
+	return db.ImportRoomCalendar(c.ID, blocks)
+}
+
+// ImportAll imports every external calendar, going on when one can't be
+// read. It returns the number of calendars imported and failed, and the
+// conflicts found.
+func ImportAll(ctx context.Context, db repository.DatabaseRepo, src Sources) (imported, failed int, conflicts []models.CalendarConflict, err error) {
+	calendars, err := db.RoomCalendars(0)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	for _, c := range calendars {
+		if err := ctx.Err(); err != nil {
+			return imported, failed, conflicts, err
+		}
+		found, err := Import(ctx, db, src, c, time.Now())
+		var readErr *ReadError
+		if errors.As(err, &readErr) {
+			failed++
+			continue
+		}
+		if err != nil {
+			return imported, failed, conflicts, err
+		}
+		imported++
+		conflicts = append(conflicts, found...)
+	}
+	return imported, failed, conflicts, nil
+}
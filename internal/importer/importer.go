@@ -0,0 +1,356 @@
+// Package importer checks spreadsheets of customers, trade licenses and
+// shareholders before they are imported in bulk. Rows are validated by binding
+// them into the same models, with the same rules, as the forms staff fill in.
+package importer
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/chamrasilva89/reservationWeb/internal/forms"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// Kinds of records that can be imported
+const (
+	KindCustomers     = "customers"
+	KindTradeLicenses = "trade-licenses"
+	KindShareholders  = "shareholders"
+)
+
+// codeField is the column linking trade licenses and shareholders to the
+// customer they belong to
+const codeField = "customerCode"
+
+// Field is a value of the imported records that a column can be mapped to
+type Field struct {
+	Name     string // form field name, as in the model's form tag
+	Label    string
+	Required bool
+	Date     bool
+	Aliases  []string // other headers the column is often given
+}
+
+// Kind describes one kind of record that can be imported
+type Kind struct {
+	Name   string
+	Title  string
+	Fields []Field
+}
+
+// Kinds lists what can be imported, in the order the upload form offers it
+var Kinds = []Kind{
+	{KindCustomers, "Customers", fields(models.Customer{}, []Field{
+		{Name: "customerCode", Label: "Customer code", Aliases: []string{"code"}},
+		{Name: "customerName", Label: "Customer name", Aliases: []string{"name", "company", "company name"}},
+		{Name: "contactPerson", Label: "Contact person", Aliases: []string{"contact"}},
+		{Name: "contactNo", Label: "Phone", Aliases: []string{"telephone", "tel", "contact no", "phone number"}},
+		{Name: "mobileNo", Label: "Mobile", Aliases: []string{"mobile no", "mobile number", "cell"}},
+		{Name: "email", Label: "Email", Aliases: []string{"email address", "e-mail"}},
+		{Name: "businessName", Label: "Business name", Aliases: []string{"business"}},
+		{Name: "natureOfBusiness", Label: "Nature of business", Aliases: []string{"activity"}},
+		{Name: "locationDetails", Label: "Location", Aliases: []string{"address"}},
+		{Name: "marketedBy", Label: "Marketer code"},
+		{Name: "marketerName", Label: "Marketer name", Aliases: []string{"marketer"}},
+		{Name: "marketerEmail", Label: "Marketer email"},
+	})},
+	{KindTradeLicenses, "Trade licenses", fields(models.TradeLicense{}, []Field{
+		{Name: codeField, Label: "Customer code", Aliases: []string{"code"}},
+		{Name: "emirate", Label: "Emirate", Required: true},
+		{Name: "tradelicenseid", Label: "Trade license number", Aliases: []string{"license number", "license no", "trade license no"}},
+		{Name: "mohreno", Label: "MOHRE number", Aliases: []string{"mohre no", "mohre"}},
+		{Name: "tradeName", Label: "Trade name"},
+		{Name: "legalState", Label: "Legal status", Aliases: []string{"legal state", "legal form"}},
+		{Name: "establishmentDate", Label: "Establishment date", Aliases: []string{"established"}},
+		{Name: "registrationDate", Label: "Registration date", Aliases: []string{"registered"}},
+		{Name: "licenseExpiryDate", Label: "Expiry date", Aliases: []string{"license expiry", "license expiry date", "expiry"}},
+	})},
+	{KindShareholders, "Shareholders", fields(models.TradeLicenseHolder{}, []Field{
+		{Name: codeField, Label: "Customer code", Aliases: []string{"code"}},
+		{Name: "shareHolderName", Label: "Shareholder name", Aliases: []string{"name", "shareholder"}},
+		{Name: "shareHolderRole", Label: "Role", Aliases: []string{"designation"}},
+		{Name: "shareHolderNationality", Label: "Nationality"},
+		{Name: "shEmirateID", Label: "Emirates ID", Aliases: []string{"emirates id number", "eid"}},
+		{Name: "shEmIDExp", Label: "Emirates ID expiry", Aliases: []string{"eid expiry"}},
+		{Name: "shPassport", Label: "Passport", Aliases: []string{"passport number", "passport no"}},
+		{Name: "shPassportExp", Label: "Passport expiry"},
+	})},
+}
+
+// fields completes the fields of a kind from the form and validate tags of
+// its model, so Required and Date follow the forms. The customer code linking
+// records to their customer is always required.
+func fields(model interface{}, fs []Field) []Field {
+	t := reflect.TypeOf(model)
+	for i := range fs {
+		if fs[i].Name == codeField {
+			fs[i].Required = true
+		}
+		for j := 0; j < t.NumField(); j++ {
+			sf := t.Field(j)
+			if sf.Tag.Get("form") != fs[i].Name {
+				continue
+			}
+			for _, rule := range strings.Split(sf.Tag.Get("validate"), ",") {
+				if rule == "required" {
+					fs[i].Required = true
+				}
+			}
+			fs[i].Date = sf.Type == reflect.TypeOf(time.Time{})
+		}
+	}
+	return fs
+}
+
+// Lookup finds a kind by name
+func Lookup(name string) (Kind, bool) {
+	for _, k := range Kinds {
+		if k.Name == name {
+			return k, true
+		}
+	}
+	return Kind{}, false
+}
+
+// Guess maps the fields of a kind to the columns whose headers name them,
+// ignoring case, spaces and punctuation. Fields without such a column are left
+// out of the mapping.
+func (k Kind) Guess(headers []string) map[string]int {
+	mapping := make(map[string]int)
+	used := make(map[int]bool)
+	for _, f := range k.Fields {
+		names := append([]string{f.Name, f.Label}, f.Aliases...)
+	columns:
+		for i, h := range headers {
+			if used[i] {
+				continue
+			}
+			for _, name := range names {
+				if simplify(h) == simplify(name) {
+					mapping[f.Name] = i
+					used[i] = true
+					break columns
+				}
+			}
+		}
+	}
+	return mapping
+}
+
+// Unmapped returns the labels of the required fields that no column is mapped to
+func (k Kind) Unmapped(mapping map[string]int) []string {
+	var labels []string
+	for _, f := range k.Fields {
+		if _, ok := mapping[f.Name]; f.Required && !ok {
+			labels = append(labels, f.Label)
+		}
+	}
+	return labels
+}
+
+// simplify keeps the lower case letters and digits of a header
+func simplify(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// CustomerLookup finds the ID of the customer with a code, reporting whether there is one
+type CustomerLookup func(code string) (int, bool, error)
+
+// Check validates the rows of a table as records of a kind, reading each field
+// from the column mapping says. Blank rows are skipped. Every other row is
+// returned with the record to store or the errors that keep it out. Customer
+// codes must be new for customers and must exist for the other kinds.
+func (k Kind) Check(t Table, mapping map[string]int, customer CustomerLookup) ([]models.ImportRow, error) {
+	var rows []models.ImportRow
+	codes := make(map[string]int) // customer code to the row it is first imported from
+
+	for i, cells := range t.Rows {
+		values := k.values(cells, mapping)
+		if len(values) == 0 {
+			continue
+		}
+		row := models.ImportRow{Row: i + 2}
+		form := forms.New(values)
+		code := strings.TrimSpace(values.Get(codeField))
+
+		switch k.Name {
+		case KindCustomers:
+			c := models.Customer{Status: models.CustomerOnboarding}
+			form.Bind(&c)
+			row.Customer = &c
+			row.Label = strings.TrimSpace(c.CustomerCode + " " + c.CustomerName)
+
+			if code != "" {
+				if first, ok := codes[code]; ok {
+					form.Errors.Add(codeField, fmt.Sprintf("%s is also on row %d", code, first))
+				} else {
+					codes[code] = row.Row
+					_, exists, err := customer(code)
+					if err != nil {
+						return nil, err
+					}
+					if exists {
+						form.Errors.Add(codeField, fmt.Sprintf("A customer with code %s already exists", code))
+					}
+				}
+			}
+
+		case KindTradeLicenses:
+			tl := models.TradeLicense{CustomerCode: code}
+			form.Required(codeField, "emirate")
+			form.Bind(&tl)
+			row.TradeLicense = &tl
+			row.Label = strings.TrimSpace(code + " " + tl.TradeLicenseNo)
+			id, err := linkCustomer(form, code, customer)
+			if err != nil {
+				return nil, err
+			}
+			row.CustomerID, tl.CustomerId = id, id
+
+		case KindShareholders:
+			sh := models.TradeLicenseHolder{CustomerCode: code}
+			form.Required(codeField)
+			form.Bind(&sh)
+			row.Shareholder = &sh
+			row.Label = strings.TrimSpace(code + " " + sh.ShareHolderName)
+			id, err := linkCustomer(form, code, customer)
+			if err != nil {
+				return nil, err
+			}
+			row.CustomerID, sh.CustomerId = id, id
+
+		default:
+			return nil, fmt.Errorf("importer: unknown kind %q", k.Name)
+		}
+
+		for _, f := range k.Fields {
+			for _, msg := range form.Errors[f.Name] {
+				row.Errors = append(row.Errors, f.Label+": "+strings.TrimSuffix(msg, " "+f.Name))
+			}
+		}
+		if !row.Valid() {
+			row.Customer, row.TradeLicense, row.Shareholder = nil, nil, nil
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// linkCustomer returns the ID of the customer a record belongs to, adding a
+// form error when there is no customer with its code
+func linkCustomer(form *forms.Form, code string, customer CustomerLookup) (int, error) {
+	if code == "" {
+		return 0, nil
+	}
+	id, ok, err := customer(code)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		form.Errors.Add(codeField, fmt.Sprintf("There is no customer with code %s", code))
+	}
+	return id, nil
+}
+
+// values reads the mapped cells of a row as form values, the way the forms
+// post them. Rows without any value give no values.
+func (k Kind) values(cells []string, mapping map[string]int) url.Values {
+	values := url.Values{}
+	for _, f := range k.Fields {
+		i, ok := mapping[f.Name]
+		if !ok || i < 0 || i >= len(cells) {
+			continue
+		}
+		v := undefuse(strings.TrimSpace(cells[i]))
+		if v == "" {
+			continue
+		}
+		switch {
+		case f.Date:
+			v = NormalizeDate(v)
+		case f.Name == "emirate":
+			v = NormalizeEmirate(v)
+		}
+		values.Set(f.Name, v)
+	}
+	return values
+}
+
+// undefuse drops the quote exports put before values that spreadsheets would
+// read as formulas, such as phone numbers starting with +
+func undefuse(v string) string {
+	if len(v) > 1 && v[0] == '\'' && strings.ContainsRune("=+-@", rune(v[1])) {
+		return v[1:]
+	}
+	return v
+}
+
+// dateLayouts are the date formats found in spreadsheets, days first as in the UAE
+var dateLayouts = []string{
+	forms.DateLayout,
+	"02/01/2006",
+	"2/1/2006",
+	"02-01-2006",
+	"02.01.2006",
+	"2006/01/02",
+	"2 Jan 2006",
+	"02 Jan 2006",
+	"2 January 2006",
+	"2-Jan-2006",
+	"02-Jan-2006",
+	"Jan 2, 2006",
+	"January 2, 2006",
+	"2006-01-02 15:04:05",
+	time.RFC3339,
+}
+
+// excelEpoch is day 0 of the dates spreadsheets store as serial numbers
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// NormalizeDate rewrites a date in one of the formats found in spreadsheets,
+// or as a spreadsheet serial number, as date inputs post it. Values that are
+// not dates are returned unchanged, for the forms to reject.
+func NormalizeDate(s string) string {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Format(forms.DateLayout)
+		}
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil && n >= 1 && n < 2958466 {
+		return excelEpoch.AddDate(0, 0, int(n)).Format(forms.DateLayout)
+	}
+	return s
+}
+
+// emirates maps the names and abbreviations of the emirates to the codes the
+// trade license form posts
+var emirates = map[string]string{
+	"abudhabi": "ABU", "auh": "ABU", "abu": "ABU",
+	"ajman": "AJM", "ajm": "AJM",
+	"dubai": "DUB", "dxb": "DUB", "dub": "DUB",
+	"fujairah": "FUJ", "fujeirah": "FUJ", "fuj": "FUJ",
+	"rasalkhaimah": "RAS", "rak": "RAS", "ras": "RAS",
+	"sharjah": "SHA", "shj": "SHA", "sha": "SHA",
+	"ummalquwain": "UMM", "uaq": "UMM", "umm": "UMM",
+}
+
+// NormalizeEmirate returns the code of an emirate written by name or
+// abbreviation, or s unchanged when it names none
+func NormalizeEmirate(s string) string {
+	if code, ok := emirates[simplify(s)]; ok {
+		return code
+	}
+	return s
+}
@@ -0,0 +1,234 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/export"
+)
+
+// existing looks customers up in a map of code to ID
+func existing(customers map[string]int) CustomerLookup {
+	return func(code string) (int, bool, error) {
+		id, ok := customers[code]
+		return id, ok, nil
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	data := "\uFEFFCustomer code;Customer name\r\nC001;Acme\r\n;\r\nC002;\"Smith; Sons\"\r\n;\r\n"
+	table, err := Read("customers.CSV", strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Headers) != 2 || table.Headers[0] != "Customer code" {
+		t.Errorf("unexpected headers %q", table.Headers)
+	}
+	if len(table.Rows) != 3 || table.Rows[2][1] != "Smith; Sons" {
+		t.Errorf("expected 3 rows with the trailing blank row dropped, got %q", table.Rows)
+	}
+
+	for name, data := range map[string]string{
+		"customers.txt": "a,b\n1,2\n",
+		"empty.csv":     "a,b\n",
+		"big.csv":       "a\n" + strings.Repeat("1\n", MaxRows+1),
+	} {
+		if _, err := Read(name, strings.NewReader(data)); err == nil {
+			t.Errorf("expected %s to be refused", name)
+		}
+	}
+}
+
+func TestReadXLSX(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := export.NewXLSX(&buf, "Customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]string{"Customer code", "Customer name", "Phone"})
+	w.Write([]string{"C001", "Acme & Co", "+97141234567"})
+	w.Write([]string{"", "", ""})
+	w.Write([]string{"C002", "", "045555555"})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := Read("customers.xlsx", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(table.Headers) != 3 || table.Headers[2] != "Phone" {
+		t.Errorf("unexpected headers %q", table.Headers)
+	}
+	if len(table.Rows) != 3 || table.Rows[0][1] != "Acme & Co" || table.Rows[2][0] != "C002" {
+		t.Errorf("unexpected rows %q", table.Rows)
+	}
+
+	if _, err := Read("customers.xlsx", strings.NewReader("not a zip")); err == nil {
+		t.Error("expected a file that isn't a workbook to be refused")
+	}
+
+	sheet := `<worksheet><sheetData><row r="-3"><c><v>1</v></c></row></sheetData></worksheet>`
+	if _, err := Read("customers.xlsx", workbook(t, sheet, 0)); err == nil || !strings.Contains(err.Error(), "row number") {
+		t.Errorf("expected a negative row number to be refused, got %v", err)
+	}
+	_, err = Read("customers.xlsx", workbook(t, `<worksheet><sheetData></sheetData></worksheet>`, maxPartSize))
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("expected shared strings too large once uncompressed to be refused, got %v", err)
+	}
+}
+
+// workbook makes an XLSX file with a sheet and shared strings padded with
+// padding spaces, which compress to almost nothing
+func workbook(t *testing.T, sheet string, padding int) io.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, _ := zw.Create("xl/worksheets/sheet1.xml")
+	io.WriteString(w, sheet)
+	w, _ = zw.Create("xl/sharedStrings.xml")
+	io.WriteString(w, "<sst>")
+	w.Write(bytes.Repeat([]byte(" "), padding))
+	io.WriteString(w, "</sst>")
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestColumnIndex(t *testing.T) {
+	for ref, want := range map[string]int{"A1": 0, "Z9": 25, "AA10": 26, "AB2": 27, "XFD1": 16383} {
+		if got := columnIndex(ref); got != want {
+			t.Errorf("columnIndex(%q) = %d, want %d", ref, got, want)
+		}
+	}
+}
+
+func TestGuess(t *testing.T) {
+	k, _ := Lookup(KindCustomers)
+	mapping := k.Guess([]string{"E-mail", "CODE", "Customer Name", "Telephone", "Notes"})
+
+	want := map[string]int{"email": 0, "customerCode": 1, "customerName": 2, "contactNo": 3}
+	if len(mapping) != len(want) {
+		t.Errorf("expected %v, got %v", want, mapping)
+	}
+	for field, col := range want {
+		if mapping[field] != col {
+			t.Errorf("expected %s in column %d, got %v", field, col, mapping)
+		}
+	}
+
+	missing := k.Unmapped(mapping)
+	if strings.Join(missing, ",") != "Contact person,Mobile" {
+		t.Errorf("unexpected unmapped fields %q", missing)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{
+		"2031-05-31":  "2031-05-31",
+		"31/05/2031":  "2031-05-31",
+		"1/5/2031":    "2031-05-01",
+		"31 May 2031": "2031-05-31",
+		"47999":       "2031-05-31",
+		"soon":        "soon",
+	} {
+		if got := NormalizeDate(in); got != want {
+			t.Errorf("NormalizeDate(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	for in, want := range map[string]string{"Dubai": "DUB", "abu dhabi": "ABU", "RAK": "RAS", "Umm Al-Quwain": "UMM", "Oman": "Oman"} {
+		if got := NormalizeEmirate(in); got != want {
+			t.Errorf("NormalizeEmirate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestCheckCustomers(t *testing.T) {
+	k, _ := Lookup(KindCustomers)
+	table := Table{
+		Headers: []string{"Customer code", "Customer name", "Contact person", "Phone", "Mobile", "Email"},
+		Rows: [][]string{
+			{"C001", "Acme", "Jane", "04 123 4567", "'+971501234567", "jane@acme.ae"},
+			{"", "", "", "", "", ""},
+			{"C001", "Acme again", "Jane", "04 123 4567", "0501234567", "jane@acme.ae"},
+			{"C900", "Taken", "Joe", "04 123 4567", "0501234567", "not an email"},
+		},
+	}
+	rows, err := k.Check(table, k.Guess(table.Headers), existing(map[string]int{"C900": 9}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected the blank row to be skipped, got %d rows", len(rows))
+	}
+
+	if !rows[0].Valid() || rows[0].Row != 2 || rows[0].Customer == nil {
+		t.Fatalf("expected row 2 to be valid, got %+v", rows[0])
+	}
+	if c := rows[0].Customer; c.ContactNo != "+97141234567" || c.MobileNo != "+971501234567" || c.Status != "onboarding" {
+		t.Errorf("expected normalized numbers of an onboarding customer, got %+v", c)
+	}
+
+	if rows[1].Valid() || rows[1].Row != 4 || !strings.Contains(rows[1].Errors[0], "also on row 2") {
+		t.Errorf("expected the repeated code to be refused, got %+v", rows[1])
+	}
+	if rows[1].Customer != nil {
+		t.Error("expected no record on an invalid row")
+	}
+
+	errs := strings.Join(rows[2].Errors, "; ")
+	if !strings.Contains(errs, "Customer code: A customer with code C900 already exists") || !strings.Contains(errs, "Email:") {
+		t.Errorf("expected the existing code and bad email to be refused, got %q", errs)
+	}
+}
+
+func TestCheckLinked(t *testing.T) {
+	customers := existing(map[string]int{"C001": 7})
+
+	k, _ := Lookup(KindTradeLicenses)
+	table := Table{
+		Headers: []string{"Customer code", "Emirate", "Trade license number", "MOHRE number", "Registration date", "Expiry date"},
+		Rows: [][]string{
+			{"C001", "Dubai", "123456", "M1", "01/01/2020", "31/12/2099"},
+			{"C404", "Dubai", "123456", "M1", "01/01/2020", "31/12/2099"},
+			{"C001", "Sharjah", "12", "", "", "2000-01-01"},
+		},
+	}
+	rows, err := k.Check(table, k.Guess(table.Headers), customers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl := rows[0].TradeLicense; !rows[0].Valid() || tl.CustomerId != 7 || tl.Emirate != "DUB" || tl.LicenseExpiry.Year() != 2099 {
+		t.Errorf("expected a valid Dubai license of customer 7, got %+v %+v", rows[0], tl)
+	}
+	if rows[1].Valid() || rows[1].Errors[0] != "Customer code: There is no customer with code C404" {
+		t.Errorf("expected the unknown customer to be refused, got %q", rows[1].Errors)
+	}
+	if len(rows[2].Errors) < 3 {
+		t.Errorf("expected the number, MOHRE number and expiry to be refused, got %q", rows[2].Errors)
+	}
+
+	k, _ = Lookup(KindShareholders)
+	table = Table{
+		Headers: []string{"Code", "Name", "Emirates ID", "Passport"},
+		Rows: [][]string{
+			{"C001", "Jane Doe", "784-1980-1234567-8", "N1234567"},
+			{"C001", "John Doe", "", ""},
+		},
+	}
+	rows, err = k.Check(table, k.Guess(table.Headers), customers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows[0].Valid() || rows[0].Shareholder.CustomerId != 7 || rows[0].Shareholder.CustomerCode != "C001" {
+		t.Errorf("expected a valid shareholder of customer 7, got %+v", rows[0])
+	}
+	if errs := strings.Join(rows[1].Errors, "; "); errs != "Emirates ID: This field cannot be blank; Passport: This field cannot be blank" {
+		t.Errorf("unexpected errors %q", errs)
+	}
+}
@@ -0,0 +1,295 @@
+package importer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Limits on the files that can be imported
+const (
+	MaxFileSize = 10 << 20
+	MaxRows     = 2000
+)
+
+// maxPartSize limits the uncompressed size of each XML part of an XLSX file,
+// which compresses far better than other files
+const maxPartSize = 64 << 20
+
+// ErrFormat is returned for files that are neither CSV nor XLSX
+var ErrFormat = errors.New("only .csv and .xlsx files can be imported")
+
+// Table is the content of a spreadsheet: the header row and the rows under it
+type Table struct {
+	Headers []string
+	Rows    [][]string
+}
+
+// Read reads the first sheet of a spreadsheet, a CSV or XLSX file told apart
+// by the extension of its name. The first row holds the headers.
+func Read(name string, r io.Reader) (Table, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxFileSize+1))
+	if err != nil {
+		return Table{}, err
+	}
+	if len(data) > MaxFileSize {
+		return Table{}, fmt.Errorf("the file is larger than %d MB", MaxFileSize>>20)
+	}
+
+	var records [][]string
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		records, err = readCSV(data)
+	case ".xlsx":
+		records, err = readXLSX(data)
+	default:
+		return Table{}, ErrFormat
+	}
+	if err != nil {
+		return Table{}, err
+	}
+
+	// Spreadsheets often carry empty rows under the data
+	for len(records) > 0 && blank(records[len(records)-1]) {
+		records = records[:len(records)-1]
+	}
+	if len(records) < 2 {
+		return Table{}, errors.New("the file has no rows under the header row")
+	}
+	if len(records)-1 > MaxRows {
+		return Table{}, fmt.Errorf("the file has %d rows, no more than %d can be imported at once", len(records)-1, MaxRows)
+	}
+
+	t := Table{Headers: records[0], Rows: records[1:]}
+	for i, h := range t.Headers {
+		t.Headers[i] = strings.TrimSpace(h)
+	}
+	return t, nil
+}
+
+func blank(cells []string) bool {
+	for _, c := range cells {
+		if strings.TrimSpace(c) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readCSV reads comma or semicolon separated values, as saved by spreadsheets
+// in different locales
+func readCSV(data []byte) ([][]string, error) {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+
+	first, _, _ := bytes.Cut(data, []byte("\n"))
+	cr := csv.NewReader(bytes.NewReader(data))
+	if bytes.Count(first, []byte(";")) > bytes.Count(first, []byte(",")) {
+		cr.Comma = ';'
+	}
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+
+	var records [][]string
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("the file is not valid CSV: %w", err)
+		}
+		if len(records) > MaxRows+1 {
+			return nil, fmt.Errorf("the file has more than %d rows, no more than %d can be imported at once", MaxRows, MaxRows)
+		}
+		records = append(records, rec)
+	}
+}
+
+// XML parts of an XLSX file that are read
+type (
+	xlsxWorkbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	xlsxRelationships struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	xlsxText struct {
+		T string `xml:"t"`
+		R []struct {
+			T string `xml:"t"`
+		} `xml:"r"`
+	}
+	xlsxSharedStrings struct {
+		Items []xlsxText `xml:"si"`
+	}
+	xlsxSheet struct {
+		Rows []struct {
+			R     int `xml:"r,attr"`
+			Cells []struct {
+				Ref    string   `xml:"r,attr"`
+				Type   string   `xml:"t,attr"`
+				Value  string   `xml:"v"`
+				Inline xlsxText `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+)
+
+// String returns the text of a shared or inline string, joining rich text runs
+func (t xlsxText) String() string {
+	if len(t.R) == 0 {
+		return t.T
+	}
+	var b strings.Builder
+	for _, r := range t.R {
+		b.WriteString(r.T)
+	}
+	return b.String()
+}
+
+// readXLSX reads the cell values of the first sheet of an XLSX workbook.
+// Numbers, dates included, are read as stored, dates being serial numbers.
+func readXLSX(data []byte) ([][]string, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, errors.New("the file is not a valid XLSX workbook")
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	var strs xlsxSharedStrings
+	if f, ok := files["xl/sharedStrings.xml"]; ok {
+		if err := decodeXML(f, &strs); err != nil {
+			return nil, err
+		}
+	}
+
+	f, ok := files[firstSheet(files)]
+	if !ok {
+		return nil, errors.New("the workbook has no sheet")
+	}
+	var sheet xlsxSheet
+	if err := decodeXML(f, &sheet); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	for _, row := range sheet.Rows {
+		if row.R < 0 {
+			return nil, fmt.Errorf("the workbook has a bad row number %d", row.R)
+		}
+		n := row.R - 1
+		if row.R == 0 {
+			n = len(records)
+		}
+		if n > MaxRows+1 {
+			return nil, fmt.Errorf("the file has more than %d rows, no more than %d can be imported at once", MaxRows, MaxRows)
+		}
+		for len(records) <= n {
+			records = append(records, nil)
+		}
+
+		var cells []string
+		for i, c := range row.Cells {
+			col := i
+			if c.Ref != "" {
+				col = columnIndex(c.Ref)
+			}
+			if col < 0 || col > 16383 {
+				return nil, fmt.Errorf("the workbook has a bad cell reference %q", c.Ref)
+			}
+			for len(cells) <= col {
+				cells = append(cells, "")
+			}
+
+			switch c.Type {
+			case "s":
+				idx, err := strconv.Atoi(c.Value)
+				if err != nil || idx < 0 || idx >= len(strs.Items) {
+					return nil, fmt.Errorf("the workbook has a bad shared string in cell %s", c.Ref)
+				}
+				cells[col] = strs.Items[idx].String()
+			case "inlineStr":
+				cells[col] = c.Inline.String()
+			case "b":
+				cells[col] = map[string]string{"1": "TRUE", "0": "FALSE"}[c.Value]
+			default:
+				cells[col] = c.Value
+			}
+		}
+		records[n] = cells
+	}
+	return records, nil
+}
+
+// firstSheet returns the name of the workbook's first sheet in the archive
+func firstSheet(files map[string]*zip.File) string {
+	const fallback = "xl/worksheets/sheet1.xml"
+
+	var wb xlsxWorkbook
+	var rels xlsxRelationships
+	wf, ok1 := files["xl/workbook.xml"]
+	rf, ok2 := files["xl/_rels/workbook.xml.rels"]
+	if !ok1 || !ok2 || decodeXML(wf, &wb) != nil || decodeXML(rf, &rels) != nil || len(wb.Sheets) == 0 {
+		return fallback
+	}
+	for _, rel := range rels.Relationships {
+		if rel.ID != wb.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/")
+		}
+		return path.Join("xl", rel.Target)
+	}
+	return fallback
+}
+
+// decodeXML decodes an XML part of a workbook, refusing parts larger than
+// maxPartSize once uncompressed whatever the archive says their size is
+func decodeXML(f *zip.File, v interface{}) error {
+	tooLarge := fmt.Errorf("the workbook's %s is larger than %d MB", path.Base(f.Name), maxPartSize>>20)
+	if f.UncompressedSize64 > maxPartSize {
+		return tooLarge
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	lr := &io.LimitedReader{R: rc, N: maxPartSize + 1}
+	if err := xml.NewDecoder(lr).Decode(v); err != nil {
+		if lr.N == 0 {
+			return tooLarge
+		}
+		return fmt.Errorf("the workbook's %s can't be read: %w", path.Base(f.Name), err)
+	}
+	return nil
+}
+
+// columnIndex returns the zero based column of a cell reference such as "AB12"
+func columnIndex(ref string) int {
+	col := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		col = col*26 + int(r-'A'+1)
+	}
+	return col - 1
+}
@@ -0,0 +1,109 @@
+// Package invoice works out what a reservation costs and lays out its
+// confirmation and invoice as a PDF
+package invoice
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// Tax is charged as a percentage of the stay
+type Tax struct {
+	Name    string
+	Percent float64
+}
+
+// DefaultTaxes are charged when no others are configured
+var DefaultTaxes = []Tax{{Name: "VAT", Percent: 5}}
+
+// ParseTaxes reads taxes written like "VAT=5,Municipality fee=7"
+func ParseTaxes(s string) ([]Tax, error) {
+	var taxes []Tax
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, percent, ok := strings.Cut(part, "=")
+		name = strings.TrimSpace(name)
+		p, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		if !ok || name == "" || err != nil || p < 0 || p > 100 {
+			return nil, fmt.Errorf("invalid tax %q, expected a name and a percentage like VAT=5", part)
+		}
+		taxes = append(taxes, Tax{Name: name, Percent: p})
+	}
+	return taxes, nil
+}
+
+// Line is one charge of an invoice
+type Line struct {
+	Description string
+	Quantity    int
+	UnitPrice   models.Money
+	Amount      models.Money
+}
+
+// TaxLine is a tax charged on an invoice
+type TaxLine struct {
+	Tax
+	Amount models.Money
+}
+
+// Invoice is the confirmation and invoice of a reservation
+type Invoice struct {
+	Reservation models.Reservation
+	Lines       []Line
+	Subtotal    models.Money
+	Taxes       []TaxLine
+	Total       models.Money
+	IssuedAt    time.Time
+}
+
+// New works out the invoice of a reservation from the price worked out when it
+// was made, with taxes charged on the stay and rounded to the fil. Reservations
+// made before prices were stored are charged the room's nightly rate.
+func New(res models.Reservation, taxes []Tax, issued time.Time) Invoice {
+	inv := Invoice{Reservation: res, IssuedAt: issued}
+
+	if len(res.Price.Lines) == 0 {
+		nights := res.Nights()
+		rate := res.Room.NightlyRate
+		inv.Lines = []Line{{
+			Description: fmt.Sprintf("%s, %s to %s", res.Room.RoomName, res.StartDate.Format("02 Jan 2006"), res.EndDate.Format("02 Jan 2006")),
+			Quantity:    nights,
+			UnitPrice:   rate,
+			Amount:      rate * models.Money(nights),
+		}}
+	}
+	for _, l := range res.Price.Lines {
+		inv.Lines = append(inv.Lines, Line{Description: l.Description, Quantity: l.Nights, UnitPrice: l.Rate, Amount: l.Amount})
+	}
+	if res.Price.Discount != 0 {
+		inv.Lines = append(inv.Lines, Line{
+			Description: "Discount code " + res.Price.DiscountCode,
+			Quantity:    1,
+			UnitPrice:   -res.Price.Discount,
+			Amount:      -res.Price.Discount,
+		})
+	}
+	for _, l := range inv.Lines {
+		inv.Subtotal += l.Amount
+	}
+
+	inv.Total = inv.Subtotal
+	for _, t := range taxes {
+		amount := models.Money(math.Round(float64(inv.Subtotal) * t.Percent / 100))
+		inv.Taxes = append(inv.Taxes, TaxLine{Tax: t, Amount: amount})
+		inv.Total += amount
+	}
+	return inv
+}
+
+// FileName is the name the invoice is downloaded under
+func (inv Invoice) FileName() string {
+	return "reservation-" + inv.Reservation.Reference + ".pdf"
+}
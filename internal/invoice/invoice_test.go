@@ -0,0 +1,85 @@
+package invoice
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+func TestNew(t *testing.T) {
+	res := models.Reservation{
+		Reference: "ABCD234567",
+		StartDate: time.Date(2026, 11, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2026, 11, 4, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "Major's Suite", NightlyRate: 65050},
+	}
+	inv := New(res, []Tax{{"VAT", 5}, {"Municipality fee", 7}}, time.Now())
+
+	if len(inv.Lines) != 1 || inv.Lines[0].Quantity != 3 || inv.Subtotal != 195150 {
+		t.Fatalf("expected 3 nights for 1,951.50, got %+v", inv)
+	}
+	// 5% of 1,951.50 is 97.575, rounded to 97.58
+	if inv.Taxes[0].Amount != 9758 || inv.Taxes[1].Amount != 13661 || inv.Total != 218569 {
+		t.Errorf("unexpected taxes %+v and total %v", inv.Taxes, inv.Total)
+	}
+	if s := inv.Total.String(); s != "2,185.69" {
+		t.Errorf("expected the total to read 2,185.69, got %s", s)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, inv); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF-")) || !bytes.Contains(buf.Bytes(), []byte("/Title (Reservation ABCD234567)")) {
+		t.Error("expected the PDF of the reservation")
+	}
+}
+
+func TestNewWithPrice(t *testing.T) {
+	res := models.Reservation{
+		Reference: "ABCD234567",
+		StartDate: time.Date(2026, 11, 5, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2026, 11, 8, 0, 0, 0, 0, time.UTC),
+		Room:      models.Room{RoomName: "Major's Suite", NightlyRate: 65000},
+		Price: models.Price{
+			Lines: []models.PriceLine{
+				{Description: "Standard rate", Nights: 1, Rate: 65000, Amount: 65000},
+				{Description: "Standard rate, weekend", Nights: 2, Rate: 80000, Amount: 160000},
+			},
+			Subtotal:     225000,
+			DiscountCode: "WELCOME",
+			Discount:     25000,
+			Total:        200000,
+		},
+	}
+	inv := New(res, DefaultTaxes, time.Now())
+
+	if len(inv.Lines) != 3 || inv.Lines[2].Amount != -25000 || inv.Lines[2].Description != "Discount code WELCOME" {
+		t.Fatalf("expected the price lines and the discount, got %+v", inv.Lines)
+	}
+	if inv.Subtotal != 200000 || inv.Taxes[0].Amount != 10000 || inv.Total != 210000 {
+		t.Errorf("expected VAT on the discounted price, got %+v", inv)
+	}
+}
+
+func TestParseTaxes(t *testing.T) {
+	taxes, err := ParseTaxes("VAT=5, Municipality fee = 7.5,")
+	if err != nil || len(taxes) != 2 || taxes[1] != (Tax{"Municipality fee", 7.5}) {
+		t.Errorf("unexpected taxes %+v (%v)", taxes, err)
+	}
+	for _, s := range []string{"VAT", "VAT=five", "=5", "VAT=150"} {
+		if _, err := ParseTaxes(s); err == nil {
+			t.Errorf("expected %q to be refused", s)
+		}
+	}
+}
+
+func TestMoney(t *testing.T) {
+	for m, want := range map[models.Money]string{0: "0.00", 5: "0.05", 123456789: "1,234,567.89", -100050: "-1,000.50"} {
+		if got := m.String(); got != want {
+			t.Errorf("Money(%d) = %q, want %q", int64(m), got, want)
+		}
+	}
+}
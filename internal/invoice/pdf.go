@@ -0,0 +1,113 @@
+package invoice
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/pdf"
+)
+
+const margin = 50.0
+
+// Write writes the invoice as a one page PDF
+func Write(w io.Writer, inv Invoice) error {
+	res := inv.Reservation
+	doc := pdf.New()
+	doc.Title = "Reservation " + res.Reference
+	doc.Created = inv.IssuedAt
+	doc.AddPage()
+
+	right := func(x, y float64, s string) {
+		doc.Text(x-doc.TextWidth(s), y, s)
+	}
+
+	doc.SetFont(pdf.HelveticaBold, 18)
+	doc.Text(margin, 70, "Reservation confirmation")
+	doc.SetFont(pdf.Helvetica, 10)
+	doc.Text(margin, 88, "and invoice")
+	doc.SetFont(pdf.HelveticaBold, 10)
+	right(pdf.PageWidth-margin, 70, "Reference "+res.Reference)
+	doc.SetFont(pdf.Helvetica, 10)
+	right(pdf.PageWidth-margin, 88, "Issued "+inv.IssuedAt.Format("02 Jan 2006"))
+	doc.Line(margin, 100, pdf.PageWidth-margin, 100)
+
+	y := 130.0
+	block := func(x float64, title string, rows [][2]string) float64 {
+		by := y
+		doc.SetFont(pdf.HelveticaBold, 11)
+		doc.Text(x, by, title)
+		by += 18
+		for _, r := range rows {
+			if r[1] == "" {
+				continue
+			}
+			doc.SetFont(pdf.Helvetica, 10)
+			doc.SetColor(90, 90, 90)
+			doc.Text(x, by, r[0])
+			doc.SetColor(0, 0, 0)
+			doc.Text(x+70, by, r[1])
+			by += 14
+		}
+		return by
+	}
+	guestEnd := block(margin, "Guest", [][2]string{
+		{"Name", strings.TrimSpace(res.FirstName + " " + res.LastName)},
+		{"Email", res.Email},
+		{"Phone", res.Phone},
+	})
+	stayEnd := block(pdf.PageWidth/2, "Stay", [][2]string{
+		{"Room", res.Room.RoomName},
+		{"Arrival", res.StartDate.Format("Mon 02 Jan 2006")},
+		{"Departure", res.EndDate.Format("Mon 02 Jan 2006")},
+		{"Nights", strconv.Itoa(res.Nights())},
+	})
+	y = max(guestEnd, stayEnd) + 20
+
+	// Charges, with the amounts right aligned in their columns
+	cols := []float64{margin, 340, 420, pdf.PageWidth - margin}
+	doc.SetColor(230, 230, 230)
+	doc.FillRect(margin, y-12, pdf.PageWidth-2*margin, 17)
+	doc.SetColor(0, 0, 0)
+	doc.SetFont(pdf.HelveticaBold, 10)
+	doc.Text(cols[0]+4, y, "Description")
+	right(cols[1], y, "Nights")
+	right(cols[2]+50, y, "Rate")
+	right(cols[3]-4, y, "Amount ("+models.Currency+")")
+	y += 20
+	doc.SetFont(pdf.Helvetica, 10)
+	for _, l := range inv.Lines {
+		doc.Text(cols[0]+4, y, l.Description)
+		right(cols[1], y, strconv.Itoa(l.Quantity))
+		right(cols[2]+50, y, l.UnitPrice.String())
+		right(cols[3]-4, y, l.Amount.String())
+		y += 16
+	}
+	doc.SetColor(200, 200, 200)
+	doc.Line(margin, y-8, pdf.PageWidth-margin, y-8)
+	doc.SetColor(0, 0, 0)
+
+	y += 8
+	total := func(label string, amount models.Money) {
+		right(cols[2]+50, y, label)
+		right(cols[3]-4, y, amount.String())
+		y += 16
+	}
+	total("Subtotal", inv.Subtotal)
+	for _, t := range inv.Taxes {
+		total(fmt.Sprintf("%s %s%%", t.Name, strconv.FormatFloat(t.Percent, 'f', -1, 64)), t.Amount)
+	}
+	doc.Line(cols[2]-40, y-10, pdf.PageWidth-margin, y-10)
+	y += 4
+	doc.SetFont(pdf.HelveticaBold, 11)
+	total("Total "+models.Currency, inv.Total)
+
+	doc.SetFont(pdf.Helvetica, 9)
+	doc.SetColor(90, 90, 90)
+	doc.Text(margin, pdf.PageHeight-60, "Please quote reference "+res.Reference+" in any correspondence about this reservation.")
+
+	_, err := doc.WriteTo(w)
+	return err
+}
@@ -0,0 +1,94 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one message like a mail server without extensions and
+// sends the commands and data it got on the returned channel
+func fakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	got := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		var session strings.Builder
+		conn.Write([]byte("220 localhost ESMTP\r\n"))
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				got <- session.String()
+				return
+			}
+			session.WriteString(line)
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case cmd == "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					session.WriteString(line)
+					if line == ".\r\n" {
+						break
+					}
+				}
+				conn.Write([]byte("250 queued\r\n"))
+			case cmd == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				got <- session.String()
+				return
+			default:
+				conn.Write([]byte("250 ok\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String(), got
+}
+
+func TestSMTP(t *testing.T) {
+	addr, got := fakeSMTP(t)
+	s := &SMTP{Addr: addr, From: "Bookings <bookings@example.com>", Timeout: 5 * time.Second}
+
+	err := s.Send(context.Background(), Message{
+		To:      "Jane Guest <jane@example.com>",
+		Subject: "Your reservation – confirmed",
+		Body:    "Hello Jane,\nyour stay is confirmed.",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session := <-got
+	for _, want := range []string{
+		"MAIL FROM:<bookings@example.com>",
+		"RCPT TO:<jane@example.com>",
+		"Subject: =?utf-8?q?Your_reservation_=E2=80=93_confirmed?=",
+		"Hello Jane,\r\nyour stay is confirmed.",
+	} {
+		if !strings.Contains(session, want) {
+			t.Errorf("expected %q in the session:\n%s", want, session)
+		}
+	}
+
+	if err := s.Send(context.Background(), Message{To: "not an address"}); err == nil {
+		t.Error("expected an invalid recipient to be refused")
+	}
+}
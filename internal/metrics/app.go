@@ -0,0 +1,16 @@
+package metrics
+
+// Metrics collected by the application
+var (
+	HTTPRequests = NewCounter("http_requests_total",
+		"Number of HTTP requests by method, route pattern and status code.",
+		"method", "route", "status")
+	HTTPDuration = NewHistogram("http_request_duration_seconds",
+		"Latency of HTTP requests by method and route pattern.",
+		DefaultBuckets, "method", "route")
+
+	ReservationsCreated = NewCounter("reservations_created_total", "Number of reservations created.")
+	CustomersAdded      = NewCounter("customers_added_total", "Number of customers added.")
+	UploadsStored       = NewCounter("uploads_stored_total", "Number of uploaded files stored, by kind.", "kind")
+	UploadsQuarantined  = NewCounter("uploads_quarantined_total", "Number of uploaded files quarantined by the virus scanner.")
+)
@@ -0,0 +1,308 @@
+package metrics
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// collector is anything that can write itself in the Prometheus text format
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]collector{}
+)
+
+// register adds c to the default registry. Registering two metrics with the same name panics.
+func register(c collector) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[c.name()]; exists {
+		panic("metrics: duplicate metric " + c.name())
+	}
+	registry[c.name()] = c
+}
+
+// Handler serves all registered metrics in the Prometheus text exposition format
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteTo(w)
+	})
+}
+
+// WriteTo writes all registered metrics, ordered by name, to w
+func WriteTo(w io.Writer) {
+	registryMu.Lock()
+	var collectors []collector
+	for _, c := range registry {
+		collectors = append(collectors, c)
+	}
+	registryMu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// Counter is a monotonically increasing value, optionally partitioned by labels
+type Counter struct {
+	metricName string
+	help       string
+	labels     []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounter creates and registers a counter
+func NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{metricName: name, help: help, labels: labels, values: map[string]float64{}}
+	register(c)
+	return c
+}
+
+// Inc adds one to the counter for the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v, which must not be negative, to the counter for the given label values
+func (c *Counter) Add(v float64, labelValues ...string) {
+	key := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Value returns the current value for the given label values
+func (c *Counter) Value(labelValues ...string) float64 {
+	key := labelKey(c.labels, labelValues)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[key]
+}
+
+func (c *Counter) name() string { return c.metricName }
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.metricName, c.help, "counter")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.labels) == 0 && len(c.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", c.metricName)
+		return
+	}
+	for _, key := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %s\n", c.metricName, key, formatFloat(c.values[key]))
+	}
+}
+
+// DefaultBuckets are latency buckets in seconds suited to web requests
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Histogram counts observations into cumulative buckets, optionally partitioned by labels
+type Histogram struct {
+	metricName string
+	help       string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+// NewHistogram creates and registers a histogram with the given upper bucket bounds
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{metricName: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+	register(h)
+	return h
+}
+
+// Observe records one observation for the given label values
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	key := labelKey(h.labels, labelValues)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{labelValues: labelValues, counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+}
+
+func (h *Histogram) name() string { return h.metricName }
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	keys := make([]string, 0, len(h.series))
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := h.series[key]
+		bucketLabels := append(append([]string{}, h.labels...), "le")
+		for i, upper := range h.buckets {
+			values := append(append([]string{}, s.labelValues...), formatFloat(upper))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelKey(bucketLabels, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.metricName, labelKey(bucketLabels, values), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.metricName, key, formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.metricName, key, s.count)
+	}
+}
+
+// funcMetric reports a value read at scrape time
+type funcMetric struct {
+	metricName string
+	help       string
+	kind       string
+	fn         func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is read from fn on every scrape
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, kind: "gauge", fn: fn})
+}
+
+// NewCounterFunc registers a counter whose value is read from fn on every scrape
+func NewCounterFunc(name, help string, fn func() float64) {
+	register(&funcMetric{metricName: name, help: help, kind: "counter", fn: fn})
+}
+
+func (f *funcMetric) name() string { return f.metricName }
+
+func (f *funcMetric) write(w io.Writer) {
+	writeHeader(w, f.metricName, f.help, f.kind)
+	fmt.Fprintf(w, "%s %s\n", f.metricName, formatFloat(f.fn()))
+}
+
+// dbStatsMetric reports a statistic of each database connection pool, labelled with the pool's name
+type dbStatsMetric struct {
+	metricName string
+	help       string
+	kind       string
+	pools      map[string]*sql.DB
+	fn         func(s sql.DBStats) float64
+}
+
+func (d *dbStatsMetric) name() string { return d.metricName }
+
+func (d *dbStatsMetric) write(w io.Writer) {
+	writeHeader(w, d.metricName, d.help, d.kind)
+
+	names := make([]string, 0, len(d.pools))
+	for name := range d.pools {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		key := labelKey([]string{"pool"}, []string{name})
+		fmt.Fprintf(w, "%s%s %s\n", d.metricName, key, formatFloat(d.fn(d.pools[name].Stats())))
+	}
+}
+
+// RegisterDBStats exposes the connection pool statistics of each of pools,
+// keyed by the value of their pool label, like "primary" and "replica"
+func RegisterDBStats(pools map[string]*sql.DB) {
+	stat := func(name, help, kind string, fn func(s sql.DBStats) float64) {
+		register(&dbStatsMetric{metricName: name, help: help, kind: kind, pools: pools, fn: fn})
+	}
+
+	stat("db_max_open_connections", "Maximum number of open connections to the database.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	stat("db_open_connections", "The number of established connections both in use and idle.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	stat("db_in_use_connections", "The number of connections currently in use.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	stat("db_idle_connections", "The number of idle connections.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	stat("db_wait_count_total", "The total number of connections waited for.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	stat("db_wait_duration_seconds_total", "The total time blocked waiting for a new connection.", "counter",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	stat("db_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	stat("db_max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	stat("db_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// labelKey renders label pairs as {a="x",b="y"}; it doubles as the map key of a series
+func labelKey(labels, values []string) string {
+	if len(labels) != len(values) {
+		panic(fmt.Sprintf("metrics: expected %d label values, got %d", len(labels), len(values)))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var b strings.Builder
+	b.WriteString("{")
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `%s="%s"`, l, escape.Replace(values[i]))
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,83 @@
+package metrics
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"testing"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("test_counter_total", "A test counter.", "route")
+	c.Inc("/a")
+	c.Add(2, "/a")
+	c.Inc(`/b"c`)
+
+	var buf bytes.Buffer
+	c.write(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		"# TYPE test_counter_total counter\n",
+		`test_counter_total{route="/a"} 3` + "\n",
+		`test_counter_total{route="/b\"c"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got\n%s", want, out)
+		}
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("test_duration_seconds", "A test histogram.", []float64{0.1, 1}, "method")
+	h.Observe(0.05, "GET")
+	h.Observe(0.5, "GET")
+	h.Observe(5, "GET")
+
+	var buf bytes.Buffer
+	h.write(&buf)
+	out := buf.String()
+
+	for _, want := range []string{
+		`test_duration_seconds_bucket{method="GET",le="0.1"} 1`,
+		`test_duration_seconds_bucket{method="GET",le="1"} 2`,
+		`test_duration_seconds_bucket{method="GET",le="+Inf"} 3`,
+		`test_duration_seconds_sum{method="GET"} 5.55`,
+		`test_duration_seconds_count{method="GET"} 3`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected output to contain %q, got\n%s", want, out)
+		}
+	}
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a wrong number of label values")
+		}
+	}()
+	HTTPRequests.Inc("GET")
+}
+
+func TestDBStatsMetric(t *testing.T) {
+	d := &dbStatsMetric{
+		metricName: "test_db_open_connections",
+		help:       "A test pool gauge.",
+		kind:       "gauge",
+		pools:      map[string]*sql.DB{"replica": {}, "primary": {}},
+		fn:         func(s sql.DBStats) float64 { return float64(s.OpenConnections) },
+	}
+
+	var buf bytes.Buffer
+	d.write(&buf)
+	out := buf.String()
+
+	want := "# HELP test_db_open_connections A test pool gauge.\n" +
+		"# TYPE test_db_open_connections gauge\n" +
+		`test_db_open_connections{pool="primary"} 0` + "\n" +
+		`test_db_open_connections{pool="replica"} 0` + "\n"
+	if out != want {
+		t.Errorf("expected\n%s\ngot\n%s", want, out)
+	}
+}
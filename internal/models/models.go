@@ -1,6 +1,12 @@
 package models
 
-import "time"
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
 
 type User struct {
 	ID          int
@@ -13,11 +19,53 @@ type User struct {
 	UpdatedAt   time.Time
 }
 
+// Access levels of users. Each level can do everything the levels below it can.
+const (
+	AccessStaff    = 1
+	AccessReviewer = 2 // can approve or reject KYC records entered by others
+	AccessAdmin    = 3
+)
+
+// IsReviewer reports whether the user may review KYC records
+func (u User) IsReviewer() bool {
+	return u.AccessLevel >= AccessReviewer
+}
+
+// IsAdmin reports whether the user may manage the site, such as its rooms
+func (u User) IsAdmin() bool {
+	return u.AccessLevel >= AccessAdmin
+}
+
+// CanExport reports whether the user may export customer and KYC data, which
+// is limited to reviewers and administrators
+func (u User) CanExport() bool {
+	return u.AccessLevel >= AccessReviewer
+}
+
 type Room struct {
-	ID         int
-	RoomName   string
-	CreatedAt  time.Time
-	UppdatedAt time.Time
+	ID          int
+	RoomName    string `form:"roomName" validate:"required"`
+	Slug        string `form:"slug"` // names the room's page, /rooms/{slug}
+	Description string `form:"description"`
+	Capacity    int    `form:"capacity" validate:"required,range=1:50"` // number of guests
+	Amenities   []string
+	NightlyRate Money // before taxes
+	WeekendRate Money // for Friday and Saturday nights, the nightly rate when 0
+	MinNights   int   // shortest stay that can be booked
+	Photos      []RoomPhoto
+	CreatedAt   time.Time
+	UppdatedAt  time.Time
+}
+
+// RoomPhoto is a photo of a room, shown on its page in order of Position
+type RoomPhoto struct {
+	ID          int
+	RoomID      int
+	FilePath    string
+	ContentType string
+	Caption     string
+	Position    int
+	CreatedAt   time.Time
 }
 
 type Restriction struct {
@@ -29,6 +77,7 @@ type Restriction struct {
 
 type Reservation struct {
 	ID        int
+	Reference string // given to the guest, who downloads the confirmation with it
 	FirstName string
 	LastName  string
 	Email     string
@@ -39,9 +88,253 @@ type Reservation struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
-	Processed int
+	State     string // one of the reservation states, changed with CheckTransition
+	Price     Price  // worked out when the reservation is made
+
+	PaymentStatus       string // PaymentPending, PaymentPaid or PaymentRefunded
+	PaymentIntentID     string // of the payment provider, empty until the guest starts paying
+	PaymentClientSecret string // lets the guest's browser complete the payment
+	AmountPaid          Money
+
+	CancelledAt     time.Time // zero unless the reservation was cancelled
+	CancellationFee Money     // charged for cancelling late, including taxes
+}
+
+// Cancelled reports whether the reservation was cancelled
+func (r Reservation) Cancelled() bool {
+	return r.State == ReservationCancelled
+}
+
+// Reservation states. Reservations are pending until staff confirm them or the
+// guest pays, and end checked out, cancelled or as a no-show.
+const (
+	ReservationPending    = "pending"
+	ReservationConfirmed  = "confirmed"
+	ReservationCheckedIn  = "checked-in"
+	ReservationCheckedOut = "checked-out"
+	ReservationCancelled  = "cancelled"
+	ReservationNoShow     = "no-show"
+)
+
+// ReservationStates lists the reservation states in the order they are reached
+var ReservationStates = []string{ReservationPending, ReservationConfirmed, ReservationCheckedIn,
+	ReservationCheckedOut, ReservationCancelled, ReservationNoShow}
+
+// IsReservationState reports whether state is one of ReservationStates
+func IsReservationState(state string) bool {
+	for _, s := range ReservationStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// reservationTransitions are the states each state can change to
+var reservationTransitions = map[string][]string{
+	ReservationPending:   {ReservationConfirmed, ReservationCancelled},
+	ReservationConfirmed: {ReservationCheckedIn, ReservationNoShow, ReservationCancelled},
+	ReservationCheckedIn: {ReservationCheckedOut},
+}
+
+// NextStates returns the states the reservation can change to
+func (r Reservation) NextStates() []string {
+	return reservationTransitions[r.State]
+}
+
+// CheckTransition returns why the reservation can't change to state at now,
+// or nil when it can. Guests are checked in or marked as no-shows from the
+// day they arrive.
+func (r Reservation) CheckTransition(state string, now time.Time) error {
+	allowed := false
+	for _, s := range r.NextStates() {
+		allowed = allowed || s == state
+	}
+	if !allowed {
+		return fmt.Errorf("a %s reservation can't be marked %s", StateLabel(r.State), StateLabel(state))
+	}
+
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if (state == ReservationCheckedIn || state == ReservationNoShow) && today.Before(r.StartDate) {
+		return fmt.Errorf("the guest arrives on %s and can't be marked %s before", r.StartDate.Format("02 Jan 2006"), StateLabel(state))
+	}
+	return nil
+}
+
+// StateLabel names a reservation state for people
+func StateLabel(state string) string {
+	switch state {
+	case ReservationCheckedIn:
+		return "checked in"
+	case ReservationCheckedOut:
+		return "checked out"
+	case ReservationNoShow:
+		return "no-show"
+	}
+	return state
+}
+
+// Actors of reservation changes not made by staff
+const (
+	ActorGuest   = "guest"
+	ActorPayment = "payment"
+)
+
+// ReservationEvent is a change of state of a reservation
+type ReservationEvent struct {
+	ID            int
+	ReservationID int
+	FromState     string // empty when the reservation is made
+	ToState       string
+	UserID        int    // the staff member who made the change, 0 for others
+	Actor         string // ActorGuest or ActorPayment when UserID is 0
+	User          User
+	Comment       string
+	CreatedAt     time.Time
+}
+
+// ActorName names who made the change
+func (e ReservationEvent) ActorName() string {
+	switch {
+	case e.UserID != 0 && e.User.FirstName != "":
+		return e.User.FirstName + " " + e.User.LastName
+	case e.UserID != 0:
+		return fmt.Sprintf("User %d", e.UserID)
+	case e.Actor == ActorPayment:
+		return "Online payment"
+	case e.Actor == ActorGuest:
+		return "Guest"
+	}
+	return "System"
+}
+
+// Payment statuses of reservations
+const (
+	PaymentPending  = "pending"
+	PaymentPaid     = "paid"
+	PaymentRefunded = "refunded"
+)
+
+// PaymentEvent is a change of the payment of a reservation reported by the
+// payment provider. Each event is applied once by its ID.
+type PaymentEvent struct {
+	ID        string
+	IntentID  string
+	Status    string // PaymentPaid or PaymentRefunded
+	Amount    Money
+	CreatedAt time.Time
+}
+
+// Nights returns the number of nights of the stay
+func (r Reservation) Nights() int {
+	return int(r.EndDate.Sub(r.StartDate).Hours()+12) / 24
+}
+
+// Currency of all amounts
+const Currency = "AED"
+
+// Money is an amount in fils, the hundredth of a dirham
+type Money int64
+
+// String formats the amount in dirhams with thousands separators, like 1,234.50
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign, m = "-", -m
+	}
+	units := strconv.FormatInt(int64(m/100), 10)
+	for i := len(units) - 3; i > 0; i -= 3 {
+		units = units[:i] + "," + units[i:]
+	}
+	return fmt.Sprintf("%s%s.%02d", sign, units, int64(m%100))
+}
+
+// ParseMoney reads an amount in dirhams, like 1,234.5 or 450
+func ParseMoney(s string) (Money, error) {
+	s = strings.ReplaceAll(strings.TrimSpace(s), ",", "")
+	units, fils, hasFils := strings.Cut(s, ".")
+	if units == "" || len(fils) > 2 || hasFils && fils == "" {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	n, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	m := Money(n * 100)
+	if fils != "" {
+		f, err := strconv.Atoi((fils + "0")[:2])
+		if err != nil || strings.ContainsAny(fils, "+-") {
+			return 0, fmt.Errorf("invalid amount %q", s)
+		}
+		m += Money(f)
+	}
+	return m, nil
+}
+
+// RatePeriod changes the rates or the minimum stay of a room, or of every room
+// when RoomID is 0, for the nights from StartDate to EndDate
+type RatePeriod struct {
+	ID          int
+	RoomID      int       `form:"periodRoom"`
+	Name        string    `form:"periodName" validate:"required"`
+	StartDate   time.Time `form:"periodStart" validate:"required"`
+	EndDate     time.Time `form:"periodEnd" validate:"required"` // the last night of the period
+	NightlyRate Money     // replaces the room's rates when not 0
+	WeekendRate Money     // for Friday and Saturday nights, NightlyRate when 0
+	Percent     int       `form:"periodPercent" validate:"range=-90:500"` // added to the room's rates when NightlyRate is 0
+	MinNights   int       `form:"periodMinNights" validate:"range=0:365"` // of stays including a night of the period, when not 0
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+}
+
+// Covers reports whether the night starting on day is in the period
+func (p RatePeriod) Covers(day time.Time) bool {
+	return !day.Before(p.StartDate) && !day.After(p.EndDate)
+}
+
+// DiscountCode takes a percentage or an amount off stays booked with it
+type DiscountCode struct {
+	ID          int
+	Code        string    `form:"code" validate:"required"`
+	Description string    `form:"codeDescription"`
+	Percent     int       `form:"codePercent" validate:"range=0:100"`
+	Amount      Money     // taken off when Percent is 0
+	ValidFrom   time.Time `form:"codeFrom"` // first arrival date, none when zero
+	ValidTo     time.Time `form:"codeTo"`   // last arrival date, none when zero
+	MinNights   int       `form:"codeMinNights" validate:"range=0:365"`
+	MaxUses     int       `form:"codeMaxUses" validate:"range=0:100000"` // unlimited when 0
+	Uses        int
+	Active      bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
 }
 
+// Price is what a stay costs before taxes
+type Price struct {
+	Lines        []PriceLine
+	Subtotal     Money
+	DiscountCode string
+	Discount     Money
+	Total        Money
+}
+
+// PriceLine is the nights of a stay charged at the same rate
+type PriceLine struct {
+	Description string
+	Nights      int
+	Rate        Money
+	Amount      Money
+}
+
+// Restriction types of room restrictions
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionExternal    = 3 // imported from an external calendar
+)
+
 type RoomRestriction struct {
 	ID            int
 	StartDate     time.Time
@@ -49,6 +342,7 @@ type RoomRestriction struct {
 	RoomID        int
 	ReservationID int
 	RestrictionID int
+	CalendarID    int // of the external calendar it was imported from, 0 for others
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
@@ -56,27 +350,107 @@ type RoomRestriction struct {
 	Restriction   Restriction
 }
 
+// RoomCalendar is an external calendar of a room, such as the iCal feed of a
+// booking site listing the room. Its events block the room's dates with
+// external restrictions.
+type RoomCalendar struct {
+	ID             int
+	RoomID         int
+	Name           string    `form:"calendarName" validate:"required"`
+	Source         string    `form:"calendarSource" validate:"required"` // an http(s) URL or the path of an .ics file
+	LastImportedAt time.Time // zero until imported
+	LastError      string    // of the last import, empty when it succeeded
+	Blocks         int       // events blocking dates at the last import
+	Conflicts      []CalendarConflict
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	Room           Room
+}
+
+// CalendarEvent is a stay or a block read from an external calendar
+type CalendarEvent struct {
+	UID       string
+	Summary   string
+	StartDate time.Time
+	EndDate   time.Time // the day after the last night
+}
+
+// CalendarConflict is an event of an external calendar on dates the room was
+// already reserved or blocked for, most likely a double booking
+type CalendarConflict struct {
+	ID            int
+	CalendarID    int
+	Event         CalendarEvent
+	RestrictionID int // the type of the restriction the event overlaps
+	ReservationID int // of the reservation the event overlaps, 0 for blocks
+	CreatedAt     time.Time
+}
+
 type Customer struct {
 	CustomerId          int
-	CustomerCode        string
-	CustomerName        string
-	ContactNo           string
-	ContactPerson       string
-	Email               string
-	MobileNo            string
-	BusinessName        string
-	Status              string
-	LocationDetails     string
-	NatureOfBusiness    string
-	MarketedBy          string
-	MarketerName        string
-	MarketerEmail       string
+	CustomerCode        string   `form:"customerCode" validate:"required"`
+	CustomerName        string   `form:"customerName" validate:"required"`
+	ContactNo           string   `form:"contactNo" validate:"required,phone"`
+	ContactPerson       string   `form:"contactPerson" validate:"required"`
+	Email               string   `form:"email" validate:"required,email"`
+	MobileNo            string   `form:"mobileNo" validate:"required,mobile"`
+	BusinessName        string   `form:"businessName"`
+	Status              string   // CustomerOnboarding or CustomerActive, older customers have other values
+	LocationDetails     string   `form:"locationDetails"`
+	NatureOfBusiness    string   `form:"natureOfBusiness"`
+	MarketedBy          string   `form:"marketedBy"`
+	MarketerName        string   `form:"marketerName"`
+	MarketerEmail       string   `form:"marketerEmail"`
 	Photos              []string // You can use a slice of strings to store photo filenames
 	CreatedAt           time.Time
 	UpdatedAt           time.Time
 	LocationCoordinates string
 }
 
+// Customer statuses. A customer stays onboarding until every required item of
+// the onboarding checklist is done and staff activate it.
+const (
+	CustomerOnboarding = "onboarding"
+	CustomerActive     = "active"
+)
+
+// CustomerFilter narrows down the customer list. Empty fields match every customer.
+type CustomerFilter struct {
+	Search string // part of the code, name, contact person, email or business name
+	Status string
+}
+
+// Matches reports whether a customer passes the filter
+func (f CustomerFilter) Matches(c Customer) bool {
+	if f.Status != "" && c.Status != f.Status {
+		return false
+	}
+	search := strings.ToLower(strings.TrimSpace(f.Search))
+	if search == "" {
+		return true
+	}
+	for _, v := range []string{c.CustomerCode, c.CustomerName, c.ContactPerson, c.Email, c.BusinessName} {
+		if strings.Contains(strings.ToLower(v), search) {
+			return true
+		}
+	}
+	return false
+}
+
+// CustomerDraft holds the values of an onboarding step that was saved without
+// being submitted, so staff can finish it later. Drafts of customers that are
+// not added yet have no CustomerID and belong to the user who started them;
+// drafts of existing customers are shared by all staff and have no UserID.
+type CustomerDraft struct {
+	ID         int
+	CustomerID int
+	UserID     int
+	Step       string
+	Values     url.Values
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
 type CustomerImages struct {
 	CustomerId   int
 	CustomerCode string
@@ -84,26 +458,34 @@ type CustomerImages struct {
 }
 
 type Attachment struct {
-	File_id  int
-	FilePath string
-	FileName string // You may want to add a field for the file name as well
-
+	File_id      int
+	CustomerId   int
+	CustomerCode string
+	Kind         string // customer, trade_license, partner or memorandum
+	FilePath     string
+	FileName     string // name the file is stored under
+	OriginalName string // name of the file on the uploader's computer
+	ContentType  string
+	Size         int64
+	Checksum     string // hex encoded SHA-256 of the content
+	ScanStatus   string // clean or not_scanned, infected files are quarantined instead of stored
+	CreatedAt    time.Time
 }
 
 type TradeLicenseHolder struct {
 	TradeLicenseID  int
 	CustomerId      int
 	CustomerCode    string
-	CustomerName    string
+	CustomerName    string `form:"customerName"`
 	ShareHolderID   int
-	ShareHolderName string
-	ShareHolderRole string
-	ShNationality   string
+	ShareHolderName string `form:"shareHolderName" validate:"required"`
+	ShareHolderRole string `form:"shareHolderRole"`
+	ShNationality   string `form:"shareHolderNationality"`
 	ShNoOfShares    int
-	ShEmirateID     string
-	ShEmIDExp       time.Time
-	ShPassport      string
-	ShPassportExp   time.Time
+	ShEmirateID     string    `form:"shEmirateID" validate:"required,emiratesid"`
+	ShEmIDExp       time.Time `form:"shEmIDExp" validate:"future"`
+	ShPassport      string    `form:"shPassport" validate:"required,passport=shareHolderNationality"`
+	ShPassportExp   time.Time `form:"shPassportExp" validate:"future"`
 	ShIDFilepath    string
 	ShIDFileName    string
 	ShPassFilepath  string
@@ -114,16 +496,16 @@ type TradeLicenseHolder struct {
 
 type TradeLicense struct {
 	TradeLicenseID   int
-	TradeLicenseNo   string
+	TradeLicenseNo   string `form:"tradelicenseid" validate:"required,tradelicense=emirate"`
 	CustomerId       int
 	CustomerCode     string
-	Emirate          string
-	MohreNo          string
-	TradeName        string
-	LegalStatus      string
-	EstablishDate    time.Time
-	RegistrationDate time.Time
-	LicenseExpiry    time.Time
+	Emirate          string    `form:"emirate"`
+	MohreNo          string    `form:"mohreno" validate:"required"`
+	TradeName        string    `form:"tradeName"`
+	LegalStatus      string    `form:"legalState"`
+	EstablishDate    time.Time `form:"establishmentDate"`
+	RegistrationDate time.Time `form:"registrationDate"`
+	LicenseExpiry    time.Time `form:"licenseExpiryDate" validate:"required,date,future,after=registrationDate,after=establishmentDate"`
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
 	FilePath         string
@@ -135,14 +517,205 @@ type Memorandum struct {
 	MemorandumID       int
 	CustomerId         int
 	CustomerCode       string
-	RepresentativeName string
-	RepNoOfShares      string
-	RepEmID            string
-	RepEmIDExp         time.Time
-	RepPassport        string
-	RepPassportExp     time.Time
+	RepresentativeName string    `form:"representativeName" validate:"required"`
+	RepNoOfShares      string    `form:"repNoOfShares" validate:"required,range=1:10000000"`
+	RepEmID            string    `form:"repEmID" validate:"emiratesid"`
+	RepEmIDExp         time.Time `form:"repEmIDExp" validate:"future"`
+	RepPassport        string    `form:"repPassport" validate:"passport"`
+	RepPassportExp     time.Time `form:"repPassportExp" validate:"future"`
 	RepIDFilepath      string
 	RepPassFilepath    string
 	CreatedAt          time.Time
 	UpdatedAt          time.Time
 }
+
+// KYC review states. Customer records are pending until a reviewer other than
+// the user who entered them approves or rejects them.
+const (
+	ReviewPending  = "pending"
+	ReviewApproved = "approved"
+	ReviewRejected = "rejected"
+)
+
+// Kinds of customer records that go through KYC review
+const (
+	RecordCustomer     = "customer"
+	RecordTradeLicense = "trade_license"
+	RecordPartner      = "partner"
+	RecordMemorandum   = "memorandum"
+)
+
+// KYCReview is the four-eyes review of one customer record
+type KYCReview struct {
+	ID           int
+	CustomerID   int
+	CustomerName string
+	RecordType   string
+	RecordID     int
+	State        string
+	SubmittedBy  int // the maker, who can't review the record
+	ReviewedBy   int // 0 while pending
+	Comment      string
+	Submitter    User
+	Reviewer     User
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// RecordLabel names the kind of record under review for staff
+func (rv KYCReview) RecordLabel() string {
+	switch rv.RecordType {
+	case RecordCustomer:
+		return "Company details"
+	case RecordTradeLicense:
+		return "Trade license"
+	case RecordPartner:
+		return "Shareholder"
+	case RecordMemorandum:
+		return "Representative"
+	}
+	return rv.RecordType
+}
+
+// KYCReviewEvent is a change of state of a review
+type KYCReviewEvent struct {
+	ID        int
+	ReviewID  int
+	FromState string // empty when the record is submitted
+	ToState   string
+	UserID    int
+	User      User
+	Comment   string
+	CreatedAt time.Time
+}
+
+// AML risk ratings of customers
+const (
+	RiskLow    = "low"
+	RiskMedium = "medium"
+	RiskHigh   = "high"
+)
+
+// RiskAssessment is the AML risk rating of a customer with the rules that
+// contributed to it
+type RiskAssessment struct {
+	CustomerID int
+	Score      int
+	Rating     string
+	Factors    []RiskFactor
+	AssessedAt time.Time
+}
+
+// RiskFactor is a scoring rule that matched a customer
+type RiskFactor struct {
+	Rule        string
+	Description string
+	Points      int
+	Detail      string // what matched, e.g. the shareholders of a listed nationality
+}
+
+// Sanctions lists that can be imported
+const (
+	SanctionsUN   = "un"   // UN Security Council consolidated list
+	SanctionsOFAC = "ofac" // US Treasury OFAC Specially Designated Nationals list
+)
+
+// SanctionsEntry is a person or organisation on a sanctions list
+type SanctionsEntry struct {
+	ID          int
+	Source      string
+	ExternalID  string // reference of the entry on its list
+	Name        string
+	Aliases     []string
+	EntryType   string // individual or entity
+	Program     string
+	Nationality string
+	Remarks     string
+	CreatedAt   time.Time
+}
+
+// States of sanctions hits. Hits are pending until staff confirm them as a
+// true match or dismiss them as a false positive.
+const (
+	HitPending   = "pending"
+	HitConfirmed = "confirmed"
+	HitDismissed = "dismissed"
+)
+
+// ScreeningSubject is a shareholder or representative whose name is screened
+// against the sanctions lists
+type ScreeningSubject struct {
+	CustomerID  int
+	SubjectType string // RecordPartner or RecordMemorandum
+	SubjectID   int
+	Name        string
+}
+
+// SanctionsHit is a subject whose name is close to a sanctions list entry. The
+// entry is copied, so hits survive lists being imported again.
+type SanctionsHit struct {
+	ID int
+	ScreeningSubject
+	CustomerName string
+	Source       string
+	ExternalID   string
+	ListedName   string // the name of the entry
+	MatchedName  string // the name or alias of the entry that matched
+	Program      string
+	Score        float64 // 0 to 1, 1 being the same name
+	State        string
+	ReviewedBy   int
+	Reviewer     User
+	Comment      string
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+}
+
+// SanctionsList sums up an imported sanctions list
+type SanctionsList struct {
+	Source     string
+	Entries    int
+	ImportedAt time.Time
+}
+
+// Import states. An import stays pending while staff map its columns and look
+// at the dry run, and is committed once its valid rows are stored.
+const (
+	ImportPending   = "pending"
+	ImportCommitted = "committed"
+)
+
+// CustomerImport is a spreadsheet of customer records uploaded for import,
+// kept from the upload through the column mapping to the commit
+type CustomerImport struct {
+	ID        int
+	UserID    int
+	Kind      string // the kind of records in the file, see package importer
+	FileName  string
+	Headers   []string
+	Rows      [][]string
+	Mapping   map[string]int // form field name to column index
+	State     string
+	Report    []ImportRow // the outcome of every row, once committed
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ImportRow is the outcome of checking or importing one spreadsheet row. Valid
+// rows hold the record to store; RecordID is set once it is stored.
+type ImportRow struct {
+	Row        int      `json:"row"` // row number in the spreadsheet, the header being row 1
+	Label      string   `json:"label"`
+	Errors     []string `json:"errors,omitempty"`
+	CustomerID int      `json:"customer_id,omitempty"`
+	RecordID   int      `json:"record_id,omitempty"`
+
+	Customer     *Customer           `json:"-"`
+	TradeLicense *TradeLicense       `json:"-"`
+	Shareholder  *TradeLicenseHolder `json:"-"`
+}
+
+// Valid reports whether the row can be imported
+func (r ImportRow) Valid() bool {
+	return len(r.Errors) == 0
+}
@@ -0,0 +1,234 @@
+// Package onboarding describes the steps of the customer onboarding wizard and
+// works out which of them are complete
+package onboarding
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+)
+
+// Steps of the wizard, in the order staff go through them
+const (
+	StepCompany      = "company"
+	StepTradeLicense = "trade-license"
+	StepPartners     = "partners"
+	StepMemorandum   = "memorandum"
+)
+
+// Step is one page of the wizard
+type Step struct {
+	Name  string
+	Title string
+}
+
+// Steps lists the wizard's steps in order
+var Steps = []Step{
+	{StepCompany, "Company details"},
+	{StepTradeLicense, "Trade license"},
+	{StepPartners, "Shareholders"},
+	{StepMemorandum, "Memorandum"},
+}
+
+// ValidStep reports whether name is one of the wizard's steps
+func ValidStep(name string) bool {
+	for _, s := range Steps {
+		if s.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// URL returns the page where staff fill in a step for a customer. The company
+// step of a customer that is not added yet is the add customer form.
+func URL(step string, customerID int) string {
+	switch step {
+	case StepTradeLicense:
+		return fmt.Sprintf("/customer/trade-license/%d", customerID)
+	case StepPartners:
+		return fmt.Sprintf("/customer/add-partner/%d", customerID)
+	case StepMemorandum:
+		return fmt.Sprintf("/customer/add-memorandum/%d", customerID)
+	default:
+		if customerID == 0 {
+			return "/customer/add"
+		}
+		return fmt.Sprintf("/customer/details/%d", customerID)
+	}
+}
+
+// Record is everything recorded about a customer that the checklist looks at
+type Record struct {
+	Customer     models.Customer
+	TradeLicense *models.TradeLicense // nil until one is recorded
+	Partners     []models.TradeLicenseHolder
+	Memorandum   []models.Memorandum
+	Attachments  []models.Attachment
+	Reviews      []models.KYCReview
+}
+
+// Item is one line of the checklist
+type Item struct {
+	Step     string
+	Label    string
+	Done     bool
+	Required bool // the customer can't be activated until it is done
+}
+
+// Checklist is the state of a customer's onboarding
+type Checklist struct {
+	Items []Item
+}
+
+// Check works out the checklist of a customer as of now
+func Check(r Record, now time.Time) Checklist {
+	c := r.Customer
+	tl := r.TradeLicense
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	idsPresent, passportsPresent := len(r.Partners) > 0, len(r.Partners) > 0
+	for _, p := range r.Partners {
+		if p.ShIDFilepath == "" {
+			idsPresent = false
+		}
+		if p.ShPassFilepath == "" {
+			passportsPresent = false
+		}
+	}
+
+	signed := false
+	for _, a := range r.Attachments {
+		if a.Kind == uploads.KindSignedMemorandum {
+			signed = true
+		}
+	}
+
+	// Every record needs an approved review of its own, a record without one was never checked
+	approved := func(recordType string, ids ...int) bool {
+		states := make(map[int]string)
+		for _, rv := range r.Reviews {
+			if rv.RecordType == recordType {
+				states[rv.RecordID] = rv.State
+			}
+		}
+		for _, id := range ids {
+			if states[id] != models.ReviewApproved {
+				return false
+			}
+		}
+		return true
+	}
+	var partnerIDs, representativeIDs []int
+	for _, p := range r.Partners {
+		partnerIDs = append(partnerIDs, p.ShareHolderID)
+	}
+	for _, rep := range r.Memorandum {
+		representativeIDs = append(representativeIDs, rep.MemorandumID)
+	}
+
+	items := []Item{
+		{StepCompany, "Company and contact details entered",
+			c.CustomerCode != "" && c.CustomerName != "" && c.ContactNo != "" && c.MobileNo != "" && c.Email != "", true},
+		{StepCompany, "Company details approved by a reviewer", approved(models.RecordCustomer, c.CustomerId), true},
+		{StepTradeLicense, "Trade license recorded", tl != nil && tl.TradeLicenseNo != "", true},
+		{StepTradeLicense, "Trade license copy uploaded", tl != nil && tl.FilePath != "", true},
+		{StepTradeLicense, "Trade license not expired", tl != nil && !tl.LicenseExpiry.Before(today), true},
+		{StepTradeLicense, "Trade license approved by a reviewer", tl != nil && approved(models.RecordTradeLicense, tl.TradeLicenseID), true},
+		{StepPartners, "At least one shareholder added", len(r.Partners) > 0, true},
+		{StepPartners, "All shareholders' Emirates ID copies uploaded", idsPresent, true},
+		{StepPartners, "All shareholders' passport copies uploaded", passportsPresent, true},
+		{StepPartners, "All shareholders approved by a reviewer", approved(models.RecordPartner, partnerIDs...), true},
+		{StepMemorandum, "Representative added", len(r.Memorandum) > 0, false},
+		{StepMemorandum, "Representatives approved by a reviewer", approved(models.RecordMemorandum, representativeIDs...), true},
+		{StepMemorandum, "Signed memorandum uploaded", signed, true},
+	}
+	return Checklist{Items: items}
+}
+
+// Complete reports whether every required item is done
+func (c Checklist) Complete() bool {
+	for _, it := range c.Items {
+		if it.Required && !it.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// Missing returns the labels of the required items that are not done
+func (c Checklist) Missing() []string {
+	var missing []string
+	for _, it := range c.Items {
+		if it.Required && !it.Done {
+			missing = append(missing, it.Label)
+		}
+	}
+	return missing
+}
+
+// StepDone reports whether all required items of a step are done
+func (c Checklist) StepDone(step string) bool {
+	for _, it := range c.Items {
+		if it.Step == step && it.Required && !it.Done {
+			return false
+		}
+	}
+	return true
+}
+
+// Next returns the first step with required items left, or "" when all are done
+func (c Checklist) Next() string {
+	for _, s := range Steps {
+		if !c.StepDone(s.Name) {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// Progress is what the wizard's progress bar shows
+type Progress struct {
+	CustomerID int
+	Current    string
+	Steps      []StepState
+	Percent    int // share of required checklist items done
+}
+
+// StepState is a step as shown in the progress bar
+type StepState struct {
+	Step
+	URL     string
+	Done    bool
+	Current bool
+}
+
+// Progress returns the progress bar of a customer's wizard, current being the
+// step shown on the page
+func (c Checklist) Progress(customerID int, current string) Progress {
+	p := Progress{CustomerID: customerID, Current: current}
+	for _, s := range Steps {
+		p.Steps = append(p.Steps, StepState{
+			Step:    s,
+			URL:     URL(s.Name, customerID),
+			Done:    customerID != 0 && c.StepDone(s.Name),
+			Current: s.Name == current,
+		})
+	}
+
+	required, done := 0, 0
+	for _, it := range c.Items {
+		if it.Required {
+			required++
+			if it.Done {
+				done++
+			}
+		}
+	}
+	if required > 0 {
+		p.Percent = done * 100 / required
+	}
+	return p
+}
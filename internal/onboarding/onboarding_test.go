@@ -0,0 +1,121 @@
+package onboarding
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/uploads"
+)
+
+var today = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+func completeRecord() Record {
+	return Record{
+		Customer: models.Customer{
+			CustomerId:   1,
+			CustomerCode: "C001",
+			CustomerName: "Test Trading LLC",
+			ContactNo:    "+97141234567",
+			MobileNo:     "+971501234567",
+			Email:        "test@example.com",
+			Status:       models.CustomerOnboarding,
+		},
+		TradeLicense: &models.TradeLicense{
+			TradeLicenseID: 2,
+			TradeLicenseNo: "123456",
+			LicenseExpiry:  today.AddDate(1, 0, 0),
+			FilePath:       "license.pdf",
+		},
+		Partners: []models.TradeLicenseHolder{
+			{ShareHolderID: 3, ShareHolderName: "A", ShIDFilepath: "id.pdf", ShPassFilepath: "passport.pdf"},
+		},
+		Attachments: []models.Attachment{{Kind: uploads.KindSignedMemorandum}},
+		Reviews: []models.KYCReview{
+			{RecordType: models.RecordCustomer, RecordID: 1, State: models.ReviewApproved},
+			{RecordType: models.RecordTradeLicense, RecordID: 2, State: models.ReviewApproved},
+			{RecordType: models.RecordPartner, RecordID: 3, State: models.ReviewApproved},
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var tests = []struct {
+		name     string
+		change   func(r *Record)
+		complete bool
+		next     string
+	}{
+		{"complete", func(r *Record) {}, true, ""},
+		{"no license", func(r *Record) { r.TradeLicense = nil }, false, StepTradeLicense},
+		{"expired license", func(r *Record) { r.TradeLicense.LicenseExpiry = today.AddDate(0, 0, -1) }, false, StepTradeLicense},
+		{"license expires today", func(r *Record) { r.TradeLicense.LicenseExpiry = today }, true, ""},
+		{"no shareholders", func(r *Record) { r.Partners = nil }, false, StepPartners},
+		{"passport copy missing", func(r *Record) {
+			r.Partners = append(r.Partners, models.TradeLicenseHolder{ShIDFilepath: "id2.pdf"})
+		}, false, StepPartners},
+		{"not signed", func(r *Record) { r.Attachments = nil }, false, StepMemorandum},
+		{"no email", func(r *Record) { r.Customer.Email = "" }, false, StepCompany},
+		{"shareholder pending review", func(r *Record) { r.Reviews[2].State = models.ReviewPending }, false, StepPartners},
+		{"shareholder without review", func(r *Record) {
+			r.Partners = append(r.Partners, models.TradeLicenseHolder{ShareHolderID: 4, ShIDFilepath: "id2.pdf", ShPassFilepath: "passport2.pdf"})
+		}, false, StepPartners},
+		{"company without review", func(r *Record) { r.Reviews = r.Reviews[1:] }, false, StepCompany},
+		{"no reviews", func(r *Record) { r.Reviews = nil }, false, StepCompany},
+		{"representative rejected", func(r *Record) {
+			r.Memorandum = []models.Memorandum{{MemorandumID: 5}}
+			r.Reviews = append(r.Reviews, models.KYCReview{RecordType: models.RecordMemorandum, RecordID: 5, State: models.ReviewRejected})
+		}, false, StepMemorandum},
+		{"representative approved", func(r *Record) {
+			r.Memorandum = []models.Memorandum{{MemorandumID: 5}}
+			r.Reviews = append(r.Reviews, models.KYCReview{RecordType: models.RecordMemorandum, RecordID: 5, State: models.ReviewApproved})
+		}, true, ""},
+	}
+
+	for _, e := range tests {
+		r := completeRecord()
+		e.change(&r)
+		c := Check(r, today.Add(15*time.Hour))
+		if c.Complete() != e.complete {
+			t.Errorf("%s: expected complete %v, missing %v", e.name, e.complete, c.Missing())
+		}
+		if c.Next() != e.next {
+			t.Errorf("%s: expected next step %q but got %q", e.name, e.next, c.Next())
+		}
+	}
+
+	// The representative is optional
+	if c := Check(completeRecord(), today); len(c.Missing()) != 0 {
+		t.Errorf("expected nothing missing without a representative, got %v", c.Missing())
+	}
+}
+
+func TestChecklist_Progress(t *testing.T) {
+	r := completeRecord()
+	r.Attachments = nil
+	p := Check(r, today).Progress(7, StepMemorandum)
+
+	if len(p.Steps) != len(Steps) {
+		t.Fatalf("expected %d steps but got %d", len(Steps), len(p.Steps))
+	}
+	for _, s := range p.Steps {
+		if s.Done != (s.Name != StepMemorandum) {
+			t.Errorf("%s: unexpected done %v", s.Name, s.Done)
+		}
+		if s.Current != (s.Name == StepMemorandum) {
+			t.Errorf("%s: unexpected current %v", s.Name, s.Current)
+		}
+	}
+	if p.Steps[2].URL != "/customer/add-partner/7" {
+		t.Errorf("unexpected URL %s", p.Steps[2].URL)
+	}
+	if p.Percent != 91 {
+		t.Errorf("expected 91 percent done but got %d", p.Percent)
+	}
+
+	// Nothing is done for a customer that is not added yet
+	p = Checklist{}.Progress(0, StepCompany)
+	if p.Steps[0].URL != "/customer/add" || p.Steps[0].Done || p.Percent != 0 {
+		t.Errorf("unexpected progress for a new customer %+v", p.Steps[0])
+	}
+}
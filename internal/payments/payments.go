@@ -0,0 +1,78 @@
+// Package payments takes payments for reservations through a payment provider
+package payments
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// ErrInvalidSignature is returned for webhook calls that weren't signed by the provider
+var ErrInvalidSignature = errors.New("payments: invalid webhook signature")
+
+// ErrIntentPaid is returned when cancelling an intent the guest paid or
+// authorized already
+var ErrIntentPaid = errors.New("payments: the intent was paid or authorized")
+
+// NewIntent asks a guest to pay for a reservation
+type NewIntent struct {
+	Amount      models.Money
+	Reference   string // of the reservation, creating an intent twice for it, Amount and Version returns the same one
+	Version     string // tells apart the intents of a reservation that replace cancelled ones
+	Email       string // where the provider sends the receipt
+	Description string
+}
+
+// Intent is a payment the guest was asked to make
+type Intent struct {
+	ID           string
+	Amount       models.Money
+	Status       string // as the provider reports it
+	ClientSecret string // lets the guest's browser complete the payment
+}
+
+// Refund gives back a payment or part of it
+type Refund struct {
+	ID       string
+	IntentID string
+	Amount   models.Money
+	Status   string // as the provider reports it
+}
+
+// Kinds of events
+const (
+	// EventSucceeded reports that the guest paid Amount
+	EventSucceeded = "succeeded"
+	// EventFailed reports that the guest's payment was declined, they can try again
+	EventFailed = "failed"
+	// EventRefunded reports that the whole payment was given back
+	EventRefunded = "refunded"
+)
+
+// Event is a change of a payment reported by the provider. Providers may
+// report an event more than once, so it is applied once by its ID.
+type Event struct {
+	ID       string
+	Kind     string // one of the Event kinds, empty for events of no interest
+	IntentID string
+	Amount   models.Money
+}
+
+// Provider takes payments. Amounts are in fils of models.Currency.
+type Provider interface {
+	// CreateIntent asks the guest to pay
+	CreateIntent(ctx context.Context, in NewIntent) (Intent, error)
+	// Capture takes the payment of an intent the guest authorized
+	Capture(ctx context.Context, intentID string) (Intent, error)
+	// Cancel cancels an intent so that the guest can't pay it anymore.
+	// ErrIntentPaid is returned for intents the guest paid or authorized, which
+	// are left as they are.
+	Cancel(ctx context.Context, intentID string) (Intent, error)
+	// Refund gives back amount of the payment of an intent
+	Refund(ctx context.Context, intentID string, amount models.Money) (Refund, error)
+	// VerifyWebhook checks that a webhook call was signed by the provider and
+	// returns the event it reports
+	VerifyWebhook(payload []byte, header http.Header) (Event, error)
+}
@@ -0,0 +1,251 @@
+// Package paymentstest stands in for the Stripe API in tests
+package paymentstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// StripeServer answers the payment intent and refund calls of the Stripe API
+// from memory
+type StripeServer struct {
+	*httptest.Server
+	SecretKey string
+
+	mu      sync.Mutex
+	nextID  int
+	intents map[string]*intent
+	keys    map[string][]byte // answers by idempotency key
+	Refunds int               // number of refunds made
+}
+
+type intent struct {
+	ID             string `json:"id"`
+	Object         string `json:"object"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Currency       string `json:"currency"`
+	Status         string `json:"status"`
+	ClientSecret   string `json:"client_secret"`
+	CaptureMethod  string `json:"capture_method"`
+	Reference      string `json:"-"`
+	Refunded       int64  `json:"-"`
+}
+
+// NewStripeServer starts a StripeServer that is closed at the end of the test
+func NewStripeServer(t testing.TB) *StripeServer {
+	s := &StripeServer{
+		SecretKey: "sk_test_paymentstest",
+		intents:   map[string]*intent{},
+		keys:      map[string][]byte{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *StripeServer) id(prefix string) string {
+	s.nextID++
+	return fmt.Sprintf("%s_test%d", prefix, s.nextID)
+}
+
+func (s *StripeServer) serve(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+s.SecretKey {
+		s.fail(w, http.StatusUnauthorized, "invalid_request_error", "Invalid API Key provided")
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodGet || r.ParseForm() != nil {
+		s.fail(w, http.StatusBadRequest, "invalid_request_error", "Invalid request")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := r.Header.Get("Idempotency-Key")
+	if answer, ok := s.keys[key]; ok && key != "" {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(answer)
+		return
+	}
+
+	var out interface{}
+	switch path := r.URL.Path; {
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/v1/payment_intents/"):
+		in := s.intents[strings.TrimPrefix(path, "/v1/payment_intents/")]
+		if in == nil {
+			s.fail(w, http.StatusNotFound, "invalid_request_error", "No such payment_intent")
+			return
+		}
+		out = in
+	case r.Method == http.MethodGet:
+		s.fail(w, http.StatusNotFound, "invalid_request_error", "Unrecognized request URL")
+		return
+	case path == "/v1/payment_intents":
+		amount, err := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+		if err != nil || amount <= 0 {
+			s.fail(w, http.StatusBadRequest, "invalid_request_error", "Invalid amount")
+			return
+		}
+		in := &intent{
+			ID:            s.id("pi"),
+			Object:        "payment_intent",
+			Amount:        amount,
+			Currency:      r.PostForm.Get("currency"),
+			Status:        "requires_payment_method",
+			CaptureMethod: r.PostForm.Get("capture_method"),
+			Reference:     r.PostForm.Get("metadata[reference]"),
+		}
+		in.ClientSecret = in.ID + "_secret_test"
+		s.intents[in.ID] = in
+		out = in
+	case strings.HasPrefix(path, "/v1/payment_intents/") && strings.HasSuffix(path, "/capture"):
+		in := s.intents[strings.TrimSuffix(strings.TrimPrefix(path, "/v1/payment_intents/"), "/capture")]
+		if in == nil {
+			s.fail(w, http.StatusNotFound, "invalid_request_error", "No such payment_intent")
+			return
+		}
+		if in.Status != "requires_capture" {
+			s.fail(w, http.StatusBadRequest, "invalid_request_error", "This PaymentIntent could not be captured")
+			return
+		}
+		in.Status, in.AmountReceived = "succeeded", in.Amount
+		out = in
+	case strings.HasPrefix(path, "/v1/payment_intents/") && strings.HasSuffix(path, "/cancel"):
+		in := s.intents[strings.TrimSuffix(strings.TrimPrefix(path, "/v1/payment_intents/"), "/cancel")]
+		if in == nil {
+			s.fail(w, http.StatusNotFound, "invalid_request_error", "No such payment_intent")
+			return
+		}
+		if in.Status == "succeeded" || in.Status == "canceled" {
+			s.failCode(w, http.StatusBadRequest, "payment_intent_unexpected_state",
+				"You cannot cancel this PaymentIntent because it has a status of "+in.Status)
+			return
+		}
+		in.Status = "canceled"
+		out = in
+	case path == "/v1/refunds":
+		in := s.intents[r.PostForm.Get("payment_intent")]
+		if in == nil {
+			s.fail(w, http.StatusNotFound, "invalid_request_error", "No such payment_intent")
+			return
+		}
+		amount, _ := strconv.ParseInt(r.PostForm.Get("amount"), 10, 64)
+		if in.Status != "succeeded" || amount <= 0 || in.Refunded+amount > in.AmountReceived {
+			s.fail(w, http.StatusBadRequest, "invalid_request_error", "Refund amount is greater than unrefunded amount on charge")
+			return
+		}
+		in.Refunded += amount
+		s.Refunds++
+		out = map[string]interface{}{
+			"id":             s.id("re"),
+			"object":         "refund",
+			"payment_intent": in.ID,
+			"amount":         amount,
+			"status":         "succeeded",
+		}
+	default:
+		s.fail(w, http.StatusNotFound, "invalid_request_error", "Unrecognized request URL")
+		return
+	}
+
+	answer, _ := json.Marshal(out)
+	if key != "" {
+		s.keys[key] = answer
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(answer)
+}
+
+func (s *StripeServer) fail(w http.ResponseWriter, status int, typ, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"type": typ, "message": message},
+	})
+}
+
+// failCode answers an invalid request error with its code
+func (s *StripeServer) failCode(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"type": "invalid_request_error", "code": code, "message": message},
+	})
+}
+
+// Pay completes the payment of an intent as the guest's browser would. Intents
+// created for manual capture are only authorized.
+func (s *StripeServer) Pay(intentID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in := s.intents[intentID]
+	if in == nil {
+		return
+	}
+	if in.CaptureMethod == "manual" {
+		in.Status = "requires_capture"
+		return
+	}
+	in.Status, in.AmountReceived = "succeeded", in.Amount
+}
+
+// IntentFor returns the ID of the last intent created for a reservation
+// reference
+func (s *StripeServer) IntentFor(reference string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	last, lastN := "", 0
+	for id, in := range s.intents {
+		var n int
+		fmt.Sscanf(id, "pi_test%d", &n)
+		if in.Reference == reference && n > lastN {
+			last, lastN = id, n
+		}
+	}
+	return last
+}
+
+// Status returns the status of an intent, empty for unknown intents
+func (s *StripeServer) Status(intentID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if in := s.intents[intentID]; in != nil {
+		return in.Status
+	}
+	return ""
+}
+
+// Event returns the webhook payload Stripe sends for an event of type typ
+// about an intent, as it is now
+func (s *StripeServer) Event(eventID, typ, intentID string) []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	in := s.intents[intentID]
+	if in == nil {
+		in = &intent{ID: intentID}
+	}
+	var object interface{} = in
+	if strings.HasPrefix(typ, "charge.") {
+		object = map[string]interface{}{
+			"id":              "ch_" + in.ID,
+			"object":          "charge",
+			"payment_intent":  in.ID,
+			"amount":          in.AmountReceived,
+			"amount_refunded": in.Refunded,
+			"refunded":        in.Refunded > 0 && in.Refunded == in.AmountReceived,
+		}
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"id":     eventID,
+		"object": "event",
+		"type":   typ,
+		"data":   map[string]interface{}{"object": object},
+	})
+	return payload
+}
@@ -0,0 +1,266 @@
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// StripeAPI is the address of the Stripe API
+const StripeAPI = "https://api.stripe.com"
+
+// WebhookTolerance is how old a signed webhook call may be, against replays
+const WebhookTolerance = 5 * time.Minute
+
+// Stripe takes payments through the Stripe API, or a server compatible with it
+type Stripe struct {
+	SecretKey     string
+	WebhookSecret string        // signs the webhook calls, whsec_...
+	BaseURL       string        // StripeAPI when empty
+	ManualCapture bool          // only authorize payments, they are taken with Capture
+	Timeout       time.Duration // limit for one API call, 0 means none
+}
+
+// StripeError is an error answered by the Stripe API
+type StripeError struct {
+	Status  int
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *StripeError) Error() string {
+	return fmt.Sprintf("stripe: %s (%d %s)", e.Message, e.Status, e.Type)
+}
+
+// stripeIntent is a payment intent of the Stripe API
+type stripeIntent struct {
+	ID             string `json:"id"`
+	Amount         int64  `json:"amount"`
+	AmountReceived int64  `json:"amount_received"`
+	Status         string `json:"status"`
+	ClientSecret   string `json:"client_secret"`
+}
+
+func (si stripeIntent) intent() Intent {
+	return Intent{ID: si.ID, Amount: models.Money(si.Amount), Status: si.Status, ClientSecret: si.ClientSecret}
+}
+
+// call posts form to the API at path, or gets path when form is nil, and
+// decodes the answer into out. The idempotency key, when not empty, makes
+// retries of the call safe.
+func (s *Stripe) call(ctx context.Context, path string, form url.Values, idempotencyKey string, out interface{}) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	base := s.BaseURL
+	if base == "" {
+		base = StripeAPI
+	}
+
+	method, payload := http.MethodGet, io.Reader(nil)
+	if form != nil {
+		method, payload = http.MethodPost, strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(base, "/")+path, payload)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("stripe: %w", err)
+	}
+
+	if resp.StatusCode >= 300 {
+		var answer struct {
+			Error StripeError `json:"error"`
+		}
+		if err := json.Unmarshal(body, &answer); err != nil || answer.Error.Message == "" {
+			answer.Error.Message = http.StatusText(resp.StatusCode)
+		}
+		answer.Error.Status = resp.StatusCode
+		return &answer.Error
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return fmt.Errorf("stripe: %w", err)
+	}
+	return nil
+}
+
+// CreateIntent creates a payment intent, once for each reservation and amount
+func (s *Stripe) CreateIntent(ctx context.Context, in NewIntent) (Intent, error) {
+	form := url.Values{
+		"amount":                    {strconv.FormatInt(int64(in.Amount), 10)},
+		"currency":                  {strings.ToLower(models.Currency)},
+		"description":               {in.Description},
+		"metadata[reference]":       {in.Reference},
+		"automatic_payment_methods": {"true"},
+	}
+	if in.Email != "" {
+		form.Set("receipt_email", in.Email)
+	}
+	if s.ManualCapture {
+		form.Set("capture_method", "manual")
+	}
+
+	key := fmt.Sprintf("intent-%s-%d", in.Reference, in.Amount)
+	if in.Version != "" {
+		key += "-" + in.Version
+	}
+	var si stripeIntent
+	err := s.call(ctx, "/v1/payment_intents", form, key, &si)
+	return si.intent(), err
+}
+
+// Capture takes the payment the guest authorized
+func (s *Stripe) Capture(ctx context.Context, intentID string) (Intent, error) {
+	var si stripeIntent
+	err := s.call(ctx, "/v1/payment_intents/"+url.PathEscape(intentID)+"/capture", url.Values{}, "capture-"+intentID, &si)
+	return si.intent(), err
+}
+
+// Cancel cancels a payment intent the guest hasn't paid or authorized. Stripe
+// would release an authorization too, so the intent is read first.
+func (s *Stripe) Cancel(ctx context.Context, intentID string) (Intent, error) {
+	path := "/v1/payment_intents/" + url.PathEscape(intentID)
+	var si stripeIntent
+	if err := s.call(ctx, path, nil, "", &si); err != nil {
+		return Intent{}, err
+	}
+	switch si.Status {
+	case "canceled":
+		return si.intent(), nil
+	case "processing", "requires_capture", "succeeded":
+		return si.intent(), ErrIntentPaid
+	}
+
+	err := s.call(ctx, path+"/cancel", url.Values{}, "cancel-"+intentID, &si)
+	var se *StripeError
+	if errors.As(err, &se) && se.Code == "payment_intent_unexpected_state" {
+		// Paid since it was read
+		return si.intent(), ErrIntentPaid
+	}
+	return si.intent(), err
+}
+
+// Refund gives back amount of a payment
+func (s *Stripe) Refund(ctx context.Context, intentID string, amount models.Money) (Refund, error) {
+	form := url.Values{
+		"payment_intent": {intentID},
+		"amount":         {strconv.FormatInt(int64(amount), 10)},
+	}
+	var r struct {
+		ID            string `json:"id"`
+		PaymentIntent string `json:"payment_intent"`
+		Amount        int64  `json:"amount"`
+		Status        string `json:"status"`
+	}
+	err := s.call(ctx, "/v1/refunds", form, fmt.Sprintf("refund-%s-%d", intentID, amount), &r)
+	return Refund{ID: r.ID, IntentID: r.PaymentIntent, Amount: models.Money(r.Amount), Status: r.Status}, err
+}
+
+// SignWebhook returns the Stripe-Signature header Stripe sends with payload at
+// time t, for tests and local tools that stand in for Stripe
+func SignWebhook(payload []byte, secret string, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + signature(payload, secret, ts)
+}
+
+func signature(payload []byte, secret, ts string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyWebhook checks the Stripe-Signature of a webhook call and returns the
+// event it reports. Calls signed more than WebhookTolerance ago are refused.
+func (s *Stripe) VerifyWebhook(payload []byte, header http.Header) (Event, error) {
+	var ts string
+	var signatures []string
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			signatures = append(signatures, v)
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || s.WebhookSecret == "" {
+		return Event{}, ErrInvalidSignature
+	}
+	if age := time.Since(time.Unix(sec, 0)); age > WebhookTolerance || age < -WebhookTolerance {
+		return Event{}, ErrInvalidSignature
+	}
+	want := []byte(signature(payload, s.WebhookSecret, ts))
+	valid := false
+	for _, sig := range signatures {
+		if hmac.Equal([]byte(sig), want) {
+			valid = true
+		}
+	}
+	if !valid {
+		return Event{}, ErrInvalidSignature
+	}
+
+	var e struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				ID             string `json:"id"`
+				AmountReceived int64  `json:"amount_received"`
+				PaymentIntent  string `json:"payment_intent"`
+				AmountRefunded int64  `json:"amount_refunded"`
+				Refunded       bool   `json:"refunded"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil || e.ID == "" {
+		return Event{}, fmt.Errorf("stripe: invalid event: %v", err)
+	}
+
+	ev := Event{ID: e.ID}
+	obj := e.Data.Object
+	switch e.Type {
+	case "payment_intent.succeeded":
+		ev.Kind, ev.IntentID, ev.Amount = EventSucceeded, obj.ID, models.Money(obj.AmountReceived)
+	case "payment_intent.payment_failed":
+		ev.Kind, ev.IntentID = EventFailed, obj.ID
+	case "charge.refunded":
+		// Partial refunds leave the reservation paid
+		if obj.Refunded {
+			ev.Kind, ev.IntentID, ev.Amount = EventRefunded, obj.PaymentIntent, models.Money(obj.AmountRefunded)
+		}
+	}
+	return ev, nil
+}
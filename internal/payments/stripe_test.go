@@ -0,0 +1,164 @@
+package payments_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/payments"
+	"github.com/chamrasilva89/reservationWeb/internal/payments/paymentstest"
+)
+
+func newStripe(t *testing.T) (*payments.Stripe, *paymentstest.StripeServer) {
+	srv := paymentstest.NewStripeServer(t)
+	return &payments.Stripe{
+		SecretKey:     srv.SecretKey,
+		WebhookSecret: "whsec_test",
+		BaseURL:       srv.URL,
+		Timeout:       5 * time.Second,
+	}, srv
+}
+
+func TestStripeIntentAndRefund(t *testing.T) {
+	s, srv := newStripe(t)
+	ctx := context.Background()
+
+	in, err := s.CreateIntent(ctx, payments.NewIntent{Amount: 105000, Reference: "ABCDE12345", Email: "guest@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.ID == "" || in.ClientSecret == "" || in.Amount != 105000 {
+		t.Fatalf("got intent %+v", in)
+	}
+	again, err := s.CreateIntent(ctx, payments.NewIntent{Amount: 105000, Reference: "ABCDE12345"})
+	if err != nil || again.ID != in.ID {
+		t.Errorf("creating the intent again gave %+v, %v, want the same intent", again, err)
+	}
+
+	// Nothing was paid yet
+	_, err = s.Refund(ctx, in.ID, 105000)
+	var apiErr *payments.StripeError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusBadRequest {
+		t.Errorf("refunding an unpaid intent gave %v, want a StripeError", err)
+	}
+
+	srv.Pay(in.ID)
+	r, err := s.Refund(ctx, in.ID, 105000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IntentID != in.ID || r.Amount != 105000 || r.Status != "succeeded" {
+		t.Errorf("got refund %+v", r)
+	}
+
+	s.SecretKey = "sk_wrong"
+	if _, err := s.CreateIntent(ctx, payments.NewIntent{Amount: 100, Reference: "OTHER"}); !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Errorf("a wrong key gave %v", err)
+	}
+}
+
+func TestStripeManualCapture(t *testing.T) {
+	s, srv := newStripe(t)
+	s.ManualCapture = true
+	ctx := context.Background()
+
+	in, err := s.CreateIntent(ctx, payments.NewIntent{Amount: 5000, Reference: "R1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Capture(ctx, in.ID); err == nil {
+		t.Error("captured an intent the guest didn't authorize")
+	}
+	srv.Pay(in.ID)
+	captured, err := s.Capture(ctx, in.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if captured.Status != "succeeded" {
+		t.Errorf("got status %q after capture", captured.Status)
+	}
+}
+
+func TestStripeCancel(t *testing.T) {
+	s, srv := newStripe(t)
+	s.ManualCapture = true
+	ctx := context.Background()
+
+	in, _ := s.CreateIntent(ctx, payments.NewIntent{Amount: 5000, Reference: "R1"})
+	cancelled, err := s.Cancel(ctx, in.ID)
+	if err != nil || cancelled.Status != "canceled" {
+		t.Fatalf("cancelling gave %+v, %v", cancelled, err)
+	}
+	if _, err := s.Cancel(ctx, in.ID); err != nil {
+		t.Errorf("cancelling again gave %v", err)
+	}
+	again, _ := s.CreateIntent(ctx, payments.NewIntent{Amount: 5000, Reference: "R1", Version: "2"})
+	if again.ID == in.ID {
+		t.Error("expected a new version to create a new intent")
+	}
+
+	// Authorizations are kept
+	srv.Pay(again.ID)
+	if _, err := s.Cancel(ctx, again.ID); !errors.Is(err, payments.ErrIntentPaid) {
+		t.Errorf("cancelling an authorized intent gave %v, want ErrIntentPaid", err)
+	}
+	if captured, err := s.Capture(ctx, again.ID); err != nil || captured.Status != "succeeded" {
+		t.Errorf("expected the authorization to be captured, got %+v, %v", captured, err)
+	}
+}
+
+func TestStripeVerifyWebhook(t *testing.T) {
+	s, srv := newStripe(t)
+	in, err := s.CreateIntent(context.Background(), payments.NewIntent{Amount: 5000, Reference: "R1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.Pay(in.ID)
+	payload := srv.Event("evt_1", "payment_intent.succeeded", in.ID)
+	now := time.Now()
+
+	signed := func(payload []byte, secret string, t time.Time) http.Header {
+		return http.Header{"Stripe-Signature": {payments.SignWebhook(payload, secret, t)}}
+	}
+
+	e, err := s.VerifyWebhook(payload, signed(payload, "whsec_test", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := payments.Event{ID: "evt_1", Kind: payments.EventSucceeded, IntentID: in.ID, Amount: models.Money(5000)}
+	if e != want {
+		t.Errorf("got event %+v, want %+v", e, want)
+	}
+
+	for name, header := range map[string]http.Header{
+		"unsigned":      {},
+		"wrong secret":  signed(payload, "whsec_other", now),
+		"old":           signed(payload, "whsec_test", now.Add(-time.Hour)),
+		"other payload": signed([]byte(`{"id":"evt_2"}`), "whsec_test", now),
+		"bad timestamp": {"Stripe-Signature": {"t=x,v1=00"}},
+	} {
+		if _, err := s.VerifyWebhook(payload, header); !errors.Is(err, payments.ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want ErrInvalidSignature", name, err)
+		}
+	}
+
+	if _, err := s.Refund(context.Background(), in.ID, 2000); err != nil {
+		t.Fatal(err)
+	}
+	partial := srv.Event("evt_2", "charge.refunded", in.ID)
+	if e, err := s.VerifyWebhook(partial, signed(partial, "whsec_test", now)); err != nil || e.Kind != "" {
+		t.Errorf("a partial refund gave %+v, %v, want an event of no interest", e, err)
+	}
+	if _, err := s.Refund(context.Background(), in.ID, 3000); err != nil {
+		t.Fatal(err)
+	}
+	full := srv.Event("evt_3", "charge.refunded", in.ID)
+	e, err = s.VerifyWebhook(full, signed(full, "whsec_test", now))
+	want = payments.Event{ID: "evt_3", Kind: payments.EventRefunded, IntentID: in.ID, Amount: 5000}
+	if err != nil || e != want {
+		t.Errorf("a full refund gave %+v, %v, want %+v", e, err, want)
+	}
+}
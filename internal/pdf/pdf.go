@@ -0,0 +1,338 @@
+// Package pdf writes simple PDF documents on A4 pages: text in the standard
+// Helvetica fonts, lines, filled rectangles and images. Positions are in
+// points from the top left corner of the page.
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Size of an A4 page in points
+const (
+	PageWidth  = 595.28
+	PageHeight = 841.89
+)
+
+// Font is one of the standard fonts every PDF reader has
+type Font int
+
+// Fonts that can be used
+const (
+	Helvetica Font = iota
+	HelveticaBold
+)
+
+// Document is a PDF document being drawn, page by page
+type Document struct {
+	Title   string
+	Created time.Time
+
+	pages  []*bytes.Buffer
+	page   int // index of the page drawn on
+	images []pdfImage
+	font   Font
+	size   float64
+}
+
+// pdfImage is an image embedded in the document as JPEG
+type pdfImage struct {
+	data          []byte
+	width, height int
+	gray          bool
+}
+
+// New returns an empty document, with Helvetica 10 as its font
+func New() *Document {
+	return &Document{font: Helvetica, size: 10, Created: time.Now()}
+}
+
+// AddPage adds a page at the end of the document and draws on it from then on
+func (d *Document) AddPage() {
+	d.pages = append(d.pages, new(bytes.Buffer))
+	d.page = len(d.pages) - 1
+}
+
+// PageCount returns the number of pages
+func (d *Document) PageCount() int {
+	return len(d.pages)
+}
+
+// SetPage goes back to page n, counting from 1, to draw more on it, such as
+// page numbers once the page count is known
+func (d *Document) SetPage(n int) {
+	if n < 1 || n > len(d.pages) {
+		panic(fmt.Sprintf("pdf: no page %d in a document of %d pages", n, len(d.pages)))
+	}
+	d.page = n - 1
+}
+
+func (d *Document) out(format string, args ...interface{}) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	fmt.Fprintf(d.pages[d.page], format, args...)
+	d.pages[d.page].WriteByte('\n')
+}
+
+// SetFont sets the font and size, in points, of the text drawn next
+func (d *Document) SetFont(f Font, size float64) {
+	d.font, d.size = f, size
+}
+
+// SetColor sets the color of the text, lines and rectangles drawn next
+func (d *Document) SetColor(r, g, b uint8) {
+	d.out("%s %s %s rg %s %s %s RG", c(r), c(g), c(b), c(r), c(g), c(b))
+}
+
+// c formats a color component for the PDF color operators
+func c(v uint8) string {
+	return num(float64(v) / 255)
+}
+
+// Text draws s with its baseline at y
+func (d *Document) Text(x, y float64, s string) {
+	d.out("BT /F%d %s Tf %s %s Td (%s) Tj ET", d.font+1, num(d.size), num(x), num(PageHeight-y), escape(encode(s)))
+}
+
+// TextWidth returns the width of s in the current font and size
+func (d *Document) TextWidth(s string) float64 {
+	widths := &helveticaWidths
+	if d.font == HelveticaBold {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, b := range encode(s) {
+		if b >= 32 && b < 127 {
+			total += int(widths[b-32])
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * d.size / 1000
+}
+
+// Line draws a line half a point wide
+func (d *Document) Line(x1, y1, x2, y2 float64) {
+	d.out("0.5 w %s %s m %s %s l S", num(x1), num(PageHeight-y1), num(x2), num(PageHeight-y2))
+}
+
+// FillRect fills a rectangle whose top left corner is at x, y
+func (d *Document) FillRect(x, y, w, h float64) {
+	d.out("%s %s %s %s re f", num(x), num(PageHeight-y-h), num(w), num(h))
+}
+
+// Image draws img in the box whose top left corner is at x, y, stretching it
+// to w by h. Images are embedded as JPEG, so transparency is lost.
+func (d *Document) Image(img image.Image, x, y, w, h float64) error {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85}); err != nil {
+		return err
+	}
+	_, gray := img.(*image.Gray)
+	b := img.Bounds()
+	d.images = append(d.images, pdfImage{data: buf.Bytes(), width: b.Dx(), height: b.Dy(), gray: gray})
+
+	d.out("q %s 0 0 %s %s %s cm /Im%d Do Q", num(w), num(h), num(x), num(PageHeight-y-h), len(d.images))
+	return nil
+}
+
+// WriteTo writes the document as a PDF file
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	if len(d.pages) == 0 {
+		d.AddPage()
+	}
+	pw := &pdfWriter{w: w}
+
+	// Objects 1 to 5 are fixed, images follow, then a page and its content for every page
+	const fixed = 5
+	firstPage := fixed + len(d.images) + 1
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+	xobjects := make([]string, len(d.images))
+	for i := range d.images {
+		xobjects[i] = fmt.Sprintf("/Im%d %d 0 R", i+1, fixed+1+i)
+	}
+	resources := fmt.Sprintf("<< /Font << /F1 3 0 R /F2 4 0 R >> /XObject << %s >> >>", strings.Join(xobjects, " "))
+
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	pw.object("<< /Type /Catalog /Pages 2 0 R >>")
+	pw.object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	pw.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	pw.object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	pw.object(fmt.Sprintf("<< /Title (%s) /Producer (reservationWeb) /CreationDate (D:%s) >>",
+		escape(encode(d.Title)), d.Created.UTC().Format("20060102150405Z")))
+
+	for _, img := range d.images {
+		space := "/DeviceRGB"
+		if img.gray {
+			space = "/DeviceGray"
+		}
+		pw.stream(fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			img.width, img.height, space), img.data)
+	}
+
+	for i, page := range d.pages {
+		pw.object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources %s /Contents %d 0 R >>",
+			num(PageWidth), num(PageHeight), resources, firstPage+2*i+1))
+
+		var content bytes.Buffer
+		zw := zlib.NewWriter(&content)
+		zw.Write(page.Bytes())
+		zw.Close()
+		pw.stream("/Filter /FlateDecode", content.Bytes())
+	}
+
+	xref := pw.n
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.offsets)+1)
+	for _, off := range pw.offsets {
+		pw.printf("%010d 00000 n \n", off)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.offsets)+1, xref)
+	return pw.n, pw.err
+}
+
+// pdfWriter writes the objects of a PDF file, keeping their offsets for the
+// cross-reference table
+type pdfWriter struct {
+	w       io.Writer
+	n       int64
+	err     error
+	offsets []int64
+}
+
+func (pw *pdfWriter) printf(format string, args ...interface{}) {
+	pw.write([]byte(fmt.Sprintf(format, args...)))
+}
+
+func (pw *pdfWriter) write(b []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(b)
+	pw.n += int64(n)
+	pw.err = err
+}
+
+func (pw *pdfWriter) object(body string) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n%s\nendobj\n", len(pw.offsets), body)
+}
+
+func (pw *pdfWriter) stream(dict string, data []byte) {
+	pw.offsets = append(pw.offsets, pw.n)
+	pw.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", len(pw.offsets), dict, len(data))
+	pw.write(data)
+	pw.printf("\nendstream\nendobj\n")
+}
+
+// num formats a number the way PDF content streams take it
+func num(v float64) string {
+	s := strconv.FormatFloat(v, 'f', 2, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "" || s == "-" {
+		return "0"
+	}
+	return s
+}
+
+// escape makes text safe inside a PDF string literal
+func escape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch c {
+		case '(', ')', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(c)
+		case '\n', '\r', '\t':
+			sb.WriteByte(' ')
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// winAnsi maps the characters of the Windows-1252 range 0x80-0x9F to their codes
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8A, '‹': 0x8B, 'Œ': 0x8C, 'Ž': 0x8E, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9A, '›': 0x9B,
+	'œ': 0x9C, 'ž': 0x9E, 'Ÿ': 0x9F,
+}
+
+// stripMarks removes accents, for letters the standard fonts don't have
+var stripMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// encode converts text to the WinAnsi encoding of the standard fonts.
+// Letters outside it lose their accents, or become ? when that isn't enough,
+// since the standard fonts have no Arabic or other scripts.
+func encode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if b, ok := encodeRune(r); ok {
+			out = append(out, b)
+			continue
+		}
+		folded, _, err := transform.String(stripMarks, string(r))
+		if err != nil {
+			folded = ""
+		}
+		encoded := false
+		for _, fr := range folded {
+			if b, ok := encodeRune(fr); ok {
+				out = append(out, b)
+				encoded = true
+			}
+		}
+		if !encoded {
+			out = append(out, '?')
+		}
+	}
+	return out
+}
+
+func encodeRune(r rune) (byte, bool) {
+	switch {
+	case r >= 32 && r < 127 || r >= 0xA0 && r <= 0xFF:
+		return byte(r), true
+	case r == '\n' || r == '\t' || r == '\r':
+		return ' ', true
+	}
+	b, ok := winAnsi[r]
+	return b, ok
+}
+
+// Widths of the printable ASCII characters, 32 to 126, in thousandths of the
+// font size, from the Adobe font metrics of the standard fonts
+var helveticaWidths = [95]uint16{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]uint16{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
@@ -0,0 +1,120 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	for in, want := range map[string]string{
+		"Plain text":      "Plain text",
+		"Zoë – café":      "Zo\xeb \x96 caf\xe9",
+		"Łódź":            "?\xf3dz",
+		"محمد":            "????",
+		"tab\there (1)\\": "tab here (1)\\",
+	} {
+		if got := string(encode(in)); got != want {
+			t.Errorf("encode(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	if got := escape([]byte(`a(b)c\`)); got != `a\(b\)c\\` {
+		t.Errorf("unexpected escape %q", got)
+	}
+}
+
+func TestTextWidth(t *testing.T) {
+	d := New()
+	d.SetFont(Helvetica, 10)
+	if w := d.TextWidth("Hello"); w != 22.78 {
+		t.Errorf("expected Hello to be 22.78 points wide, got %v", w)
+	}
+	d.SetFont(HelveticaBold, 10)
+	if w := d.TextWidth("Hello"); w != 24.45 {
+		t.Errorf("expected bold Hello to be 24.45 points wide, got %v", w)
+	}
+}
+
+func TestWriteTo(t *testing.T) {
+	d := New()
+	d.Title = "Test (document)"
+	d.AddPage()
+	d.SetFont(HelveticaBold, 14)
+	d.Text(50, 60, "First page")
+	d.Line(50, 70, 300, 70)
+	d.AddPage()
+	d.SetColor(200, 200, 200)
+	d.FillRect(50, 50, 100, 20)
+	if err := d.Image(image.NewGray(image.Rect(0, 0, 4, 3)), 50, 100, 40, 30); err != nil {
+		t.Fatal(err)
+	}
+	d.SetPage(1)
+	d.Text(500, 800, "Page 1 of 2")
+
+	var buf bytes.Buffer
+	if _, err := d.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.Bytes()
+
+	if !bytes.HasPrefix(out, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(out, []byte("%%EOF\n")) {
+		t.Fatal("expected a PDF header and trailer")
+	}
+	if !bytes.Contains(out, []byte("/Count 2")) || !bytes.Contains(out, []byte("/ColorSpace /DeviceGray")) {
+		t.Error("expected two pages and a gray image")
+	}
+	if !bytes.Contains(out, []byte(`/Title (Test \(document\))`)) {
+		t.Error("expected the escaped title")
+	}
+
+	// The cross-reference table points at every object
+	m := regexp.MustCompile(`startxref\n(\d+)\n`).FindSubmatch(out)
+	if m == nil {
+		t.Fatal("expected startxref")
+	}
+	xref, _ := strconv.Atoi(string(m[1]))
+	if !bytes.HasPrefix(out[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d doesn't point at the xref table", xref)
+	}
+	entries := regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllSubmatch(out[xref:], -1)
+	if len(entries) != 5+1+4 {
+		t.Errorf("expected 10 objects, got %d", len(entries))
+	}
+	for i, e := range entries {
+		off, _ := strconv.Atoi(string(e[1]))
+		if want := fmt.Sprintf("%d 0 obj\n", i+1); !bytes.HasPrefix(out[off:], []byte(want)) {
+			t.Errorf("xref entry %d points at %q", i+1, out[off:off+10])
+		}
+	}
+
+	// Page numbers drawn later end up on the first page
+	first := pageContent(t, out, 7)
+	if !strings.Contains(first, "(First page) Tj") || !strings.Contains(first, "(Page 1 of 2) Tj") {
+		t.Errorf("unexpected content of page 1: %q", first)
+	}
+}
+
+// pageContent inflates the content stream object n of a PDF file
+func pageContent(t *testing.T, pdf []byte, n int) string {
+	t.Helper()
+	start := bytes.Index(pdf, []byte(fmt.Sprintf("\n%d 0 obj\n", n)))
+	if start < 0 {
+		t.Fatalf("no object %d", n)
+	}
+	rest := pdf[start:]
+	begin := bytes.Index(rest, []byte("stream\n")) + len("stream\n")
+	end := bytes.Index(rest, []byte("\nendstream"))
+	zr, err := zlib.NewReader(bytes.NewReader(rest[begin:end]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(zr)
+	return string(content)
+}
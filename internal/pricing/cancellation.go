@@ -0,0 +1,45 @@
+package pricing
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// CancellationPolicy decides what guests are charged for cancelling
+type CancellationPolicy struct {
+	FreeDays   int // cancelling is free until this many days before arrival
+	FeePercent int // of the total, charged for cancelling later
+}
+
+// DefaultCancellationPolicy lets guests cancel for free until 2 days before
+// arrival, and charges the whole stay after that
+var DefaultCancellationPolicy = CancellationPolicy{FreeDays: 2, FeePercent: 100}
+
+// Deadline returns when free cancellation of a stay arriving on arrival ends
+func (p CancellationPolicy) Deadline(arrival time.Time) time.Time {
+	return arrival.AddDate(0, 0, -p.FreeDays)
+}
+
+// Fee returns what cancelling a stay arriving on arrival costs at now, as a
+// part of the stay's total rounded to the fil
+func (p CancellationPolicy) Fee(total models.Money, arrival, now time.Time) models.Money {
+	if now.Before(p.Deadline(arrival)) {
+		return 0
+	}
+	return percentOf(total, p.FeePercent)
+}
+
+// String describes the policy to guests
+func (p CancellationPolicy) String() string {
+	switch {
+	case p.FeePercent == 0:
+		return "Free cancellation until arrival."
+	case p.FreeDays == 0:
+		return fmt.Sprintf("Cancelling costs %d%% of the total.", p.FeePercent)
+	case p.FreeDays == 1:
+		return fmt.Sprintf("Free cancellation until the day before arrival, then %d%% of the total is charged.", p.FeePercent)
+	}
+	return fmt.Sprintf("Free cancellation until %d days before arrival, then %d%% of the total is charged.", p.FreeDays, p.FeePercent)
+}
@@ -0,0 +1,191 @@
+// Package pricing works out what stays cost from the rates of the rooms, rate
+// periods such as seasons, minimum stays and discount codes
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// ErrNoNights is returned for stays that don't end after they start
+var ErrNoNights = errors.New("the departure must be after the arrival")
+
+// MaxNights is the longest stay that can be priced and booked
+const MaxNights = 365
+
+// ErrTooLong is returned for stays of more than MaxNights
+var ErrTooLong = fmt.Errorf("stays are at most %d nights", MaxNights)
+
+// MinStayError is returned for stays shorter than the room or a rate period allows
+type MinStayError struct {
+	MinNights int
+	Period    string // the rate period setting the minimum, empty for the room's own
+}
+
+func (e *MinStayError) Error() string {
+	if e.Period != "" {
+		return fmt.Sprintf("stays during %s are at least %d nights", e.Period, e.MinNights)
+	}
+	return fmt.Sprintf("stays in this room are at least %d nights", e.MinNights)
+}
+
+// DiscountError is returned when a discount code can't be used for a stay
+type DiscountError struct {
+	Code   string
+	Reason string
+}
+
+func (e *DiscountError) Error() string {
+	return "discount code " + e.Code + " " + e.Reason
+}
+
+// StandardRate names the room's own rates on price lines
+const StandardRate = "Standard rate"
+
+// IsWeekend reports whether the night starting on day is charged at the weekend rate
+func IsWeekend(day time.Time) bool {
+	wd := day.Weekday()
+	return wd == time.Friday || wd == time.Saturday
+}
+
+// NormalizeCode returns a discount code the way it is stored, so guests can type it in any case
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// Quote works out the price of staying in room from start to end. periods are
+// the rate periods of the room and of all rooms, those not covering the stay
+// are ignored. code is the discount code the guest entered, or nil.
+//
+// The price is returned with the error for stays that are too short or codes
+// that can't be used, so it can still be shown to the guest.
+func Quote(room models.Room, periods []models.RatePeriod, code *models.DiscountCode, start, end time.Time) (models.Price, error) {
+	var price models.Price
+	if !end.After(start) {
+		return price, ErrNoNights
+	}
+	if end.After(start.AddDate(0, 0, MaxNights)) {
+		return price, ErrTooLong
+	}
+
+	minStay := &MinStayError{MinNights: room.MinNights}
+	lines := map[string]int{}
+	nights := 0
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		nights++
+
+		var rates *models.RatePeriod
+		for i := range periods {
+			p := &periods[i]
+			if (p.RoomID != 0 && p.RoomID != room.ID) || !p.Covers(day) {
+				continue
+			}
+			if p.MinNights > minStay.MinNights {
+				minStay.MinNights, minStay.Period = p.MinNights, p.Name
+			}
+			if (p.NightlyRate != 0 || p.Percent != 0) && (rates == nil || takesPrecedence(*p, *rates)) {
+				rates = p
+			}
+		}
+
+		rate, description := nightRate(room, rates, day)
+		key := fmt.Sprintf("%s\x00%d", description, rate)
+		i, ok := lines[key]
+		if !ok {
+			i = len(price.Lines)
+			lines[key] = i
+			price.Lines = append(price.Lines, models.PriceLine{Description: description, Rate: rate})
+		}
+		price.Lines[i].Nights++
+		price.Lines[i].Amount += rate
+		price.Subtotal += rate
+	}
+	price.Total = price.Subtotal
+
+	if nights < minStay.MinNights {
+		return price, minStay
+	}
+
+	if code != nil {
+		if reason := unusable(*code, start, nights); reason != "" {
+			return price, &DiscountError{Code: code.Code, Reason: reason}
+		}
+		discount := code.Amount
+		if code.Percent > 0 {
+			discount = percentOf(price.Subtotal, code.Percent)
+		}
+		price.DiscountCode = code.Code
+		price.Discount = min(discount, price.Subtotal)
+		price.Total = price.Subtotal - price.Discount
+	}
+	return price, nil
+}
+
+// takesPrecedence reports whether the rates of period p apply rather than those
+// of other: periods of the room before periods of all rooms, then the one
+// starting last, which is usually the shorter one
+func takesPrecedence(p, other models.RatePeriod) bool {
+	if (p.RoomID != 0) != (other.RoomID != 0) {
+		return p.RoomID != 0
+	}
+	if !p.StartDate.Equal(other.StartDate) {
+		return p.StartDate.After(other.StartDate)
+	}
+	return p.ID > other.ID
+}
+
+// nightRate returns the rate of the night starting on day and the description
+// of the line it is charged on
+func nightRate(room models.Room, p *models.RatePeriod, day time.Time) (models.Money, string) {
+	weekday, weekend := room.NightlyRate, room.WeekendRate
+	name := StandardRate
+	if p != nil {
+		name = p.Name
+		if p.NightlyRate != 0 {
+			weekday, weekend = p.NightlyRate, p.WeekendRate
+		} else {
+			weekday, weekend = adjust(weekday, p.Percent), adjust(weekend, p.Percent)
+		}
+	}
+	if weekend == 0 {
+		weekend = weekday
+	}
+	if IsWeekend(day) && weekend != weekday {
+		return weekend, name + ", weekend"
+	}
+	return weekday, name
+}
+
+// adjust adds percent to an amount, rounded to the fil
+func adjust(m models.Money, percent int) models.Money {
+	return models.Money(math.Round(float64(m) * float64(100+percent) / 100))
+}
+
+// percentOf returns percent of an amount, rounded to the fil
+func percentOf(m models.Money, percent int) models.Money {
+	return models.Money(math.Round(float64(m) * float64(percent) / 100))
+}
+
+// unusable returns why code can't be used for a stay of nights arriving on
+// start, or "" when it can. Uses are checked again when the reservation is
+// stored, as other guests may use the code in the meantime.
+func unusable(code models.DiscountCode, start time.Time, nights int) string {
+	switch {
+	case !code.Active:
+		return "is not valid"
+	case !code.ValidFrom.IsZero() && start.Before(code.ValidFrom):
+		return "is valid for arrivals from " + code.ValidFrom.Format("02 Jan 2006")
+	case !code.ValidTo.IsZero() && start.After(code.ValidTo):
+		return "was valid for arrivals until " + code.ValidTo.Format("02 Jan 2006")
+	case nights < code.MinNights:
+		return fmt.Sprintf("is valid for stays of at least %d nights", code.MinNights)
+	case code.MaxUses > 0 && code.Uses >= code.MaxUses:
+		return "has been used up"
+	}
+	return ""
+}
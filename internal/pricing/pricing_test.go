@@ -0,0 +1,154 @@
+package pricing
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+func day(d int) time.Time {
+	return time.Date(2051, 3, d, 0, 0, 0, 0, time.UTC)
+}
+
+// room is charged 500.00 a night and 600.00 on Friday and Saturday nights
+var room = models.Room{ID: 7, RoomName: "Garden Room", NightlyRate: 50000, WeekendRate: 60000, MinNights: 2}
+
+func TestQuote(t *testing.T) {
+	// Wednesday 1 March to Monday 6 March 2051 has 2 weekend nights
+	price, err := Quote(room, nil, nil, day(1), day(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.PriceLine{
+		{Description: "Standard rate", Nights: 3, Rate: 50000, Amount: 150000},
+		{Description: "Standard rate, weekend", Nights: 2, Rate: 60000, Amount: 120000},
+	}
+	if len(price.Lines) != 2 || price.Lines[0] != want[0] || price.Lines[1] != want[1] {
+		t.Errorf("unexpected lines %+v", price.Lines)
+	}
+	if price.Subtotal != 270000 || price.Total != 270000 {
+		t.Errorf("expected a total of 2,700.00, got %v", price.Total)
+	}
+
+	// A festival night of the room beats a season of all rooms, and periods
+	// of other rooms are ignored
+	periods := []models.RatePeriod{
+		{ID: 1, Name: "Spring", StartDate: day(3), EndDate: day(4), Percent: 10},
+		{ID: 2, RoomID: room.ID, Name: "Festival", StartDate: day(4), EndDate: day(4), NightlyRate: 80000},
+		{ID: 3, RoomID: 8, Name: "Other room", StartDate: day(1), EndDate: day(31), NightlyRate: 10000},
+	}
+	price, err = Quote(room, periods, nil, day(1), day(6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []models.PriceLine{
+		{Description: "Standard rate", Nights: 3, Rate: 50000, Amount: 150000},
+		{Description: "Spring, weekend", Nights: 1, Rate: 66000, Amount: 66000},
+		{Description: "Festival", Nights: 1, Rate: 80000, Amount: 80000},
+	}
+	if len(price.Lines) != 3 || price.Lines[0] != want[0] || price.Lines[1] != want[1] || price.Lines[2] != want[2] {
+		t.Errorf("unexpected lines %+v", price.Lines)
+	}
+	if price.Total != 296000 {
+		t.Errorf("expected a total of 2,960.00, got %v", price.Total)
+	}
+
+	if _, err := Quote(room, nil, nil, day(3), day(3)); err != ErrNoNights {
+		t.Errorf("expected ErrNoNights, got %v", err)
+	}
+	if _, err := Quote(room, nil, nil, day(1), day(1).AddDate(0, 0, MaxNights)); err != nil {
+		t.Errorf("expected a stay of %d nights to be priced, got %v", MaxNights, err)
+	}
+	if _, err := Quote(room, nil, nil, time.Time{}, time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)); err != ErrTooLong {
+		t.Errorf("expected ErrTooLong, got %v", err)
+	}
+}
+
+func TestQuoteMinStay(t *testing.T) {
+	var minStay *MinStayError
+	if _, err := Quote(room, nil, nil, day(1), day(2)); !errors.As(err, &minStay) || minStay.MinNights != 2 || minStay.Period != "" {
+		t.Errorf("expected the room's minimum stay, got %v", err)
+	}
+
+	periods := []models.RatePeriod{{ID: 1, Name: "Easter", StartDate: day(2), EndDate: day(2), MinNights: 4}}
+	price, err := Quote(room, periods, nil, day(1), day(3))
+	if !errors.As(err, &minStay) || minStay.MinNights != 4 || minStay.Period != "Easter" {
+		t.Errorf("expected the minimum stay of Easter, got %v", err)
+	}
+	if err.Error() != "stays during Easter are at least 4 nights" || price.Total != 100000 {
+		t.Errorf("unexpected error %q and price %v", err, price.Total)
+	}
+	// A period only setting a minimum stay keeps the room's rates
+	if len(price.Lines) != 1 || price.Lines[0].Description != StandardRate {
+		t.Errorf("unexpected lines %+v", price.Lines)
+	}
+	if _, err := Quote(room, periods, nil, day(3), day(5)); err != nil {
+		t.Errorf("expected stays after Easter to be priced, got %v", err)
+	}
+}
+
+func TestQuoteDiscount(t *testing.T) {
+	tenOff := models.DiscountCode{Code: "SPRING10", Percent: 10, Active: true, ValidTo: day(31)}
+	price, err := Quote(room, nil, &tenOff, day(1), day(6))
+	if err != nil || price.Discount != 27000 || price.Total != 243000 || price.DiscountCode != "SPRING10" {
+		t.Errorf("expected 270.00 off, got %+v (%v)", price, err)
+	}
+
+	// Fixed amounts are capped at the price
+	big := models.DiscountCode{Code: "BIG", Amount: 500000, Active: true}
+	price, err = Quote(room, nil, &big, day(1), day(3))
+	if err != nil || price.Discount != 100000 || price.Total != 0 {
+		t.Errorf("expected the stay to be free, got %+v (%v)", price, err)
+	}
+
+	for _, c := range []struct {
+		code   models.DiscountCode
+		reason string
+	}{
+		{models.DiscountCode{Code: "OFF", Percent: 10}, "is not valid"},
+		{models.DiscountCode{Code: "LATE", Percent: 10, Active: true, ValidFrom: day(10)}, "is valid for arrivals from 10 Mar 2051"},
+		{models.DiscountCode{Code: "OLD", Percent: 10, Active: true, ValidTo: day(0)}, "was valid for arrivals until 28 Feb 2051"},
+		{models.DiscountCode{Code: "LONG", Percent: 10, Active: true, MinNights: 7}, "is valid for stays of at least 7 nights"},
+		{models.DiscountCode{Code: "ONCE", Percent: 10, Active: true, MaxUses: 1, Uses: 1}, "has been used up"},
+	} {
+		var de *DiscountError
+		price, err := Quote(room, nil, &c.code, day(1), day(6))
+		if !errors.As(err, &de) || de.Reason != c.reason {
+			t.Errorf("%s: expected %q, got %v", c.code.Code, c.reason, err)
+		}
+		if price.Total != 270000 {
+			t.Errorf("%s: expected the price without discount, got %v", c.code.Code, price.Total)
+		}
+	}
+}
+
+func TestCancellationPolicy(t *testing.T) {
+	p := CancellationPolicy{FreeDays: 2, FeePercent: 50}
+	arrival := day(10)
+
+	if d := p.Deadline(arrival); !d.Equal(day(8)) {
+		t.Errorf("got deadline %v, want 8 March", d)
+	}
+	for _, c := range []struct {
+		now  time.Time
+		want models.Money
+	}{
+		{day(1), 0},
+		{day(7).Add(23 * time.Hour), 0},
+		{day(8), 50025},
+		{day(9).Add(12 * time.Hour), 50025},
+	} {
+		if fee := p.Fee(100050, arrival, c.now); fee != c.want {
+			t.Errorf("cancelling on %v: got fee %d, want %d", c.now, fee, c.want)
+		}
+	}
+
+	if s := DefaultCancellationPolicy.String(); s != "Free cancellation until 2 days before arrival, then 100% of the total is charged." {
+		t.Errorf("unexpected description %q", s)
+	}
+	if fee := (CancellationPolicy{FreeDays: 2}).Fee(100050, arrival, day(9)); fee != 0 {
+		t.Errorf("a policy without fee charged %d", fee)
+	}
+}
@@ -20,6 +20,7 @@ var functions = template.FuncMap{
 	"formatDate": FormatDate,
 	"iterate":    Iterate,
 	"add":        Add,
+	"stateLabel": models.StateLabel,
 }
 
 var app *config.AppConfig
@@ -93,7 +94,7 @@ func Templates(w http.ResponseWriter, r *http.Request, tmpl string, td *models.T
 	}
 	_, err = buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("Error writing template to browser", err)
+		app.Logger.Error("error writing template to browser", "template", tmpl, "error", err)
 		return err
 	}
 
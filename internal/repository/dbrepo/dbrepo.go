@@ -4,17 +4,29 @@ import (
 	"database/sql"
 
 	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/driver"
 	"github.com/chamrasilva89/reservationWeb/internal/repository"
 )
 
 type postgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
+
+	// Read serves list and report queries; it is the read replica when one is
+	// configured and the primary otherwise
+	Read *sql.DB
 }
 
-func NewPostgresRepo(conn *sql.DB, a *config.AppConfig) repository.DatabaseRepo {
+func NewPostgresRepo(conn *driver.DB, a *config.AppConfig) repository.DatabaseRepo {
 	return &postgresDBRepo{
-		App: a,
-		DB:  conn,
+		App:  a,
+		DB:   conn.SQL,
+		Read: conn.Reader(),
 	}
 }
+
+// NewMemoryRepo returns a repository that keeps all data in memory. It needs no
+// database and is used for tests and the demo mode.
+func NewMemoryRepo(a *config.AppConfig) (repository.DatabaseRepo, error) {
+	return newMemoryDBRepo(a)
+}
@@ -0,0 +1,2019 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"errors"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// memoryDBRepo is an in-memory implementation of repository.DatabaseRepo. It is
+// used by the handler tests and by the no-database demo mode.
+type memoryDBRepo struct {
+	App *config.AppConfig
+	mu  sync.RWMutex
+
+	rooms            map[int]models.Room
+	roomPhotos       map[int]models.RoomPhoto
+	ratePeriods      map[int]models.RatePeriod
+	discountCodes    map[int]models.DiscountCode
+	restrictions     map[int]models.Restriction
+	users            map[int]models.User
+	reservations     map[int]models.Reservation
+	paymentEvents    map[string]models.PaymentEvent
+	resHistory       map[int]models.ReservationEvent
+	roomRestrictions map[int]models.RoomRestriction
+	roomCalendars    map[int]models.RoomCalendar
+	calConflicts     map[int]models.CalendarConflict
+	customers        map[int]models.Customer
+	files            map[int]models.Attachment
+	tradeLicenses    map[int]models.TradeLicense
+	partners         map[int]models.TradeLicenseHolder
+	memorandums      map[int]models.Memorandum
+	drafts           map[int]models.CustomerDraft
+	reviews          map[int]models.KYCReview
+	reviewHistory    map[int]models.KYCReviewEvent
+	risk             map[int]models.RiskAssessment // by customer ID
+	sanctions        map[int]models.SanctionsEntry
+	hits             map[int]models.SanctionsHit
+	imports          map[int]models.CustomerImport
+
+	nextID int
+}
+
+// Credentials of the administrator seeded into every in-memory repository, so
+// the demo site can be logged into. The reviewer has the same password and can
+// approve what the administrator enters.
+const (
+	DemoUserEmail     = "admin@admin.com"
+	DemoUserPassword  = "password"
+	DemoReviewerEmail = "reviewer@admin.com"
+)
+
+// newMemoryDBRepo returns an in-memory repository seeded with the rooms and
+// restriction types of the database migrations and the demo administrator.
+func newMemoryDBRepo(a *config.AppConfig) (*memoryDBRepo, error) {
+	m := &memoryDBRepo{
+		App:              a,
+		rooms:            map[int]models.Room{},
+		roomPhotos:       map[int]models.RoomPhoto{},
+		ratePeriods:      map[int]models.RatePeriod{},
+		discountCodes:    map[int]models.DiscountCode{},
+		restrictions:     map[int]models.Restriction{},
+		users:            map[int]models.User{},
+		reservations:     map[int]models.Reservation{},
+		paymentEvents:    map[string]models.PaymentEvent{},
+		resHistory:       map[int]models.ReservationEvent{},
+		roomRestrictions: map[int]models.RoomRestriction{},
+		roomCalendars:    map[int]models.RoomCalendar{},
+		calConflicts:     map[int]models.CalendarConflict{},
+		customers:        map[int]models.Customer{},
+		files:            map[int]models.Attachment{},
+		tradeLicenses:    map[int]models.TradeLicense{},
+		partners:         map[int]models.TradeLicenseHolder{},
+		memorandums:      map[int]models.Memorandum{},
+		drafts:           map[int]models.CustomerDraft{},
+		reviews:          map[int]models.KYCReview{},
+		reviewHistory:    map[int]models.KYCReviewEvent{},
+		risk:             map[int]models.RiskAssessment{},
+		sanctions:        map[int]models.SanctionsEntry{},
+		hits:             map[int]models.SanctionsHit{},
+		imports:          map[int]models.CustomerImport{},
+		nextID:           100,
+	}
+
+	now := time.Now()
+	m.rooms[1] = models.Room{ID: 1, RoomName: "General's Quarters", Slug: "generals-quarters", Capacity: 2,
+		Description: "A quiet double room overlooking the garden, with a writing desk and a reading corner.",
+		Amenities:   []string{"Queen bed", "Private bathroom", "Wi-Fi", "Air conditioning"},
+		NightlyRate: 45000, WeekendRate: 52000, MinNights: 1, CreatedAt: now, UppdatedAt: now}
+	m.rooms[2] = models.Room{ID: 2, RoomName: "Major's Suite", Slug: "majors-suite", Capacity: 4,
+		Description: "Our largest suite, with a separate living room and a balcony facing the sea.",
+		Amenities:   []string{"King bed", "Sofa bed", "Bathtub", "Balcony", "Wi-Fi", "Air conditioning"},
+		NightlyRate: 65000, MinNights: 1, CreatedAt: now, UppdatedAt: now}
+	m.restrictions[1] = models.Restriction{ID: 1, RestrictionName: "Reservation", CreatedAt: now, UppdatedAt: now}
+	m.restrictions[2] = models.Restriction{ID: 2, RestrictionName: "Owner Block", CreatedAt: now, UppdatedAt: now}
+	m.restrictions[3] = models.Restriction{ID: 3, RestrictionName: "External", CreatedAt: now, UppdatedAt: now}
+	m.discountCodes[1] = models.DiscountCode{ID: 1, Code: "WELCOME10", Description: "10% off for new guests",
+		Percent: 10, Active: true, CreatedAt: now, UpdatedAt: now}
+
+	_, err := m.addUser(models.User{
+		FirstName:   "Admin",
+		LastName:    "User",
+		Email:       DemoUserEmail,
+		AccessLevel: models.AccessAdmin,
+	}, DemoUserPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = m.addUser(models.User{
+		FirstName:   "Review",
+		LastName:    "User",
+		Email:       DemoReviewerEmail,
+		AccessLevel: models.AccessReviewer,
+	}, DemoUserPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// newID returns the next identifier. Callers must hold the write lock.
+func (m *memoryDBRepo) newID() int {
+	m.nextID++
+	return m.nextID
+}
+
+// addUser stores a user, hashing the plain text password with bcrypt, and
+// returns the new user's ID
+func (m *memoryDBRepo) addUser(u models.User, password string) (int, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.users {
+		if existing.Email == u.Email {
+			return 0, errors.New("a user with that email already exists")
+		}
+	}
+
+	u.ID = m.newID()
+	u.Password = string(hash)
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = time.Now()
+	m.users[u.ID] = u
+
+	return u.ID, nil
+}
+
+func (m *memoryDBRepo) AllUsers() bool {
+	return true
+}
+
+// Ping always succeeds, there is no database to reach
+func (m *memoryDBRepo) Ping() error {
+	return nil
+}
+
+// InsertReservation inserts a pending reservation with its price and the room
+// restriction holding its dates, and returns its ID, counting the use of its
+// discount code. ErrNotAvailable is returned when the room is reserved or
+// blocked on the dates.
+func (m *memoryDBRepo) InsertReservation(res models.Reservation) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[res.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == res.RoomID && overlaps(r, res.StartDate, res.EndDate) {
+			return 0, repository.ErrNotAvailable
+		}
+	}
+	if res.Price.DiscountCode != "" {
+		id := m.findDiscountCode(res.Price.DiscountCode)
+		d, ok := m.discountCodes[id]
+		if !ok || d.MaxUses > 0 && d.Uses >= d.MaxUses {
+			return 0, repository.ErrDiscountUsedUp
+		}
+		d.Uses++
+		d.UpdatedAt = time.Now()
+		m.discountCodes[id] = d
+	}
+
+	res.ID = m.newID()
+	res.State = models.ReservationPending
+	res.PaymentStatus = models.PaymentPending
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	m.reservations[res.ID] = res
+	id := m.newID()
+	m.roomRestrictions[id] = models.RoomRestriction{ID: id, StartDate: res.StartDate, EndDate: res.EndDate,
+		RoomID: res.RoomID, ReservationID: res.ID, RestrictionID: models.RestrictionReservation,
+		CreatedAt: res.CreatedAt, UpdatedAt: res.UpdatedAt}
+	id = m.newID()
+	m.resHistory[id] = models.ReservationEvent{ID: id, ReservationID: res.ID, ToState: res.State,
+		Actor: models.ActorGuest, CreatedAt: res.CreatedAt}
+
+	return res.ID, nil
+}
+
+// InsertRoomRestriction inserts a room restriction
+func (m *memoryDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[r.RoomID]; !ok {
+		return errors.New("room does not exist")
+	}
+	if _, ok := m.restrictions[r.RestrictionID]; !ok {
+		return errors.New("restriction does not exist")
+	}
+
+	r.ID = m.newID()
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	m.roomRestrictions[r.ID] = r
+
+	return nil
+}
+
+// overlaps reports whether the half-open range [start, end) intersects the
+// restriction, using the same comparison as the SQL queries.
+func overlaps(r models.RoomRestriction, start, end time.Time) bool {
+	return start.Before(r.EndDate) && end.After(r.StartDate)
+}
+
+// SearchAvailabilityByDatesByRoomID returns true if the room has no restriction in the date range
+func (m *memoryDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == roomID && overlaps(r, start, end) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for given date range
+func (m *memoryDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	blocked := map[int]bool{}
+	for _, r := range m.roomRestrictions {
+		if overlaps(r, start, end) {
+			blocked[r.RoomID] = true
+		}
+	}
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		if !blocked[room.ID] {
+			rooms = append(rooms, room)
+		}
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].ID < rooms[j].ID })
+
+	return rooms, nil
+}
+
+// GetUserByID returns a user by id
+func (m *memoryDBRepo) GetUserByID(id int) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return u, sql.ErrNoRows
+	}
+	return u, nil
+}
+
+// UpdateUser updates a user
+func (m *memoryDBRepo) UpdateUser(u models.User) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	existing, ok := m.users[u.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+
+	existing.FirstName = u.FirstName
+	existing.LastName = u.LastName
+	existing.Email = u.Email
+	existing.AccessLevel = u.AccessLevel
+	existing.UpdatedAt = time.Now()
+	m.users[u.ID] = existing
+
+	return nil
+}
+
+// Authenticate authenticates a user
+func (m *memoryDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	m.mu.RLock()
+	var found *models.User
+	for _, u := range m.users {
+		if u.Email == email {
+			u := u
+			found = &u
+			break
+		}
+	}
+	m.mu.RUnlock()
+
+	if found == nil {
+		return 0, "", sql.ErrNoRows
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(found.Password), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return found.ID, found.Password, nil
+}
+
+// withRoom attaches the room to a reservation. Callers must hold the lock.
+func (m *memoryDBRepo) withRoom(res models.Reservation) models.Reservation {
+	res.Room = m.rooms[res.RoomID]
+	return res
+}
+
+// sortedReservations returns the reservations matching keep, ordered by start
+// date. Callers must hold the lock.
+func (m *memoryDBRepo) sortedReservations(keep func(models.Reservation) bool) []models.Reservation {
+	var reservations []models.Reservation
+	for _, res := range m.reservations {
+		if keep(res) {
+			reservations = append(reservations, m.withRoom(res))
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].StartDate.Equal(reservations[j].StartDate) {
+			return reservations[i].ID < reservations[j].ID
+		}
+		return reservations[i].StartDate.Before(reservations[j].StartDate)
+	})
+	return reservations
+}
+
+// AllReservations returns the reservations in state, or all of them when
+// state is empty, ordered by arrival
+func (m *memoryDBRepo) AllReservations(state string) ([]models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedReservations(func(res models.Reservation) bool { return state == "" || res.State == state }), nil
+}
+
+// GetReservationByID returns one reservation by ID
+func (m *memoryDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	res, ok := m.reservations[id]
+	if !ok {
+		return res, sql.ErrNoRows
+	}
+	return m.withRoom(res), nil
+}
+
+// GetReservationByReference returns the reservation with the reference given to the guest
+func (m *memoryDBRepo) GetReservationByReference(reference string) (models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, res := range m.reservations {
+		if res.Reference == reference {
+			return m.withRoom(res), nil
+		}
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// UpdateReservation updates a reservation's guest details
+func (m *memoryDBRepo) UpdateReservation(u models.Reservation) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[u.ID]
+	if !ok {
+		return nil
+	}
+
+	res.FirstName = u.FirstName
+	res.LastName = u.LastName
+	res.Email = u.Email
+	res.Phone = u.Phone
+	res.UpdatedAt = time.Now()
+	m.reservations[u.ID] = res
+
+	return nil
+}
+
+// DeleteReservation deletes one reservation by id, along with its room restrictions
+func (m *memoryDBRepo) DeleteReservation(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.reservations, id)
+	for rid, r := range m.roomRestrictions {
+		if r.ReservationID == id {
+			delete(m.roomRestrictions, rid)
+		}
+	}
+
+	return nil
+}
+
+// SetPaymentIntent keeps the payment intent the guest pays a reservation with.
+// An empty intentID drops the intent, a new one is made when the guest pays.
+func (m *memoryDBRepo) SetPaymentIntent(reservationID int, intentID, clientSecret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[reservationID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	res.PaymentIntentID = intentID
+	res.PaymentClientSecret = clientSecret
+	res.UpdatedAt = time.Now()
+	m.reservations[reservationID] = res
+	return nil
+}
+
+// GetReservationByPaymentIntent returns the reservation paid with a payment intent
+func (m *memoryDBRepo) GetReservationByPaymentIntent(intentID string) (models.Reservation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, res := range m.reservations {
+		if intentID != "" && res.PaymentIntentID == intentID {
+			return m.withRoom(res), nil
+		}
+	}
+	return models.Reservation{}, sql.ErrNoRows
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to other
+// dates at a new price, or returns ErrNotAvailable when the room is reserved or
+// blocked on them. A payment the guest started for the old price is dropped.
+func (m *memoryDBRepo) ChangeReservationDates(id int, start, end time.Time, price models.Price) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res, ok := m.reservations[id]
+	if !ok || res.State != models.ReservationPending && res.State != models.ReservationConfirmed {
+		return sql.ErrNoRows
+	}
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == res.RoomID && r.ReservationID != id && overlaps(r, start, end) {
+			return repository.ErrNotAvailable
+		}
+	}
+
+	now := time.Now()
+	res.StartDate, res.EndDate = start, end
+	res.Price.Lines, res.Price.Subtotal, res.Price.Discount, res.Price.Total = price.Lines, price.Subtotal, price.Discount, price.Total
+	if res.PaymentStatus == models.PaymentPending {
+		res.PaymentIntentID, res.PaymentClientSecret = "", ""
+	}
+	res.UpdatedAt = now
+	m.reservations[id] = res
+	for rid, r := range m.roomRestrictions {
+		if r.ReservationID == id {
+			r.StartDate, r.EndDate, r.UpdatedAt = start, end, now
+			m.roomRestrictions[rid] = r
+		}
+	}
+	return nil
+}
+
+// transitionReservation changes the state of a reservation from e.FromState
+// and records the change. Cancelled reservations and no-shows free their
+// dates. Callers must hold the lock.
+func (m *memoryDBRepo) transitionReservation(e models.ReservationEvent) error {
+	res, ok := m.reservations[e.ReservationID]
+	if !ok || res.State != e.FromState {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	res.State = e.ToState
+	res.UpdatedAt = now
+	if e.ToState == models.ReservationCancelled {
+		res.CancelledAt = now
+	}
+	m.reservations[res.ID] = res
+	if e.ToState == models.ReservationCancelled || e.ToState == models.ReservationNoShow {
+		for rid, r := range m.roomRestrictions {
+			if r.ReservationID == res.ID {
+				delete(m.roomRestrictions, rid)
+			}
+		}
+	}
+
+	e.ID = m.newID()
+	e.CreatedAt = now
+	m.resHistory[e.ID] = e
+	return nil
+}
+
+// TransitionReservation changes the state of a reservation from e.FromState
+// and records who changed it
+func (m *memoryDBRepo) TransitionReservation(e models.ReservationEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.transitionReservation(e)
+}
+
+// CancelReservation cancels a reservation with the fee charged for it and
+// frees its dates
+func (m *memoryDBRepo) CancelReservation(e models.ReservationEvent, fee models.Money) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e.ToState = models.ReservationCancelled
+	if err := m.transitionReservation(e); err != nil {
+		return err
+	}
+	res := m.reservations[e.ReservationID]
+	res.CancellationFee = fee
+	m.reservations[res.ID] = res
+	return nil
+}
+
+// GetReservationHistory returns the changes of state of a reservation, oldest first
+func (m *memoryDBRepo) GetReservationHistory(reservationID int) ([]models.ReservationEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []models.ReservationEvent
+	for _, e := range m.resHistory {
+		if e.ReservationID == reservationID {
+			e.User = m.reviewUser(e.UserID)
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+// ApplyPaymentEvent records a payment event and moves the payment status of
+// its reservation forward. Paying confirms pending reservations, and is recorded
+// for cancelled ones so that it can be refunded. It reports false for events that were already
+// applied or don't change the status.
+func (m *memoryDBRepo) ApplyPaymentEvent(e models.PaymentEvent) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var res models.Reservation
+	for _, r := range m.reservations {
+		if e.IntentID != "" && r.PaymentIntentID == e.IntentID {
+			res = r
+		}
+	}
+	if res.ID == 0 {
+		return false, sql.ErrNoRows
+	}
+	if _, ok := m.paymentEvents[e.ID]; ok {
+		return false, nil
+	}
+	e.CreatedAt = time.Now()
+	m.paymentEvents[e.ID] = e
+
+	switch {
+	case e.Status == models.PaymentPaid && res.PaymentStatus == models.PaymentPending:
+		res.AmountPaid = e.Amount
+	case e.Status == models.PaymentRefunded && res.PaymentStatus != models.PaymentRefunded:
+	default:
+		return false, nil
+	}
+	res.PaymentStatus = e.Status
+	res.UpdatedAt = time.Now()
+	m.reservations[res.ID] = res
+	if e.Status == models.PaymentPaid && res.State == models.ReservationPending {
+		// Paying confirms the reservation
+		err := m.transitionReservation(models.ReservationEvent{ReservationID: res.ID,
+			FromState: models.ReservationPending, ToState: models.ReservationConfirmed, Actor: models.ActorPayment})
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// InsertCustomer inserts a customer, submits it for review by submittedBy and returns its ID
+func (m *memoryDBRepo) InsertCustomer(res models.Customer, submittedBy int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res.CustomerId = m.newID()
+	res.CreatedAt = time.Now()
+	res.UpdatedAt = time.Now()
+	m.customers[res.CustomerId] = res
+	m.insertKYCReview(models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordCustomer,
+		RecordID: res.CustomerId, SubmittedBy: submittedBy}, res.CreatedAt)
+
+	return res.CustomerId, nil
+}
+
+// AllCustomers returns all customers ordered by ID
+func (m *memoryDBRepo) AllCustomers() ([]models.Customer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var customers []models.Customer
+	for _, c := range m.customers {
+		customers = append(customers, c)
+	}
+	sort.Slice(customers, func(i, j int) bool { return customers[i].CustomerId < customers[j].CustomerId })
+
+	return customers, nil
+}
+
+// InsertFile records an uploaded customer file and returns its ID, or
+// repository.ErrDuplicateFile when the customer already has the file
+func (m *memoryDBRepo) InsertFile(a models.Attachment) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if a.Checksum != "" {
+		for _, f := range m.files {
+			if f.CustomerId == a.CustomerId && f.Checksum == a.Checksum {
+				return 0, repository.ErrDuplicateFile
+			}
+		}
+	}
+
+	a.File_id = m.newID()
+	a.CreatedAt = time.Now()
+	m.files[a.File_id] = a
+
+	return a.File_id, nil
+}
+
+// GetAttachmentByChecksum returns the customer's file with the given checksum, or sql.ErrNoRows
+func (m *memoryDBRepo) GetAttachmentByChecksum(customerID int, checksum string) (models.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var found models.Attachment
+	for _, a := range m.files {
+		if a.CustomerId == customerID && a.Checksum == checksum && (found.File_id == 0 || a.File_id < found.File_id) {
+			found = a
+		}
+	}
+	if found.File_id == 0 {
+		return found, sql.ErrNoRows
+	}
+	return found, nil
+}
+
+// GetAttachmentByID returns one uploaded file by ID
+func (m *memoryDBRepo) GetAttachmentByID(id int) (models.Attachment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.files[id]
+	if !ok {
+		return a, sql.ErrNoRows
+	}
+	return a, nil
+}
+
+// GetCustomerByID returns one customer by ID
+func (m *memoryDBRepo) GetCustomerByID(id int) (models.Customer, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.customers[id]
+	if !ok {
+		return c, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+// GetAttachmentsByCustomerID returns the files uploaded for a customer. Like the
+// SQL version it returns sql.ErrNoRows when the customer has no files.
+func (m *memoryDBRepo) GetAttachmentsByCustomerID(id int) (models.CustomerImages, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var res models.CustomerImages
+	for _, a := range m.files {
+		if a.CustomerId == id {
+			res.Attachments = append(res.Attachments, a)
+		}
+	}
+	if len(res.Attachments) == 0 {
+		return res, sql.ErrNoRows
+	}
+	sort.Slice(res.Attachments, func(i, j int) bool { return res.Attachments[i].File_id < res.Attachments[j].File_id })
+
+	res.CustomerId = id
+	res.CustomerCode = res.Attachments[0].CustomerCode
+
+	return res, nil
+}
+
+// GetTradeShareInforByID returns trade license shareholder information by customer ID
+func (m *memoryDBRepo) GetTradeShareInforByID(id int) ([]models.TradeLicenseHolder, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var shareholders []models.TradeLicenseHolder
+	for _, p := range m.partners {
+		if p.CustomerId == id {
+			shareholders = append(shareholders, p)
+		}
+	}
+	sort.Slice(shareholders, func(i, j int) bool {
+		return shareholders[i].ShareHolderID < shareholders[j].ShareHolderID
+	})
+
+	return shareholders, nil
+}
+
+// GetTradeLicenseInforByID returns the trade license of a customer
+func (m *memoryDBRepo) GetTradeLicenseInforByID(id int) (models.TradeLicense, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, tl := range m.tradeLicenses {
+		if tl.CustomerId == id {
+			return tl, nil
+		}
+	}
+	return models.TradeLicense{}, sql.ErrNoRows
+}
+
+// GetTradeLicenseByLicenseID returns a trade license by its own ID
+func (m *memoryDBRepo) GetTradeLicenseByLicenseID(id int) (models.TradeLicense, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tl, ok := m.tradeLicenses[id]
+	if !ok {
+		return models.TradeLicense{}, sql.ErrNoRows
+	}
+	return tl, nil
+}
+
+// InsertTradeLicense inserts a trade license, submits it for review by submittedBy and returns its ID
+func (m *memoryDBRepo) InsertTradeLicense(res models.TradeLicense, submittedBy int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res.TradeLicenseID = m.newID()
+	m.tradeLicenses[res.TradeLicenseID] = res
+	m.insertKYCReview(models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordTradeLicense,
+		RecordID: res.TradeLicenseID, SubmittedBy: submittedBy}, time.Now())
+
+	return res.TradeLicenseID, nil
+}
+
+// GetMemorandumInforByID returns the memorandum representatives of a customer
+func (m *memoryDBRepo) GetMemorandumInforByID(id int) ([]models.Memorandum, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var memorandum []models.Memorandum
+	for _, rep := range m.memorandums {
+		if rep.CustomerId == id {
+			memorandum = append(memorandum, rep)
+		}
+	}
+	sort.Slice(memorandum, func(i, j int) bool {
+		return memorandum[i].MemorandumID < memorandum[j].MemorandumID
+	})
+
+	return memorandum, nil
+}
+
+// InsertPartner inserts a trade license shareholder, submits it for review by submittedBy and returns its ID
+func (m *memoryDBRepo) InsertPartner(res models.TradeLicenseHolder, submittedBy int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res.ShareHolderID = m.newID()
+	m.partners[res.ShareHolderID] = res
+	m.insertKYCReview(models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordPartner,
+		RecordID: res.ShareHolderID, SubmittedBy: submittedBy}, time.Now())
+
+	return res.ShareHolderID, nil
+}
+
+// GetCustomerCodeByID returns the customer code of a customer
+func (m *memoryDBRepo) GetCustomerCodeByID(id int) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.customers[id]
+	if !ok {
+		return "", sql.ErrNoRows
+	}
+	return c.CustomerCode, nil
+}
+
+// InsertMemorandum inserts a memorandum representative, submits it for review by submittedBy and returns its ID
+func (m *memoryDBRepo) InsertMemorandum(res models.Memorandum, submittedBy int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	res.MemorandumID = m.newID()
+	m.memorandums[res.MemorandumID] = res
+	m.insertKYCReview(models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordMemorandum,
+		RecordID: res.MemorandumID, SubmittedBy: submittedBy}, time.Now())
+
+	return res.MemorandumID, nil
+}
+
+// ActivateCustomer marks a customer active, unless one of its records' reviews
+// is not approved
+func (m *memoryDBRepo) ActivateCustomer(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.customers[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	for _, rv := range m.reviews {
+		if rv.CustomerID == id && rv.State != models.ReviewApproved {
+			return repository.ErrReviewsPending
+		}
+	}
+	c.Status = models.CustomerActive
+	c.UpdatedAt = time.Now()
+	m.customers[id] = c
+
+	return nil
+}
+
+// UpdateCustomerStatus sets the status of a customer
+func (m *memoryDBRepo) UpdateCustomerStatus(id int, status string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.customers[id]
+	if !ok {
+		return nil
+	}
+	c.Status = status
+	c.UpdatedAt = time.Now()
+	m.customers[id] = c
+
+	return nil
+}
+
+// findDraft returns the ID of the draft of an onboarding step, or 0. Callers must hold the lock.
+func (m *memoryDBRepo) findDraft(customerID, userID int, step string) int {
+	for id, d := range m.drafts {
+		if d.CustomerID == customerID && d.UserID == userID && d.Step == step {
+			return id
+		}
+	}
+	return 0
+}
+
+// GetCustomerDraft returns the draft of an onboarding step, or sql.ErrNoRows
+func (m *memoryDBRepo) GetCustomerDraft(customerID, userID int, step string) (models.CustomerDraft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id := m.findDraft(customerID, userID, step)
+	if id == 0 {
+		return models.CustomerDraft{}, sql.ErrNoRows
+	}
+	d := m.drafts[id]
+	d.Values = cloneValues(d.Values)
+	return d, nil
+}
+
+// GetCustomerDrafts returns the drafts of an existing customer's onboarding steps
+func (m *memoryDBRepo) GetCustomerDrafts(customerID int) ([]models.CustomerDraft, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var drafts []models.CustomerDraft
+	for _, d := range m.drafts {
+		if d.CustomerID == customerID && d.UserID == 0 {
+			d.Values = cloneValues(d.Values)
+			drafts = append(drafts, d)
+		}
+	}
+	sort.Slice(drafts, func(i, j int) bool { return drafts[i].ID < drafts[j].ID })
+
+	return drafts, nil
+}
+
+// SaveCustomerDraft stores the draft of an onboarding step, replacing the
+// previous draft of the same step
+func (m *memoryDBRepo) SaveCustomerDraft(d models.CustomerDraft) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d.Values = cloneValues(d.Values)
+	d.UpdatedAt = time.Now()
+	if id := m.findDraft(d.CustomerID, d.UserID, d.Step); id != 0 {
+		d.ID = id
+		d.CreatedAt = m.drafts[id].CreatedAt
+	} else {
+		d.ID = m.newID()
+		d.CreatedAt = d.UpdatedAt
+	}
+	m.drafts[d.ID] = d
+
+	return nil
+}
+
+// DeleteCustomerDraft deletes the draft of an onboarding step, if there is one
+func (m *memoryDBRepo) DeleteCustomerDraft(customerID, userID int, step string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.drafts, m.findDraft(customerID, userID, step))
+
+	return nil
+}
+
+// cloneValues copies form values so stored drafts don't share them with callers
+func cloneValues(v url.Values) url.Values {
+	out := make(url.Values, len(v))
+	for k, vs := range v {
+		out[k] = append([]string(nil), vs...)
+	}
+	return out
+}
+
+// insertKYCReview stores a pending review and the first entry of its history
+// and returns the review's ID. Callers must hold the lock.
+func (m *memoryDBRepo) insertKYCReview(rv models.KYCReview, now time.Time) int {
+	rv.ID = m.newID()
+	rv.State = models.ReviewPending
+	rv.ReviewedBy = 0
+	rv.Comment = ""
+	rv.CreatedAt = now
+	rv.UpdatedAt = now
+	m.reviews[rv.ID] = rv
+
+	e := models.KYCReviewEvent{ID: m.newID(), ReviewID: rv.ID, ToState: models.ReviewPending,
+		UserID: rv.SubmittedBy, CreatedAt: now}
+	m.reviewHistory[e.ID] = e
+
+	return rv.ID
+}
+
+// reviewUser returns the user shown on reviews, without the password. Callers must hold the lock.
+func (m *memoryDBRepo) reviewUser(id int) models.User {
+	u := m.users[id]
+	return models.User{ID: id, FirstName: u.FirstName, LastName: u.LastName, Email: u.Email}
+}
+
+// withNames adds the customer and user names to a review. Callers must hold the lock.
+func (m *memoryDBRepo) withNames(rv models.KYCReview) models.KYCReview {
+	rv.CustomerName = m.customers[rv.CustomerID].CustomerName
+	rv.Submitter = m.reviewUser(rv.SubmittedBy)
+	rv.Reviewer = m.reviewUser(rv.ReviewedBy)
+	return rv
+}
+
+// GetKYCReviewByID returns a review, or sql.ErrNoRows
+func (m *memoryDBRepo) GetKYCReviewByID(id int) (models.KYCReview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	rv, ok := m.reviews[id]
+	if !ok {
+		return models.KYCReview{}, sql.ErrNoRows
+	}
+	return m.withNames(rv), nil
+}
+
+// GetKYCReviewsByCustomerID returns the reviews of a customer's records, oldest first
+func (m *memoryDBRepo) GetKYCReviewsByCustomerID(customerID int) ([]models.KYCReview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reviews []models.KYCReview
+	for _, rv := range m.reviews {
+		if rv.CustomerID == customerID {
+			reviews = append(reviews, m.withNames(rv))
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].ID < reviews[j].ID })
+
+	return reviews, nil
+}
+
+// PendingKYCReviews returns the reviews waiting for a reviewer, oldest first
+func (m *memoryDBRepo) PendingKYCReviews() ([]models.KYCReview, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var reviews []models.KYCReview
+	for _, rv := range m.reviews {
+		if rv.State == models.ReviewPending {
+			reviews = append(reviews, m.withNames(rv))
+		}
+	}
+	sort.Slice(reviews, func(i, j int) bool { return reviews[i].ID < reviews[j].ID })
+
+	return reviews, nil
+}
+
+// UpdateKYCReviewState moves a review from e.FromState to e.ToState and records
+// the change in its history, or returns sql.ErrNoRows when the review is not in
+// e.FromState
+func (m *memoryDBRepo) UpdateKYCReviewState(e models.KYCReviewEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rv, ok := m.reviews[e.ReviewID]
+	if !ok || rv.State != e.FromState {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	rv.State = e.ToState
+	rv.Comment = e.Comment
+	rv.UpdatedAt = now
+	if e.ToState == models.ReviewPending {
+		rv.SubmittedBy = e.UserID
+		rv.ReviewedBy = 0
+	} else {
+		rv.ReviewedBy = e.UserID
+	}
+	m.reviews[rv.ID] = rv
+
+	e.ID = m.newID()
+	e.CreatedAt = now
+	m.reviewHistory[e.ID] = e
+
+	return nil
+}
+
+// GetKYCReviewHistory returns the changes of state of a review, oldest first
+func (m *memoryDBRepo) GetKYCReviewHistory(reviewID int) ([]models.KYCReviewEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []models.KYCReviewEvent
+	for _, e := range m.reviewHistory {
+		if e.ReviewID == reviewID {
+			e.User = m.reviewUser(e.UserID)
+			events = append(events, e)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+
+	return events, nil
+}
+
+// SaveRiskAssessment stores the risk rating of a customer, replacing the previous one
+func (m *memoryDBRepo) SaveRiskAssessment(a models.RiskAssessment) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	a.Factors = append([]models.RiskFactor(nil), a.Factors...)
+	m.risk[a.CustomerID] = a
+
+	return nil
+}
+
+// GetRiskAssessment returns the risk rating of a customer, or sql.ErrNoRows
+// when the customer was not assessed yet
+func (m *memoryDBRepo) GetRiskAssessment(customerID int) (models.RiskAssessment, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	a, ok := m.risk[customerID]
+	if !ok {
+		return models.RiskAssessment{}, sql.ErrNoRows
+	}
+	a.Factors = append([]models.RiskFactor(nil), a.Factors...)
+	return a, nil
+}
+
+// ReplaceSanctionsEntries replaces the entries of a sanctions list with a newly
+// imported version of it
+func (m *memoryDBRepo) ReplaceSanctionsEntries(source string, entries []models.SanctionsEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, e := range m.sanctions {
+		if e.Source == source {
+			delete(m.sanctions, id)
+		}
+	}
+
+	now := time.Now()
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		if seen[e.ExternalID] {
+			continue
+		}
+		seen[e.ExternalID] = true
+		e.ID = m.newID()
+		e.Source = source
+		e.Aliases = append([]string(nil), e.Aliases...)
+		e.CreatedAt = now
+		m.sanctions[e.ID] = e
+	}
+
+	return nil
+}
+
+// AllSanctionsEntries returns the entries of every imported sanctions list
+func (m *memoryDBRepo) AllSanctionsEntries() ([]models.SanctionsEntry, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []models.SanctionsEntry
+	for _, e := range m.sanctions {
+		e.Aliases = append([]string(nil), e.Aliases...)
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].ID < entries[j].ID })
+
+	return entries, nil
+}
+
+// SanctionsLists sums up the imported sanctions lists
+func (m *memoryDBRepo) SanctionsLists() ([]models.SanctionsList, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	bySource := make(map[string]*models.SanctionsList)
+	for _, e := range m.sanctions {
+		l, ok := bySource[e.Source]
+		if !ok {
+			l = &models.SanctionsList{Source: e.Source}
+			bySource[e.Source] = l
+		}
+		l.Entries++
+		if e.CreatedAt.After(l.ImportedAt) {
+			l.ImportedAt = e.CreatedAt
+		}
+	}
+
+	var lists []models.SanctionsList
+	for _, l := range bySource {
+		lists = append(lists, *l)
+	}
+	sort.Slice(lists, func(i, j int) bool { return lists[i].Source < lists[j].Source })
+
+	return lists, nil
+}
+
+// ScreeningSubjects returns every shareholder and representative, to be
+// screened against the sanctions lists
+func (m *memoryDBRepo) ScreeningSubjects() ([]models.ScreeningSubject, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var subjects []models.ScreeningSubject
+	for _, p := range m.partners {
+		subjects = append(subjects, models.ScreeningSubject{CustomerID: p.CustomerId,
+			SubjectType: models.RecordPartner, SubjectID: p.ShareHolderID, Name: p.ShareHolderName})
+	}
+	for _, rep := range m.memorandums {
+		subjects = append(subjects, models.ScreeningSubject{CustomerID: rep.CustomerId,
+			SubjectType: models.RecordMemorandum, SubjectID: rep.MemorandumID, Name: rep.RepresentativeName})
+	}
+	sort.Slice(subjects, func(i, j int) bool {
+		a, b := subjects[i], subjects[j]
+		if a.CustomerID != b.CustomerID {
+			return a.CustomerID < b.CustomerID
+		}
+		if a.SubjectType != b.SubjectType {
+			return a.SubjectType < b.SubjectType
+		}
+		return a.SubjectID < b.SubjectID
+	})
+
+	return subjects, nil
+}
+
+// InsertSanctionsHit records a hit and returns its ID, or 0 when the subject
+// was already found to match the same entry
+func (m *memoryDBRepo) InsertSanctionsHit(h models.SanctionsHit) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.hits {
+		if existing.SubjectType == h.SubjectType && existing.SubjectID == h.SubjectID &&
+			existing.Source == h.Source && existing.ExternalID == h.ExternalID {
+			return 0, nil
+		}
+	}
+
+	now := time.Now()
+	h.ID = m.newID()
+	h.State = models.HitPending
+	h.ReviewedBy = 0
+	h.Comment = ""
+	h.CreatedAt = now
+	h.UpdatedAt = now
+	m.hits[h.ID] = h
+
+	return h.ID, nil
+}
+
+// withHitNames adds the customer and reviewer names to a hit. Callers must hold the lock.
+func (m *memoryDBRepo) withHitNames(h models.SanctionsHit) models.SanctionsHit {
+	h.CustomerName = m.customers[h.CustomerID].CustomerName
+	h.Reviewer = m.reviewUser(h.ReviewedBy)
+	return h
+}
+
+// GetSanctionsHitByID returns a hit, or sql.ErrNoRows
+func (m *memoryDBRepo) GetSanctionsHitByID(id int) (models.SanctionsHit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h, ok := m.hits[id]
+	if !ok {
+		return models.SanctionsHit{}, sql.ErrNoRows
+	}
+	return m.withHitNames(h), nil
+}
+
+// sortHits puts the best matches first
+func sortHits(hits []models.SanctionsHit) {
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
+
+// SanctionsHits returns the hits in a state, or all of them when state is
+// empty, the best matches first
+func (m *memoryDBRepo) SanctionsHits(state string) ([]models.SanctionsHit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hits []models.SanctionsHit
+	for _, h := range m.hits {
+		if state == "" || h.State == state {
+			hits = append(hits, m.withHitNames(h))
+		}
+	}
+	sortHits(hits)
+
+	return hits, nil
+}
+
+// GetSanctionsHitsByCustomerID returns the hits of a customer's shareholders
+// and representatives, the best matches first
+func (m *memoryDBRepo) GetSanctionsHitsByCustomerID(customerID int) ([]models.SanctionsHit, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var hits []models.SanctionsHit
+	for _, h := range m.hits {
+		if h.CustomerID == customerID {
+			hits = append(hits, m.withHitNames(h))
+		}
+	}
+	sortHits(hits)
+
+	return hits, nil
+}
+
+// UpdateSanctionsHitState confirms or dismisses a pending hit. It returns
+// sql.ErrNoRows when the hit is not pending.
+func (m *memoryDBRepo) UpdateSanctionsHitState(id int, state string, userID int, comment string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, ok := m.hits[id]
+	if !ok || h.State != models.HitPending {
+		return sql.ErrNoRows
+	}
+	h.State = state
+	h.ReviewedBy = userID
+	h.Comment = comment
+	h.UpdatedAt = time.Now()
+	m.hits[id] = h
+
+	return nil
+}
+
+// filteredCustomers returns the customers f matches, ordered by code. Callers must hold the lock.
+func (m *memoryDBRepo) filteredCustomers(f models.CustomerFilter) []models.Customer {
+	var customers []models.Customer
+	for _, c := range m.customers {
+		if f.Matches(c) {
+			customers = append(customers, c)
+		}
+	}
+	sort.Slice(customers, func(i, j int) bool {
+		if customers[i].CustomerCode != customers[j].CustomerCode {
+			return customers[i].CustomerCode < customers[j].CustomerCode
+		}
+		return customers[i].CustomerId < customers[j].CustomerId
+	})
+	return customers
+}
+
+// EachCustomer calls fn with every customer f matches, ordered by code,
+// stopping at the first error fn returns
+func (m *memoryDBRepo) EachCustomer(f models.CustomerFilter, fn func(models.Customer) error) error {
+	m.mu.RLock()
+	customers := m.filteredCustomers(f)
+	m.mu.RUnlock()
+
+	for _, c := range customers {
+		if err := fn(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachTradeLicense calls fn with the trade license of every customer f
+// matches, ordered by customer code, stopping at the first error fn returns
+func (m *memoryDBRepo) EachTradeLicense(f models.CustomerFilter, fn func(models.TradeLicense) error) error {
+	m.mu.RLock()
+	var licenses []models.TradeLicense
+	for _, c := range m.filteredCustomers(f) {
+		for _, t := range m.tradeLicenses {
+			if t.CustomerId == c.CustomerId {
+				t.CustomerCode = c.CustomerCode
+				licenses = append(licenses, t)
+			}
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, t := range licenses {
+		if err := fn(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachShareholder calls fn with the shareholders of every customer f matches,
+// ordered by customer code, stopping at the first error fn returns
+func (m *memoryDBRepo) EachShareholder(f models.CustomerFilter, fn func(models.TradeLicenseHolder) error) error {
+	m.mu.RLock()
+	var shareholders []models.TradeLicenseHolder
+	for _, c := range m.filteredCustomers(f) {
+		var own []models.TradeLicenseHolder
+		for _, s := range m.partners {
+			if s.CustomerId == c.CustomerId {
+				s.CustomerCode = c.CustomerCode
+				s.CustomerName = c.CustomerName
+				own = append(own, s)
+			}
+		}
+		sort.Slice(own, func(i, j int) bool { return own[i].ShareHolderID < own[j].ShareHolderID })
+		shareholders = append(shareholders, own...)
+	}
+	m.mu.RUnlock()
+
+	for _, s := range shareholders {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EachMemorandum calls fn with the memorandum representatives of every
+// customer f matches, ordered by customer code, stopping at the first error
+// fn returns
+func (m *memoryDBRepo) EachMemorandum(f models.CustomerFilter, fn func(models.Memorandum) error) error {
+	m.mu.RLock()
+	var representatives []models.Memorandum
+	for _, c := range m.filteredCustomers(f) {
+		var own []models.Memorandum
+		for _, r := range m.memorandums {
+			if r.CustomerId == c.CustomerId {
+				r.CustomerCode = c.CustomerCode
+				own = append(own, r)
+			}
+		}
+		sort.Slice(own, func(i, j int) bool { return own[i].MemorandumID < own[j].MemorandumID })
+		representatives = append(representatives, own...)
+	}
+	m.mu.RUnlock()
+
+	for _, r := range representatives {
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetCustomerIDByCode returns the ID of the customer with a customer code
+func (m *memoryDBRepo) GetCustomerIDByCode(code string) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, c := range m.customers {
+		if c.CustomerCode == code {
+			return c.CustomerId, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+// InsertCustomerImport stores an uploaded spreadsheet pending import and returns its ID
+func (m *memoryDBRepo) InsertCustomerImport(ci models.CustomerImport) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	ci.ID = m.newID()
+	ci.State = models.ImportPending
+	ci.Report = nil
+	ci.CreatedAt = now
+	ci.UpdatedAt = now
+	m.imports[ci.ID] = ci
+
+	return ci.ID, nil
+}
+
+// GetCustomerImportByID returns an import with its rows, mapping and report
+func (m *memoryDBRepo) GetCustomerImportByID(id int) (models.CustomerImport, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	ci, ok := m.imports[id]
+	if !ok {
+		return ci, sql.ErrNoRows
+	}
+	return ci, nil
+}
+
+// UpdateCustomerImportMapping sets which column each field of a pending import
+// is read from. It returns sql.ErrNoRows when the import is not pending.
+func (m *memoryDBRepo) UpdateCustomerImportMapping(id int, mapping map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ci, ok := m.imports[id]
+	if !ok || ci.State != models.ImportPending {
+		return sql.ErrNoRows
+	}
+	ci.Mapping = mapping
+	ci.UpdatedAt = time.Now()
+	m.imports[id] = ci
+
+	return nil
+}
+
+// CommitCustomerImport stores the valid rows of a pending import, submits them
+// for review by submittedBy and keeps rows as the import's report. The IDs of
+// the stored records are set in rows. It returns sql.ErrNoRows when the import
+// is not pending.
+func (m *memoryDBRepo) CommitCustomerImport(id int, rows []models.ImportRow, submittedBy int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ci, ok := m.imports[id]
+	if !ok || ci.State != models.ImportPending {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	for i, row := range rows {
+		if !row.Valid() {
+			continue
+		}
+		var recordType string
+		switch {
+		case row.Customer != nil:
+			c := *row.Customer
+			c.CustomerId = m.newID()
+			c.CreatedAt, c.UpdatedAt = now, now
+			m.customers[c.CustomerId] = c
+			rows[i].RecordID, rows[i].CustomerID = c.CustomerId, c.CustomerId
+			recordType = models.RecordCustomer
+		case row.TradeLicense != nil:
+			tl := *row.TradeLicense
+			tl.TradeLicenseID = m.newID()
+			tl.CreatedAt, tl.UpdatedAt = now, now
+			m.tradeLicenses[tl.TradeLicenseID] = tl
+			rows[i].RecordID = tl.TradeLicenseID
+			recordType = models.RecordTradeLicense
+		case row.Shareholder != nil:
+			sh := *row.Shareholder
+			sh.ShareHolderID = m.newID()
+			sh.CreatedAt, sh.UpdatedAt = now, now
+			m.partners[sh.ShareHolderID] = sh
+			rows[i].RecordID = sh.ShareHolderID
+			recordType = models.RecordPartner
+		}
+		m.insertKYCReview(models.KYCReview{CustomerID: rows[i].CustomerID, RecordType: recordType,
+			RecordID: rows[i].RecordID, SubmittedBy: submittedBy}, now)
+	}
+
+	ci.State = models.ImportCommitted
+	ci.Report = rows
+	ci.UpdatedAt = now
+	m.imports[id] = ci
+
+	return nil
+}
+
+// AllRooms returns all rooms by name, without their photos
+func (m *memoryDBRepo) AllRooms() ([]models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rooms []models.Room
+	for _, room := range m.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].RoomName < rooms[j].RoomName })
+	return rooms, nil
+}
+
+// withPhotos attaches the photos to a room. Callers must hold the lock.
+func (m *memoryDBRepo) withPhotos(room models.Room) models.Room {
+	room.Photos = nil
+	for _, p := range m.roomPhotos {
+		if p.RoomID == room.ID {
+			room.Photos = append(room.Photos, p)
+		}
+	}
+	sort.Slice(room.Photos, func(i, j int) bool {
+		if room.Photos[i].Position == room.Photos[j].Position {
+			return room.Photos[i].ID < room.Photos[j].ID
+		}
+		return room.Photos[i].Position < room.Photos[j].Position
+	})
+	return room
+}
+
+// GetRoomByID returns a room with its photos
+func (m *memoryDBRepo) GetRoomByID(id int) (models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	room, ok := m.rooms[id]
+	if !ok {
+		return room, sql.ErrNoRows
+	}
+	return m.withPhotos(room), nil
+}
+
+// GetRoomBySlug returns the room whose page is /rooms/{slug}, with its photos
+func (m *memoryDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, room := range m.rooms {
+		if room.Slug == slug {
+			return m.withPhotos(room), nil
+		}
+	}
+	return models.Room{}, sql.ErrNoRows
+}
+
+// slugTaken reports whether another room than id has slug. Callers must hold the lock.
+func (m *memoryDBRepo) slugTaken(slug string, id int) bool {
+	for _, room := range m.rooms {
+		if room.Slug == slug && room.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertRoom inserts a room and returns its ID
+func (m *memoryDBRepo) InsertRoom(room models.Room) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.slugTaken(room.Slug, 0) {
+		return 0, errors.New("a room with that slug already exists")
+	}
+	room.ID = m.newID()
+	room.Photos = nil
+	room.CreatedAt = time.Now()
+	room.UppdatedAt = room.CreatedAt
+	m.rooms[room.ID] = room
+	return room.ID, nil
+}
+
+// UpdateRoom updates the details of a room, leaving its photos alone
+func (m *memoryDBRepo) UpdateRoom(room models.Room) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	old, ok := m.rooms[room.ID]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if m.slugTaken(room.Slug, room.ID) {
+		return errors.New("a room with that slug already exists")
+	}
+	room.Photos = nil
+	room.CreatedAt = old.CreatedAt
+	room.UppdatedAt = time.Now()
+	m.rooms[room.ID] = room
+	return nil
+}
+
+// DeleteRoom deletes a room with its photos and rate periods. Rooms that were
+// ever reserved or blocked are kept, as deleting them would delete the
+// reservations.
+func (m *memoryDBRepo) DeleteRoom(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[id]; !ok {
+		return sql.ErrNoRows
+	}
+	for _, res := range m.reservations {
+		if res.RoomID == id {
+			return repository.ErrRoomInUse
+		}
+	}
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == id {
+			return repository.ErrRoomInUse
+		}
+	}
+
+	delete(m.rooms, id)
+	for pid, p := range m.roomPhotos {
+		if p.RoomID == id {
+			delete(m.roomPhotos, pid)
+		}
+	}
+	for pid, p := range m.ratePeriods {
+		if p.RoomID == id {
+			delete(m.ratePeriods, pid)
+		}
+	}
+	for cid, c := range m.roomCalendars {
+		if c.RoomID == id {
+			m.deleteRoomCalendar(cid)
+		}
+	}
+	return nil
+}
+
+// InsertRoomPhoto records a photo of a room, after the room's other photos
+func (m *memoryDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[p.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+	p.Position = 1
+	for _, other := range m.roomPhotos {
+		if other.RoomID == p.RoomID && other.Position >= p.Position {
+			p.Position = other.Position + 1
+		}
+	}
+	p.ID = m.newID()
+	p.CreatedAt = time.Now()
+	m.roomPhotos[p.ID] = p
+	return p.ID, nil
+}
+
+// GetRoomPhotoByID returns one photo of a room
+func (m *memoryDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	p, ok := m.roomPhotos[id]
+	if !ok {
+		return p, sql.ErrNoRows
+	}
+	return p, nil
+}
+
+// DeleteRoomPhoto deletes the record of a photo, the caller removes its file
+func (m *memoryDBRepo) DeleteRoomPhoto(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roomPhotos[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.roomPhotos, id)
+	return nil
+}
+
+// RoomRestrictions returns the restrictions of a room that end after from, by
+// start date
+func (m *memoryDBRepo) RoomRestrictions(roomID int, from time.Time) ([]models.RoomRestriction, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var restrictions []models.RoomRestriction
+	for _, r := range m.roomRestrictions {
+		if r.RoomID == roomID && r.EndDate.After(from) {
+			r.Restriction = m.restrictions[r.RestrictionID]
+			restrictions = append(restrictions, r)
+		}
+	}
+	sort.Slice(restrictions, func(i, j int) bool {
+		if restrictions[i].StartDate.Equal(restrictions[j].StartDate) {
+			return restrictions[i].ID < restrictions[j].ID
+		}
+		return restrictions[i].StartDate.Before(restrictions[j].StartDate)
+	})
+	return restrictions, nil
+}
+
+// roomCalendar returns a calendar with the name of its room and the
+// conflicts of its last import. Callers must hold the lock.
+func (m *memoryDBRepo) roomCalendar(c models.RoomCalendar) models.RoomCalendar {
+	c.Room = models.Room{ID: c.RoomID, RoomName: m.rooms[c.RoomID].RoomName, Slug: m.rooms[c.RoomID].Slug}
+	c.Conflicts = nil
+	for _, cf := range m.calConflicts {
+		if cf.CalendarID == c.ID {
+			c.Conflicts = append(c.Conflicts, cf)
+		}
+	}
+	sort.Slice(c.Conflicts, func(i, j int) bool { return c.Conflicts[i].ID < c.Conflicts[j].ID })
+	return c
+}
+
+// RoomCalendars returns the external calendars of a room, or of every room
+// when roomID is 0, with the conflicts of their last import
+func (m *memoryDBRepo) RoomCalendars(roomID int) ([]models.RoomCalendar, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var calendars []models.RoomCalendar
+	for _, c := range m.roomCalendars {
+		if roomID == 0 || c.RoomID == roomID {
+			calendars = append(calendars, m.roomCalendar(c))
+		}
+	}
+	sort.Slice(calendars, func(i, j int) bool { return calendars[i].ID < calendars[j].ID })
+	return calendars, nil
+}
+
+// GetRoomCalendar returns an external calendar by ID
+func (m *memoryDBRepo) GetRoomCalendar(id int) (models.RoomCalendar, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.roomCalendars[id]
+	if !ok {
+		return c, sql.ErrNoRows
+	}
+	return m.roomCalendar(c), nil
+}
+
+// InsertRoomCalendar adds an external calendar to a room and returns its ID
+func (m *memoryDBRepo) InsertRoomCalendar(c models.RoomCalendar) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[c.RoomID]; !ok {
+		return 0, errors.New("room does not exist")
+	}
+	c.ID = m.newID()
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = c.CreatedAt
+	c.Conflicts = nil
+	m.roomCalendars[c.ID] = c
+	return c.ID, nil
+}
+
+// deleteRoomCalendar removes a calendar with the dates it blocked and its
+// conflicts. Callers must hold the write lock.
+func (m *memoryDBRepo) deleteRoomCalendar(id int) {
+	delete(m.roomCalendars, id)
+	for rid, r := range m.roomRestrictions {
+		if r.CalendarID == id {
+			delete(m.roomRestrictions, rid)
+		}
+	}
+	for cid, c := range m.calConflicts {
+		if c.CalendarID == id {
+			delete(m.calConflicts, cid)
+		}
+	}
+}
+
+// DeleteRoomCalendar removes an external calendar and frees the dates it blocked
+func (m *memoryDBRepo) DeleteRoomCalendar(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.roomCalendars[id]; !ok {
+		return sql.ErrNoRows
+	}
+	m.deleteRoomCalendar(id)
+	return nil
+}
+
+// ImportRoomCalendar replaces the external restrictions of a calendar with
+// its events and returns the events overlapping other restrictions of the
+// room, which are kept as the calendar's conflicts
+func (m *memoryDBRepo) ImportRoomCalendar(calendarID int, events []models.CalendarEvent) ([]models.CalendarConflict, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.roomCalendars[calendarID]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	for rid, r := range m.roomRestrictions {
+		if r.CalendarID == calendarID {
+			delete(m.roomRestrictions, rid)
+		}
+	}
+	for cid, cf := range m.calConflicts {
+		if cf.CalendarID == calendarID {
+			delete(m.calConflicts, cid)
+		}
+	}
+
+	now := time.Now()
+	var conflicts []models.CalendarConflict
+	for _, e := range events {
+		var overlapped []models.RoomRestriction
+		for _, r := range m.roomRestrictions {
+			if r.RoomID == c.RoomID && r.CalendarID != calendarID && overlaps(r, e.StartDate, e.EndDate) {
+				overlapped = append(overlapped, r)
+			}
+		}
+		sort.Slice(overlapped, func(i, j int) bool { return overlapped[i].ID < overlapped[j].ID })
+		for _, r := range overlapped {
+			cf := models.CalendarConflict{ID: m.newID(), CalendarID: calendarID, Event: e,
+				RestrictionID: r.RestrictionID, ReservationID: r.ReservationID, CreatedAt: now}
+			m.calConflicts[cf.ID] = cf
+			conflicts = append(conflicts, cf)
+		}
+	}
+	for _, e := range events {
+		id := m.newID()
+		m.roomRestrictions[id] = models.RoomRestriction{ID: id, StartDate: e.StartDate, EndDate: e.EndDate,
+			RoomID: c.RoomID, RestrictionID: models.RestrictionExternal, CalendarID: calendarID,
+			CreatedAt: now, UpdatedAt: now}
+	}
+
+	c.LastImportedAt, c.LastError, c.Blocks, c.UpdatedAt = now, "", len(events), now
+	m.roomCalendars[calendarID] = c
+	return conflicts, nil
+}
+
+// SetRoomCalendarError records why the last import of a calendar failed,
+// keeping the dates it blocked before
+func (m *memoryDBRepo) SetRoomCalendarError(id int, msg string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	c, ok := m.roomCalendars[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	c.LastError, c.UpdatedAt = msg, time.Now()
+	m.roomCalendars[id] = c
+	return nil
+}
+
+// AllRatePeriods returns all rate periods by start date, with the names of their rooms
+func (m *memoryDBRepo) AllRatePeriods() ([]models.RatePeriod, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedRatePeriods(func(models.RatePeriod) bool { return true }), nil
+}
+
+// RatePeriodsForRoom returns the rate periods of a room and of all rooms that
+// have nights from start to end
+func (m *memoryDBRepo) RatePeriodsForRoom(roomID int, start, end time.Time) ([]models.RatePeriod, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.sortedRatePeriods(func(p models.RatePeriod) bool {
+		return (p.RoomID == roomID || p.RoomID == 0) && p.StartDate.Before(end) && !p.EndDate.Before(start)
+	}), nil
+}
+
+// sortedRatePeriods returns the rate periods matching keep by start date.
+// Callers must hold the lock.
+func (m *memoryDBRepo) sortedRatePeriods(keep func(models.RatePeriod) bool) []models.RatePeriod {
+	var periods []models.RatePeriod
+	for _, p := range m.ratePeriods {
+		if keep(p) {
+			p.Room = models.Room{ID: p.RoomID, RoomName: m.rooms[p.RoomID].RoomName}
+			periods = append(periods, p)
+		}
+	}
+	sort.Slice(periods, func(i, j int) bool {
+		if periods[i].StartDate.Equal(periods[j].StartDate) {
+			return periods[i].ID < periods[j].ID
+		}
+		return periods[i].StartDate.Before(periods[j].StartDate)
+	})
+	return periods
+}
+
+// InsertRatePeriod inserts a rate period and returns its ID
+func (m *memoryDBRepo) InsertRatePeriod(p models.RatePeriod) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rooms[p.RoomID]; p.RoomID != 0 && !ok {
+		return 0, errors.New("room does not exist")
+	}
+
+	p.ID = m.newID()
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = p.CreatedAt
+	m.ratePeriods[p.ID] = p
+	return p.ID, nil
+}
+
+// DeleteRatePeriod deletes a rate period
+func (m *memoryDBRepo) DeleteRatePeriod(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.ratePeriods[id]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.ratePeriods, id)
+	return nil
+}
+
+// findDiscountCode returns the ID of a discount code, 0 when there is none.
+// Callers must hold the lock.
+func (m *memoryDBRepo) findDiscountCode(code string) int {
+	for id, d := range m.discountCodes {
+		if d.Code == code {
+			return id
+		}
+	}
+	return 0
+}
+
+// AllDiscountCodes returns all discount codes in alphabetical order
+func (m *memoryDBRepo) AllDiscountCodes() ([]models.DiscountCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var codes []models.DiscountCode
+	for _, d := range m.discountCodes {
+		codes = append(codes, d)
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].Code < codes[j].Code })
+	return codes, nil
+}
+
+// GetDiscountCode returns the discount code a guest entered, already normalized
+func (m *memoryDBRepo) GetDiscountCode(code string) (models.DiscountCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	d, ok := m.discountCodes[m.findDiscountCode(code)]
+	if !ok {
+		return d, sql.ErrNoRows
+	}
+	return d, nil
+}
+
+// InsertDiscountCode inserts a discount code and returns its ID
+func (m *memoryDBRepo) InsertDiscountCode(d models.DiscountCode) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findDiscountCode(d.Code) != 0 {
+		return 0, errors.New("a discount code with that code already exists")
+	}
+
+	d.ID = m.newID()
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = d.CreatedAt
+	m.discountCodes[d.ID] = d
+	return d.ID, nil
+}
+
+// SetDiscountCodeActive turns a discount code on or off
+func (m *memoryDBRepo) SetDiscountCodeActive(id int, active bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	d, ok := m.discountCodes[id]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	d.Active = active
+	d.UpdatedAt = time.Now()
+	m.discountCodes[id] = d
+	return nil
+}
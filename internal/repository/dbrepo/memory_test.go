@@ -0,0 +1,223 @@
+package dbrepo
+
+import (
+	"database/sql"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+)
+
+func newTestMemoryRepo(t *testing.T) *memoryDBRepo {
+	m, err := newMemoryDBRepo(&config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func day(d int) time.Time {
+	return time.Date(2050, time.January, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestMemoryRepo_Availability(t *testing.T) {
+	m := newTestMemoryRepo(t)
+
+	err := m.InsertRoomRestriction(models.RoomRestriction{
+		StartDate:     day(10),
+		EndDate:       day(12),
+		RoomID:        1,
+		RestrictionID: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name      string
+		start     time.Time
+		end       time.Time
+		available bool
+	}{
+		{"before", day(8), day(10), true},
+		{"after", day(12), day(14), true},
+		{"overlap start", day(9), day(11), false},
+		{"overlap end", day(11), day(13), false},
+		{"inside", day(10), day(11), false},
+		{"around", day(9), day(13), false},
+	}
+
+	for _, e := range tests {
+		ok, err := m.SearchAvailabilityByDatesByRoomID(e.start, e.end, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != e.available {
+			t.Errorf("%s: expected available %v but got %v", e.name, e.available, ok)
+		}
+
+		rooms, _ := m.SearchAvailabilityForAllRooms(e.start, e.end)
+		if e.available && len(rooms) != 2 {
+			t.Errorf("%s: expected 2 free rooms but got %d", e.name, len(rooms))
+		}
+		if !e.available && (len(rooms) != 1 || rooms[0].ID != 2) {
+			t.Errorf("%s: expected only room 2 to be free but got %v", e.name, rooms)
+		}
+	}
+
+	if err := m.InsertRoomRestriction(models.RoomRestriction{RoomID: 99, RestrictionID: 1}); err == nil {
+		t.Error("expected an error for a room that does not exist")
+	}
+}
+
+func TestMemoryRepo_Authenticate(t *testing.T) {
+	m := newTestMemoryRepo(t)
+
+	id, _, err := m.Authenticate(DemoUserEmail, DemoUserPassword)
+	if err != nil || id == 0 {
+		t.Fatalf("expected the demo user to log in, got id %d and error %v", id, err)
+	}
+
+	if _, _, err := m.Authenticate(DemoUserEmail, "wrong"); err == nil {
+		t.Error("expected an error for a wrong password")
+	}
+
+	if _, _, err := m.Authenticate("nobody@example.com", DemoUserPassword); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown user but got %v", err)
+	}
+}
+
+func TestMemoryRepo_Customers(t *testing.T) {
+	m := newTestMemoryRepo(t)
+
+	if _, err := m.GetCustomerByID(1); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows but got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id, err := m.InsertCustomer(models.Customer{CustomerCode: "C001"}, 1)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			a := models.Attachment{CustomerId: id, CustomerCode: "C001", FilePath: "/tmp/a.pdf", FileName: "a.pdf", Checksum: "abc"}
+			if _, err := m.InsertFile(a); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	customers, _ := m.AllCustomers()
+	if len(customers) != 20 {
+		t.Fatalf("expected 20 customers but got %d", len(customers))
+	}
+
+	attachments, err := m.GetAttachmentsByCustomerID(customers[0].CustomerId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments.Attachments) != 1 || attachments.CustomerCode != "C001" {
+		t.Errorf("unexpected attachments %+v", attachments)
+	}
+
+	if _, err := m.GetAttachmentByChecksum(customers[0].CustomerId, "abc"); err != nil {
+		t.Errorf("expected to find the file by checksum, got %v", err)
+	}
+	if _, err := m.GetAttachmentByChecksum(customers[0].CustomerId, "other"); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown checksum, got %v", err)
+	}
+
+	code, err := m.GetCustomerCodeByID(customers[0].CustomerId)
+	if err != nil || code != "C001" {
+		t.Errorf("expected customer code C001 but got %q (%v)", code, err)
+	}
+}
+
+func TestMemoryRepo_ActivateCustomer(t *testing.T) {
+	m := newTestMemoryRepo(t)
+
+	if err := m.ActivateCustomer(99); err != sql.ErrNoRows {
+		t.Errorf("expected sql.ErrNoRows for an unknown customer, got %v", err)
+	}
+
+	id, err := m.InsertCustomer(models.Customer{CustomerCode: "C001"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.ActivateCustomer(id); err != repository.ErrReviewsPending {
+		t.Errorf("expected ErrReviewsPending while the review is pending, got %v", err)
+	}
+
+	reviews, _ := m.GetKYCReviewsByCustomerID(id)
+	if len(reviews) != 1 {
+		t.Fatalf("expected one review but got %d", len(reviews))
+	}
+	m.UpdateKYCReviewState(models.KYCReviewEvent{ReviewID: reviews[0].ID,
+		FromState: models.ReviewPending, ToState: models.ReviewApproved, UserID: 2})
+
+	if err := m.ActivateCustomer(id); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ := m.GetCustomerByID(id); c.Status != models.CustomerActive {
+		t.Errorf("expected the customer to be active, got %q", c.Status)
+	}
+}
+
+func TestMemoryRepo_TransitionReservation(t *testing.T) {
+	m := newTestMemoryRepo(t)
+
+	id, err := m.InsertReservation(models.Reservation{RoomID: 1, StartDate: day(10), EndDate: day(12)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.InsertReservation(models.Reservation{RoomID: 1, StartDate: day(11), EndDate: day(13)}); err != repository.ErrNotAvailable {
+		t.Errorf("expected the reserved dates to be refused, got %v", err)
+	}
+
+	// Of staff confirming the same reservation at once, only one succeeds
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	confirmed := 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(user int) {
+			defer wg.Done()
+			err := m.TransitionReservation(models.ReservationEvent{ReservationID: id, FromState: models.ReservationPending,
+				ToState: models.ReservationConfirmed, UserID: user})
+			if err != nil && err != sql.ErrNoRows {
+				t.Error(err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				confirmed++
+			}
+		}(i + 1)
+	}
+	wg.Wait()
+	if confirmed != 1 {
+		t.Fatalf("expected one confirmation but got %d", confirmed)
+	}
+
+	if err := m.CancelReservation(models.ReservationEvent{ReservationID: id, FromState: models.ReservationConfirmed,
+		Actor: models.ActorGuest}, 5000); err != nil {
+		t.Fatal(err)
+	}
+	res, _ := m.GetReservationByID(id)
+	if !res.Cancelled() || res.CancellationFee != 5000 || res.CancelledAt.IsZero() {
+		t.Errorf("expected the reservation to be cancelled with a fee, got %+v", res)
+	}
+
+	history, _ := m.GetReservationHistory(id)
+	if len(history) != 3 || history[1].ToState != models.ReservationConfirmed || history[2].Actor != models.ActorGuest {
+		t.Errorf("unexpected history %+v", history)
+	}
+}
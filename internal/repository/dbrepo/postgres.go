@@ -2,27 +2,91 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// rowQuerier runs queries returning a row, on the database or in a transaction
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
 func (m *postgresDBRepo) AllUsers() bool {
 	return true
 }
 
+// Ping checks that the database can be reached
+func (m *postgresDBRepo) Ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	return m.DB.PingContext(ctx)
+}
+
+// InsertReservation inserts a pending reservation with its price and the room
+// restriction holding its dates, and returns its ID. ErrNotAvailable is
+// returned when the room is reserved or blocked on the dates. A discount code
+// on the price is counted as used, and ErrDiscountUsedUp returned when it
+// reached its maximum uses.
 func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	//
+
+	lines, err := json.Marshal(res.Price.Lines)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the room, so two guests can't book the same dates
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+		where room_id = $1 and $2 < end_date and $3 > start_date`, roomID, res.StartDate, res.EndDate).Scan(&taken)
+	if err != nil {
+		return 0, err
+	}
+	if taken > 0 {
+		return 0, repository.ErrNotAvailable
+	}
+
+	if res.Price.DiscountCode != "" {
+		r, err := tx.ExecContext(ctx, `update discount_codes set uses = uses + 1, updated_at = $2
+			where code = $1 and (max_uses = 0 or uses < max_uses)`, res.Price.DiscountCode, time.Now())
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := r.RowsAffected(); n == 0 {
+			return 0, repository.ErrDiscountUsedUp
+		}
+	}
+
 	var newID int
 	stmt := `insert into reservations (first_name,last_name,email,phone,start_date,
-					end_date,room_id,created_at,updated_at) values($1,$2,$3,$4,$5,$6,$7,$8,$9) returning id`
+					end_date,room_id,created_at,updated_at,reference,
+					price_lines,subtotal,discount_code,discount,total)
+					values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -32,13 +96,32 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.RoomID,
 		time.Now(),
 		time.Now(),
+		res.Reference,
+		string(lines),
+		res.Price.Subtotal,
+		res.Price.DiscountCode,
+		res.Price.Discount,
+		res.Price.Total,
 	).Scan(&newID)
 
 	if err != nil {
 		return newID, err
 	}
 
-	return 0, nil
+	_, err = tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, reservation_id,
+		created_at, updated_at, restriction_id) values ($1, $2, $3, $4, $5, $5, $6)`,
+		res.StartDate, res.EndDate, roomID, newID, time.Now(), models.RestrictionReservation)
+	if err != nil {
+		return newID, err
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into reservation_history (reservation_id, from_state, to_state, actor, created_at, updated_at)
+		values ($1, '', $2, $3, $4, $4)`, newID, models.ReservationPending, models.ActorGuest, time.Now())
+	if err != nil {
+		return newID, err
+	}
+
+	return newID, tx.Commit()
 }
 
 func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
@@ -63,13 +146,14 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// SearchAvailabilityByDatesByRoomID returns true if the room has no restriction in the date range
 func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	var numRows int
-	query := `select count(id) from room_restrictions where $1 < end_date $2 > start_date`
+	query := `select count(id) from room_restrictions where room_id = $1 and $2 < end_date and $3 > start_date`
 
-	row := m.DB.QueryRowContext(ctx, query, start, end)
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
 	err := row.Scan(&numRows)
 	if err != nil {
 		return false, err
@@ -89,25 +173,22 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 	var rooms []models.Room
 
 	query := `
-		select
-			r.id, r.room_name
+		select ` + roomColumns + `
 		from
 			rooms r
 		where r.id not in 
-		(select room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date);
+		(select room_id from room_restrictions rr where $1 < rr.end_date and $2 > rr.start_date)
+		order by r.id;
 		`
 
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
 		return rooms, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-		)
+		room, err := scanRoom(rows.Scan)
 		if err != nil {
 			return rooms, err
 		}
@@ -193,8 +274,9 @@ func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string,
 	return id, hashedPassword, nil // If everything is successful, return the user's ID and hashed password
 }
 
-// AllReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+// AllReservations returns the reservations in state, or all of them when
+// state is empty, ordered by arrival
+func (m *postgresDBRepo) AllReservations(state string) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -202,14 +284,15 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 	query := `
 		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed,
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.state,
 		rm.id, rm.room_name
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
+		where $1 = '' or r.state = $1
 		order by r.start_date asc
 `
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.Read.QueryContext(ctx, query, state)
 	if err != nil {
 		return reservations, err
 	}
@@ -228,7 +311,7 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 			&i.RoomID,
 			&i.CreatedAt,
 			&i.UpdatedAt,
-			&i.Processed,
+			&i.State,
 			&i.Room.ID,
 			&i.Room.RoomName,
 		)
@@ -248,22 +331,37 @@ func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
 
 // GetReservationByID returns one reservation by ID
 func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	return m.getReservation("r.id = $1", id)
+}
+
+// GetReservationByReference returns the reservation with the reference given to the guest
+func (m *postgresDBRepo) GetReservationByReference(reference string) (models.Reservation, error) {
+	return m.getReservation("r.reference = $1", reference)
+}
+
+// getReservation returns the reservation matching where, with its room
+func (m *postgresDBRepo) getReservation(where string, arg interface{}) (models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var res models.Reservation
 
 	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-		r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, 
-		rm.id, rm.room_name
+		select r.id, coalesce(r.reference, ''), r.first_name, r.last_name, r.email, r.phone, r.start_date,
+		r.end_date, r.room_id, r.created_at, r.updated_at, r.state,
+		r.price_lines, r.subtotal, r.discount_code, r.discount, r.total,
+		r.payment_status, coalesce(r.payment_intent_id, ''), r.payment_client_secret, r.amount_paid,
+		r.cancelled_at, r.cancellation_fee,
+		rm.id, rm.room_name, rm.nightly_rate
 		from reservations r
 		left join rooms rm on (r.room_id = rm.id)
-		where r.id = $1
-`
-	row := m.DB.QueryRowContext(ctx, query, id)
+		where ` + where
+	var lines string
+	var cancelled sql.NullTime
+	row := m.DB.QueryRowContext(ctx, query, arg)
 	err := row.Scan(
 		&res.ID,
+		&res.Reference,
 		&res.FirstName,
 		&res.LastName,
 		&res.Email,
@@ -273,69 +371,32 @@ func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error)
 		&res.RoomID,
 		&res.CreatedAt,
 		&res.UpdatedAt,
-		&res.Processed,
+		&res.State,
+		&lines,
+		&res.Price.Subtotal,
+		&res.Price.DiscountCode,
+		&res.Price.Discount,
+		&res.Price.Total,
+		&res.PaymentStatus,
+		&res.PaymentIntentID,
+		&res.PaymentClientSecret,
+		&res.AmountPaid,
+		&cancelled,
+		&res.CancellationFee,
 		&res.Room.ID,
 		&res.Room.RoomName,
+		&res.Room.NightlyRate,
 	)
 
 	if err != nil {
 		return res, err
 	}
-
-	return res, nil
-}
-
-// AllNewReservations returns a slice of all reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
-
-	var reservations []models.Reservation
-
-	query := `
-		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, 
-		r.end_date, r.room_id, r.created_at, r.updated_at, 
-		rm.id, rm.room_name
-		from reservations r
-		left join rooms rm on (r.room_id = rm.id)
-		where processed = 0
-		order by r.start_date asc
-`
-
-	rows, err := m.DB.QueryContext(ctx, query)
-	if err != nil {
-		return reservations, err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var i models.Reservation
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
-
-		if err != nil {
-			return reservations, err
-		}
-		reservations = append(reservations, i)
-	}
-
-	if err = rows.Err(); err != nil {
-		return reservations, err
+	if err := json.Unmarshal([]byte(lines), &res.Price.Lines); err != nil {
+		return res, fmt.Errorf("price of reservation %d: %w", res.ID, err)
 	}
+	res.CancelledAt = cancelled.Time
 
-	return reservations, nil
+	return res, nil
 }
 
 // UpdateReservation updates a reservation in the database
@@ -379,30 +440,273 @@ func (m *postgresDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
-// UpdateProcessedForReservation updates processed for a reservation by id
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// SetPaymentIntent keeps the payment intent the guest pays a reservation with.
+// An empty intentID drops the intent, a new one is made when the guest pays.
+func (m *postgresDBRepo) SetPaymentIntent(reservationID int, intentID, clientSecret string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "update reservations set processed = $1 where id = $2"
+	res, err := m.DB.ExecContext(ctx, `update reservations set payment_intent_id = nullif($1, ''), payment_client_secret = $2,
+		updated_at = $3 where id = $4`, intentID, clientSecret, time.Now(), reservationID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetReservationByPaymentIntent returns the reservation paid with a payment intent
+func (m *postgresDBRepo) GetReservationByPaymentIntent(intentID string) (models.Reservation, error) {
+	return m.getReservation("r.payment_intent_id = $1", intentID)
+}
+
+// ApplyPaymentEvent records a payment event and moves the payment status of
+// its reservation forward, from pending to paid and from paid to refunded.
+// Paying confirms pending reservations, and is recorded for cancelled ones so
+// that it can be refunded. It reports false for events that were already applied or don't change the
+// status, and sql.ErrNoRows for intents of no reservation.
+func (m *postgresDBRepo) ApplyPaymentEvent(e models.PaymentEvent) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var reservationID int
+	var status, state string
+	err = tx.QueryRowContext(ctx, `select id, payment_status, state from reservations where payment_intent_id = $1 for update`,
+		e.IntentID).Scan(&reservationID, &status, &state)
+	if err != nil {
+		return false, err
+	}
+
+	r, err := tx.ExecContext(ctx, `insert into payment_events (event_id, reservation_id, status, amount, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5) on conflict (event_id) do nothing`,
+		e.ID, reservationID, e.Status, e.Amount, time.Now())
+	if err != nil {
+		return false, err
+	}
+	if n, _ := r.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	switch {
+	case e.Status == models.PaymentPaid && status == models.PaymentPending:
+		_, err = tx.ExecContext(ctx, `update reservations set payment_status = $1, amount_paid = $2, updated_at = $3
+			where id = $4`, e.Status, e.Amount, time.Now(), reservationID)
+		if err == nil && state == models.ReservationPending {
+			// Paying confirms the reservation
+			err = transitionReservation(ctx, tx, models.ReservationEvent{
+				ReservationID: reservationID,
+				FromState:     models.ReservationPending,
+				ToState:       models.ReservationConfirmed,
+				Actor:         models.ActorPayment,
+			})
+		}
+	case e.Status == models.PaymentRefunded && status != models.PaymentRefunded:
+		_, err = tx.ExecContext(ctx, `update reservations set payment_status = $1, updated_at = $2
+			where id = $3`, e.Status, time.Now(), reservationID)
+	default:
+		// Recorded, so a replay is recognized, but the status stays
+		return false, tx.Commit()
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// ChangeReservationDates moves a reservation and its room restriction to other
+// dates at a new price, or returns ErrNotAvailable when the room is reserved or
+// blocked on them. A payment the guest started for the old price is dropped.
+func (m *postgresDBRepo) ChangeReservationDates(id int, start, end time.Time, price models.Price) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lines, err := json.Marshal(price.Lines)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	return nil
+	// Lock the room, so two changes can't take the same dates
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select rm.id from reservations r join rooms rm on (rm.id = r.room_id)
+		where r.id = $1 and r.state in ('pending', 'confirmed') for update of rm`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var taken int
+	err = tx.QueryRowContext(ctx, `select count(id) from room_restrictions
+		where room_id = $1 and $2 < end_date and $3 > start_date and (reservation_id is null or reservation_id <> $4)`,
+		roomID, start, end, id).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken > 0 {
+		return repository.ErrNotAvailable
+	}
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, `update reservations set start_date = $1, end_date = $2, price_lines = $3,
+		subtotal = $4, discount = $5, total = $6, updated_at = $7,
+		payment_intent_id = case when payment_status = 'pending' then null else payment_intent_id end,
+		payment_client_secret = case when payment_status = 'pending' then '' else payment_client_secret end
+		where id = $8`,
+		start, end, string(lines), price.Subtotal, price.Discount, price.Total, now, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `update room_restrictions set start_date = $1, end_date = $2, updated_at = $3
+		where reservation_id = $4`, start, end, now, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transitionReservation changes the state of a reservation from e.FromState
+// and records the change, or returns sql.ErrNoRows when the reservation is in
+// another state. Cancelled reservations and no-shows free their dates.
+func transitionReservation(ctx context.Context, tx *sql.Tx, e models.ReservationEvent) error {
+	now := time.Now()
+	stmt := `update reservations set state = $1, updated_at = $2 where id = $3 and state = $4`
+	if e.ToState == models.ReservationCancelled {
+		stmt = `update reservations set state = $1, updated_at = $2, cancelled_at = $2 where id = $3 and state = $4`
+	}
+	result, err := tx.ExecContext(ctx, stmt, e.ToState, now, e.ReservationID, e.FromState)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	if e.ToState == models.ReservationCancelled || e.ToState == models.ReservationNoShow {
+		if _, err := tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, e.ReservationID); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `insert into reservation_history (reservation_id, from_state, to_state, user_id, actor,
+		comment, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $7)`,
+		e.ReservationID, e.FromState, e.ToState, e.UserID, e.Actor, e.Comment, now)
+	return err
+}
+
+// TransitionReservation changes the state of a reservation from e.FromState
+// and records who changed it. It returns sql.ErrNoRows when the reservation
+// is in another state, such as when someone else changed it meanwhile.
+func (m *postgresDBRepo) TransitionReservation(e models.ReservationEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := transitionReservation(ctx, tx, e); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (m *postgresDBRepo) InsertCustomer(res models.Customer) (int, error) {
+// CancelReservation cancels a reservation with the fee charged for it and
+// frees its dates
+func (m *postgresDBRepo) CancelReservation(e models.ReservationEvent, fee models.Money) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	//
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	e.ToState = models.ReservationCancelled
+	if err := transitionReservation(ctx, tx, e); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `update reservations set cancellation_fee = $1 where id = $2`, fee, e.ReservationID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetReservationHistory returns the changes of state of a reservation, oldest first
+func (m *postgresDBRepo) GetReservationHistory(reservationID int) ([]models.ReservationEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var events []models.ReservationEvent
+	query := `select h.history_id, h.reservation_id, h.from_state, h.to_state, h.user_id, h.actor, h.comment,
+		h.created_at, coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		from reservation_history h
+		left join users u on (u.id = h.user_id)
+		where h.reservation_id = $1
+		order by h.created_at, h.history_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reservationID)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.ReservationEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.ReservationID,
+			&e.FromState,
+			&e.ToState,
+			&e.UserID,
+			&e.Actor,
+			&e.Comment,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+		)
+		if err != nil {
+			return events, err
+		}
+		e.User.ID = e.UserID
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
+
+// InsertCustomer inserts a customer and submits it for review by submittedBy
+func (m *postgresDBRepo) InsertCustomer(res models.Customer, submittedBy int) (int, error) {
+	rv := models.KYCReview{RecordType: models.RecordCustomer, SubmittedBy: submittedBy}
+	return m.insertSubmitted(rv, func(ctx context.Context, tx *sql.Tx) (int, error) {
+		return insertCustomer(ctx, tx, res)
+	})
+}
+
+// insertCustomer inserts a customer with q, a database or a transaction
+func insertCustomer(ctx context.Context, q rowQuerier, res models.Customer) (int, error) {
 	var newID int
 	stmt := `insert into customers (customer_code,customer_name,contact_person,contact_tel,contact_mobile,
 		contact_email,customer_business,customer_location,customer_status,marketer_name,marketer_code,marketer_email,business_nature) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13) returning customer_id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err := q.QueryRowContext(ctx, stmt,
 		res.CustomerCode,
 		res.CustomerName,
 		res.ContactPerson,
@@ -438,7 +742,7 @@ func (m *postgresDBRepo) AllCustomers() ([]models.Customer, error) {
 	FROM customers
 `
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	rows, err := m.Read.QueryContext(ctx, query)
 	if err != nil {
 		return customers, err
 	}
@@ -476,15 +780,43 @@ func (m *postgresDBRepo) AllCustomers() ([]models.Customer, error) {
 	return customers, nil
 }
 
-func (m *postgresDBRepo) InsertFile(customerCode, filePath string, customerId int, uniqueFilenameWithExtension string) (int, error) {
+// uniqueViolation is the SQLSTATE of an insert or update breaking a unique index
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a unique index violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
+
+// InsertFile records an uploaded customer file and returns its ID, or
+// repository.ErrDuplicateFile when the customer already has the file
+func (m *postgresDBRepo) InsertFile(a models.Attachment) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	var newID int
-	stmt := `INSERT INTO customer_images (customer_id,customer_code, file_path,file_name) VALUES ($1, $2,$3, $4) RETURNING file_id`
+	stmt := `INSERT INTO customer_images (customer_id, customer_code, kind, file_path, file_name,
+		original_name, content_type, size_bytes, checksum, scan_status, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING file_id`
 
-	err := m.DB.QueryRowContext(ctx, stmt, customerId, customerCode, filePath, uniqueFilenameWithExtension).Scan(&newID)
+	err := m.DB.QueryRowContext(ctx, stmt,
+		a.CustomerId,
+		a.CustomerCode,
+		a.Kind,
+		a.FilePath,
+		a.FileName,
+		a.OriginalName,
+		a.ContentType,
+		a.Size,
+		a.Checksum,
+		a.ScanStatus,
+		time.Now(),
+	).Scan(&newID)
 
+	if isUniqueViolation(err) {
+		return 0, repository.ErrDuplicateFile
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -492,6 +824,69 @@ func (m *postgresDBRepo) InsertFile(customerCode, filePath string, customerId in
 	return newID, nil
 }
 
+// GetAttachmentByChecksum returns the customer's file with the given checksum, or sql.ErrNoRows
+func (m *postgresDBRepo) GetAttachmentByChecksum(customerID int, checksum string) (models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var a models.Attachment
+	query := `SELECT file_id, customer_id, customer_code, kind, file_path, file_name,
+		original_name, content_type, size_bytes, checksum, scan_status, created_at
+		FROM customer_images WHERE customer_id = $1 AND checksum = $2
+		ORDER BY file_id LIMIT 1`
+
+	err := m.DB.QueryRowContext(ctx, query, customerID, checksum).Scan(
+		&a.File_id,
+		&a.CustomerId,
+		&a.CustomerCode,
+		&a.Kind,
+		&a.FilePath,
+		&a.FileName,
+		&a.OriginalName,
+		&a.ContentType,
+		&a.Size,
+		&a.Checksum,
+		&a.ScanStatus,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
+// GetAttachmentByID returns one uploaded file by ID
+func (m *postgresDBRepo) GetAttachmentByID(id int) (models.Attachment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var a models.Attachment
+	query := `SELECT file_id, customer_id, customer_code, kind, file_path, file_name,
+		original_name, content_type, size_bytes, checksum, scan_status, created_at
+		FROM customer_images WHERE file_id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&a.File_id,
+		&a.CustomerId,
+		&a.CustomerCode,
+		&a.Kind,
+		&a.FilePath,
+		&a.FileName,
+		&a.OriginalName,
+		&a.ContentType,
+		&a.Size,
+		&a.Checksum,
+		&a.ScanStatus,
+		&a.CreatedAt,
+	)
+	if err != nil {
+		return a, err
+	}
+
+	return a, nil
+}
+
 // GetReservationByID returns one reservation by ID
 func (m *postgresDBRepo) GetCustomerByID(id int) (models.Customer, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -544,7 +939,9 @@ func (m *postgresDBRepo) GetAttachmentsByCustomerID(id int) (models.CustomerImag
 	}
 
 	// Now, retrieve the attachments associated with the customer
-	attachmentsQuery := `SELECT file_id, file_path,file_name FROM customer_images WHERE customer_id = $1`
+	attachmentsQuery := `SELECT file_id, customer_id, customer_code, kind, file_path, file_name,
+		original_name, content_type, size_bytes, checksum, scan_status, created_at
+		FROM customer_images WHERE customer_id = $1 ORDER BY file_id`
 	rows, err := m.DB.QueryContext(ctx, attachmentsQuery, id)
 	if err != nil {
 		return res, err
@@ -556,7 +953,21 @@ func (m *postgresDBRepo) GetAttachmentsByCustomerID(id int) (models.CustomerImag
 
 	for rows.Next() {
 		var attachment models.Attachment
-		if err := rows.Scan(&attachment.File_id, &attachment.FilePath, &attachment.FileName); err != nil {
+		err := rows.Scan(
+			&attachment.File_id,
+			&attachment.CustomerId,
+			&attachment.CustomerCode,
+			&attachment.Kind,
+			&attachment.FilePath,
+			&attachment.FileName,
+			&attachment.OriginalName,
+			&attachment.ContentType,
+			&attachment.Size,
+			&attachment.Checksum,
+			&attachment.ScanStatus,
+			&attachment.CreatedAt,
+		)
+		if err != nil {
 			return res, err
 		}
 		attachments = append(attachments, attachment)
@@ -587,7 +998,6 @@ func (m *postgresDBRepo) GetTradeShareInforByID(id int) ([]models.TradeLicenseHo
 	FROM trade_license_shareholders where customer_id = $1`
 	rows, err := m.DB.QueryContext(ctx, query, id)
 	if err != nil {
-		fmt.Println("Error executing query:", err)
 		return shareholders, err
 	}
 	defer rows.Close()
@@ -614,30 +1024,41 @@ func (m *postgresDBRepo) GetTradeShareInforByID(id int) ([]models.TradeLicenseHo
 		)
 
 		if err != nil {
-			fmt.Println("Error scanning row:", err)
 			return shareholders, err
 		}
 		shareholders = append(shareholders, i)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Println("Error iterating through rows:", err)
 		return shareholders, err
 	}
 
-	fmt.Println("Data retrieved successfully:", shareholders)
+	m.App.Logger.Debug("loaded shareholders", "customer_id", id, "count", len(shareholders))
 	return shareholders, nil
 }
 
-// GetReservationByID returns one reservation by ID
+// GetTradeLicenseInforByID returns the trade license of a customer
 func (m *postgresDBRepo) GetTradeLicenseInforByID(id int) (models.TradeLicense, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
+	return m.getTradeLicense(ctx, "customer_id", id)
+}
+
+// GetTradeLicenseByLicenseID returns a trade license by its own ID
+func (m *postgresDBRepo) GetTradeLicenseByLicenseID(id int) (models.TradeLicense, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.getTradeLicense(ctx, "trade_license_id", id)
+}
+
+// getTradeLicense returns the trade license whose column is id
+func (m *postgresDBRepo) getTradeLicense(ctx context.Context, column string, id int) (models.TradeLicense, error) {
 	var res models.TradeLicense
 
 	query := `SELECT trade_license_id, customer_id, emirate, "mohreNo", trade_name, legal_status, establishment_date, registration_date, license_expiray, created_at, updated_at,file_path,file_name,trade_license_no
-	FROM trade_license where customer_id = $1`
+	FROM trade_license where ` + column + ` = $1`
 	row := m.DB.QueryRowContext(ctx, query, id)
 	err := row.Scan(
 		&res.TradeLicenseID,
@@ -663,10 +1084,16 @@ func (m *postgresDBRepo) GetTradeLicenseInforByID(id int) (models.TradeLicense,
 	return res, nil
 }
 
-func (m *postgresDBRepo) InsertTradeLicense(res models.TradeLicense) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	//
+// InsertTradeLicense inserts a trade license and submits it for review by submittedBy
+func (m *postgresDBRepo) InsertTradeLicense(res models.TradeLicense, submittedBy int) (int, error) {
+	rv := models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordTradeLicense, SubmittedBy: submittedBy}
+	return m.insertSubmitted(rv, func(ctx context.Context, tx *sql.Tx) (int, error) {
+		return insertTradeLicense(ctx, tx, res)
+	})
+}
+
+// insertTradeLicense inserts a trade license with q, a database or a transaction
+func insertTradeLicense(ctx context.Context, q rowQuerier, res models.TradeLicense) (int, error) {
 	var newID int
 	stmt := `insert into trade_license (customer_id, emirate, 
 		"mohreNo", trade_name, legal_status, establishment_date, 
@@ -674,7 +1101,7 @@ func (m *postgresDBRepo) InsertTradeLicense(res models.TradeLicense) (int, error
 		file_path, file_name,trade_license_no) 
 		values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13) returning trade_license_id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err := q.QueryRowContext(ctx, stmt,
 		res.CustomerId,
 		res.Emirate,
 		res.MohreNo,
@@ -710,7 +1137,6 @@ func (m *postgresDBRepo) GetMemorandumInforByID(id int) ([]models.Memorandum, er
 	FROM memorandums where customer_id = $1`
 	rows, err := m.DB.QueryContext(ctx, query, id)
 	if err != nil {
-		fmt.Println("Error executing query:", err)
 		return memorandum, err
 	}
 	defer rows.Close()
@@ -735,25 +1161,35 @@ func (m *postgresDBRepo) GetMemorandumInforByID(id int) ([]models.Memorandum, er
 		)
 
 		if err != nil {
-			fmt.Println("Error scanning row:", err)
 			return memorandum, err
 		}
 		memorandum = append(memorandum, i)
 	}
 
 	if err = rows.Err(); err != nil {
-		fmt.Println("Error iterating through rows:", err)
 		return memorandum, err
 	}
 
-	fmt.Println("Data retrieved successfully:", memorandum)
+	m.App.Logger.Debug("loaded memorandum representatives", "customer_id", id, "count", len(memorandum))
 	return memorandum, nil
 }
 
-func (m *postgresDBRepo) InsertPartner(res models.TradeLicenseHolder) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// InsertPartner inserts a shareholder and submits it for review by submittedBy
+func (m *postgresDBRepo) InsertPartner(res models.TradeLicenseHolder, submittedBy int) (int, error) {
+	rv := models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordPartner, SubmittedBy: submittedBy}
+	newID, err := m.insertSubmitted(rv, func(ctx context.Context, tx *sql.Tx) (int, error) {
+		return insertPartner(ctx, tx, res)
+	})
+	if err != nil {
+		return 0, err
+	}
 
+	m.App.Logger.Debug("inserted partner", "shareholder_id", newID, "customer_id", res.CustomerId)
+	return newID, nil
+}
+
+// insertPartner inserts a shareholder with q, a database or a transaction
+func insertPartner(ctx context.Context, q rowQuerier, res models.TradeLicenseHolder) (int, error) {
 	var newID int
 	stmt := `insert into trade_license_shareholders (trade_license_id, customer_id, 
 		customer_code,
@@ -763,7 +1199,7 @@ func (m *postgresDBRepo) InsertPartner(res models.TradeLicenseHolder) (int, erro
 			shareholder_passport, passport_expire_date, 
 			id_file_path, passport_file_path,created_at,updated_at) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15) returning shareholder_id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err := q.QueryRowContext(ctx, stmt,
 		res.TradeLicenseID,
 		res.CustomerId,
 		res.CustomerCode,
@@ -782,11 +1218,9 @@ func (m *postgresDBRepo) InsertPartner(res models.TradeLicenseHolder) (int, erro
 	).Scan(&newID)
 
 	if err != nil {
-		fmt.Println("Error inserting partner:", err)
 		return 0, err
 	}
 
-	fmt.Println("Inserted new partner with ID:", newID)
 	return newID, nil
 }
 
@@ -805,10 +1239,22 @@ func (m *postgresDBRepo) GetCustomerCodeByID(id int) (string, error) {
 	return customerCode, err
 }
 
-func (m *postgresDBRepo) InsertMemorandum(res models.Memorandum) (int, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// InsertMemorandum inserts a representative and submits it for review by submittedBy
+func (m *postgresDBRepo) InsertMemorandum(res models.Memorandum, submittedBy int) (int, error) {
+	rv := models.KYCReview{CustomerID: res.CustomerId, RecordType: models.RecordMemorandum, SubmittedBy: submittedBy}
+	newID, err := m.insertSubmitted(rv, func(ctx context.Context, tx *sql.Tx) (int, error) {
+		return insertMemorandum(ctx, tx, res)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	m.App.Logger.Debug("inserted memorandum representative", "memorandum_id", newID, "customer_id", res.CustomerId)
+	return newID, nil
+}
 
+// insertMemorandum inserts a representative with q, a database or a transaction
+func insertMemorandum(ctx context.Context, q rowQuerier, res models.Memorandum) (int, error) {
 	var newID int
 	stmt := `insert into memorandums 
 	(trade_license_id, customer_id, 
@@ -818,7 +1264,7 @@ func (m *postgresDBRepo) InsertMemorandum(res models.Memorandum) (int, error) {
 		passport_file_path, created_at, updated_at) 
 		values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13) returning memorandum_id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err := q.QueryRowContext(ctx, stmt,
 		res.TradeLicenseID,
 		res.CustomerId,
 		res.CustomerCode,
@@ -835,10 +1281,1757 @@ func (m *postgresDBRepo) InsertMemorandum(res models.Memorandum) (int, error) {
 	).Scan(&newID)
 
 	if err != nil {
-		fmt.Println("Error inserting partner:", err)
 		return 0, err
 	}
 
-	fmt.Println("Inserted new partner with ID:", newID)
 	return newID, nil
 }
+
+// UpdateCustomerStatus sets the status of a customer
+func (m *postgresDBRepo) UpdateCustomerStatus(id int, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update customers set customer_status = $1 where customer_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, status, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ActivateCustomer marks a customer active, unless one of its records' reviews
+// is not approved. The reviews are locked until then, so a review can't be
+// sent back while the customer is being activated.
+func (m *postgresDBRepo) ActivateCustomer(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `select review_id from kyc_reviews where customer_id = $1 for update`, id); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `update customers set customer_status = $1, updated_at = $2
+		where customer_id = $3 and not exists (
+			select 1 from kyc_reviews where customer_id = $3 and state <> $4)`,
+		models.CustomerActive, time.Now(), id, models.ReviewApproved)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		var exists bool
+		err := tx.QueryRowContext(ctx, `select exists (select 1 from customers where customer_id = $1)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return sql.ErrNoRows
+		}
+		return repository.ErrReviewsPending
+	}
+
+	return tx.Commit()
+}
+
+// GetCustomerDraft returns the draft of an onboarding step, or sql.ErrNoRows
+func (m *postgresDBRepo) GetCustomerDraft(customerID, userID int, step string) (models.CustomerDraft, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var d models.CustomerDraft
+	var values string
+	query := `select draft_id, customer_id, user_id, step, form_values, created_at, updated_at
+		from customer_drafts where customer_id = $1 and user_id = $2 and step = $3`
+
+	err := m.DB.QueryRowContext(ctx, query, customerID, userID, step).Scan(
+		&d.ID,
+		&d.CustomerID,
+		&d.UserID,
+		&d.Step,
+		&values,
+		&d.CreatedAt,
+		&d.UpdatedAt,
+	)
+	if err != nil {
+		return d, err
+	}
+
+	if err := json.Unmarshal([]byte(values), &d.Values); err != nil {
+		return d, err
+	}
+	return d, nil
+}
+
+// GetCustomerDrafts returns the drafts of an existing customer's onboarding steps
+func (m *postgresDBRepo) GetCustomerDrafts(customerID int) ([]models.CustomerDraft, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var drafts []models.CustomerDraft
+	query := `select draft_id, customer_id, user_id, step, form_values, created_at, updated_at
+		from customer_drafts where customer_id = $1 and user_id = 0 order by draft_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, customerID)
+	if err != nil {
+		return drafts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.CustomerDraft
+		var values string
+		err := rows.Scan(
+			&d.ID,
+			&d.CustomerID,
+			&d.UserID,
+			&d.Step,
+			&values,
+			&d.CreatedAt,
+			&d.UpdatedAt,
+		)
+		if err != nil {
+			return drafts, err
+		}
+		if err := json.Unmarshal([]byte(values), &d.Values); err != nil {
+			return drafts, err
+		}
+		drafts = append(drafts, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return drafts, err
+	}
+
+	return drafts, nil
+}
+
+// SaveCustomerDraft stores the draft of an onboarding step, replacing the
+// previous draft of the same step
+func (m *postgresDBRepo) SaveCustomerDraft(d models.CustomerDraft) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	values, err := json.Marshal(d.Values)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into customer_drafts (customer_id, user_id, step, form_values, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5)
+		on conflict (customer_id, user_id, step)
+		do update set form_values = excluded.form_values, updated_at = excluded.updated_at`
+
+	_, err = m.DB.ExecContext(ctx, stmt, d.CustomerID, d.UserID, d.Step, string(values), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteCustomerDraft deletes the draft of an onboarding step, if there is one
+func (m *postgresDBRepo) DeleteCustomerDraft(customerID, userID int, step string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from customer_drafts where customer_id = $1 and user_id = $2 and step = $3`
+
+	_, err := m.DB.ExecContext(ctx, query, customerID, userID, step)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// insertSubmitted inserts a record with insert and submits it for review in
+// the same transaction, so that no record is stored without its review. The
+// record ID of rv is set to the inserted record, and so is its customer ID
+// when the record is a customer.
+func (m *postgresDBRepo) insertSubmitted(rv models.KYCReview, insert func(ctx context.Context, tx *sql.Tx) (int, error)) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insert(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	rv.RecordID = newID
+	if rv.RecordType == models.RecordCustomer {
+		rv.CustomerID = newID
+	}
+	if _, err := insertKYCReview(ctx, tx, rv, time.Now()); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// insertKYCReview inserts a pending review and the first entry of its history in tx
+func insertKYCReview(ctx context.Context, tx *sql.Tx, rv models.KYCReview, now time.Time) (int, error) {
+	var newID int
+	stmt := `insert into kyc_reviews (customer_id, record_type, record_id, state, submitted_by,
+		reviewed_by, comment, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, 0, '', $6, $6) returning review_id`
+
+	err := tx.QueryRowContext(ctx, stmt,
+		rv.CustomerID,
+		rv.RecordType,
+		rv.RecordID,
+		models.ReviewPending,
+		rv.SubmittedBy,
+		now,
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt = `insert into kyc_review_history (review_id, from_state, to_state, user_id, comment, created_at, updated_at)
+		values ($1, '', $2, $3, '', $4, $4)`
+	if _, err = tx.ExecContext(ctx, stmt, newID, models.ReviewPending, rv.SubmittedBy, now); err != nil {
+		return 0, err
+	}
+	return newID, nil
+}
+
+// kycReviewColumns are the columns scanned by scanKYCReview
+const kycReviewColumns = `r.review_id, r.customer_id, coalesce(c.customer_name, ''), r.record_type, r.record_id,
+	r.state, r.submitted_by, r.reviewed_by, r.comment, r.created_at, r.updated_at,
+	coalesce(s.first_name, ''), coalesce(s.last_name, ''), coalesce(s.email, ''),
+	coalesce(v.first_name, ''), coalesce(v.last_name, ''), coalesce(v.email, '')
+	from kyc_reviews r
+	left join customers c on (c.customer_id = r.customer_id)
+	left join users s on (s.id = r.submitted_by)
+	left join users v on (v.id = r.reviewed_by)`
+
+// scanKYCReview scans a row selected with kycReviewColumns
+func scanKYCReview(row interface{ Scan(...interface{}) error }) (models.KYCReview, error) {
+	var rv models.KYCReview
+	err := row.Scan(
+		&rv.ID,
+		&rv.CustomerID,
+		&rv.CustomerName,
+		&rv.RecordType,
+		&rv.RecordID,
+		&rv.State,
+		&rv.SubmittedBy,
+		&rv.ReviewedBy,
+		&rv.Comment,
+		&rv.CreatedAt,
+		&rv.UpdatedAt,
+		&rv.Submitter.FirstName,
+		&rv.Submitter.LastName,
+		&rv.Submitter.Email,
+		&rv.Reviewer.FirstName,
+		&rv.Reviewer.LastName,
+		&rv.Reviewer.Email,
+	)
+	rv.Submitter.ID = rv.SubmittedBy
+	rv.Reviewer.ID = rv.ReviewedBy
+	return rv, err
+}
+
+// queryKYCReviews returns the reviews selected by the where clause of query.
+// It reads from the primary, as reviews decide whether a customer is activated.
+func (m *postgresDBRepo) queryKYCReviews(query string, args ...interface{}) ([]models.KYCReview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reviews []models.KYCReview
+	rows, err := m.DB.QueryContext(ctx, "select "+kycReviewColumns+" "+query, args...)
+	if err != nil {
+		return reviews, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		rv, err := scanKYCReview(rows)
+		if err != nil {
+			return reviews, err
+		}
+		reviews = append(reviews, rv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reviews, err
+	}
+
+	return reviews, nil
+}
+
+// GetKYCReviewByID returns a review, or sql.ErrNoRows
+func (m *postgresDBRepo) GetKYCReviewByID(id int) (models.KYCReview, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, "select "+kycReviewColumns+" where r.review_id = $1", id)
+	return scanKYCReview(row)
+}
+
+// GetKYCReviewsByCustomerID returns the reviews of a customer's records, oldest first
+func (m *postgresDBRepo) GetKYCReviewsByCustomerID(customerID int) ([]models.KYCReview, error) {
+	return m.queryKYCReviews("where r.customer_id = $1 order by r.review_id", customerID)
+}
+
+// PendingKYCReviews returns the reviews waiting for a reviewer, oldest first
+func (m *postgresDBRepo) PendingKYCReviews() ([]models.KYCReview, error) {
+	return m.queryKYCReviews("where r.state = $1 order by r.created_at, r.review_id", models.ReviewPending)
+}
+
+// UpdateKYCReviewState moves a review from e.FromState to e.ToState and records
+// the change in its history. The user of the event becomes the reviewer, or the
+// submitter when the record goes back to pending. It returns sql.ErrNoRows when
+// the review is not in e.FromState, for instance because someone else decided it.
+func (m *postgresDBRepo) UpdateKYCReviewState(e models.KYCReviewEvent) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	stmt := `update kyc_reviews set state = $1, reviewed_by = $2, comment = $3, updated_at = $4
+		where review_id = $5 and state = $6`
+	args := []interface{}{e.ToState, e.UserID, e.Comment, now, e.ReviewID, e.FromState}
+	if e.ToState == models.ReviewPending {
+		stmt = `update kyc_reviews set state = $1, submitted_by = $2, comment = $3, updated_at = $4,
+			reviewed_by = 0 where review_id = $5 and state = $6`
+	}
+
+	result, err := tx.ExecContext(ctx, stmt, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	stmt = `insert into kyc_review_history (review_id, from_state, to_state, user_id, comment, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $6)`
+	if _, err = tx.ExecContext(ctx, stmt, e.ReviewID, e.FromState, e.ToState, e.UserID, e.Comment, now); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetKYCReviewHistory returns the changes of state of a review, oldest first
+func (m *postgresDBRepo) GetKYCReviewHistory(reviewID int) ([]models.KYCReviewEvent, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var events []models.KYCReviewEvent
+	query := `select h.history_id, h.review_id, h.from_state, h.to_state, h.user_id, h.comment, h.created_at,
+		coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+		from kyc_review_history h
+		left join users u on (u.id = h.user_id)
+		where h.review_id = $1
+		order by h.created_at, h.history_id`
+
+	rows, err := m.DB.QueryContext(ctx, query, reviewID)
+	if err != nil {
+		return events, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.KYCReviewEvent
+		err := rows.Scan(
+			&e.ID,
+			&e.ReviewID,
+			&e.FromState,
+			&e.ToState,
+			&e.UserID,
+			&e.Comment,
+			&e.CreatedAt,
+			&e.User.FirstName,
+			&e.User.LastName,
+			&e.User.Email,
+		)
+		if err != nil {
+			return events, err
+		}
+		e.User.ID = e.UserID
+		events = append(events, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
+
+// SaveRiskAssessment stores the risk rating of a customer, replacing the previous one
+func (m *postgresDBRepo) SaveRiskAssessment(a models.RiskAssessment) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	factors, err := json.Marshal(a.Factors)
+	if err != nil {
+		return err
+	}
+
+	stmt := `insert into customer_risk (customer_id, score, rating, factors, assessed_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $6)
+		on conflict (customer_id)
+		do update set score = excluded.score, rating = excluded.rating, factors = excluded.factors,
+		assessed_at = excluded.assessed_at, updated_at = excluded.updated_at`
+
+	_, err = m.DB.ExecContext(ctx, stmt, a.CustomerID, a.Score, a.Rating, string(factors), a.AssessedAt, time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetRiskAssessment returns the risk rating of a customer, or sql.ErrNoRows
+// when the customer was not assessed yet
+func (m *postgresDBRepo) GetRiskAssessment(customerID int) (models.RiskAssessment, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var a models.RiskAssessment
+	var factors string
+	query := `select customer_id, score, rating, factors, assessed_at from customer_risk where customer_id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, customerID).Scan(
+		&a.CustomerID,
+		&a.Score,
+		&a.Rating,
+		&factors,
+		&a.AssessedAt,
+	)
+	if err != nil {
+		return a, err
+	}
+
+	if err := json.Unmarshal([]byte(factors), &a.Factors); err != nil {
+		return a, err
+	}
+	return a, nil
+}
+
+// ReplaceSanctionsEntries replaces the entries of a sanctions list with a newly
+// imported version of it
+func (m *postgresDBRepo) ReplaceSanctionsEntries(source string, entries []models.SanctionsEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `delete from sanctions_entries where source = $1`, source); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `insert into sanctions_entries (source, external_id, name, aliases,
+		entry_type, program, nationality, remarks, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9)
+		on conflict (source, external_id) do nothing`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	now := time.Now()
+	for _, e := range entries {
+		aliases, err := json.Marshal(e.Aliases)
+		if err != nil {
+			return err
+		}
+		_, err = stmt.ExecContext(ctx, source, e.ExternalID, e.Name, string(aliases),
+			e.EntryType, e.Program, e.Nationality, e.Remarks, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AllSanctionsEntries returns the entries of every imported sanctions list
+func (m *postgresDBRepo) AllSanctionsEntries() ([]models.SanctionsEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var entries []models.SanctionsEntry
+	query := `select entry_id, source, external_id, name, aliases, entry_type, program, nationality,
+		remarks, created_at from sanctions_entries order by entry_id`
+
+	rows, err := m.Read.QueryContext(ctx, query)
+	if err != nil {
+		return entries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.SanctionsEntry
+		var aliases string
+		err := rows.Scan(
+			&e.ID,
+			&e.Source,
+			&e.ExternalID,
+			&e.Name,
+			&aliases,
+			&e.EntryType,
+			&e.Program,
+			&e.Nationality,
+			&e.Remarks,
+			&e.CreatedAt,
+		)
+		if err != nil {
+			return entries, err
+		}
+		if err := json.Unmarshal([]byte(aliases), &e.Aliases); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err = rows.Err(); err != nil {
+		return entries, err
+	}
+
+	return entries, nil
+}
+
+// SanctionsLists sums up the imported sanctions lists
+func (m *postgresDBRepo) SanctionsLists() ([]models.SanctionsList, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var lists []models.SanctionsList
+	query := `select source, count(*), max(created_at) from sanctions_entries group by source order by source`
+
+	rows, err := m.Read.QueryContext(ctx, query)
+	if err != nil {
+		return lists, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l models.SanctionsList
+		if err := rows.Scan(&l.Source, &l.Entries, &l.ImportedAt); err != nil {
+			return lists, err
+		}
+		lists = append(lists, l)
+	}
+
+	if err = rows.Err(); err != nil {
+		return lists, err
+	}
+
+	return lists, nil
+}
+
+// ScreeningSubjects returns every shareholder and representative, to be
+// screened against the sanctions lists
+func (m *postgresDBRepo) ScreeningSubjects() ([]models.ScreeningSubject, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var subjects []models.ScreeningSubject
+	query := `select customer_id, $1, shareholder_id, shareholder_name from trade_license_shareholders
+		union all
+		select customer_id, $2, memorandum_id, representative_name from memorandums
+		order by 1, 2, 3`
+
+	rows, err := m.Read.QueryContext(ctx, query, models.RecordPartner, models.RecordMemorandum)
+	if err != nil {
+		return subjects, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var s models.ScreeningSubject
+		if err := rows.Scan(&s.CustomerID, &s.SubjectType, &s.SubjectID, &s.Name); err != nil {
+			return subjects, err
+		}
+		subjects = append(subjects, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return subjects, err
+	}
+
+	return subjects, nil
+}
+
+// InsertSanctionsHit records a hit and returns its ID, or 0 when the subject
+// was already found to match the same entry
+func (m *postgresDBRepo) InsertSanctionsHit(h models.SanctionsHit) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+	stmt := `insert into sanctions_hits (customer_id, subject_type, subject_id, subject_name, source,
+		external_id, listed_name, matched_name, program, score, state, reviewed_by, comment,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, 0, '', $12, $12)
+		on conflict (subject_type, subject_id, source, external_id) do nothing
+		returning hit_id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		h.CustomerID,
+		h.SubjectType,
+		h.SubjectID,
+		h.Name,
+		h.Source,
+		h.ExternalID,
+		h.ListedName,
+		h.MatchedName,
+		h.Program,
+		h.Score,
+		models.HitPending,
+		time.Now(),
+	).Scan(&newID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// sanctionsHitColumns are the columns scanned by scanSanctionsHit
+const sanctionsHitColumns = `h.hit_id, h.customer_id, coalesce(c.customer_name, ''), h.subject_type,
+	h.subject_id, h.subject_name, h.source, h.external_id, h.listed_name, h.matched_name, h.program,
+	h.score, h.state, h.reviewed_by, h.comment, h.created_at, h.updated_at,
+	coalesce(u.first_name, ''), coalesce(u.last_name, ''), coalesce(u.email, '')
+	from sanctions_hits h
+	left join customers c on (c.customer_id = h.customer_id)
+	left join users u on (u.id = h.reviewed_by)`
+
+// scanSanctionsHit scans a row selected with sanctionsHitColumns
+func scanSanctionsHit(row interface{ Scan(...interface{}) error }) (models.SanctionsHit, error) {
+	var h models.SanctionsHit
+	err := row.Scan(
+		&h.ID,
+		&h.CustomerID,
+		&h.CustomerName,
+		&h.SubjectType,
+		&h.SubjectID,
+		&h.Name,
+		&h.Source,
+		&h.ExternalID,
+		&h.ListedName,
+		&h.MatchedName,
+		&h.Program,
+		&h.Score,
+		&h.State,
+		&h.ReviewedBy,
+		&h.Comment,
+		&h.CreatedAt,
+		&h.UpdatedAt,
+		&h.Reviewer.FirstName,
+		&h.Reviewer.LastName,
+		&h.Reviewer.Email,
+	)
+	h.Reviewer.ID = h.ReviewedBy
+	return h, err
+}
+
+// querySanctionsHits returns the hits selected by the where clause of query
+func (m *postgresDBRepo) querySanctionsHits(query string, args ...interface{}) ([]models.SanctionsHit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var hits []models.SanctionsHit
+	rows, err := m.Read.QueryContext(ctx, "select "+sanctionsHitColumns+" "+query, args...)
+	if err != nil {
+		return hits, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		h, err := scanSanctionsHit(rows)
+		if err != nil {
+			return hits, err
+		}
+		hits = append(hits, h)
+	}
+
+	if err = rows.Err(); err != nil {
+		return hits, err
+	}
+
+	return hits, nil
+}
+
+// GetSanctionsHitByID returns a hit, or sql.ErrNoRows
+func (m *postgresDBRepo) GetSanctionsHitByID(id int) (models.SanctionsHit, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, "select "+sanctionsHitColumns+" where h.hit_id = $1", id)
+	return scanSanctionsHit(row)
+}
+
+// SanctionsHits returns the hits in a state, or all of them when state is
+// empty, the best matches first
+func (m *postgresDBRepo) SanctionsHits(state string) ([]models.SanctionsHit, error) {
+	if state == "" {
+		return m.querySanctionsHits("order by h.score desc, h.hit_id")
+	}
+	return m.querySanctionsHits("where h.state = $1 order by h.score desc, h.hit_id", state)
+}
+
+// GetSanctionsHitsByCustomerID returns the hits of a customer's shareholders
+// and representatives, the best matches first
+func (m *postgresDBRepo) GetSanctionsHitsByCustomerID(customerID int) ([]models.SanctionsHit, error) {
+	return m.querySanctionsHits("where h.customer_id = $1 order by h.score desc, h.hit_id", customerID)
+}
+
+// UpdateSanctionsHitState confirms or dismisses a pending hit. It returns
+// sql.ErrNoRows when the hit is not pending.
+func (m *postgresDBRepo) UpdateSanctionsHitState(id int, state string, userID int, comment string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update sanctions_hits set state = $1, reviewed_by = $2, comment = $3, updated_at = $4
+		where hit_id = $5 and state = $6`
+
+	result, err := m.DB.ExecContext(ctx, stmt, state, userID, comment, time.Now(), id, models.HitPending)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// exportTimeout bounds the queries that stream whole tables for exports
+const exportTimeout = 5 * time.Minute
+
+// customerFilterWhere returns the where clause selecting the customers, aliased
+// c, that f matches, with its arguments
+func customerFilterWhere(f models.CustomerFilter) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.Status != "" {
+		args = append(args, f.Status)
+		conds = append(conds, fmt.Sprintf("c.customer_status = $%d", len(args)))
+	}
+	if search := strings.TrimSpace(f.Search); search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(search)
+		args = append(args, "%"+escaped+"%")
+		n := len(args)
+		conds = append(conds, fmt.Sprintf(`(c.customer_code ilike $%[1]d or c.customer_name ilike $%[1]d
+			or c.contact_person ilike $%[1]d or c.contact_email ilike $%[1]d or c.customer_business ilike $%[1]d)`, n))
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return "where " + strings.Join(conds, " and "), args
+}
+
+// eachRow runs an export query on the read database and calls scan with each
+// row, stopping at the first error
+func (m *postgresDBRepo) eachRow(query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+
+	rows, err := m.Read.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// EachCustomer calls fn with every customer f matches, ordered by code,
+// stopping at the first error fn returns
+func (m *postgresDBRepo) EachCustomer(f models.CustomerFilter, fn func(models.Customer) error) error {
+	where, args := customerFilterWhere(f)
+	query := `select c.customer_id, c.customer_code, c.customer_name, c.contact_person, c.contact_tel,
+		c.contact_mobile, c.contact_email, c.customer_business, c.customer_location, c.customer_status,
+		c.marketer_name, c.marketer_code, c.marketer_email, c.business_nature, c.created_at, c.updated_at
+		from customers c ` + where + ` order by c.customer_code, c.customer_id`
+
+	return m.eachRow(query, args, func(rows *sql.Rows) error {
+		var c models.Customer
+		err := rows.Scan(
+			&c.CustomerId,
+			&c.CustomerCode,
+			&c.CustomerName,
+			&c.ContactPerson,
+			&c.ContactNo,
+			&c.MobileNo,
+			&c.Email,
+			&c.BusinessName,
+			&c.LocationDetails,
+			&c.Status,
+			&c.MarketerName,
+			&c.MarketedBy,
+			&c.MarketerEmail,
+			&c.NatureOfBusiness,
+			&c.CreatedAt,
+			&c.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(c)
+	})
+}
+
+// EachTradeLicense calls fn with the trade license of every customer f
+// matches, ordered by customer code, stopping at the first error fn returns
+func (m *postgresDBRepo) EachTradeLicense(f models.CustomerFilter, fn func(models.TradeLicense) error) error {
+	where, args := customerFilterWhere(f)
+	query := `select t.trade_license_id, t.customer_id, c.customer_code, t.trade_license_no, t.emirate,
+		t."mohreNo", t.trade_name, t.legal_status, t.establishment_date, t.registration_date,
+		t.license_expiray, t.file_path, t.file_name, t.created_at, t.updated_at
+		from trade_license t join customers c on (c.customer_id = t.customer_id) ` + where +
+		` order by c.customer_code, t.trade_license_id`
+
+	return m.eachRow(query, args, func(rows *sql.Rows) error {
+		var t models.TradeLicense
+		err := rows.Scan(
+			&t.TradeLicenseID,
+			&t.CustomerId,
+			&t.CustomerCode,
+			&t.TradeLicenseNo,
+			&t.Emirate,
+			&t.MohreNo,
+			&t.TradeName,
+			&t.LegalStatus,
+			&t.EstablishDate,
+			&t.RegistrationDate,
+			&t.LicenseExpiry,
+			&t.FilePath,
+			&t.FileName,
+			&t.CreatedAt,
+			&t.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(t)
+	})
+}
+
+// EachShareholder calls fn with the shareholders of every customer f matches,
+// ordered by customer code, stopping at the first error fn returns
+func (m *postgresDBRepo) EachShareholder(f models.CustomerFilter, fn func(models.TradeLicenseHolder) error) error {
+	where, args := customerFilterWhere(f)
+	query := `select s.trade_license_id, s.customer_id, s.shareholder_id, c.customer_code, c.customer_name,
+		s.shareholder_name, s.shareholder_role, s.shareholder_nationality, s.shareholder_no_of_shares,
+		s."shareholder_emirateID", s.emirateid_expire_date, s.shareholder_passport, s.passport_expire_date,
+		s.id_file_path, s.passport_file_path, s.created_at, s.updated_at
+		from trade_license_shareholders s join customers c on (c.customer_id = s.customer_id) ` + where +
+		` order by c.customer_code, s.shareholder_id`
+
+	return m.eachRow(query, args, func(rows *sql.Rows) error {
+		var s models.TradeLicenseHolder
+		err := rows.Scan(
+			&s.TradeLicenseID,
+			&s.CustomerId,
+			&s.ShareHolderID,
+			&s.CustomerCode,
+			&s.CustomerName,
+			&s.ShareHolderName,
+			&s.ShareHolderRole,
+			&s.ShNationality,
+			&s.ShNoOfShares,
+			&s.ShEmirateID,
+			&s.ShEmIDExp,
+			&s.ShPassport,
+			&s.ShPassportExp,
+			&s.ShIDFilepath,
+			&s.ShPassFilepath,
+			&s.CreatedAt,
+			&s.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(s)
+	})
+}
+
+// EachMemorandum calls fn with the memorandum representatives of every
+// customer f matches, ordered by customer code, stopping at the first error
+// fn returns
+func (m *postgresDBRepo) EachMemorandum(f models.CustomerFilter, fn func(models.Memorandum) error) error {
+	where, args := customerFilterWhere(f)
+	query := `select r.trade_license_id, r.customer_id, r.memorandum_id, c.customer_code,
+		r.representative_name, r.representative_no_of_shares, r."representative_emirateID",
+		r.emirateid_expire_date, r.representative_passport, r.passport_expire_date,
+		r.id_file_path, r.passport_file_path, r.created_at, r.updated_at
+		from memorandums r join customers c on (c.customer_id = r.customer_id) ` + where +
+		` order by c.customer_code, r.memorandum_id`
+
+	return m.eachRow(query, args, func(rows *sql.Rows) error {
+		var r models.Memorandum
+		err := rows.Scan(
+			&r.TradeLicenseID,
+			&r.CustomerId,
+			&r.MemorandumID,
+			&r.CustomerCode,
+			&r.RepresentativeName,
+			&r.RepNoOfShares,
+			&r.RepEmID,
+			&r.RepEmIDExp,
+			&r.RepPassport,
+			&r.RepPassportExp,
+			&r.RepIDFilepath,
+			&r.RepPassFilepath,
+			&r.CreatedAt,
+			&r.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+		return fn(r)
+	})
+}
+
+// GetCustomerIDByCode returns the ID of the customer with a customer code
+func (m *postgresDBRepo) GetCustomerIDByCode(code string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	query := `select customer_id from customers where customer_code = $1`
+	if err := m.DB.QueryRowContext(ctx, query, code).Scan(&id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// InsertCustomerImport stores an uploaded spreadsheet pending import and returns its ID
+func (m *postgresDBRepo) InsertCustomerImport(ci models.CustomerImport) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	headers, err := json.Marshal(ci.Headers)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := json.Marshal(ci.Rows)
+	if err != nil {
+		return 0, err
+	}
+	mapping, err := json.Marshal(ci.Mapping)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	stmt := `insert into customer_imports (user_id, kind, file_name, headers, rows, mapping, state, report,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, '[]', $8, $8) returning import_id`
+
+	err = m.DB.QueryRowContext(ctx, stmt,
+		ci.UserID,
+		ci.Kind,
+		ci.FileName,
+		string(headers),
+		string(rows),
+		string(mapping),
+		models.ImportPending,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// GetCustomerImportByID returns an import with its rows, mapping and report
+func (m *postgresDBRepo) GetCustomerImportByID(id int) (models.CustomerImport, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var ci models.CustomerImport
+	var headers, rows, mapping, report string
+	query := `select import_id, user_id, kind, file_name, headers, rows, mapping, state, report,
+		created_at, updated_at
+		from customer_imports where import_id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&ci.ID,
+		&ci.UserID,
+		&ci.Kind,
+		&ci.FileName,
+		&headers,
+		&rows,
+		&mapping,
+		&ci.State,
+		&report,
+		&ci.CreatedAt,
+		&ci.UpdatedAt,
+	)
+	if err != nil {
+		return ci, err
+	}
+
+	for _, f := range []struct {
+		text string
+		into interface{}
+	}{{headers, &ci.Headers}, {rows, &ci.Rows}, {mapping, &ci.Mapping}, {report, &ci.Report}} {
+		if err := json.Unmarshal([]byte(f.text), f.into); err != nil {
+			return ci, err
+		}
+	}
+
+	return ci, nil
+}
+
+// UpdateCustomerImportMapping sets which column each field of a pending import
+// is read from. It returns sql.ErrNoRows when the import is not pending.
+func (m *postgresDBRepo) UpdateCustomerImportMapping(id int, mapping map[string]int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	text, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update customer_imports set mapping = $1, updated_at = $2 where import_id = $3 and state = $4`
+	result, err := m.DB.ExecContext(ctx, stmt, string(text), time.Now(), id, models.ImportPending)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// CommitCustomerImport stores the valid rows of a pending import in one
+// transaction, so either all of them are stored or none, and keeps rows as
+// the import's report. Each stored record is submitted for review by
+// submittedBy in the same transaction. The IDs of the stored records are set
+// in rows. It returns sql.ErrNoRows when the import is not pending.
+func (m *postgresDBRepo) CommitCustomerImport(id int, rows []models.ImportRow, submittedBy int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var state string
+	query := `select state from customer_imports where import_id = $1 for update`
+	if err := tx.QueryRowContext(ctx, query, id).Scan(&state); err != nil {
+		return err
+	}
+	if state != models.ImportPending {
+		return sql.ErrNoRows
+	}
+
+	now := time.Now()
+	for i, row := range rows {
+		if !row.Valid() {
+			continue
+		}
+		rv := models.KYCReview{CustomerID: row.CustomerID, SubmittedBy: submittedBy}
+		switch {
+		case row.Customer != nil:
+			rows[i].RecordID, err = insertCustomer(ctx, tx, *row.Customer)
+			rows[i].CustomerID = rows[i].RecordID
+			rv.CustomerID, rv.RecordType = rows[i].RecordID, models.RecordCustomer
+		case row.TradeLicense != nil:
+			tl := *row.TradeLicense
+			tl.CreatedAt, tl.UpdatedAt = now, now
+			rows[i].RecordID, err = insertTradeLicense(ctx, tx, tl)
+			rv.RecordType = models.RecordTradeLicense
+		case row.Shareholder != nil:
+			sh := *row.Shareholder
+			sh.CreatedAt, sh.UpdatedAt = now, now
+			rows[i].RecordID, err = insertPartner(ctx, tx, sh)
+			rv.RecordType = models.RecordPartner
+		}
+		if err == nil {
+			rv.RecordID = rows[i].RecordID
+			_, err = insertKYCReview(ctx, tx, rv, now)
+		}
+		if err != nil {
+			return fmt.Errorf("row %d: %w", row.Row, err)
+		}
+	}
+
+	report, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	stmt := `update customer_imports set state = $1, report = $2, updated_at = $3 where import_id = $4`
+	if _, err := tx.ExecContext(ctx, stmt, models.ImportCommitted, string(report), now, id); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// roomColumns are the columns scanned by scanRoom
+const roomColumns = `id, room_name, coalesce(slug, ''), description, capacity, amenities, nightly_rate, weekend_rate,
+	min_nights, created_at, updated_at`
+
+// scanRoom scans the roomColumns of a row into a room
+func scanRoom(scan func(dest ...interface{}) error) (models.Room, error) {
+	var room models.Room
+	var amenities string
+	err := scan(&room.ID, &room.RoomName, &room.Slug, &room.Description, &room.Capacity, &amenities,
+		&room.NightlyRate, &room.WeekendRate, &room.MinNights, &room.CreatedAt, &room.UppdatedAt)
+	if err != nil {
+		return room, err
+	}
+	if err := json.Unmarshal([]byte(amenities), &room.Amenities); err != nil {
+		return room, fmt.Errorf("amenities of room %d: %w", room.ID, err)
+	}
+	return room, nil
+}
+
+// AllRooms returns all rooms by name, without their photos
+func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.Read.QueryContext(ctx, `select `+roomColumns+` from rooms order by room_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rooms []models.Room
+	for rows.Next() {
+		room, err := scanRoom(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// GetRoomByID returns a room with its photos
+func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
+	return m.getRoom("id = $1", id)
+}
+
+// GetRoomBySlug returns the room whose page is /rooms/{slug}, with its photos
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	return m.getRoom("slug = $1", slug)
+}
+
+func (m *postgresDBRepo) getRoom(where string, arg interface{}) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	room, err := scanRoom(m.DB.QueryRowContext(ctx, `select `+roomColumns+` from rooms where `+where, arg).Scan)
+	if err != nil {
+		return room, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `select photo_id, room_id, file_path, content_type, caption, position, created_at
+		from room_photos where room_id = $1 order by position, photo_id`, room.ID)
+	if err != nil {
+		return room, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+		if err := rows.Scan(&p.ID, &p.RoomID, &p.FilePath, &p.ContentType, &p.Caption, &p.Position, &p.CreatedAt); err != nil {
+			return room, err
+		}
+		room.Photos = append(room.Photos, p)
+	}
+	return room, rows.Err()
+}
+
+// InsertRoom inserts a room and returns its ID
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	amenities, err := json.Marshal(room.Amenities)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+	err = m.DB.QueryRowContext(ctx, `insert into rooms (room_name, slug, description, capacity, amenities, nightly_rate,
+		weekend_rate, min_nights, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) returning id`,
+		room.RoomName, room.Slug, room.Description, room.Capacity, string(amenities), room.NightlyRate,
+		room.WeekendRate, room.MinNights, time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// UpdateRoom updates the details of a room, leaving its photos alone
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	amenities, err := json.Marshal(room.Amenities)
+	if err != nil {
+		return err
+	}
+
+	res, err := m.DB.ExecContext(ctx, `update rooms set room_name = $1, slug = $2, description = $3, capacity = $4,
+		amenities = $5, nightly_rate = $6, weekend_rate = $7, min_nights = $8, updated_at = $9 where id = $10`,
+		room.RoomName, room.Slug, room.Description, room.Capacity, string(amenities), room.NightlyRate,
+		room.WeekendRate, room.MinNights, time.Now(), room.ID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteRoom deletes a room with its photos and rate periods. Rooms that were
+// ever reserved or blocked are kept, as deleting them would delete the
+// reservations.
+func (m *postgresDBRepo) DeleteRoom(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var used bool
+	err = tx.QueryRowContext(ctx, `select exists (select 1 from reservations where room_id = $1)
+		or exists (select 1 from room_restrictions where room_id = $1)`, id).Scan(&used)
+	if err != nil {
+		return err
+	}
+	if used {
+		return repository.ErrRoomInUse
+	}
+
+	res, err := tx.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
+}
+
+// InsertRoomPhoto records a photo of a room, after the room's other photos
+func (m *postgresDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, `insert into room_photos (room_id, file_path, content_type, caption, position,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, (select coalesce(max(position), 0) + 1 from room_photos where room_id = $1), $5, $5)
+		returning photo_id`,
+		p.RoomID, p.FilePath, p.ContentType, p.Caption, time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// GetRoomPhotoByID returns one photo of a room
+func (m *postgresDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.RoomPhoto
+	err := m.DB.QueryRowContext(ctx, `select photo_id, room_id, file_path, content_type, caption, position, created_at
+		from room_photos where photo_id = $1`, id,
+	).Scan(&p.ID, &p.RoomID, &p.FilePath, &p.ContentType, &p.Caption, &p.Position, &p.CreatedAt)
+	return p, err
+}
+
+// DeleteRoomPhoto deletes the record of a photo, the caller removes its file
+func (m *postgresDBRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from room_photos where photo_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// RoomRestrictions returns the restrictions of a room that end after from, by
+// start date
+func (m *postgresDBRepo) RoomRestrictions(roomID int, from time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.Read.QueryContext(ctx, `select rr.id, rr.start_date, rr.end_date, rr.room_id,
+		coalesce(rr.reservation_id, 0), rr.restriction_id, coalesce(rr.calendar_id, 0), rr.created_at, rr.updated_at,
+		coalesce(r.restriction_name, '')
+		from room_restrictions rr left join restrictions r on (r.id = rr.restriction_id)
+		where rr.room_id = $1 and rr.end_date > $2
+		order by rr.start_date, rr.id`, roomID, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var restrictions []models.RoomRestriction
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(&r.ID, &r.StartDate, &r.EndDate, &r.RoomID, &r.ReservationID, &r.RestrictionID,
+			&r.CalendarID, &r.CreatedAt, &r.UpdatedAt, &r.Restriction.RestrictionName)
+		if err != nil {
+			return nil, err
+		}
+		r.Restriction.ID = r.RestrictionID
+		restrictions = append(restrictions, r)
+	}
+	return restrictions, rows.Err()
+}
+
+// roomCalendarColumns are the columns scanned by scanRoomCalendar
+const roomCalendarColumns = `c.calendar_id, c.room_id, c.name, c.source, c.last_imported_at, c.last_error, c.blocks,
+	c.created_at, c.updated_at, rm.room_name, rm.slug`
+
+// scanRoomCalendar scans the roomCalendarColumns of a row into a calendar
+func scanRoomCalendar(scan func(dest ...interface{}) error) (models.RoomCalendar, error) {
+	var c models.RoomCalendar
+	var imported sql.NullTime
+	err := scan(&c.ID, &c.RoomID, &c.Name, &c.Source, &imported, &c.LastError, &c.Blocks,
+		&c.CreatedAt, &c.UpdatedAt, &c.Room.RoomName, &c.Room.Slug)
+	c.LastImportedAt = imported.Time
+	c.Room.ID = c.RoomID
+	return c, err
+}
+
+// calendarConflicts returns the conflicts of the calendars of a room, or of
+// every room when roomID is 0, by calendar. It reads from the primary, as
+// GetRoomCalendar resolves conflicts right after an import writes them
+func (m *postgresDBRepo) calendarConflicts(ctx context.Context, roomID int) (map[int][]models.CalendarConflict, error) {
+	rows, err := m.DB.QueryContext(ctx, `select f.conflict_id, f.calendar_id, f.uid, f.summary, f.start_date,
+		f.end_date, f.restriction_id, coalesce(f.reservation_id, 0), f.created_at
+		from calendar_conflicts f join room_calendars c on (c.calendar_id = f.calendar_id)
+		where $1 = 0 or c.room_id = $1
+		order by f.conflict_id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	conflicts := map[int][]models.CalendarConflict{}
+	for rows.Next() {
+		var f models.CalendarConflict
+		err := rows.Scan(&f.ID, &f.CalendarID, &f.Event.UID, &f.Event.Summary, &f.Event.StartDate,
+			&f.Event.EndDate, &f.RestrictionID, &f.ReservationID, &f.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		conflicts[f.CalendarID] = append(conflicts[f.CalendarID], f)
+	}
+	return conflicts, rows.Err()
+}
+
+// RoomCalendars returns the external calendars of a room, or of every room
+// when roomID is 0, with the conflicts of their last import
+func (m *postgresDBRepo) RoomCalendars(roomID int) ([]models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.Read.QueryContext(ctx, `select `+roomCalendarColumns+`
+		from room_calendars c join rooms rm on (rm.id = c.room_id)
+		where $1 = 0 or c.room_id = $1
+		order by c.calendar_id`, roomID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var calendars []models.RoomCalendar
+	for rows.Next() {
+		c, err := scanRoomCalendar(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	conflicts, err := m.calendarConflicts(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range calendars {
+		calendars[i].Conflicts = conflicts[calendars[i].ID]
+	}
+	return calendars, nil
+}
+
+// GetRoomCalendar returns an external calendar by ID
+func (m *postgresDBRepo) GetRoomCalendar(id int) (models.RoomCalendar, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	row := m.DB.QueryRowContext(ctx, `select `+roomCalendarColumns+`
+		from room_calendars c join rooms rm on (rm.id = c.room_id)
+		where c.calendar_id = $1`, id)
+	c, err := scanRoomCalendar(row.Scan)
+	if err != nil {
+		return c, err
+	}
+
+	conflicts, err := m.calendarConflicts(ctx, c.RoomID)
+	c.Conflicts = conflicts[c.ID]
+	return c, err
+}
+
+// InsertRoomCalendar adds an external calendar to a room and returns its ID
+func (m *postgresDBRepo) InsertRoomCalendar(c models.RoomCalendar) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, `insert into room_calendars (room_id, name, source, created_at, updated_at)
+		values ($1, $2, $3, $4, $4) returning calendar_id`,
+		c.RoomID, c.Name, c.Source, time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// DeleteRoomCalendar removes an external calendar and frees the dates it
+// blocked, which are deleted with it
+func (m *postgresDBRepo) DeleteRoomCalendar(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from room_calendars where calendar_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ImportRoomCalendar replaces the external restrictions of a calendar with
+// its events and returns the events overlapping other restrictions of the
+// room, which are kept as the calendar's conflicts
+func (m *postgresDBRepo) ImportRoomCalendar(calendarID int, events []models.CalendarEvent) ([]models.CalendarConflict, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Lock the room, so reservations made meanwhile are either seen as
+	// conflicts or see the imported dates
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select rm.id from room_calendars c join rooms rm on (rm.id = c.room_id)
+		where c.calendar_id = $1 for update of rm`, calendarID).Scan(&roomID)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from room_restrictions where calendar_id = $1`, calendarID); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from calendar_conflicts where calendar_id = $1`, calendarID); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var conflicts []models.CalendarConflict
+	for _, e := range events {
+		rows, err := tx.QueryContext(ctx, `select restriction_id, coalesce(reservation_id, 0) from room_restrictions
+			where room_id = $1 and $2 < end_date and $3 > start_date order by id`, roomID, e.StartDate, e.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		var found []models.CalendarConflict
+		for rows.Next() {
+			f := models.CalendarConflict{CalendarID: calendarID, Event: e, CreatedAt: now}
+			if err := rows.Scan(&f.RestrictionID, &f.ReservationID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			found = append(found, f)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, found...)
+	}
+
+	for i, f := range conflicts {
+		var reservationID interface{}
+		if f.ReservationID != 0 {
+			reservationID = f.ReservationID
+		}
+		err := tx.QueryRowContext(ctx, `insert into calendar_conflicts (calendar_id, uid, summary, start_date,
+			end_date, restriction_id, reservation_id, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $8) returning conflict_id`,
+			calendarID, f.Event.UID, f.Event.Summary, f.Event.StartDate, f.Event.EndDate, f.RestrictionID,
+			reservationID, now,
+		).Scan(&conflicts[i].ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, e := range events {
+		_, err := tx.ExecContext(ctx, `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+			calendar_id, created_at, updated_at) values ($1, $2, $3, $4, $5, $6, $6)`,
+			e.StartDate, e.EndDate, roomID, models.RestrictionExternal, calendarID, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `update room_calendars set last_imported_at = $1, last_error = '', blocks = $2,
+		updated_at = $1 where calendar_id = $3`, now, len(events), calendarID)
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, tx.Commit()
+}
+
+// SetRoomCalendarError records why the last import of a calendar failed,
+// keeping the dates it blocked before
+func (m *postgresDBRepo) SetRoomCalendarError(id int, msg string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `update room_calendars set last_error = $1, updated_at = $2
+		where calendar_id = $3`, msg, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ratePeriodColumns are the columns scanned by scanRatePeriod
+const ratePeriodColumns = `p.period_id, coalesce(p.room_id, 0), p.name, p.start_date, p.end_date, p.nightly_rate,
+	p.weekend_rate, p.percent, p.min_nights, p.created_at, p.updated_at, coalesce(rm.room_name, '')`
+
+// scanRatePeriod scans the ratePeriodColumns of a row into a rate period
+func scanRatePeriod(scan func(dest ...interface{}) error) (models.RatePeriod, error) {
+	var p models.RatePeriod
+	err := scan(&p.ID, &p.RoomID, &p.Name, &p.StartDate, &p.EndDate, &p.NightlyRate,
+		&p.WeekendRate, &p.Percent, &p.MinNights, &p.CreatedAt, &p.UpdatedAt, &p.Room.RoomName)
+	p.Room.ID = p.RoomID
+	return p, err
+}
+
+// queryRatePeriods returns the rate periods matching where, by start date. It
+// reads from the primary, as the periods price reservations and requotes
+func (m *postgresDBRepo) queryRatePeriods(where string, args ...interface{}) ([]models.RatePeriod, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, `select `+ratePeriodColumns+`
+		from rate_periods p left join rooms rm on (p.room_id = rm.id)
+		where `+where+` order by p.start_date, p.period_id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var periods []models.RatePeriod
+	for rows.Next() {
+		p, err := scanRatePeriod(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		periods = append(periods, p)
+	}
+	return periods, rows.Err()
+}
+
+// AllRatePeriods returns all rate periods by start date, with the names of their rooms
+func (m *postgresDBRepo) AllRatePeriods() ([]models.RatePeriod, error) {
+	return m.queryRatePeriods("true")
+}
+
+// RatePeriodsForRoom returns the rate periods of a room and of all rooms that
+// have nights from start to end
+func (m *postgresDBRepo) RatePeriodsForRoom(roomID int, start, end time.Time) ([]models.RatePeriod, error) {
+	return m.queryRatePeriods("(p.room_id = $1 or p.room_id is null) and p.start_date < $3 and p.end_date >= $2",
+		roomID, start, end)
+}
+
+// InsertRatePeriod inserts a rate period and returns its ID
+func (m *postgresDBRepo) InsertRatePeriod(p models.RatePeriod) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var roomID interface{}
+	if p.RoomID != 0 {
+		roomID = p.RoomID
+	}
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, `insert into rate_periods (room_id, name, start_date, end_date, nightly_rate,
+		weekend_rate, percent, min_nights, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $9) returning period_id`,
+		roomID, p.Name, p.StartDate, p.EndDate, p.NightlyRate, p.WeekendRate, p.Percent, p.MinNights, time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// DeleteRatePeriod deletes a rate period. Reservations keep the prices worked out with it.
+func (m *postgresDBRepo) DeleteRatePeriod(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `delete from rate_periods where period_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// discountCodeColumns are the columns scanned by scanDiscountCode
+const discountCodeColumns = `code_id, code, description, percent, amount, valid_from, valid_to, min_nights,
+	max_uses, uses, active, created_at, updated_at`
+
+// scanDiscountCode scans the discountCodeColumns of a row into a discount code
+func scanDiscountCode(scan func(dest ...interface{}) error) (models.DiscountCode, error) {
+	var d models.DiscountCode
+	var from, to sql.NullTime
+	err := scan(&d.ID, &d.Code, &d.Description, &d.Percent, &d.Amount, &from, &to, &d.MinNights,
+		&d.MaxUses, &d.Uses, &d.Active, &d.CreatedAt, &d.UpdatedAt)
+	d.ValidFrom, d.ValidTo = from.Time, to.Time
+	return d, err
+}
+
+// AllDiscountCodes returns all discount codes in alphabetical order
+func (m *postgresDBRepo) AllDiscountCodes() ([]models.DiscountCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.Read.QueryContext(ctx, `select `+discountCodeColumns+` from discount_codes order by code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []models.DiscountCode
+	for rows.Next() {
+		d, err := scanDiscountCode(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, d)
+	}
+	return codes, rows.Err()
+}
+
+// GetDiscountCode returns the discount code a guest entered, already normalized
+func (m *postgresDBRepo) GetDiscountCode(code string) (models.DiscountCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return scanDiscountCode(m.DB.QueryRowContext(ctx,
+		`select `+discountCodeColumns+` from discount_codes where code = $1`, code).Scan)
+}
+
+// InsertDiscountCode inserts a discount code and returns its ID
+func (m *postgresDBRepo) InsertDiscountCode(d models.DiscountCode) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	from := sql.NullTime{Time: d.ValidFrom, Valid: !d.ValidFrom.IsZero()}
+	to := sql.NullTime{Time: d.ValidTo, Valid: !d.ValidTo.IsZero()}
+
+	var newID int
+	err := m.DB.QueryRowContext(ctx, `insert into discount_codes (code, description, percent, amount, valid_from,
+		valid_to, min_nights, max_uses, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) returning code_id`,
+		d.Code, d.Description, d.Percent, d.Amount, from, to, d.MinNights, d.MaxUses, d.Active, time.Now(),
+	).Scan(&newID)
+	return newID, err
+}
+
+// SetDiscountCodeActive turns a discount code on or off
+func (m *postgresDBRepo) SetDiscountCodeActive(id int, active bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := m.DB.ExecContext(ctx, `update discount_codes set active = $1, updated_at = $2 where code_id = $3`,
+		active, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
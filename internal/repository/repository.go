@@ -1,40 +1,133 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/chamrasilva89/reservationWeb/internal/models"
 )
 
+// ErrRoomInUse is returned when deleting a room that was reserved or blocked
+var ErrRoomInUse = errors.New("the room has reservations or blocked dates")
+
+// ErrDiscountUsedUp is returned when storing a reservation with a discount
+// code that reached its maximum uses
+var ErrDiscountUsedUp = errors.New("the discount code has been used up")
+
+// ErrNotAvailable is returned when booking or moving a reservation to dates on
+// which its room is reserved or blocked
+var ErrNotAvailable = errors.New("the room is not available on these dates")
+
+// ErrReviewsPending is returned when activating a customer one of whose
+// records' reviews is not approved
+var ErrReviewsPending = errors.New("not every review of the customer is approved")
+
+// ErrDuplicateFile is returned when recording a file whose checksum the
+// customer already has a file with
+var ErrDuplicateFile = errors.New("the customer already has this file")
+
 type DatabaseRepo interface {
 	AllUsers() bool
+	Ping() error
 
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
 	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
 	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
 
+	AllRooms() ([]models.Room, error)
+	GetRoomByID(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	DeleteRoom(id int) error
+	InsertRoomPhoto(p models.RoomPhoto) (int, error)
+	GetRoomPhotoByID(id int) (models.RoomPhoto, error)
+	DeleteRoomPhoto(id int) error
+	RoomRestrictions(roomID int, from time.Time) ([]models.RoomRestriction, error)
+	RoomCalendars(roomID int) ([]models.RoomCalendar, error)
+	GetRoomCalendar(id int) (models.RoomCalendar, error)
+	InsertRoomCalendar(c models.RoomCalendar) (int, error)
+	DeleteRoomCalendar(id int) error
+	ImportRoomCalendar(calendarID int, events []models.CalendarEvent) ([]models.CalendarConflict, error)
+	SetRoomCalendarError(id int, msg string) error
+
+	AllRatePeriods() ([]models.RatePeriod, error)
+	RatePeriodsForRoom(roomID int, start, end time.Time) ([]models.RatePeriod, error)
+	InsertRatePeriod(p models.RatePeriod) (int, error)
+	DeleteRatePeriod(id int) error
+	AllDiscountCodes() ([]models.DiscountCode, error)
+	GetDiscountCode(code string) (models.DiscountCode, error)
+	InsertDiscountCode(d models.DiscountCode) (int, error)
+	SetDiscountCodeActive(id int, active bool) error
+
 	GetUserByID(id int) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
 
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	AllReservations(state string) ([]models.Reservation, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByReference(reference string) (models.Reservation, error)
 	UpdateReservation(u models.Reservation) error
 	DeleteReservation(id int) error
-	UpdateProcessedForReservation(id, processed int) error
+	TransitionReservation(e models.ReservationEvent) error
+	GetReservationHistory(reservationID int) ([]models.ReservationEvent, error)
+	SetPaymentIntent(reservationID int, intentID, clientSecret string) error
+	GetReservationByPaymentIntent(intentID string) (models.Reservation, error)
+	ApplyPaymentEvent(e models.PaymentEvent) (bool, error)
+	ChangeReservationDates(id int, start, end time.Time, price models.Price) error
+	CancelReservation(e models.ReservationEvent, fee models.Money) error
 
-	InsertCustomer(res models.Customer) (int, error)
+	InsertCustomer(res models.Customer, submittedBy int) (int, error)
 	AllCustomers() ([]models.Customer, error)
-	InsertFile(customerCode, filePath string, customerId int, uniqueFilenameWithExtension string) (int, error)
+	InsertFile(a models.Attachment) (int, error)
+	GetAttachmentByChecksum(customerID int, checksum string) (models.Attachment, error)
+	GetAttachmentByID(id int) (models.Attachment, error)
 	GetCustomerByID(id int) (models.Customer, error)
 	GetAttachmentsByCustomerID(id int) (models.CustomerImages, error)
 	GetTradeShareInforByID(id int) ([]models.TradeLicenseHolder, error)
 	GetTradeLicenseInforByID(id int) (models.TradeLicense, error)
-	InsertTradeLicense(res models.TradeLicense) (int, error)
+	GetTradeLicenseByLicenseID(id int) (models.TradeLicense, error)
+	InsertTradeLicense(res models.TradeLicense, submittedBy int) (int, error)
 	GetMemorandumInforByID(id int) ([]models.Memorandum, error)
-	InsertPartner(res models.TradeLicenseHolder) (int, error)
+	InsertPartner(res models.TradeLicenseHolder, submittedBy int) (int, error)
 	GetCustomerCodeByID(id int) (string, error)
-	InsertMemorandum(res models.Memorandum) (int, error)
+	InsertMemorandum(res models.Memorandum, submittedBy int) (int, error)
+	UpdateCustomerStatus(id int, status string) error
+	ActivateCustomer(id int) error
+
+	GetCustomerDraft(customerID, userID int, step string) (models.CustomerDraft, error)
+	GetCustomerDrafts(customerID int) ([]models.CustomerDraft, error)
+	SaveCustomerDraft(d models.CustomerDraft) error
+	DeleteCustomerDraft(customerID, userID int, step string) error
+
+	GetKYCReviewByID(id int) (models.KYCReview, error)
+	GetKYCReviewsByCustomerID(customerID int) ([]models.KYCReview, error)
+	PendingKYCReviews() ([]models.KYCReview, error)
+	UpdateKYCReviewState(e models.KYCReviewEvent) error
+	GetKYCReviewHistory(reviewID int) ([]models.KYCReviewEvent, error)
+
+	SaveRiskAssessment(a models.RiskAssessment) error
+	GetRiskAssessment(customerID int) (models.RiskAssessment, error)
+
+	ReplaceSanctionsEntries(source string, entries []models.SanctionsEntry) error
+	AllSanctionsEntries() ([]models.SanctionsEntry, error)
+	SanctionsLists() ([]models.SanctionsList, error)
+	ScreeningSubjects() ([]models.ScreeningSubject, error)
+	InsertSanctionsHit(h models.SanctionsHit) (int, error)
+	GetSanctionsHitByID(id int) (models.SanctionsHit, error)
+	SanctionsHits(state string) ([]models.SanctionsHit, error)
+	GetSanctionsHitsByCustomerID(customerID int) ([]models.SanctionsHit, error)
+	UpdateSanctionsHitState(id int, state string, userID int, comment string) error
+
+	EachCustomer(f models.CustomerFilter, fn func(models.Customer) error) error
+	EachTradeLicense(f models.CustomerFilter, fn func(models.TradeLicense) error) error
+	EachShareholder(f models.CustomerFilter, fn func(models.TradeLicenseHolder) error) error
+	EachMemorandum(f models.CustomerFilter, fn func(models.Memorandum) error) error
+
+	GetCustomerIDByCode(code string) (int, error)
+	InsertCustomerImport(ci models.CustomerImport) (int, error)
+	GetCustomerImportByID(id int) (models.CustomerImport, error)
+	UpdateCustomerImportMapping(id int, mapping map[string]int) error
+	CommitCustomerImport(id int, rows []models.ImportRow, submittedBy int) error
 }
@@ -0,0 +1,295 @@
+// Package risk rates the money laundering risk of customers with configurable
+// scoring rules
+package risk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// Kinds of rules
+const (
+	// KindNationality matches shareholders whose nationality is one of Values
+	KindNationality = "nationality"
+	// KindLegalStatus matches trade licenses whose legal status is one of Values
+	KindLegalStatus = "legal_status"
+	// KindBusiness matches customers whose nature of business mentions one of
+	// Values as whole words
+	KindBusiness = "business"
+	// KindExpiredDocuments matches customers with an expired trade license,
+	// Emirates ID or passport
+	KindExpiredDocuments = "expired_documents"
+	// KindForeignShareholders matches customers with at least Min shareholders
+	// whose nationality is not one of Values
+	KindForeignShareholders = "foreign_shareholders"
+)
+
+// Rule adds Points to the score of the customers it matches
+type Rule struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Kind        string   `json:"kind"`
+	Values      []string `json:"values,omitempty"`
+	Min         int      `json:"min,omitempty"`
+	Points      int      `json:"points"`
+}
+
+// Engine scores customers with a set of rules. Scores of at least Medium are
+// rated medium risk and scores of at least High are rated high risk.
+type Engine struct {
+	Medium int    `json:"medium"`
+	High   int    `json:"high"`
+	Rules  []Rule `json:"rules"`
+}
+
+// Default returns the rules used when none are configured
+func Default() *Engine {
+	return &Engine{
+		Medium: 30,
+		High:   60,
+		Rules: []Rule{
+			{
+				Name:        "high_risk_nationality",
+				Description: "Shareholder from a high risk jurisdiction",
+				Kind:        KindNationality,
+				Values: []string{"Iran, Islamic Republic of", "Korea, Democratic People's Republic of",
+					"Myanmar", "Syrian Arab Republic", "Yemen", "Afghanistan"},
+				Points: 60,
+			},
+			{
+				Name:        "cash_intensive_business",
+				Description: "Cash intensive or high value goods business",
+				Kind:        KindBusiness,
+				Values: []string{"gold", "jewellery", "jewelry", "jewels", "precious", "diamond", "diamonds",
+					"money exchange", "remittance", "remittances", "hawala", "real estate", "crypto",
+					"cryptocurrency", "virtual asset", "virtual assets", "used car", "used cars", "arms"},
+				Points: 30,
+			},
+			{
+				Name:        "sole_proprietorship",
+				Description: "Sole proprietorship, ownership and control rest with one person",
+				Kind:        KindLegalStatus,
+				Values:      []string{"Proprietorship"},
+				Points:      10,
+			},
+			{
+				Name:        "expired_documents",
+				Description: "Expired trade license or identity documents",
+				Kind:        KindExpiredDocuments,
+				Points:      20,
+			},
+			{
+				Name:        "foreign_shareholders",
+				Description: "Two or more foreign shareholders",
+				Kind:        KindForeignShareholders,
+				Values:      []string{"United Arab Emirates"},
+				Min:         2,
+				Points:      15,
+			},
+		},
+	}
+}
+
+// Load reads rules in JSON, in the format of Default
+func Load(r io.Reader) (*Engine, error) {
+	var e Engine
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&e); err != nil {
+		return nil, fmt.Errorf("risk: cannot read rules: %w", err)
+	}
+	if err := e.check(); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+// LoadFile reads rules from a JSON file
+func LoadFile(path string) (*Engine, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Load(f)
+}
+
+// check reports the first mistake in the rules
+func (e *Engine) check() error {
+	if e.Medium <= 0 || e.High <= e.Medium {
+		return fmt.Errorf("risk: thresholds must be 0 < medium < high, got %d and %d", e.Medium, e.High)
+	}
+	seen := make(map[string]bool)
+	for _, r := range e.Rules {
+		if r.Name == "" || seen[r.Name] {
+			return fmt.Errorf("risk: every rule needs a unique name, got %q", r.Name)
+		}
+		seen[r.Name] = true
+		switch r.Kind {
+		case KindNationality, KindLegalStatus, KindBusiness, KindForeignShareholders:
+			if len(r.Values) == 0 {
+				return fmt.Errorf("risk: rule %s needs values", r.Name)
+			}
+		case KindExpiredDocuments:
+		default:
+			return fmt.Errorf("risk: rule %s has unknown kind %q", r.Name, r.Kind)
+		}
+	}
+	return nil
+}
+
+// Profile is everything recorded about a customer that rules look at
+type Profile struct {
+	Customer     models.Customer
+	TradeLicense *models.TradeLicense // nil until one is recorded
+	Partners     []models.TradeLicenseHolder
+	Memorandum   []models.Memorandum
+}
+
+// Assess scores a customer as of now
+func (e *Engine) Assess(p Profile, now time.Time) models.RiskAssessment {
+	a := models.RiskAssessment{CustomerID: p.Customer.CustomerId, AssessedAt: now}
+	for _, r := range e.Rules {
+		detail, ok := r.match(p, now)
+		if !ok {
+			continue
+		}
+		a.Score += r.Points
+		a.Factors = append(a.Factors, models.RiskFactor{
+			Rule:        r.Name,
+			Description: r.Description,
+			Points:      r.Points,
+			Detail:      detail,
+		})
+	}
+
+	switch {
+	case a.Score >= e.High:
+		a.Rating = models.RiskHigh
+	case a.Score >= e.Medium:
+		a.Rating = models.RiskMedium
+	default:
+		a.Rating = models.RiskLow
+	}
+	return a
+}
+
+// match reports whether a rule matches the customer, with what matched
+func (r Rule) match(p Profile, now time.Time) (string, bool) {
+	switch r.Kind {
+	case KindNationality:
+		var names []string
+		for _, s := range p.Partners {
+			if oneOf(s.ShNationality, r.Values) {
+				names = append(names, fmt.Sprintf("%s (%s)", s.ShareHolderName, s.ShNationality))
+			}
+		}
+		return strings.Join(names, ", "), len(names) > 0
+
+	case KindLegalStatus:
+		if p.TradeLicense != nil && oneOf(p.TradeLicense.LegalStatus, r.Values) {
+			return p.TradeLicense.LegalStatus, true
+		}
+
+	case KindBusiness:
+		business := strings.ToLower(p.Customer.NatureOfBusiness)
+		for _, v := range r.Values {
+			if v != "" && mentions(business, strings.ToLower(v)) {
+				return v, true
+			}
+		}
+
+	case KindExpiredDocuments:
+		expired := expiredDocuments(p, now)
+		return strings.Join(expired, ", "), len(expired) > 0
+
+	case KindForeignShareholders:
+		var names []string
+		for _, s := range p.Partners {
+			if s.ShNationality != "" && !oneOf(s.ShNationality, r.Values) {
+				names = append(names, s.ShareHolderName)
+			}
+		}
+		min := r.Min
+		if min < 1 {
+			min = 1
+		}
+		if len(names) >= min {
+			return fmt.Sprintf("%d foreign shareholders: %s", len(names), strings.Join(names, ", ")), true
+		}
+	}
+	return "", false
+}
+
+// expiredDocuments lists the customer's documents that expired before today.
+// Documents without an expiry date are left out.
+func expiredDocuments(p Profile, now time.Time) []string {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	expired := func(t time.Time) bool { return !t.IsZero() && t.Before(today) }
+
+	var docs []string
+	if p.TradeLicense != nil && expired(p.TradeLicense.LicenseExpiry) {
+		docs = append(docs, "trade license")
+	}
+	for _, s := range p.Partners {
+		if expired(s.ShEmIDExp) {
+			docs = append(docs, s.ShareHolderName+"'s Emirates ID")
+		}
+		if expired(s.ShPassportExp) {
+			docs = append(docs, s.ShareHolderName+"'s passport")
+		}
+	}
+	for _, rep := range p.Memorandum {
+		if expired(rep.RepEmIDExp) {
+			docs = append(docs, rep.RepresentativeName+"'s Emirates ID")
+		}
+		if expired(rep.RepPassportExp) {
+			docs = append(docs, rep.RepresentativeName+"'s passport")
+		}
+	}
+	return docs
+}
+
+// mentions reports whether text contains phrase as whole words, so that arms
+// matches "arms trading" but not "farms" or "alarms"
+func mentions(text, phrase string) bool {
+	for i := 0; i+len(phrase) <= len(text); {
+		j := strings.Index(text[i:], phrase)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(phrase)
+		before, _ := utf8.DecodeLastRuneInString(text[:start])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !isWord(before) && !isWord(after) {
+			return true
+		}
+		i = start + 1
+	}
+	return false
+}
+
+// isWord reports whether r is part of a word, utf8.RuneError at either end of
+// the text is not
+func isWord(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// oneOf reports whether s is one of values, ignoring case and surrounding spaces
+func oneOf(s string, values []string) bool {
+	s = strings.TrimSpace(s)
+	for _, v := range values {
+		if strings.EqualFold(s, strings.TrimSpace(v)) {
+			return true
+		}
+	}
+	return false
+}
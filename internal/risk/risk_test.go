@@ -0,0 +1,104 @@
+package risk
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+var now = time.Date(2026, time.October, 19, 10, 0, 0, 0, time.UTC)
+
+func TestEngine_Assess(t *testing.T) {
+	e := Default()
+	nextYear := now.AddDate(1, 0, 0)
+	lastMonth := now.AddDate(0, -1, 0)
+
+	var tests = []struct {
+		name    string
+		profile Profile
+		score   int
+		rating  string
+		rules   []string
+	}{
+		{"nothing recorded", Profile{}, 0, models.RiskLow, nil},
+		{"local trading company", Profile{
+			Customer:     models.Customer{NatureOfBusiness: "General trading of foodstuff"},
+			TradeLicense: &models.TradeLicense{LegalStatus: "Limited Liability Company", LicenseExpiry: nextYear},
+			Partners:     []models.TradeLicenseHolder{{ShareHolderName: "A", ShNationality: "United Arab Emirates", ShEmIDExp: nextYear}},
+		}, 0, models.RiskLow, nil},
+		{"gold trader with an expired license", Profile{
+			Customer:     models.Customer{NatureOfBusiness: "Trading in GOLD and jewellery"},
+			TradeLicense: &models.TradeLicense{LegalStatus: "proprietorship", LicenseExpiry: lastMonth},
+		}, 60, models.RiskHigh, []string{"cash_intensive_business", "sole_proprietorship", "expired_documents"}},
+		{"foreign shareholders", Profile{
+			Partners: []models.TradeLicenseHolder{
+				{ShareHolderName: "A", ShNationality: "India"},
+				{ShareHolderName: "B", ShNationality: "United Kingdom"},
+				{ShareHolderName: "C"},
+			},
+		}, 15, models.RiskLow, []string{"foreign_shareholders"}},
+		{"keyword inside other words", Profile{
+			Customer: models.Customer{NatureOfBusiness: "Poultry farms, pharmsupply and fire alarms"},
+		}, 0, models.RiskLow, nil},
+		{"arms dealer", Profile{
+			Customer: models.Customer{NatureOfBusiness: "Trading of small arms"},
+		}, 30, models.RiskMedium, []string{"cash_intensive_business"}},
+		{"listed nationality", Profile{
+			Partners: []models.TradeLicenseHolder{{ShareHolderName: "A", ShNationality: "Myanmar"}},
+		}, 60, models.RiskHigh, []string{"high_risk_nationality"}},
+		{"expired passport of a representative", Profile{
+			Memorandum: []models.Memorandum{{RepresentativeName: "R", RepPassportExp: lastMonth}},
+		}, 20, models.RiskLow, []string{"expired_documents"}},
+	}
+
+	for _, tt := range tests {
+		a := e.Assess(tt.profile, now)
+		if a.Score != tt.score || a.Rating != tt.rating {
+			t.Errorf("%s: expected %d (%s) but got %d (%s)", tt.name, tt.score, tt.rating, a.Score, a.Rating)
+		}
+		var rules []string
+		for _, f := range a.Factors {
+			rules = append(rules, f.Rule)
+		}
+		if strings.Join(rules, ",") != strings.Join(tt.rules, ",") {
+			t.Errorf("%s: expected rules %v but got %v", tt.name, tt.rules, rules)
+		}
+	}
+
+	a := e.Assess(Profile{Partners: []models.TradeLicenseHolder{{ShareHolderName: "Ali", ShPassportExp: lastMonth}}}, now)
+	if len(a.Factors) != 1 || a.Factors[0].Detail != "Ali's passport" {
+		t.Errorf("expected the expired passport in the detail, got %+v", a.Factors)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	e, err := Load(strings.NewReader(`{"medium": 10, "high": 20, "rules": [
+		{"name": "emirate", "description": "Offshore", "kind": "legal_status", "values": ["Offshore"], "points": 25}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := e.Assess(Profile{TradeLicense: &models.TradeLicense{LegalStatus: "Offshore"}}, now)
+	if a.Score != 25 || a.Rating != models.RiskHigh {
+		t.Errorf("expected the loaded rules to rate the customer high, got %+v", a)
+	}
+
+	var bad = []string{
+		`{"medium": 10, "high": 5, "rules": []}`,
+		`{"medium": 10, "high": 20, "rules": [{"name": "x", "kind": "weather", "points": 1}]}`,
+		`{"medium": 10, "high": 20, "rules": [{"name": "x", "kind": "nationality", "points": 1}]}`,
+		`{"medium": 10, "high": 20, "rules": [{"name": "x", "kind": "expired_documents"}, {"name": "x", "kind": "expired_documents"}]}`,
+		`{"medium": 10, "high": 20, "treshold": 3}`,
+	}
+	for _, s := range bad {
+		if _, err := Load(strings.NewReader(s)); err == nil {
+			t.Errorf("expected an error for %s", s)
+		}
+	}
+
+	if err := Default().check(); err != nil {
+		t.Errorf("the default rules are invalid: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package sanctions
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// honorifics are dropped from names before they are compared
+var honorifics = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "miss": true, "dr": true, "eng": true,
+	"sheikh": true, "shaikh": true, "sheik": true, "haji": true, "hajji": true,
+	"alhaj": true, "alhajj": true, "mullah": true, "maulana": true,
+}
+
+// particles link the parts of Arabic names and are spelled in many ways, so
+// they are dropped too
+var particles = map[string]bool{
+	"al": true, "el": true, "ul": true, "bin": true, "bint": true, "ibn": true, "ben": true,
+}
+
+// spellings maps common transliterations of Arabic and South Asian names to
+// one spelling
+var spellings = variants(map[string][]string{
+	"muhammad": {"mohammed", "mohammad", "mohamed", "mohamad", "muhammed", "muhamad", "mohd", "mhd"},
+	"ahmad":    {"ahmed", "ahmet"},
+	"abd":      {"abdel", "abdul", "abdal", "abdil", "abdoul"},
+	"abdullah": {"abdallah", "abdulla"},
+	"husayn":   {"hussein", "hussain", "husain", "husein", "hossein"},
+	"hasan":    {"hassan"},
+	"usama":    {"osama", "usamah"},
+	"umar":     {"omar"},
+	"uthman":   {"othman", "osman", "usman"},
+	"yusuf":    {"yousef", "youssef", "yousuf", "yusef"},
+	"ibrahim":  {"ebrahim"},
+	"mustafa":  {"mostafa", "moustafa"},
+	"khalid":   {"khaled"},
+	"said":     {"saeed", "sayed", "sayyid", "syed"},
+})
+
+// variants turns spellings grouped by the one used into a lookup table
+func variants(groups map[string][]string) map[string]string {
+	m := make(map[string]string)
+	for spelling, vs := range groups {
+		for _, v := range vs {
+			m[v] = spelling
+		}
+	}
+	return m
+}
+
+// foldMarks removes accents, e.g. é becomes e
+var foldMarks = transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+
+// Tokens splits a name into normalized words: lower case, without accents,
+// punctuation, honorifics or particles, with common transliterations spelled
+// one way. "Al-" and "El-" joined to a word are dropped as well.
+func Tokens(name string) []string {
+	folded, _, err := transform.String(foldMarks, name)
+	if err != nil {
+		folded = name
+	}
+	folded = strings.ToLower(folded)
+
+	words := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-'
+	})
+
+	var tokens []string
+	for _, w := range words {
+		for _, part := range strings.Split(w, "-") {
+			if part == "" || honorifics[part] || particles[part] {
+				continue
+			}
+			tokens = append(tokens, canonical(part))
+		}
+	}
+	return tokens
+}
+
+// canonical spells a word the way spellings and a few phonetic rules say
+func canonical(w string) string {
+	if s, ok := spellings[w]; ok {
+		return s
+	}
+	w = strings.NewReplacer("ou", "u", "ee", "i", "oo", "u", "ph", "f", "q", "k").Replace(w)
+
+	// Double letters are often written single in transliterations
+	var b strings.Builder
+	var last rune
+	for _, r := range w {
+		if r != last {
+			b.WriteRune(r)
+		}
+		last = r
+	}
+	return b.String()
+}
+
+// Normalize returns the normalized words of a name, joined by spaces
+func Normalize(name string) string {
+	return strings.Join(Tokens(name), " ")
+}
+
+// Score compares two names, giving 1 for the same name and 0 for names with
+// nothing in common. Words are compared with Jaro-Winkler in any order, so
+// "Hussein, Saddam" matches "Saddam Hussein", and every word counts, so a
+// single shared first name scores low.
+func Score(a, b string) float64 {
+	return scoreTokens(Tokens(a), Tokens(b))
+}
+
+func scoreTokens(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	tokenScore := (bestMatches(a, b) + bestMatches(b, a)) / 2
+
+	// Names written without spaces, or split differently, still compare as a whole
+	wholeScore := JaroWinkler(sortedJoin(a), sortedJoin(b))
+
+	if wholeScore > tokenScore {
+		return wholeScore
+	}
+	return tokenScore
+}
+
+// bestMatches averages, over the words of a, the best score against a word of b
+func bestMatches(a, b []string) float64 {
+	total := 0.0
+	for _, x := range a {
+		best := 0.0
+		for _, y := range b {
+			if s := JaroWinkler(x, y); s > best {
+				best = s
+			}
+		}
+		total += best
+	}
+	return total / float64(len(a))
+}
+
+func sortedJoin(tokens []string) string {
+	sorted := append([]string(nil), tokens...)
+	sort.Strings(sorted)
+	return strings.Join(sorted, "")
+}
+
+// JaroWinkler returns the Jaro-Winkler similarity of two strings, from 0 for
+// nothing in common to 1 for equal strings
+func JaroWinkler(a, b string) float64 {
+	s1, s2 := []rune(a), []rune(b)
+	if len(s1) == 0 && len(s2) == 0 {
+		return 1
+	}
+	if len(s1) == 0 || len(s2) == 0 {
+		return 0
+	}
+
+	window := max(len(s1), len(s2))/2 - 1
+	if window < 0 {
+		window = 0
+	}
+
+	matched1 := make([]bool, len(s1))
+	matched2 := make([]bool, len(s2))
+	matches := 0
+	for i := range s1 {
+		lo, hi := max(0, i-window), min(len(s2), i+window+1)
+		for j := lo; j < hi; j++ {
+			if !matched2[j] && s1[i] == s2[j] {
+				matched1[i], matched2[j] = true, true
+				matches++
+				break
+			}
+		}
+	}
+	if matches == 0 {
+		return 0
+	}
+
+	// Count the matched characters that are in a different order
+	transpositions := 0
+	j := 0
+	for i := range s1 {
+		if !matched1[i] {
+			continue
+		}
+		for !matched2[j] {
+			j++
+		}
+		if s1[i] != s2[j] {
+			transpositions++
+		}
+		j++
+	}
+
+	m := float64(matches)
+	jaro := (m/float64(len(s1)) + m/float64(len(s2)) + (m-float64(transpositions)/2)/m) / 3
+
+	// Boost strings that share a prefix of up to four characters
+	prefix := 0
+	for prefix < min(4, len(s1), len(s2)) && s1[prefix] == s2[prefix] {
+		prefix++
+	}
+	return jaro + float64(prefix)*0.1*(1-jaro)
+}
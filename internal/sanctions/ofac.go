@@ -0,0 +1,108 @@
+package sanctions
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// ofacNull is how the OFAC files write empty values
+const ofacNull = "-0-"
+
+// ParseOFAC reads the OFAC Specially Designated Nationals list from SDN.CSV
+// and, when alt is not nil, the aliases in ALT.CSV. Vessels and aircraft are
+// left out, only people and organisations are screened.
+func ParseOFAC(sdn, alt io.Reader) ([]models.SanctionsEntry, error) {
+	var entries []models.SanctionsEntry
+	index := make(map[string]int)
+
+	err := readOFAC(sdn, func(rec []string) {
+		// ent_num, SDN_Name, SDN_Type, Program, Title, Call_Sign, Vess_type,
+		// Tonnage, GRT, Vess_flag, Vess_owner, Remarks
+		if len(rec) < 4 {
+			return
+		}
+		entryType := "entity"
+		switch ofacValue(rec[2]) {
+		case "individual":
+			entryType = "individual"
+		case "vessel", "aircraft":
+			return
+		}
+		e := models.SanctionsEntry{
+			Source:     models.SanctionsOFAC,
+			ExternalID: rec[0],
+			Name:       ofacValue(rec[1]),
+			EntryType:  entryType,
+			Program:    ofacValue(rec[3]),
+		}
+		if len(rec) > 11 {
+			e.Remarks = ofacValue(rec[11])
+		}
+		if e.Name == "" {
+			return
+		}
+		index[e.ExternalID] = len(entries)
+		entries = append(entries, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("sanctions: no entries found, is this the OFAC SDN.CSV file?")
+	}
+
+	if alt == nil {
+		return entries, nil
+	}
+	err = readOFAC(alt, func(rec []string) {
+		// ent_num, alt_num, alt_type, alt_name, alt_remarks
+		if len(rec) < 4 {
+			return
+		}
+		i, ok := index[rec[0]]
+		if name := ofacValue(rec[3]); ok && name != "" {
+			entries[i].Aliases = append(entries[i].Aliases, name)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// readOFAC calls fn with each record of an OFAC CSV file whose first column is
+// an entry number. The files have no header and end with an EOF character.
+func readOFAC(r io.Reader, fn func(rec []string)) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("sanctions: cannot read OFAC list: %w", err)
+		}
+		for i := range rec {
+			rec[i] = strings.TrimSpace(rec[i])
+		}
+		if _, err := strconv.Atoi(rec[0]); err != nil {
+			continue
+		}
+		fn(rec)
+	}
+}
+
+// ofacValue returns a value of an OFAC file, with nulls as empty strings
+func ofacValue(s string) string {
+	if s == ofacNull {
+		return ""
+	}
+	return s
+}
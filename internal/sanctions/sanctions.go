@@ -0,0 +1,187 @@
+// Package sanctions imports sanctions lists and screens the names of
+// shareholders and representatives against them
+package sanctions
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+)
+
+// DefaultThreshold is the lowest Score reported as a hit. It catches spelling
+// variants and reordered names while leaving out names that merely share a word.
+const DefaultThreshold = 0.9
+
+// Match is a sanctions list entry close to a screened name
+type Match struct {
+	Entry       models.SanctionsEntry
+	MatchedName string // the name or alias of the entry that matched
+	Score       float64
+}
+
+// Screener compares names against the entries of the sanctions lists
+type Screener struct {
+	Threshold float64
+	entries   []models.SanctionsEntry
+	names     []listedName
+}
+
+// listedName is a name or alias of an entry, split into tokens once
+type listedName struct {
+	entry  int
+	name   string
+	tokens []string
+}
+
+// NewScreener prepares the entries for screening. Names scoring at least
+// threshold are reported.
+func NewScreener(entries []models.SanctionsEntry, threshold float64) *Screener {
+	s := &Screener{Threshold: threshold, entries: entries}
+	for i, e := range entries {
+		for _, name := range append([]string{e.Name}, e.Aliases...) {
+			if tokens := Tokens(name); len(tokens) > 0 {
+				s.names = append(s.names, listedName{entry: i, name: name, tokens: tokens})
+			}
+		}
+	}
+	return s
+}
+
+// Len returns the number of entries screened against
+func (s *Screener) Len() int {
+	return len(s.entries)
+}
+
+// Screen returns the entries whose name or an alias is close to name, best first
+func (s *Screener) Screen(name string) []Match {
+	tokens := Tokens(name)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	best := make(map[int]Match)
+	for _, ln := range s.names {
+		score := scoreTokens(tokens, ln.tokens)
+		if score < s.Threshold {
+			continue
+		}
+		if m, ok := best[ln.entry]; !ok || score > m.Score {
+			best[ln.entry] = Match{Entry: s.entries[ln.entry], MatchedName: ln.name, Score: score}
+		}
+	}
+
+	matches := make([]Match, 0, len(best))
+	for _, m := range best {
+		matches = append(matches, m)
+	}
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score == matches[j].Score {
+			return matches[i].Entry.Name < matches[j].Entry.Name
+		}
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// Hits screens a subject and returns a pending hit for every match
+func (s *Screener) Hits(sub models.ScreeningSubject) []models.SanctionsHit {
+	var hits []models.SanctionsHit
+	for _, m := range s.Screen(sub.Name) {
+		hits = append(hits, models.SanctionsHit{
+			ScreeningSubject: sub,
+			Source:           m.Entry.Source,
+			ExternalID:       m.Entry.ExternalID,
+			ListedName:       m.Entry.Name,
+			MatchedName:      m.MatchedName,
+			Program:          m.Entry.Program,
+			Score:            m.Score,
+			State:            models.HitPending,
+		})
+	}
+	return hits
+}
+
+// Load returns a screener over every entry of the imported lists
+func Load(db repository.DatabaseRepo, threshold float64) (*Screener, error) {
+	entries, err := db.AllSanctionsEntries()
+	if err != nil {
+		return nil, err
+	}
+	return NewScreener(entries, threshold), nil
+}
+
+// Cache keeps a screener over the imported lists, so names entered one at a
+// time are screened without loading the lists again each time
+type Cache struct {
+	mu sync.Mutex
+	s  *Screener
+}
+
+// Get returns the cached screener, loading the lists the first time
+func (c *Cache) Get(db repository.DatabaseRepo, threshold float64) (*Screener, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.s == nil {
+		s, err := Load(db, threshold)
+		if err != nil {
+			return nil, err
+		}
+		c.s = s
+	}
+	return c.s, nil
+}
+
+// Reload loads the lists again, so names are screened against the entries
+// imported since, and returns the new screener
+func (c *Cache) Reload(db repository.DatabaseRepo, threshold float64) (*Screener, error) {
+	s, err := Load(db, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.s = s
+	c.mu.Unlock()
+	return s, nil
+}
+
+// ScreenAll screens every shareholder and representative against the lists,
+// recording the hits that were not found before. It returns the number of
+// subjects screened and of new hits.
+func ScreenAll(ctx context.Context, db repository.DatabaseRepo, threshold float64) (screened, found int, err error) {
+	s, err := Load(db, threshold)
+	if err != nil {
+		return 0, 0, err
+	}
+	return s.ScreenAll(ctx, db)
+}
+
+// ScreenAll screens every shareholder and representative against the
+// screener's entries, like the package level ScreenAll
+func (s *Screener) ScreenAll(ctx context.Context, db repository.DatabaseRepo) (screened, found int, err error) {
+	subjects, err := db.ScreeningSubjects()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for _, sub := range subjects {
+		if err := ctx.Err(); err != nil {
+			return screened, found, err
+		}
+		for _, h := range s.Hits(sub) {
+			id, err := db.InsertSanctionsHit(h)
+			if err != nil {
+				return screened, found, err
+			}
+			if id != 0 {
+				found++
+			}
+		}
+		screened++
+	}
+	return screened, found, nil
+}
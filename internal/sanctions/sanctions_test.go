@@ -0,0 +1,265 @@
+package sanctions
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+func TestJaroWinkler(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want float64
+	}{
+		{"martha", "marhta", 0.961},
+		{"dwayne", "duane", 0.840},
+		{"dixon", "dicksonx", 0.813},
+		{"abc", "abc", 1},
+		{"abc", "xyz", 0},
+		{"", "", 1},
+		{"abc", "", 0},
+	}
+	for _, tt := range tests {
+		if got := JaroWinkler(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+			t.Errorf("JaroWinkler(%q, %q): expected %.3f but got %.3f", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name, want string
+	}{
+		{"Sheikh Mohammed bin Rashid Al-Maktoum", "muhammad rashid maktum"},
+		{"  José  MÜLLER ", "jose muler"},
+		{"Osama bin Laden", "usama laden"},
+		{"Abdel-Rahman El-Sayed", "abd rahman said"},
+		{"Mr. & Mrs.", ""},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.name); got != tt.want {
+			t.Errorf("Normalize(%q): expected %q but got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestScore(t *testing.T) {
+	matches := [][2]string{
+		{"Mohammed Al-Hussein", "Muhammad Hussain"},
+		{"HUSSEIN, Saddam", "Saddam Hussein"},
+		{"Yousef Abdallah", "Yusuf Abdullah"},
+		{"Ahmed Khaled", "Ahmad Khalid"},
+		{"Abdulrahman Said", "Abdul Rahman Saeed"},
+	}
+	for _, m := range matches {
+		if s := Score(m[0], m[1]); s < DefaultThreshold {
+			t.Errorf("Score(%q, %q): expected a match but got %.3f", m[0], m[1], s)
+		}
+	}
+
+	different := [][2]string{
+		{"Mohammed Al-Hussein", "Mohammed Khan"},
+		{"John Smith", "Jane Doe"},
+		{"Ahmed Khaled", "Omar Ibrahim"},
+		{"", "Saddam Hussein"},
+	}
+	for _, d := range different {
+		if s := Score(d[0], d[1]); s >= DefaultThreshold {
+			t.Errorf("Score(%q, %q): expected no match but got %.3f", d[0], d[1], s)
+		}
+	}
+}
+
+const unList = `<?xml version="1.0" encoding="UTF-8"?>
+<CONSOLIDATED_LIST dateGenerated="2026-10-01T00:00:00Z">
+  <INDIVIDUALS>
+    <INDIVIDUAL>
+      <DATAID>6908555</DATAID>
+      <FIRST_NAME>ABDUL</FIRST_NAME>
+      <SECOND_NAME>RAHMAN</SECOND_NAME>
+      <THIRD_NAME>YASIN</THIRD_NAME>
+      <UN_LIST_TYPE>Al-Qaida</UN_LIST_TYPE>
+      <REFERENCE_NUMBER>QDi.042</REFERENCE_NUMBER>
+      <COMMENTS1>Test entry.</COMMENTS1>
+      <NATIONALITY><VALUE>Iraq</VALUE></NATIONALITY>
+      <INDIVIDUAL_ALIAS><QUALITY>Good</QUALITY><ALIAS_NAME>Abdul Rahman Said Yasin</ALIAS_NAME></INDIVIDUAL_ALIAS>
+      <INDIVIDUAL_ALIAS><QUALITY>Low</QUALITY><ALIAS_NAME>Aboud</ALIAS_NAME></INDIVIDUAL_ALIAS>
+    </INDIVIDUAL>
+  </INDIVIDUALS>
+  <ENTITIES>
+    <ENTITY>
+      <DATAID>110000</DATAID>
+      <FIRST_NAME>GOLDEN SANDS TRADING</FIRST_NAME>
+      <UN_LIST_TYPE>DPRK</UN_LIST_TYPE>
+      <REFERENCE_NUMBER></REFERENCE_NUMBER>
+      <ENTITY_ALIAS><QUALITY>a.k.a.</QUALITY><ALIAS_NAME>Golden Sand General Trading LLC</ALIAS_NAME></ENTITY_ALIAS>
+    </ENTITY>
+  </ENTITIES>
+</CONSOLIDATED_LIST>`
+
+func TestParseUN(t *testing.T) {
+	entries, err := ParseUN(strings.NewReader(unList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries but got %d", len(entries))
+	}
+
+	e := entries[0]
+	if e.Source != models.SanctionsUN || e.ExternalID != "QDi.042" || e.Name != "ABDUL RAHMAN YASIN" ||
+		e.EntryType != "individual" || e.Program != "Al-Qaida" || e.Nationality != "Iraq" {
+		t.Errorf("unexpected individual %+v", e)
+	}
+	if len(e.Aliases) != 1 || e.Aliases[0] != "Abdul Rahman Said Yasin" {
+		t.Errorf("expected only the good alias but got %q", e.Aliases)
+	}
+
+	e = entries[1]
+	if e.ExternalID != "110000" || e.EntryType != "entity" || len(e.Aliases) != 1 {
+		t.Errorf("expected the entity to fall back to its DATAID but got %+v", e)
+	}
+
+	if _, err := ParseUN(strings.NewReader("<html></html>")); err == nil {
+		t.Error("expected an error for a file without entries")
+	}
+}
+
+const sdnList = `36,"AEROCARIBBEAN AIRLINES",-0- ,"CUBA",-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,-0- 
+2674,"HUSSEIN, Saddam","individual","IRAQ2",-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,-0- ,"DOB 28 Apr 1937."
+15036,"ATLANTIC STAR","vessel","SDGT",-0- ,"9HA123","Cargo",-0- ,-0- ,"Malta",-0- ,-0- 
+` + "\x1a"
+
+const altList = `2674,1,"aka","HUSSEIN AL-TIKRITI, Saddam",-0- 
+15036,2,"fka","STAR OF THE SEA",-0- 
+`
+
+func TestParseOFAC(t *testing.T) {
+	entries, err := ParseOFAC(strings.NewReader(sdnList), strings.NewReader(altList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected the vessel to be left out but got %d entries", len(entries))
+	}
+
+	if e := entries[0]; e.ExternalID != "36" || e.EntryType != "entity" || e.Program != "CUBA" || e.Remarks != "" {
+		t.Errorf("unexpected entity %+v", e)
+	}
+	e := entries[1]
+	if e.Source != models.SanctionsOFAC || e.Name != "HUSSEIN, Saddam" || e.EntryType != "individual" ||
+		e.Remarks != "DOB 28 Apr 1937." {
+		t.Errorf("unexpected individual %+v", e)
+	}
+	if len(e.Aliases) != 1 || e.Aliases[0] != "HUSSEIN AL-TIKRITI, Saddam" {
+		t.Errorf("expected the alias from ALT.CSV but got %q", e.Aliases)
+	}
+
+	if _, err := ParseOFAC(strings.NewReader("name,type\n"), nil); err == nil {
+		t.Error("expected an error for a file without entries")
+	}
+}
+
+func TestScreener(t *testing.T) {
+	entries, err := ParseOFAC(strings.NewReader(sdnList), strings.NewReader(altList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewScreener(entries, DefaultThreshold)
+	if s.Len() != 2 {
+		t.Errorf("expected 2 entries but got %d", s.Len())
+	}
+
+	matches := s.Screen("Sadam Husain")
+	if len(matches) != 1 || matches[0].Entry.ExternalID != "2674" {
+		t.Fatalf("expected Saddam Hussein to match but got %+v", matches)
+	}
+	if matches[0].MatchedName != "HUSSEIN, Saddam" {
+		t.Errorf("expected the listed name to match best but got %q", matches[0].MatchedName)
+	}
+
+	if matches := s.Screen("Saddam Al-Tikriti Hussein"); len(matches) != 1 || matches[0].MatchedName != "HUSSEIN AL-TIKRITI, Saddam" {
+		t.Errorf("expected the alias to match but got %+v", matches)
+	}
+	if matches := s.Screen("Saddam Rahman"); len(matches) != 0 {
+		t.Errorf("expected a shared first name not to match but got %+v", matches)
+	}
+
+	hits := s.Hits(models.ScreeningSubject{CustomerID: 7, SubjectType: models.RecordPartner, SubjectID: 9, Name: "Saddam Hussain"})
+	if len(hits) != 1 || hits[0].CustomerID != 7 || hits[0].Source != models.SanctionsOFAC ||
+		hits[0].ListedName != "HUSSEIN, Saddam" || hits[0].State != models.HitPending {
+		t.Errorf("unexpected hits %+v", hits)
+	}
+}
+
+func TestScreenAll(t *testing.T) {
+	db, err := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ParseOFAC(strings.NewReader(sdnList), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ReplaceSanctionsEntries(models.SanctionsOFAC, entries); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertPartner(models.TradeLicenseHolder{CustomerId: 1, ShareHolderName: "Sadam Hussain"}, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.InsertMemorandum(models.Memorandum{CustomerId: 1, RepresentativeName: "John Smith"}, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	screened, found, err := ScreenAll(context.Background(), db, DefaultThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if screened != 2 || found != 1 {
+		t.Errorf("expected 2 screened and 1 hit but got %d and %d", screened, found)
+	}
+
+	// Hits already recorded are not recorded again
+	if _, found, err := ScreenAll(context.Background(), db, DefaultThreshold); err != nil || found != 0 {
+		t.Errorf("expected no new hits but got %d (%v)", found, err)
+	}
+}
+
+func TestCache(t *testing.T) {
+	db, err := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := ParseOFAC(strings.NewReader(sdnList), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c Cache
+	s, err := c.Get(db, DefaultThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != 0 {
+		t.Fatalf("expected an empty screener but got %d entries", s.Len())
+	}
+
+	// The lists are only loaded again when asked to
+	if err := db.ReplaceSanctionsEntries(models.SanctionsOFAC, entries); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := c.Get(db, DefaultThreshold); s.Len() != 0 {
+		t.Errorf("expected the cached screener but got %d entries", s.Len())
+	}
+	if _, err := c.Reload(db, DefaultThreshold); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := c.Get(db, DefaultThreshold); s.Len() != len(entries) {
+		t.Errorf("expected %d entries after reloading but got %d", len(entries), s.Len())
+	}
+}
@@ -0,0 +1,96 @@
+package sanctions
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// unEntry is an INDIVIDUAL or ENTITY element of the UN consolidated list
+type unEntry struct {
+	DataID          string    `xml:"DATAID"`
+	FirstName       string    `xml:"FIRST_NAME"`
+	SecondName      string    `xml:"SECOND_NAME"`
+	ThirdName       string    `xml:"THIRD_NAME"`
+	FourthName      string    `xml:"FOURTH_NAME"`
+	ListType        string    `xml:"UN_LIST_TYPE"`
+	ReferenceNumber string    `xml:"REFERENCE_NUMBER"`
+	Comments        string    `xml:"COMMENTS1"`
+	Nationalities   []string  `xml:"NATIONALITY>VALUE"`
+	Aliases         []unAlias `xml:"INDIVIDUAL_ALIAS"`
+	EntityAliases   []unAlias `xml:"ENTITY_ALIAS"`
+}
+
+type unAlias struct {
+	Quality string `xml:"QUALITY"`
+	Name    string `xml:"ALIAS_NAME"`
+}
+
+// ParseUN reads the UN Security Council consolidated list in its XML format,
+// as downloaded from the UN website
+func ParseUN(r io.Reader) ([]models.SanctionsEntry, error) {
+	var entries []models.SanctionsEntry
+	dec := xml.NewDecoder(r)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("sanctions: cannot read UN list: %w", err)
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "INDIVIDUAL" && start.Name.Local != "ENTITY" {
+			continue
+		}
+		var u unEntry
+		if err := dec.DecodeElement(&u, &start); err != nil {
+			return nil, fmt.Errorf("sanctions: cannot read UN list: %w", err)
+		}
+
+		e := models.SanctionsEntry{
+			Source:      models.SanctionsUN,
+			ExternalID:  strings.TrimSpace(u.ReferenceNumber),
+			Name:        joinNames(u.FirstName, u.SecondName, u.ThirdName, u.FourthName),
+			EntryType:   "individual",
+			Program:     strings.TrimSpace(u.ListType),
+			Nationality: strings.Join(u.Nationalities, ", "),
+			Remarks:     strings.TrimSpace(u.Comments),
+		}
+		if start.Name.Local == "ENTITY" {
+			e.EntryType = "entity"
+		}
+		if e.ExternalID == "" {
+			e.ExternalID = strings.TrimSpace(u.DataID)
+		}
+		// Low quality aliases are too vague to screen against, the UN says so itself
+		for _, a := range append(u.Aliases, u.EntityAliases...) {
+			if name := strings.TrimSpace(a.Name); name != "" && !strings.EqualFold(a.Quality, "Low") {
+				e.Aliases = append(e.Aliases, name)
+			}
+		}
+		if e.Name != "" && e.ExternalID != "" {
+			entries = append(entries, e)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("sanctions: no entries found, is this the UN consolidated list in XML?")
+	}
+	return entries, nil
+}
+
+// joinNames joins the parts of a name that are present
+func joinNames(parts ...string) string {
+	var present []string
+	for _, p := range parts {
+		if p = strings.TrimSpace(p); p != "" {
+			present = append(present, p)
+		}
+	}
+	return strings.Join(present, " ")
+}
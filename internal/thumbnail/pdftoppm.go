@@ -0,0 +1,43 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Pdftoppm renders PDF pages with the pdftoppm tool from poppler-utils
+type Pdftoppm struct {
+	Path string // path of the pdftoppm binary
+}
+
+// FindPdftoppm returns a renderer using the pdftoppm binary on the PATH, or nil when it is not installed
+func FindPdftoppm() *Pdftoppm {
+	path, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil
+	}
+	return &Pdftoppm{Path: path}
+}
+
+// RenderFirstPage renders the first page of the PDF at path, scaled so its longest side is size pixels
+func (p *Pdftoppm) RenderFirstPage(ctx context.Context, path string, size int) (image.Image, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.Path,
+		"-png", "-f", "1", "-l", "1", "-singlefile",
+		"-scale-to", strconv.Itoa(size),
+		path, "-", // "-" writes the image to stdout
+	)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("pdftoppm: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return png.Decode(&stdout)
+}
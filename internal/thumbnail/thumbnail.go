@@ -0,0 +1,237 @@
+// Package thumbnail creates and caches small previews of uploaded documents
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+// DefaultSize is the longest side of a thumbnail in pixels
+const DefaultSize = 256
+
+// MaxPixels is the largest image, in pixels, that is decoded to make a
+// thumbnail. Bigger images get the placeholder, so a small file claiming huge
+// dimensions can't exhaust memory.
+const MaxPixels = 50_000_000
+
+// ErrUnsupported is returned for files no thumbnail can be made from
+var ErrUnsupported = errors.New("no thumbnail for this type of file")
+
+// PDFRenderer renders the first page of a PDF file
+type PDFRenderer interface {
+	RenderFirstPage(ctx context.Context, path string, size int) (image.Image, error)
+}
+
+// Generator makes thumbnails of attachments and caches them in Dir
+type Generator struct {
+	Dir  string
+	Size int
+
+	// PDF renders previews of PDF files, nil shows a placeholder for them instead
+	PDF PDFRenderer
+}
+
+// New returns a Generator caching thumbnails in dir
+func New(dir string, pdf PDFRenderer) *Generator {
+	return &Generator{Dir: dir, Size: DefaultSize, PDF: pdf}
+}
+
+// Path returns where the thumbnail of the attachment is cached
+func (g *Generator) Path(a models.Attachment) string {
+	return filepath.Join(g.Dir, strconv.Itoa(a.File_id)+".jpg")
+}
+
+// Thumbnail returns the path of the cached thumbnail of the attachment, making
+// it first when it is missing or older than the file
+func (g *Generator) Thumbnail(ctx context.Context, a models.Attachment) (string, error) {
+	path := g.Path(a)
+
+	if thumb, err := os.Stat(path); err == nil {
+		if src, err := os.Stat(a.FilePath); err == nil && !thumb.ModTime().Before(src.ModTime()) {
+			return path, nil
+		}
+	}
+
+	if err := g.Generate(ctx, a); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// Generate makes the thumbnail of the attachment and writes it to the cache
+func (g *Generator) Generate(ctx context.Context, a models.Attachment) error {
+	img, err := g.Preview(ctx, a, g.size())
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return err
+	}
+	return g.write(g.Path(a), buf.Bytes())
+}
+
+// Preview returns a picture of the attachment whose longest side is at most
+// size pixels, on a white background. PDF files show their first page.
+func (g *Generator) Preview(ctx context.Context, a models.Attachment, size int) (image.Image, error) {
+	var img image.Image
+	var err error
+
+	switch a.ContentType {
+	case "image/jpeg", "image/png":
+		img, err = decodeImage(a.FilePath)
+	case "application/pdf":
+		if g.PDF == nil {
+			return nil, ErrUnsupported
+		}
+		img, err = g.PDF.RenderFirstPage(ctx, a.FilePath, size)
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return Resize(img, size), nil
+}
+
+func (g *Generator) size() int {
+	if g.Size > 0 {
+		return g.Size
+	}
+	return DefaultSize
+}
+
+// write stores a thumbnail through a temporary file, so concurrent requests
+// never read a partially written one
+func (g *Generator) write(path string, data []byte) error {
+	if err := os.MkdirAll(g.Dir, 0o750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(g.Dir, ".thumb-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// decodeImage decodes a JPEG or PNG file after checking its dimensions
+func decodeImage(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > MaxPixels {
+		return nil, fmt.Errorf("%w: image is %dx%d", ErrUnsupported, cfg.Width, cfg.Height)
+	}
+
+	if _, err := f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// Resize scales img down so that its longest side is size pixels, averaging
+// the source pixels that fall into each target pixel. Smaller images are
+// returned unchanged, on a white background.
+func Resize(img image.Image, size int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return image.NewRGBA(image.Rect(0, 0, 1, 1))
+	}
+
+	tw, th := w, h
+	if w > size || h > size {
+		if w >= h {
+			tw, th = size, max(1, h*size/w)
+		} else {
+			tw, th = max(1, w*size/h), size
+		}
+	}
+
+	// Flatten transparency onto white, JPEG has no alpha channel
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Over)
+	if tw == w && th == h {
+		return src
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := y*h/th, max((y+1)*h/th, y*h/th+1)
+		for x := 0; x < tw; x++ {
+			x0, x1 := x*w/tw, max((x+1)*w/tw, x*w/tw+1)
+
+			var r, g, bl, n uint32
+			for sy := y0; sy < y1; sy++ {
+				i := src.PixOffset(x0, sy)
+				for sx := x0; sx < x1; sx++ {
+					r += uint32(src.Pix[i])
+					g += uint32(src.Pix[i+1])
+					bl += uint32(src.Pix[i+2])
+					i += 4
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), 255})
+		}
+	}
+	return dst
+}
+
+// placeholder is shown for files without a thumbnail
+var placeholder = makePlaceholder()
+
+// Placeholder returns a PNG picture of a blank page, served when a file has no thumbnail
+func Placeholder() []byte {
+	return placeholder
+}
+
+func makePlaceholder() []byte {
+	const w, h = 180, DefaultSize
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.NewUniform(color.RGBA{233, 236, 239, 255}), image.Point{}, draw.Src)
+
+	// A white page with a grey border and a few lines of "text"
+	page := image.Rect(20, 20, w-20, h-20)
+	draw.Draw(img, page, image.NewUniform(color.RGBA{173, 181, 189, 255}), image.Point{}, draw.Src)
+	draw.Draw(img, page.Inset(2), image.White, image.Point{}, draw.Src)
+	for y := page.Min.Y + 30; y < page.Max.Y-20; y += 16 {
+		line := image.Rect(page.Min.X+16, y, page.Max.X-16, y+4)
+		draw.Draw(img, line, image.NewUniform(color.RGBA{206, 212, 218, 255}), image.Point{}, draw.Src)
+	}
+
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
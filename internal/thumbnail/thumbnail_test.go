@@ -0,0 +1,149 @@
+package thumbnail
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+)
+
+func writePNG(t *testing.T, path string, w, h int) {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{200, 20, 20, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	var tests = []struct {
+		w, h   int
+		tw, th int
+	}{
+		{1000, 500, 256, 128},
+		{500, 1000, 128, 256},
+		{100, 50, 100, 50},
+		{3000, 1, 256, 1},
+	}
+
+	for _, e := range tests {
+		got := Resize(image.NewRGBA(image.Rect(0, 0, e.w, e.h)), 256).Bounds()
+		if got.Dx() != e.tw || got.Dy() != e.th {
+			t.Errorf("%dx%d: expected %dx%d but got %dx%d", e.w, e.h, e.tw, e.th, got.Dx(), got.Dy())
+		}
+	}
+
+	// Transparent pixels become white and colours are preserved when averaging
+	src := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	src.Set(0, 0, color.NRGBA{0, 0, 255, 255})
+	src.Set(1, 0, color.NRGBA{0, 0, 255, 255})
+	src.Set(0, 1, color.NRGBA{0, 0, 255, 255})
+	src.Set(1, 1, color.NRGBA{0, 0, 255, 255})
+	out := Resize(src, 2)
+	if c := color.RGBAModel.Convert(out.At(0, 0)).(color.RGBA); c != (color.RGBA{0, 0, 255, 255}) {
+		t.Errorf("expected blue but got %v", c)
+	}
+	if c := color.RGBAModel.Convert(out.At(1, 0)).(color.RGBA); c != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("expected white but got %v", c)
+	}
+}
+
+type fakePDF struct {
+	calls int
+}
+
+func (f *fakePDF) RenderFirstPage(ctx context.Context, path string, size int) (image.Image, error) {
+	f.calls++
+	return image.NewRGBA(image.Rect(0, 0, size*3/4, size)), nil
+}
+
+func TestGenerator(t *testing.T) {
+	dir := t.TempDir()
+	g := New(filepath.Join(dir, ".thumbs"), nil)
+
+	photo := models.Attachment{File_id: 1, ContentType: "image/png", FilePath: filepath.Join(dir, "photo.png")}
+	writePNG(t, photo.FilePath, 800, 600)
+
+	path, err := g.Thumbnail(context.Background(), photo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 192 {
+		t.Errorf("expected a 256x192 thumbnail but got %v", b)
+	}
+
+	// The cached thumbnail is reused until the file changes
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(path, old, old)
+	if _, err := g.Thumbnail(context.Background(), photo); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(path); !fi.ModTime().After(old) {
+		t.Error("expected a thumbnail older than the file to be made again")
+	}
+	made, _ := os.Stat(path)
+	if _, err := g.Thumbnail(context.Background(), photo); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Stat(path); !fi.ModTime().Equal(made.ModTime()) {
+		t.Error("expected the cached thumbnail to be reused")
+	}
+
+	doc := models.Attachment{File_id: 2, ContentType: "application/pdf", FilePath: filepath.Join(dir, "doc.pdf")}
+	if _, err := g.Thumbnail(context.Background(), doc); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a PDF without a renderer, got %v", err)
+	}
+
+	pdf := &fakePDF{}
+	g.PDF = pdf
+	if _, err := g.Thumbnail(context.Background(), doc); err != nil || pdf.calls != 1 {
+		t.Errorf("expected the PDF renderer to be used once, got %d calls (%v)", pdf.calls, err)
+	}
+
+	// A small file claiming huge dimensions is not decoded
+	huge := models.Attachment{File_id: 3, ContentType: "image/png", FilePath: filepath.Join(dir, "huge.png")}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1)))
+	data := buf.Bytes()
+	// Patch the width and height in the IHDR chunk and fix up its checksum
+	copy(data[16:24], []byte{0, 0, 0x40, 0, 0, 0, 0x40, 0})
+	binary.BigEndian.PutUint32(data[29:33], crc32.ChecksumIEEE(data[12:29]))
+	os.WriteFile(huge.FilePath, data, 0o600)
+	if err := g.Generate(context.Background(), huge); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("expected ErrUnsupported for a huge image, got %v", err)
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	if _, err := png.Decode(bytes.NewReader(Placeholder())); err != nil {
+		t.Errorf("placeholder is not a valid PNG: %v", err)
+	}
+}
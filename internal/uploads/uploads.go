@@ -0,0 +1,426 @@
+package uploads
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/chamrasilva89/reservationWeb/internal/antivirus"
+	"github.com/chamrasilva89/reservationWeb/internal/models"
+	"github.com/chamrasilva89/reservationWeb/internal/repository"
+	"github.com/google/uuid"
+)
+
+// Kinds of uploaded files, stored with every file so they can be told apart
+const (
+	KindCustomer     = "customer"
+	KindTradeLicense = "trade_license"
+	KindPartner      = "partner"
+	KindMemorandum   = "memorandum"
+
+	// KindSignedMemorandum is the signed memorandum of association, uploaded
+	// during onboarding
+	KindSignedMemorandum = "signed_memorandum"
+)
+
+// MaxRequestSize caps the size of a whole multipart request, all files included
+const MaxRequestSize = 32 << 20
+
+// DefaultLimit is the largest file accepted for a form field without its own limit
+const DefaultLimit = 5 << 20
+
+// Errors returned for rejected files. Their messages are shown to the user.
+var (
+	ErrTooLarge        = errors.New("the file is too large")
+	ErrUnsupportedType = errors.New("only PDF, JPEG and PNG files can be uploaded")
+	ErrDuplicate       = errors.New("this file has already been uploaded for the customer")
+	ErrEmpty           = errors.New("the file is empty")
+	ErrInfected        = errors.New("the file failed the virus scan and was quarantined")
+	ErrScanFailed      = errors.New("the file could not be checked for viruses, please try again later")
+)
+
+// allowedTypes maps the sniffed content types that are accepted to the extension they are stored with
+var allowedTypes = map[string]string{
+	"application/pdf": ".pdf",
+	"image/jpeg":      ".jpg",
+	"image/png":       ".png",
+}
+
+// unsafeChars matches everything that must not appear in a directory name
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// Store validates uploaded files and saves them under Root, recording each
+// one in the customer_images table
+type Store struct {
+	Root   string
+	DB     repository.DatabaseRepo
+	Limits map[string]int64 // maximum file size per form field
+
+	// Scanner checks files for malware before they are stored, nil disables scanning.
+	// Infected files are moved to QuarantineDir instead.
+	Scanner       antivirus.Scanner
+	QuarantineDir string
+}
+
+// NewStore returns a Store saving files under root with the default size limits
+// and quarantine directory
+func NewStore(root string, db repository.DatabaseRepo) *Store {
+	return &Store{
+		Root: root,
+		DB:   db,
+		// Customer directories never contain a dot, so this can't clash with one
+		QuarantineDir: filepath.Join(root, ".quarantine"),
+		Limits: map[string]int64{
+			"photos":          10 << 20,
+			"shIDFilepath":    DefaultLimit,
+			"shPassFilepath":  DefaultLimit,
+			"repIDFilepath":   DefaultLimit,
+			"repPassFilepath": DefaultLimit,
+			"photo":           10 << 20,
+		},
+	}
+}
+
+// Upload is a file that passed validation and is ready to be saved
+type Upload struct {
+	Field        string
+	OriginalName string
+	ContentType  string
+	Size         int64
+	Checksum     string
+	ScanStatus   string
+
+	header *multipart.FileHeader
+}
+
+// Open opens the uploaded file for reading
+func (u *Upload) Open() (multipart.File, error) {
+	return u.header.Open()
+}
+
+// limit returns the maximum size of a file posted in field
+func (s *Store) limit(field string) int64 {
+	if l, ok := s.Limits[field]; ok {
+		return l
+	}
+	return DefaultLimit
+}
+
+// Check validates the size and content type of a posted file, computes its
+// checksum, makes sure the customer does not already have the same file and
+// scans it for malware. A customerID of 0 means the customer is new and skips
+// the duplicate check.
+func (s *Store) Check(ctx context.Context, customerID int, field string, fh *multipart.FileHeader) (*Upload, error) {
+	limit := s.limit(field)
+	if fh.Size > limit {
+		return nil, ErrTooLarge
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Sniff the type from the content, the client's Content-Type header can't be trusted
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrEmpty
+	}
+	contentType := http.DetectContentType(head[:n])
+	if _, ok := allowedTypes[contentType]; !ok {
+		return nil, ErrUnsupportedType
+	}
+
+	h := sha256.New()
+	h.Write(head[:n])
+	size, err := io.Copy(h, io.LimitReader(f, limit+1-int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	size += int64(n)
+	if size > limit {
+		return nil, ErrTooLarge
+	}
+
+	u := &Upload{
+		Field:        field,
+		OriginalName: filepath.Base(fh.Filename),
+		ContentType:  contentType,
+		Size:         size,
+		Checksum:     hex.EncodeToString(h.Sum(nil)),
+		ScanStatus:   antivirus.StatusNotScanned,
+		header:       fh,
+	}
+
+	if customerID != 0 {
+		if err := s.checkDuplicate(customerID, u.Checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.scan(ctx, customerID, u); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// scan runs the upload through the scanner, quarantining it when it is infected
+func (s *Store) scan(ctx context.Context, customerID int, u *Upload) error {
+	if s.Scanner == nil {
+		return nil
+	}
+
+	f, err := u.header.Open()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	res, err := s.Scanner.Scan(ctx, f)
+	if err != nil {
+		return &ScanError{Err: err}
+	}
+	if !res.Infected {
+		u.ScanStatus = antivirus.StatusClean
+		return nil
+	}
+
+	u.ScanStatus = antivirus.StatusInfected
+	if err := s.quarantine(customerID, u, res.Signature); err != nil {
+		return err
+	}
+	return &InfectedError{Upload: u, Signature: res.Signature}
+}
+
+// InfectedError is returned by Check for files the scanner flagged, it matches ErrInfected
+type InfectedError struct {
+	Upload    *Upload
+	Signature string
+}
+
+func (e *InfectedError) Error() string {
+	return ErrInfected.Error()
+}
+
+func (e *InfectedError) Is(target error) bool {
+	return target == ErrInfected
+}
+
+// ScanError is returned by Check when the scanner failed, it matches ErrScanFailed
+type ScanError struct {
+	Err error
+}
+
+func (e *ScanError) Error() string {
+	return ErrScanFailed.Error()
+}
+
+func (e *ScanError) Is(target error) bool {
+	return target == ErrScanFailed
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// quarantineRecord is written next to a quarantined file to tell where it came from
+type quarantineRecord struct {
+	CustomerID    int       `json:"customer_id"`
+	Field         string    `json:"field"`
+	OriginalName  string    `json:"original_name"`
+	ContentType   string    `json:"content_type"`
+	Size          int64     `json:"size"`
+	Checksum      string    `json:"checksum"`
+	Signature     string    `json:"signature"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// quarantine keeps a copy of an infected file for investigation, under a name
+// without its extension so it can't be opened by accident
+func (s *Store) quarantine(customerID int, u *Upload, signature string) error {
+	if err := os.MkdirAll(s.QuarantineDir, 0o700); err != nil {
+		return err
+	}
+
+	name := uuid.New().String()
+	path := filepath.Join(s.QuarantineDir, name+".quarantined")
+	if err := s.write(path, u, 0o600); err != nil {
+		return err
+	}
+
+	record, err := json.MarshalIndent(quarantineRecord{
+		CustomerID:    customerID,
+		Field:         u.Field,
+		OriginalName:  u.OriginalName,
+		ContentType:   u.ContentType,
+		Size:          u.Size,
+		Checksum:      u.Checksum,
+		Signature:     signature,
+		QuarantinedAt: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.QuarantineDir, name+".json"), record, 0o600)
+}
+
+// CheckAll checks several files posted in the same field, also rejecting the
+// same file being posted twice
+func (s *Store) CheckAll(ctx context.Context, customerID int, field string, fhs []*multipart.FileHeader) ([]*Upload, error) {
+	var checked []*Upload
+	seen := map[string]bool{}
+	for _, fh := range fhs {
+		u, err := s.Check(ctx, customerID, field, fh)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(fh.Filename), err)
+		}
+		if seen[u.Checksum] {
+			return nil, fmt.Errorf("%s: %w", u.OriginalName, ErrDuplicate)
+		}
+		seen[u.Checksum] = true
+		checked = append(checked, u)
+	}
+	return checked, nil
+}
+
+func (s *Store) checkDuplicate(customerID int, checksum string) error {
+	_, err := s.DB.GetAttachmentByChecksum(customerID, checksum)
+	if err == nil {
+		return ErrDuplicate
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	return err
+}
+
+// Dir returns the directory files of the given kind are stored in for a customer
+func (s *Store) Dir(customerCode, kind string) string {
+	code := unsafeChars.ReplaceAllString(customerCode, "_")
+	if code == "" || code == "_" {
+		code = "unknown"
+	}
+	return filepath.Join(s.Root, code, kind)
+}
+
+// Save writes a checked file to disk under a random name and records it for
+// the customer. A file the customer got meanwhile, such as from a concurrent
+// upload, is removed again and reported as ErrDuplicate.
+func (s *Store) Save(customerID int, customerCode, kind string, u *Upload) (models.Attachment, error) {
+	dir := s.Dir(customerCode, kind)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return models.Attachment{}, err
+	}
+
+	storedName := uuid.New().String() + allowedTypes[u.ContentType]
+	path := filepath.Join(dir, storedName)
+	if err := s.write(path, u, 0o640); err != nil {
+		return models.Attachment{}, err
+	}
+
+	a := models.Attachment{
+		CustomerId:   customerID,
+		CustomerCode: customerCode,
+		Kind:         kind,
+		FilePath:     path,
+		FileName:     storedName,
+		OriginalName: u.OriginalName,
+		ContentType:  u.ContentType,
+		Size:         u.Size,
+		Checksum:     u.Checksum,
+		ScanStatus:   u.ScanStatus,
+	}
+	id, err := s.DB.InsertFile(a)
+	if err != nil {
+		os.Remove(path)
+		if errors.Is(err, repository.ErrDuplicateFile) {
+			return models.Attachment{}, ErrDuplicate
+		}
+		return models.Attachment{}, err
+	}
+	a.File_id = id
+
+	return a, nil
+}
+
+// RoomsDir is the directory under Root room photos are stored in. Customer
+// directories never contain a dot, so it can't clash with one.
+const RoomsDir = ".rooms"
+
+// SaveRoomPhoto writes a checked photo of a room to disk under a random name
+// and returns its path. Recording it is up to the caller.
+func (s *Store) SaveRoomPhoto(roomID int, u *Upload) (string, error) {
+	dir := filepath.Join(s.Root, RoomsDir, strconv.Itoa(roomID))
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, uuid.New().String()+allowedTypes[u.ContentType])
+	if err := s.write(path, u, 0o640); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// write copies the upload to path through a temporary file, so a failed copy
+// never leaves a partial file under the final name
+func (s *Store) write(path string, u *Upload, perm os.FileMode) error {
+	src, err := u.header.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), io.LimitReader(src, u.Size+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(h.Sum(nil)) != u.Checksum {
+		return errors.New("uploaded file changed while it was being stored")
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+
+	// Names are random, but never replace an existing file
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("file %s already exists", path)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// IsRejected reports whether err means the file itself was refused, as
+// opposed to a failure while storing it
+func IsRejected(err error) bool {
+	return errors.Is(err, ErrTooLarge) || errors.Is(err, ErrUnsupportedType) ||
+		errors.Is(err, ErrDuplicate) || errors.Is(err, ErrEmpty) ||
+		errors.Is(err, ErrInfected) || errors.Is(err, ErrScanFailed)
+}
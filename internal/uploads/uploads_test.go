@@ -0,0 +1,242 @@
+package uploads
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chamrasilva89/reservationWeb/internal/antivirus"
+	"github.com/chamrasilva89/reservationWeb/internal/config"
+	"github.com/chamrasilva89/reservationWeb/internal/repository/dbrepo"
+)
+
+var (
+	pngData = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+	pdfData = []byte("%PDF-1.4\n1 0 obj\n<<>>\nendobj\n")
+)
+
+func newTestStore(t *testing.T) *Store {
+	db, err := dbrepo.NewMemoryRepo(&config.AppConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewStore(t.TempDir(), db)
+}
+
+// fileHeaders builds the file headers a handler would see for a multipart
+// request posting the given files in field
+func fileHeaders(t *testing.T, field string, files map[string][]byte) []*multipart.FileHeader {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for name, data := range files {
+		fw, err := mw.CreateFormFile(field, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(data)
+	}
+	mw.Close()
+
+	r := httptest.NewRequest("POST", "/", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		t.Fatal(err)
+	}
+	return r.MultipartForm.File[field]
+}
+
+func TestStore_Check(t *testing.T) {
+	s := newTestStore(t)
+	s.Limits["small"] = 16
+
+	var tests = []struct {
+		name        string
+		field       string
+		fileName    string
+		data        []byte
+		err         error
+		contentType string
+	}{
+		{"png", "photos", "photo.png", pngData, nil, "image/png"},
+		{"pdf with wrong extension", "photos", "scan.png", pdfData, nil, "application/pdf"},
+		{"text", "photos", "notes.pdf", []byte("just some text"), ErrUnsupportedType, ""},
+		{"html", "photos", "page.png", []byte("<html><script>alert(1)</script></html>"), ErrUnsupportedType, ""},
+		{"empty", "photos", "empty.png", nil, ErrEmpty, ""},
+		{"too large", "small", "photo.png", pngData, ErrTooLarge, ""},
+	}
+
+	for _, e := range tests {
+		fh := fileHeaders(t, e.field, map[string][]byte{e.fileName: e.data})[0]
+		u, err := s.Check(context.Background(), 0, e.field, fh)
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected error %v but got %v", e.name, e.err, err)
+			continue
+		}
+		if err != nil {
+			if !IsRejected(err) {
+				t.Errorf("%s: expected %v to be a rejection", e.name, err)
+			}
+			continue
+		}
+		if u.ContentType != e.contentType {
+			t.Errorf("%s: expected content type %s but got %s", e.name, e.contentType, u.ContentType)
+		}
+		if u.Size != int64(len(e.data)) || len(u.Checksum) != 64 {
+			t.Errorf("%s: unexpected size %d or checksum %q", e.name, u.Size, u.Checksum)
+		}
+	}
+}
+
+func TestStore_SaveAndDuplicates(t *testing.T) {
+	s := newTestStore(t)
+
+	fhs := fileHeaders(t, "photos", map[string][]byte{"../../etc/passwd.png": pngData})
+	u, err := s.Check(context.Background(), 7, "photos", fhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.OriginalName != "passwd.png" {
+		t.Errorf("expected the path to be stripped from the original name, got %q", u.OriginalName)
+	}
+
+	a, err := s.Save(7, "../C001", KindCustomer, u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(a.FilePath, s.Root+string(filepath.Separator)) {
+		t.Errorf("file %s was stored outside of %s", a.FilePath, s.Root)
+	}
+	if a.FileName == u.OriginalName || filepath.Ext(a.FileName) != ".png" {
+		t.Errorf("expected a random .png name but got %q", a.FileName)
+	}
+	stored, err := os.ReadFile(a.FilePath)
+	if err != nil || !bytes.Equal(stored, pngData) {
+		t.Errorf("stored file does not match the upload (%v)", err)
+	}
+
+	// Saving it again, as a concurrent upload checked before the first was
+	// recorded would, leaves no second file on disk
+	if _, err := s.Save(7, "../C001", KindCustomer, u); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate but got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(a.FilePath)); len(entries) != 1 {
+		t.Errorf("expected only the first file on disk but found %d", len(entries))
+	}
+
+	// The same content under another name is a duplicate for this customer only
+	fhs = fileHeaders(t, "photos", map[string][]byte{"copy.png": pngData})
+	if _, err := s.Check(context.Background(), 7, "photos", fhs[0]); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate but got %v", err)
+	}
+	if _, err := s.Check(context.Background(), 8, "photos", fhs[0]); err != nil {
+		t.Errorf("expected another customer to accept the file, got %v", err)
+	}
+
+	fhs = fileHeaders(t, "photos", map[string][]byte{"a.png": pngData, "b.png": pngData})
+	if _, err := s.CheckAll(context.Background(), 0, "photos", fhs); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected the same file posted twice to be rejected, got %v", err)
+	}
+}
+
+// fakeScanner flags content containing "VIRUS" and fails when err is set
+type fakeScanner struct {
+	err error
+}
+
+func (f fakeScanner) Scan(ctx context.Context, r io.Reader) (antivirus.Result, error) {
+	if f.err != nil {
+		return antivirus.Result{}, f.err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return antivirus.Result{}, err
+	}
+	if bytes.Contains(data, []byte("VIRUS")) {
+		return antivirus.Result{Infected: true, Signature: "Test-Virus"}, nil
+	}
+	return antivirus.Result{}, nil
+}
+
+func TestStore_Scanning(t *testing.T) {
+	s := newTestStore(t)
+
+	// Without a scanner files are accepted as not scanned
+	fhs := fileHeaders(t, "photos", map[string][]byte{"photo.png": pngData})
+	u, err := s.Check(context.Background(), 7, "photos", fhs[0])
+	if err != nil || u.ScanStatus != antivirus.StatusNotScanned {
+		t.Fatalf("expected a not scanned upload, got %+v (%v)", u, err)
+	}
+
+	s.Scanner = fakeScanner{}
+	u, err = s.Check(context.Background(), 7, "photos", fhs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := s.Save(7, "C001", KindCustomer, u)
+	if err != nil || a.ScanStatus != antivirus.StatusClean {
+		t.Errorf("expected a clean attachment, got %+v (%v)", a, err)
+	}
+
+	infected := append(append([]byte{}, pdfData...), "VIRUS"...)
+	fhs = fileHeaders(t, "shIDFilepath", map[string][]byte{"id.pdf": infected})
+	_, err = s.Check(context.Background(), 7, "shIDFilepath", fhs[0])
+	var infectedErr *InfectedError
+	if !errors.Is(err, ErrInfected) || !errors.As(err, &infectedErr) || !IsRejected(err) {
+		t.Fatalf("expected ErrInfected but got %v", err)
+	}
+	if infectedErr.Signature != "Test-Virus" {
+		t.Errorf("expected signature Test-Virus but got %q", infectedErr.Signature)
+	}
+
+	// The infected file is kept in quarantine with a record of where it came from
+	records, _ := filepath.Glob(filepath.Join(s.QuarantineDir, "*.json"))
+	if len(records) != 1 {
+		t.Fatalf("expected 1 quarantine record but found %d", len(records))
+	}
+	var record quarantineRecord
+	data, _ := os.ReadFile(records[0])
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.CustomerID != 7 || record.OriginalName != "id.pdf" || record.Signature != "Test-Virus" {
+		t.Errorf("unexpected quarantine record %+v", record)
+	}
+	quarantined, err := os.ReadFile(strings.TrimSuffix(records[0], ".json") + ".quarantined")
+	if err != nil || !bytes.Equal(quarantined, infected) {
+		t.Errorf("quarantined file does not match the upload (%v)", err)
+	}
+
+	s.Scanner = fakeScanner{err: errors.New("connection refused")}
+	_, err = s.Check(context.Background(), 7, "shIDFilepath", fhs[0])
+	if !errors.Is(err, ErrScanFailed) || !IsRejected(err) || strings.Contains(err.Error(), "refused") {
+		t.Errorf("expected ErrScanFailed without the cause in the message, got %v", err)
+	}
+}
+
+func TestStore_Dir(t *testing.T) {
+	s := &Store{Root: "/uploads"}
+
+	var tests = []struct {
+		code string
+		want string
+	}{
+		{"C001", filepath.Join("/uploads", "C001", KindPartner)},
+		{"../../etc", filepath.Join("/uploads", "_etc", KindPartner)},
+		{"..", filepath.Join("/uploads", "unknown", KindPartner)},
+		{"", filepath.Join("/uploads", "unknown", KindPartner)},
+	}
+
+	for _, e := range tests {
+		if got := s.Dir(e.code, KindPartner); got != e.want {
+			t.Errorf("Dir(%q): expected %s but got %s", e.code, e.want, got)
+		}
+	}
+}
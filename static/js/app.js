@@ -87,3 +87,54 @@ function Prompt() {
     custom: custom,
   };
 }
+
+// prefillFromDocument sends the scan chosen in a file input to the server, which
+// reads the fields of the document, and fills the empty inputs of the form.
+// targets maps the extracted fields (name, document_number, emirates_id,
+// expiry_date, ...) to the names of the inputs they go in. Filled inputs are
+// highlighted so staff check them before saving.
+function prefillFromDocument(input, kind, targets) {
+  if (input.files.length !== 1) {
+    return;
+  }
+
+  let form = input.form;
+  let data = new FormData();
+  data.append("csrf_token", form.querySelector("input[name='csrf_token']").value);
+  data.append("kind", kind);
+  data.append("document", input.files[0]);
+
+  fetch("/customer/extract", { method: "post", body: data })
+    .then((response) => response.json())
+    .then((resp) => {
+      if (!resp.ok) {
+        attention.toast({ msg: resp.message, icon: "warning" });
+        return;
+      }
+
+      let filled = 0;
+      for (const [field, name] of Object.entries(targets)) {
+        let el = form.querySelector("[name='" + name + "']");
+        let value = resp.fields ? resp.fields[field] : undefined;
+        if (el && value && el.value === "") {
+          el.value = value;
+          el.classList.add("border-warning");
+          el.title = "Read from the document, please check";
+          filled++;
+        }
+      }
+
+      let warnings = resp.warnings || [];
+      if (filled === 0 && warnings.length === 0) {
+        warnings.push("No fields could be read from the document.");
+      }
+      if (warnings.length > 0) {
+        attention.toast({ msg: warnings.join(" "), icon: "warning" });
+      } else {
+        attention.toast({ msg: "Filled " + filled + " field(s) from the document, please check them." });
+      }
+    })
+    .catch(() => {
+      attention.toast({ msg: "The document could not be read.", icon: "error" });
+    });
+}